@@ -1,14 +1,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/supurazako/rtc-emulator/internal/cli"
+	"github.com/supurazako/rtc-emulator/internal/lab"
 )
 
 func main() {
 	if err := cli.Execute(); err != nil {
+		var exitErr *lab.RootlessExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
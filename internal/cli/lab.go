@@ -1,53 +1,242 @@
 package cli
 
 import (
-	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/supurazako/rtc-emulator/internal/lab"
 )
 
+const (
+	// vnetAnnotation marks commands that work with the in-process vnet backend.
+	vnetAnnotation = "vnet"
+	// dryRunAnnotation marks commands that can record their system commands
+	// with --dry-run.
+	dryRunAnnotation = "dry-run"
+	// rootlessAnnotation marks the commands that start or stop the rootless
+	// namespace holder.
+	rootlessAnnotation = "rootless"
+	rootlessStart      = "start"
+	rootlessStop       = "stop"
+)
+
 func newLabCmd() *cobra.Command {
+	var backend string
+	var rootless bool
+	var dryRun string
+	var lockTimeout time.Duration
+
 	cmd := &cobra.Command{
 		Use:   "lab",
 		Short: "Manage local lab environments",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			ctx, err := lab.WithSettings(cmd.Context(), lab.Settings{
+				Backend:     backend,
+				DryRun:      dryRun != "",
+				LockTimeout: lockTimeout,
+			})
+			if err != nil {
+				return err
+			}
+			cmd.SetContext(ctx)
+			vnet := strings.EqualFold(strings.TrimSpace(backend), lab.BackendVNet)
+			if vnet && cmd.Annotations[vnetAnnotation] == "" {
+				return fmt.Errorf("the %s backend supports only lab scenario run", lab.BackendVNet)
+			}
+			if dryRun != "" {
+				return setupDryRun(cmd, dryRun, vnet || rootless)
+			}
+			if !rootless || lab.InRootlessNamespace() {
+				return nil
+			}
+			if vnet {
+				return fmt.Errorf("--rootless cannot be combined with the %s backend", lab.BackendVNet)
+			}
+			slirp, _ := cmd.Flags().GetBool("slirp")
+			err = lab.RunRootless(cmd.Context(), lab.RootlessOptions{
+				Args:  os.Args[1:],
+				Start: cmd.Annotations[rootlessAnnotation] == rootlessStart,
+				Stop:  cmd.Annotations[rootlessAnnotation] == rootlessStop,
+				Slirp: slirp,
+			})
+			// The command already ran inside the namespaces.
+			cmd.RunE = func(*cobra.Command, []string) error { return err }
+			return nil
+		},
 	}
+	cmd.PersistentFlags().StringVar(&backend, "backend", lab.BackendExec, "network backend: exec runs ip/tc/sysctl, netlink talks to the kernel directly, vnet runs scenarios in-process without privileges")
+	cmd.PersistentFlags().BoolVar(&rootless, "rootless", false, "run the lab in user and network namespaces owned by the current user instead of as root")
+	cmd.PersistentFlags().StringVar(&dryRun, "dry-run", "", "print the system commands a command would run, as text or json, without running them")
+	cmd.PersistentFlags().Lookup("dry-run").NoOptDefVal = "text"
+	cmd.PersistentFlags().DurationVar(&lockTimeout, "lock-timeout", lab.DefaultLockTimeout, "how long a command that changes the lab waits for another one to finish; 0 fails at once")
 
 	cmd.AddCommand(
 		newLabCreateCmd(),
+		newLabNodeCmd(),
 		newLabApplyCmd(),
 		newLabImpairCmd(),
+		newLabFirewallCmd(),
+		newLabLinkCmd(),
 		newLabScenarioCmd(),
 		newLabWebRTCCmd(),
+		newLabSignalCmd(),
+		newLabTURNCmd(),
 		newLabShowCmd(),
+		newLabAuditCmd(),
+		newLabDoctorCmd(),
+		newLabReconcileCmd(),
 		newLabDestroyCmd(),
 	)
 
 	return cmd
 }
 
+// setupDryRun records the system commands of cmd instead of running them and
+// prints them in place of its usual output.
+func setupDryRun(cmd *cobra.Command, format string, unsupportedMode bool) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid --dry-run format %q: use text or json", format)
+	}
+	if cmd.Annotations[dryRunAnnotation] == "" {
+		return fmt.Errorf("%s does not support --dry-run", cmd.CommandPath())
+	}
+	if unsupportedMode {
+		return errors.New("--dry-run cannot be combined with --rootless or the vnet backend")
+	}
+	run := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		cmd.SetOut(io.Discard)
+		err := run(cmd, args)
+		cmd.SetOut(out)
+		if printErr := printDryRunCommands(out, format, lab.DryRunCommands(cmd.Context())); printErr != nil {
+			return errors.Join(err, printErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func printDryRunCommands(w io.Writer, format string, commands []lab.DryRunCommand) error {
+	if format == "json" {
+		if commands == nil {
+			commands = []lab.DryRunCommand{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(commands)
+	}
+	rollbackHeader := false
+	for _, c := range commands {
+		if c.Rollback && !rollbackHeader {
+			fmt.Fprintln(w, "# rollback if a later step fails:")
+			rollbackHeader = true
+		}
+		fmt.Fprintln(w, shellJoin(c.Command))
+	}
+	return nil
+}
+
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"$;&|<>(){}*?!#") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
 func newLabCreateCmd() *cobra.Command {
 	var nodes int
+	var natSpecs []string
+	var topologyPath string
+	var ipv6 bool
+	var mtu int
+	var firewallBackend string
+	var slirp bool
 
 	cmd := &cobra.Command{
-		Use:   "create",
-		Short: "Create a lab environment",
+		Use:         "create",
+		Short:       "Create a lab environment",
+		Annotations: map[string]string{dryRunAnnotation: "supported", rootlessAnnotation: rootlessStart},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.Create(context.Background(), lab.CreateOptions{Nodes: nodes})
+			if slirp && !lab.InRootlessNamespace() {
+				return errors.New("--slirp requires --rootless")
+			}
+			if topologyPath != "" {
+				if cmd.Flags().Changed("nodes") || cmd.Flags().Changed("nat") || ipv6 || cmd.Flags().Changed("firewall-backend") {
+					return errors.New("--topology cannot be combined with --nodes, --nat, --ipv6, or --firewall-backend")
+				}
+				topo, err := lab.LoadTopology(topologyPath)
+				if err != nil {
+					return err
+				}
+				result, err := lab.Create(cmd.Context(), lab.CreateOptions{Topology: topo, MTU: mtu})
+				if err != nil {
+					return err
+				}
+				printTopologyCreateResult(cmd, result)
+				if mtu != 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "mtu=%d\n", mtu)
+				}
+				return nil
+			}
+			nats := make([]lab.NodeNAT, 0, len(natSpecs))
+			for _, spec := range natSpecs {
+				nat, err := lab.ParseNodeNAT(spec)
+				if err != nil {
+					return err
+				}
+				nats = append(nats, nat)
+			}
+			result, err := lab.Create(cmd.Context(), lab.CreateOptions{
+				Nodes:           nodes,
+				NAT:             nats,
+				IPv6:            ipv6,
+				MTU:             mtu,
+				FirewallBackend: firewallBackend,
+			})
 			if err != nil {
 				return err
 			}
 
 			fmt.Fprintf(cmd.OutOrStdout(), "created bridge=%s nodes=%d\n", result.Bridge, len(result.Nodes))
+			if result.FirewallBackend == lab.FirewallBackendNFTables {
+				fmt.Fprintln(cmd.OutOrStdout(), "firewall-backend=nftables table=inet rtc-emulator")
+			}
+			if mtu != 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "mtu=%d\n", mtu)
+			}
+			if result.SubnetIPv6 != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "subnet=%s subnet-ipv6=%s\n", result.Subnet, result.SubnetIPv6)
+			}
 			for _, node := range result.Nodes {
+				if node.IPv6 != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "- %s ip=%s ipv6=%s\n", node.Name, node.IP, node.IPv6)
+					continue
+				}
+				if node.NAT != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "- %s ip=%s nat=%s private-ip=%s\n", node.Name, node.IP, node.NAT, node.PrivateIP)
+					continue
+				}
 				fmt.Fprintf(cmd.OutOrStdout(), "- %s ip=%s\n", node.Name, node.IP)
 			}
-			if result.InternetReachable {
+			switch {
+			case result.InternetCheckSkipped:
+				fmt.Fprintln(cmd.OutOrStdout(), "internet-check=skipped")
+			case result.InternetReachable:
 				fmt.Fprintln(cmd.OutOrStdout(), "internet-check=ok")
-			} else {
+			default:
 				fmt.Fprintln(cmd.OutOrStdout(), "internet-check=skipped-or-unreachable (host bridge connectivity is confirmed)")
 			}
 			return nil
@@ -55,10 +244,98 @@ func newLabCreateCmd() *cobra.Command {
 	}
 
 	cmd.Flags().IntVar(&nodes, "nodes", 1, "number of nodes to create")
+	cmd.Flags().StringArrayVar(&natSpecs, "nat", nil, "put a node behind its own NAT as NODE=TYPE; TYPE is full-cone, port-restricted, or symmetric (repeatable)")
+	cmd.Flags().IntVar(&mtu, "mtu", 0, "MTU for every node's eth0 and its peer; 0 keeps the default 1500")
+	cmd.Flags().BoolVar(&ipv6, "ipv6", false, "also assign IPv6 ULA addresses (dual-stack) to the bridge and nodes")
+	cmd.Flags().StringVar(&topologyPath, "topology", "", "create routed nodes, routers, and links from a JSON topology file")
+	cmd.Flags().StringVar(&firewallBackend, "firewall-backend", lab.FirewallBackendAuto, "host NAT and forwarding rules: auto, iptables, or nftables")
+	cmd.Flags().BoolVar(&slirp, "slirp", false, "with --rootless, give the lab internet access through slirp4netns")
+
+	return cmd
+}
+
+func printTopologyCreateResult(cmd *cobra.Command, result *lab.CreateResult) {
+	fmt.Fprintf(cmd.OutOrStdout(), "created topology nodes=%d routers=%d links=%d\n", len(result.Nodes), len(result.Routers), len(result.Links))
+	for _, node := range result.Nodes {
+		fmt.Fprintf(cmd.OutOrStdout(), "- %s ip=%s\n", node.Name, node.IP)
+	}
+	for _, router := range result.Routers {
+		fmt.Fprintf(cmd.OutOrStdout(), "- %s router\n", router)
+	}
+	for _, link := range result.Links {
+		fmt.Fprintf(cmd.OutOrStdout(), "- link %s:%s=%s %s:%s=%s", link.A, link.AInterface, link.AIP, link.B, link.BInterface, link.BIP)
+		for _, kv := range [][2]string{{"delay", link.Delay}, {"jitter", link.Jitter}, {"loss", link.Loss}, {"bw", link.BW}} {
+			if kv[1] != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), " %s=%s", kv[0], kv[1])
+			}
+		}
+		fmt.Fprintln(cmd.OutOrStdout())
+	}
+}
+
+func newLabNodeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "node",
+		Short: "Add or remove nodes on a running lab",
+	}
+
+	cmd.AddCommand(
+		newLabNodeAddCmd(),
+		newLabNodeRemoveCmd(),
+	)
 
 	return cmd
 }
 
+func newLabNodeAddCmd() *cobra.Command {
+	var name string
+
+	cmd := &cobra.Command{
+		Use:         "add",
+		Short:       "Add a plain bridge node (never NAT) to the running lab",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			node, err := lab.AddNode(cmd.Context(), lab.NodeAddOptions{Name: name})
+			if err != nil {
+				return err
+			}
+
+			if node.IPv6 != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "added %s ip=%s ipv6=%s\n", node.Name, node.IP, node.IPv6)
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "added %s ip=%s\n", node.Name, node.IP)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "node name as nodeN; defaults to the lowest free index")
+
+	return cmd
+}
+
+func newLabNodeRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:         "remove NAME",
+		Short:       "Remove a node from the running lab",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.RemoveNode(cmd.Context(), lab.NodeRemoveOptions{Name: args[0]})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "removed %s\n", result.Name)
+			if result.NATNamespace != "" {
+				fmt.Fprintf(cmd.OutOrStdout(), "- %s\n", result.NATNamespace)
+			}
+			return nil
+		},
+	}
+}
+
 func newLabImpairCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "impair",
@@ -81,11 +358,12 @@ func newLabImpairApplyCmd() *cobra.Command {
 	var bw string
 
 	cmd := &cobra.Command{
-		Use:   "apply",
-		Short: "Apply impairments to a node",
-		Args:  cobra.NoArgs,
+		Use:         "apply",
+		Short:       "Apply impairments to a node",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.Apply(context.Background(), lab.ApplyOptions{
+			result, err := lab.Apply(cmd.Context(), lab.ApplyOptions{
 				Node:   node,
 				Delay:  delay,
 				Loss:   loss,
@@ -110,11 +388,12 @@ func newLabImpairClearCmd() *cobra.Command {
 	var node string
 
 	cmd := &cobra.Command{
-		Use:   "clear",
-		Short: "Clear impairments from a node",
-		Args:  cobra.NoArgs,
+		Use:         "clear",
+		Short:       "Clear impairments from a node",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.Clear(context.Background(), lab.ClearOptions{Node: node})
+			result, err := lab.Clear(cmd.Context(), lab.ClearOptions{Node: node})
 			if err != nil {
 				return err
 			}
@@ -134,6 +413,116 @@ func newLabImpairClearCmd() *cobra.Command {
 	return cmd
 }
 
+func newLabFirewallCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "Manage per-node firewall profiles",
+	}
+
+	cmd.AddCommand(
+		newLabFirewallApplyCmd(),
+		newLabFirewallClearCmd(),
+	)
+
+	return cmd
+}
+
+func newLabFirewallApplyCmd() *cobra.Command {
+	var node string
+	var profile string
+
+	cmd := &cobra.Command{
+		Use:         "apply",
+		Short:       "Apply a firewall profile inside a node",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.ApplyFirewall(cmd.Context(), lab.FirewallApplyOptions{Node: node, Profile: profile})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "applied node=%s firewall=%s rules=%d\n", result.Node, result.Profile, result.Rules)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&node, "node", "", "target node")
+	cmd.Flags().StringVar(&profile, "profile", "", "firewall profile: block-udp, allow-443-only, or block-stun")
+	_ = cmd.MarkFlagRequired("node")
+	_ = cmd.MarkFlagRequired("profile")
+
+	return cmd
+}
+
+func newLabFirewallClearCmd() *cobra.Command {
+	var node string
+
+	cmd := &cobra.Command{
+		Use:         "clear",
+		Short:       "Remove the firewall profile from a node",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.ClearFirewall(cmd.Context(), lab.FirewallClearOptions{Node: node})
+			if err != nil {
+				return err
+			}
+
+			status := "absent"
+			if result.Cleared {
+				status = "cleared"
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "cleared node=%s firewall=%s\n", result.Node, status)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&node, "node", "", "target node")
+	_ = cmd.MarkFlagRequired("node")
+
+	return cmd
+}
+
+func newLabLinkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "link",
+		Short: "Manage node link settings",
+	}
+
+	cmd.AddCommand(newLabLinkSetCmd())
+
+	return cmd
+}
+
+func newLabLinkSetCmd() *cobra.Command {
+	var node string
+	var mtu int
+
+	cmd := &cobra.Command{
+		Use:         "set",
+		Short:       "Change link settings of a node at runtime",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.SetLink(cmd.Context(), lab.LinkSetOptions{Node: node, MTU: mtu})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "set node=%s mtu=%d previous-mtu=%d\n", result.Node, result.MTU, result.PreviousMTU)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&node, "node", "", "target node")
+	cmd.Flags().IntVar(&mtu, "mtu", 0, "MTU for the node's eth0 and its peer, e.g. 1200")
+	_ = cmd.MarkFlagRequired("node")
+	_ = cmd.MarkFlagRequired("mtu")
+
+	return cmd
+}
+
 func newLabScenarioCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "scenario",
@@ -157,13 +546,25 @@ func newLabScenarioRunCmd() *cobra.Command {
 	var impaired time.Duration
 	var recovery time.Duration
 	var statsInterval time.Duration
+	var rawStats bool
+	var actionSpecs []string
+	var ice lab.WebRTCICEOptions
 
 	cmd := &cobra.Command{
-		Use:   "run SCENARIO",
-		Short: "Run a named lab scenario and save event logs",
-		Args:  cobra.ExactArgs(1),
+		Use:         "run SCENARIO",
+		Short:       "Run a named lab scenario and save event logs",
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{vnetAnnotation: "supported", dryRunAnnotation: "supported"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.RunScenario(context.Background(), lab.ScenarioRunOptions{
+			actions := make([]lab.ScenarioAction, 0, len(actionSpecs))
+			for _, spec := range actionSpecs {
+				action, err := lab.ParseScenarioAction(spec)
+				if err != nil {
+					return err
+				}
+				actions = append(actions, action)
+			}
+			result, err := lab.RunScenario(cmd.Context(), lab.ScenarioRunOptions{
 				Scenario:         args[0],
 				RunsDir:          runsDir,
 				Node:             node,
@@ -176,6 +577,9 @@ func newLabScenarioRunCmd() *cobra.Command {
 				ImpairedDuration: impaired,
 				RecoveryDuration: recovery,
 				StatsInterval:    statsInterval,
+				RawStats:         rawStats,
+				Actions:          actions,
+				ICE:              ice,
 			})
 			if result != nil {
 				printScenarioRunResult(cmd, result)
@@ -195,6 +599,9 @@ func newLabScenarioRunCmd() *cobra.Command {
 	cmd.Flags().DurationVar(&impaired, "impaired", 10*time.Second, "impaired phase duration")
 	cmd.Flags().DurationVar(&recovery, "recovery", 5*time.Second, "recovery phase duration")
 	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "also write the full getStats report per node as stats.raw.<node>.jsonl")
+	cmd.Flags().StringArrayVar(&actionSpecs, "action", nil, "run an action at the start of a phase as PHASE:ACTION, e.g. impaired:ice-restart, impaired:firewall=block-udp, or impaired:mtu=1200 (repeatable)")
+	addICEFlags(cmd, &ice)
 
 	return cmd
 }
@@ -205,6 +612,9 @@ func printScenarioRunResult(cmd *cobra.Command, result *lab.ScenarioRunResult) {
 	fmt.Fprintf(cmd.OutOrStdout(), "latest-dir=%s\n", result.LatestDir)
 	fmt.Fprintf(cmd.OutOrStdout(), "events=%s\n", result.EventsPath)
 	fmt.Fprintf(cmd.OutOrStdout(), "stats=%s\n", result.StatsPath)
+	for _, path := range result.RawStatsPaths {
+		fmt.Fprintf(cmd.OutOrStdout(), "raw-stats=%s\n", path)
+	}
 }
 
 func newLabWebRTCCmd() *cobra.Command {
@@ -215,7 +625,12 @@ func newLabWebRTCCmd() *cobra.Command {
 
 	cmd.AddCommand(
 		newLabWebRTCP2PCmd(),
+		newLabWebRTCMeshCmd(),
+		newLabWebRTCSFUCmd(),
+		newLabWebRTCJoinCmd(),
 		newLabWebRTCPeerCmd(),
+		newLabWebRTCMeshPeerCmd(),
+		newLabWebRTCSFUPeerCmd(),
 	)
 
 	return cmd
@@ -227,18 +642,26 @@ func newLabWebRTCP2PCmd() *cobra.Command {
 	var nodeB string
 	var duration time.Duration
 	var statsInterval time.Duration
+	var rawStats bool
+	var signaling string
+	var signalURL string
+	var ice lab.WebRTCICEOptions
 
 	cmd := &cobra.Command{
 		Use:   "p2p",
 		Short: "Run a lab WebRTC P2P flow and save stats logs",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.RunWebRTCP2P(context.Background(), lab.WebRTCP2POptions{
+			result, err := lab.RunWebRTCP2P(cmd.Context(), lab.WebRTCP2POptions{
 				RunsDir:       runsDir,
 				NodeA:         nodeA,
 				NodeB:         nodeB,
 				Duration:      duration,
 				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				Signaling:     signaling,
+				SignalURL:     signalURL,
+				ICE:           ice,
 			})
 			if result != nil {
 				printWebRTCP2PResult(cmd, result)
@@ -252,6 +675,9 @@ func newLabWebRTCP2PCmd() *cobra.Command {
 	cmd.Flags().StringVar(&nodeB, "node-b", "node2", "answerer node")
 	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
 	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "also write the full getStats report per node as stats.raw.<node>.jsonl")
+	addSignalingFlags(cmd, &signaling, &signalURL)
+	addICEFlags(cmd, &ice)
 
 	return cmd
 }
@@ -262,6 +688,196 @@ func printWebRTCP2PResult(cmd *cobra.Command, result *lab.WebRTCP2PResult) {
 	fmt.Fprintf(cmd.OutOrStdout(), "latest-dir=%s\n", result.LatestDir)
 	fmt.Fprintf(cmd.OutOrStdout(), "events=%s\n", result.EventsPath)
 	fmt.Fprintf(cmd.OutOrStdout(), "stats=%s\n", result.StatsPath)
+	for _, path := range result.RawStatsPaths {
+		fmt.Fprintf(cmd.OutOrStdout(), "raw-stats=%s\n", path)
+	}
+}
+
+func newLabWebRTCMeshCmd() *cobra.Command {
+	var runsDir string
+	var nodes []string
+	var duration time.Duration
+	var statsInterval time.Duration
+	var rawStats bool
+	var signaling string
+	var signalURL string
+	var ice lab.WebRTCICEOptions
+
+	cmd := &cobra.Command{
+		Use:   "mesh",
+		Short: "Run a full-mesh lab WebRTC flow across several nodes and save stats logs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.RunWebRTCMesh(cmd.Context(), lab.WebRTCMeshOptions{
+				RunsDir:       runsDir,
+				Nodes:         nodes,
+				Duration:      duration,
+				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				Signaling:     signaling,
+				SignalURL:     signalURL,
+				ICE:           ice,
+			})
+			if result != nil {
+				printWebRTCMeshResult(cmd, result)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&runsDir, "runs-dir", "runs", "directory for WebRTC run outputs")
+	cmd.Flags().StringSliceVar(&nodes, "nodes", []string{"node1", "node2", "node3"}, "comma-separated mesh member nodes")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "also write the full getStats report per node pair as stats.raw.<node>.<peer>.jsonl")
+	addSignalingFlags(cmd, &signaling, &signalURL)
+	addICEFlags(cmd, &ice)
+
+	return cmd
+}
+
+func printWebRTCMeshResult(cmd *cobra.Command, result *lab.WebRTCMeshResult) {
+	fmt.Fprintf(cmd.OutOrStdout(), "run-id=%s\n", result.RunID)
+	fmt.Fprintf(cmd.OutOrStdout(), "run-dir=%s\n", result.RunDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "latest-dir=%s\n", result.LatestDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "events=%s\n", result.EventsPath)
+	fmt.Fprintf(cmd.OutOrStdout(), "stats=%s\n", result.StatsPath)
+	for _, path := range result.RawStatsPaths {
+		fmt.Fprintf(cmd.OutOrStdout(), "raw-stats=%s\n", path)
+	}
+}
+
+func newLabWebRTCSFUCmd() *cobra.Command {
+	var runsDir string
+	var sfuNode string
+	var publishers []string
+	var subscribers []string
+	var duration time.Duration
+	var statsInterval time.Duration
+	var rawStats bool
+	var signaling string
+	var signalURL string
+	var ice lab.WebRTCICEOptions
+
+	cmd := &cobra.Command{
+		Use:   "sfu",
+		Short: "Run a built-in forwarding SFU with synthetic publishers and subscribers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.RunWebRTCSFU(cmd.Context(), lab.WebRTCSFUOptions{
+				RunsDir:       runsDir,
+				SFUNode:       sfuNode,
+				Publishers:    publishers,
+				Subscribers:   subscribers,
+				Duration:      duration,
+				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				Signaling:     signaling,
+				SignalURL:     signalURL,
+				ICE:           ice,
+			})
+			if result != nil {
+				printWebRTCSFUResult(cmd, result)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&runsDir, "runs-dir", "runs", "directory for WebRTC run outputs")
+	cmd.Flags().StringVar(&sfuNode, "sfu-node", "node3", "node that runs the forwarding SFU")
+	cmd.Flags().StringSliceVar(&publishers, "publishers", []string{"node1"}, "comma-separated nodes that publish synthetic video")
+	cmd.Flags().StringSliceVar(&subscribers, "subscribers", []string{"node2"}, "comma-separated nodes that subscribe to every publisher")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "also write the full getStats report per node pair as stats.raw.<node>.<peer>.jsonl")
+	addSignalingFlags(cmd, &signaling, &signalURL)
+	addICEFlags(cmd, &ice)
+
+	return cmd
+}
+
+func printWebRTCSFUResult(cmd *cobra.Command, result *lab.WebRTCSFUResult) {
+	fmt.Fprintf(cmd.OutOrStdout(), "run-id=%s\n", result.RunID)
+	fmt.Fprintf(cmd.OutOrStdout(), "run-dir=%s\n", result.RunDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "latest-dir=%s\n", result.LatestDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "events=%s\n", result.EventsPath)
+	fmt.Fprintf(cmd.OutOrStdout(), "stats=%s\n", result.StatsPath)
+	for _, path := range result.RawStatsPaths {
+		fmt.Fprintf(cmd.OutOrStdout(), "raw-stats=%s\n", path)
+	}
+}
+
+func newLabWebRTCJoinCmd() *cobra.Command {
+	var runsDir string
+	var node string
+	var role string
+	var room string
+	var signalURL string
+	var duration time.Duration
+	var statsInterval time.Duration
+	var rawStats bool
+	var ice lab.WebRTCICEOptions
+
+	cmd := &cobra.Command{
+		Use:   "join",
+		Short: "Join a WebSocket signaling room from one node and save stats logs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.RunWebRTCJoin(cmd.Context(), lab.WebRTCJoinOptions{
+				RunsDir:       runsDir,
+				Node:          node,
+				Role:          role,
+				Room:          room,
+				SignalURL:     signalURL,
+				Duration:      duration,
+				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				ICE:           ice,
+			})
+			if result != nil {
+				printWebRTCJoinResult(cmd, result)
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&runsDir, "runs-dir", "runs", "directory for WebRTC run outputs")
+	cmd.Flags().StringVar(&node, "node", "node1", "node that runs the built-in endpoint")
+	cmd.Flags().StringVar(&role, "role", "answerer", "offerer or answerer")
+	cmd.Flags().StringVar(&room, "room", "", "signaling room shared with the other endpoint")
+	cmd.Flags().StringVar(&signalURL, "signal-url", "", "WebSocket signaling server url, e.g. ws://10.200.0.1:8089")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "also write the full getStats report as stats.raw.<node>.jsonl")
+	addICEFlags(cmd, &ice)
+	_ = cmd.MarkFlagRequired("room")
+	_ = cmd.MarkFlagRequired("signal-url")
+
+	return cmd
+}
+
+func printWebRTCJoinResult(cmd *cobra.Command, result *lab.WebRTCJoinResult) {
+	fmt.Fprintf(cmd.OutOrStdout(), "run-id=%s\n", result.RunID)
+	fmt.Fprintf(cmd.OutOrStdout(), "run-dir=%s\n", result.RunDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "latest-dir=%s\n", result.LatestDir)
+	fmt.Fprintf(cmd.OutOrStdout(), "events=%s\n", result.EventsPath)
+	fmt.Fprintf(cmd.OutOrStdout(), "stats=%s\n", result.StatsPath)
+	for _, path := range result.RawStatsPaths {
+		fmt.Fprintf(cmd.OutOrStdout(), "raw-stats=%s\n", path)
+	}
+}
+
+func addSignalingFlags(cmd *cobra.Command, signaling *string, signalURL *string) {
+	cmd.Flags().StringVar(signaling, "signaling", "file", "signaling transport: file or ws")
+	cmd.Flags().StringVar(signalURL, "signal-url", "", "external WebSocket signaling server url; ws without it starts one on the bridge")
+}
+
+func addICEFlags(cmd *cobra.Command, ice *lab.WebRTCICEOptions) {
+	cmd.Flags().StringSliceVar(&ice.Servers, "ice-servers", nil, "comma-separated STUN/TURN urls, e.g. turn:10.200.0.4:3478?transport=tcp")
+	cmd.Flags().StringVar(&ice.Username, "ice-username", "", "username for TURN ice servers")
+	cmd.Flags().StringVar(&ice.Credential, "ice-credential", "", "credential for TURN ice servers")
+	cmd.Flags().StringVar(&ice.CredentialFile, "ice-credential-file", "", "file holding the credential for TURN ice servers")
+	cmd.Flags().StringVar(&ice.TransportPolicy, "ice-transport-policy", "all", "ice transport policy: all or relay")
 }
 
 func newLabWebRTCPeerCmd() *cobra.Command {
@@ -272,6 +888,10 @@ func newLabWebRTCPeerCmd() *cobra.Command {
 	var peer string
 	var duration time.Duration
 	var statsInterval time.Duration
+	var rawStats bool
+	var signalURL string
+	var signalRoom string
+	var ice lab.WebRTCICEOptions
 
 	cmd := &cobra.Command{
 		Use:    "peer",
@@ -279,7 +899,7 @@ func newLabWebRTCPeerCmd() *cobra.Command {
 		Hidden: true,
 		Args:   cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return lab.RunWebRTCPeer(context.Background(), lab.WebRTCPeerOptions{
+			return lab.RunWebRTCPeer(cmd.Context(), lab.WebRTCPeerOptions{
 				Role:          role,
 				RunID:         runID,
 				RunDir:        runDir,
@@ -287,6 +907,10 @@ func newLabWebRTCPeerCmd() *cobra.Command {
 				Peer:          peer,
 				Duration:      duration,
 				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				SignalURL:     signalURL,
+				SignalRoom:    signalRoom,
+				ICE:           ice,
 			})
 		},
 	}
@@ -298,6 +922,10 @@ func newLabWebRTCPeerCmd() *cobra.Command {
 	cmd.Flags().StringVar(&peer, "peer", "", "remote peer node")
 	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
 	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "write the full getStats report per interval")
+	cmd.Flags().StringVar(&signalURL, "signal-url", "", "WebSocket signaling server url; empty uses signal files")
+	addICEFlags(cmd, &ice)
+	cmd.Flags().StringVar(&signalRoom, "signal-room", "", "signaling room; defaults to the run id")
 	_ = cmd.MarkFlagRequired("role")
 	_ = cmd.MarkFlagRequired("run-id")
 	_ = cmd.MarkFlagRequired("run-dir")
@@ -307,6 +935,209 @@ func newLabWebRTCPeerCmd() *cobra.Command {
 	return cmd
 }
 
+func newLabWebRTCMeshPeerCmd() *cobra.Command {
+	var runID string
+	var runDir string
+	var node string
+	var nodes []string
+	var duration time.Duration
+	var statsInterval time.Duration
+	var rawStats bool
+	var signalURL string
+	var ice lab.WebRTCICEOptions
+
+	cmd := &cobra.Command{
+		Use:    "mesh-peer",
+		Short:  "Run one internal WebRTC mesh member process",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lab.RunWebRTCMeshPeer(cmd.Context(), lab.WebRTCMeshPeerOptions{
+				RunID:         runID,
+				RunDir:        runDir,
+				Node:          node,
+				Nodes:         nodes,
+				Duration:      duration,
+				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				SignalURL:     signalURL,
+				ICE:           ice,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&runID, "run-id", "", "run id")
+	cmd.Flags().StringVar(&runDir, "run-dir", "", "run directory")
+	cmd.Flags().StringVar(&node, "node", "", "current node")
+	cmd.Flags().StringSliceVar(&nodes, "nodes", nil, "all mesh member nodes in offer order")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "write the full getStats report per interval")
+	cmd.Flags().StringVar(&signalURL, "signal-url", "", "WebSocket signaling server url; empty uses signal files")
+	addICEFlags(cmd, &ice)
+	_ = cmd.MarkFlagRequired("run-id")
+	_ = cmd.MarkFlagRequired("run-dir")
+	_ = cmd.MarkFlagRequired("node")
+	_ = cmd.MarkFlagRequired("nodes")
+
+	return cmd
+}
+
+func newLabWebRTCSFUPeerCmd() *cobra.Command {
+	var role string
+	var runID string
+	var runDir string
+	var node string
+	var sfuNode string
+	var publishers []string
+	var subscribers []string
+	var duration time.Duration
+	var statsInterval time.Duration
+	var rawStats bool
+	var signalURL string
+	var ice lab.WebRTCICEOptions
+
+	cmd := &cobra.Command{
+		Use:    "sfu-peer",
+		Short:  "Run one internal WebRTC SFU, publisher, or subscriber process",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return lab.RunWebRTCSFUPeer(cmd.Context(), lab.WebRTCSFUPeerOptions{
+				Role:          role,
+				RunID:         runID,
+				RunDir:        runDir,
+				Node:          node,
+				SFUNode:       sfuNode,
+				Publishers:    publishers,
+				Subscribers:   subscribers,
+				Duration:      duration,
+				StatsInterval: statsInterval,
+				RawStats:      rawStats,
+				SignalURL:     signalURL,
+				ICE:           ice,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&role, "role", "", "sfu, publisher, or subscriber")
+	cmd.Flags().StringVar(&runID, "run-id", "", "run id")
+	cmd.Flags().StringVar(&runDir, "run-dir", "", "run directory")
+	cmd.Flags().StringVar(&node, "node", "", "current node")
+	cmd.Flags().StringVar(&sfuNode, "sfu-node", "", "node that runs the forwarding SFU")
+	cmd.Flags().StringSliceVar(&publishers, "publishers", nil, "publisher nodes")
+	cmd.Flags().StringSliceVar(&subscribers, "subscribers", nil, "subscriber nodes")
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "stats collection duration")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "stats collection interval")
+	cmd.Flags().BoolVar(&rawStats, "raw-stats", false, "write the full getStats report per interval")
+	cmd.Flags().StringVar(&signalURL, "signal-url", "", "WebSocket signaling server url; empty uses signal files")
+	addICEFlags(cmd, &ice)
+	_ = cmd.MarkFlagRequired("role")
+	_ = cmd.MarkFlagRequired("run-id")
+	_ = cmd.MarkFlagRequired("run-dir")
+	_ = cmd.MarkFlagRequired("node")
+	_ = cmd.MarkFlagRequired("sfu-node")
+	_ = cmd.MarkFlagRequired("publishers")
+	_ = cmd.MarkFlagRequired("subscribers")
+
+	return cmd
+}
+
+func newLabSignalCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "signal",
+		Short: "Manage the lab WebRTC signaling service",
+	}
+
+	cmd.AddCommand(newLabSignalServeCmd())
+
+	return cmd
+}
+
+func newLabSignalServeCmd() *cobra.Command {
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve WebSocket signaling rooms until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return lab.ServeSignaling(ctx, lab.SignalServeOptions{Listen: listen}, func(url string) {
+				fmt.Fprintf(cmd.OutOrStdout(), "signal-url=%s\n", url)
+				fmt.Fprintf(cmd.OutOrStdout(), "room-url=%s/rooms/<room>?peer=<name>\n", url)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "10.200.0.1:8089", "listen address; the default is the lab bridge IP")
+
+	return cmd
+}
+
+func newLabTURNCmd() *cobra.Command {
+	var opts lab.TURNOptions
+
+	cmd := &cobra.Command{
+		Use:   "turn",
+		Short: "Run an embedded STUN/TURN server inside a node until interrupted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return lab.RunTURN(ctx, opts, cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Node, "node", "", "node that runs the TURN server")
+	cmd.Flags().IntVar(&opts.Port, "port", 3478, "UDP and TCP listen port")
+	cmd.Flags().StringVar(&opts.Realm, "realm", "rtc-emulator", "TURN realm")
+	cmd.Flags().StringVar(&opts.Username, "username", "rtcemu", "TURN username")
+	cmd.Flags().StringVar(&opts.Password, "password", "", "TURN password; generated when empty")
+	_ = cmd.MarkFlagRequired("node")
+
+	cmd.AddCommand(newLabTURNServeCmd())
+
+	return cmd
+}
+
+func newLabTURNServeCmd() *cobra.Command {
+	var opts lab.TURNServeOptions
+
+	cmd := &cobra.Command{
+		Use:    "serve",
+		Short:  "Run the internal TURN server process",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return lab.ServeTURN(ctx, opts, func(info lab.TURNServerInfo) {
+				fmt.Fprintf(cmd.OutOrStdout(), "stun-url=%s\n", info.STUNURL)
+				for _, url := range info.TURNURLs {
+					fmt.Fprintf(cmd.OutOrStdout(), "turn-url=%s\n", url)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "username=%s\n", info.Username)
+				fmt.Fprintf(cmd.OutOrStdout(), "credential=%s\n", info.Credential)
+				fmt.Fprintf(cmd.OutOrStdout(), "ice-flags=--ice-servers %s --ice-username %s --ice-credential-file %s\n",
+					strings.Join(info.TURNURLs, ","), info.Username, info.CredentialFile)
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.ListenIP, "listen-ip", "", "node IP to listen and relay on")
+	cmd.Flags().IntVar(&opts.Port, "port", 3478, "UDP and TCP listen port")
+	cmd.Flags().StringVar(&opts.Realm, "realm", "rtc-emulator", "TURN realm")
+	cmd.Flags().StringVar(&opts.Username, "username", "", "TURN username")
+	cmd.Flags().StringVar(&opts.PasswordFile, "password-file", "", "file holding the TURN password")
+	_ = cmd.MarkFlagRequired("listen-ip")
+	_ = cmd.MarkFlagRequired("username")
+	_ = cmd.MarkFlagRequired("password-file")
+
+	return cmd
+}
+
 func newLabApplyCmd() *cobra.Command {
 	var node string
 	var delay string
@@ -315,11 +1146,12 @@ func newLabApplyCmd() *cobra.Command {
 	var bw string
 
 	cmd := &cobra.Command{
-		Use:   "apply",
-		Short: "Apply impairments to a node",
-		Args:  cobra.NoArgs,
+		Use:         "apply",
+		Short:       "Apply impairments to a node",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
+		Args:        cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.Apply(context.Background(), lab.ApplyOptions{
+			result, err := lab.Apply(cmd.Context(), lab.ApplyOptions{
 				Node:   node,
 				Delay:  delay,
 				Loss:   loss,
@@ -377,12 +1209,130 @@ func newLabShowCmd() *cobra.Command {
 	}
 }
 
-func newLabDestroyCmd() *cobra.Command {
+func newLabAuditCmd() *cobra.Command {
+	var runDir string
+	var failed bool
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Show the system commands run for the current lab",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			records, err := lab.ReadAuditLog(runDir)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			enc := json.NewEncoder(out)
+			for _, rec := range records {
+				if failed && rec.ExitStatus == 0 && rec.Error == "" {
+					continue
+				}
+				if asJSON {
+					if err := enc.Encode(rec); err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Fprintf(out, "%s %.3fs exit=%d %s\n", rec.Time, rec.DurationSeconds, rec.ExitStatus, shellJoin(rec.Command))
+				if rec.Stderr != "" {
+					fmt.Fprintf(out, "  stderr: %s\n", rec.Stderr)
+				} else if rec.Error != "" {
+					fmt.Fprintf(out, "  error: %s\n", rec.Error)
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&runDir, "run-dir", "", "show the commands recorded in a scenario run directory instead")
+	cmd.Flags().BoolVar(&failed, "failed", false, "show only failed commands")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print records as JSON lines")
+	return cmd
+}
+
+func newLabDoctorCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that this host can run a lab and that the current lab is healthy",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.Doctor(cmd.Context())
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(result); err != nil {
+					return err
+				}
+			} else {
+				printDoctorResult(out, result)
+			}
+			if n := result.Failures(); n > 0 {
+				return fmt.Errorf("lab doctor found %d problem(s)", n)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print checks as JSON")
+	return cmd
+}
+
+func printDoctorResult(w io.Writer, result *lab.DoctorResult) {
+	for _, c := range result.Checks {
+		fmt.Fprintf(w, "%-4s  %-42s %s\n", c.Status, c.Name, c.Detail)
+		if c.Fix != "" {
+			fmt.Fprintf(w, "      fix: %s\n", c.Fix)
+		}
+	}
+}
+
+func newLabReconcileCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "destroy",
-		Short: "Destroy lab environment",
+		Use:         "reconcile",
+		Short:       "Repair the lab so the kernel matches its state file",
+		Annotations: map[string]string{dryRunAnnotation: "supported"},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			result, err := lab.Destroy(context.Background())
+			result, err := lab.Reconcile(cmd.Context())
+			if result == nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if len(result.Fixes) == 0 {
+				fmt.Fprintln(out, "nothing to fix")
+			}
+			failed := 0
+			for _, fix := range result.Fixes {
+				if fix.Error != "" {
+					failed++
+					fmt.Fprintf(out, "failed %s: %s: %s\n", fix.Item, fix.Action, fix.Error)
+					continue
+				}
+				fmt.Fprintf(out, "fixed  %s: %s\n", fix.Item, fix.Action)
+			}
+			if err != nil {
+				if failed == 0 {
+					return err
+				}
+				return fmt.Errorf("lab reconcile could not fix %d item(s)", failed)
+			}
+			return nil
+		},
+	}
+}
+
+func newLabDestroyCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:         "destroy",
+		Short:       "Destroy lab environment",
+		Annotations: map[string]string{dryRunAnnotation: "supported", rootlessAnnotation: rootlessStop},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := lab.Destroy(cmd.Context(), lab.DestroyOptions{Force: force})
 			if err != nil {
 				return err
 			}
@@ -393,7 +1343,30 @@ func newLabDestroyCmd() *cobra.Command {
 			}
 			fmt.Fprintf(cmd.OutOrStdout(), "state-missing-fallback=%t\n", result.StateMissingFallback)
 			fmt.Fprintf(cmd.OutOrStdout(), "ip-forward-restored=%t\n", result.IPForwardRestored)
+			forceable := false
+			for _, item := range result.Found {
+				status := "removed"
+				if !containsItem(result.Removed, item) {
+					status = "kept"
+					forceable = forceable || strings.HasPrefix(item, "namespace ") || strings.HasPrefix(item, "link ")
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "found %s: %s\n", item, status)
+			}
+			if forceable && !force {
+				fmt.Fprintln(cmd.OutOrStdout(), "rerun with --force to remove leftover namespaces and links")
+			}
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&force, "force", false, "without lab state, also delete managed namespaces and lab links found by name")
+	return cmd
+}
+
+func containsItem(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
 }
@@ -2,6 +2,8 @@ package cli
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -9,6 +11,141 @@ import (
 	"github.com/supurazako/rtc-emulator/internal/lab"
 )
 
+func TestLabCreateRejectsTopologyWithNodes(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "create", "--topology", "topology.json", "--nodes", "3"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--topology cannot be combined with --nodes, --nat, --ipv6, or --firewall-backend") {
+		t.Fatalf("expected topology flag conflict, got %v", err)
+	}
+}
+
+func TestLabRejectsUnknownBackend(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "--backend", "ebpf", "show"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), `unsupported backend "ebpf"`) {
+		t.Fatalf("expected unsupported backend error, got %v", err)
+	}
+}
+
+func TestLabVNetBackendRejectsNonScenarioCommands(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "--backend", "vnet", "create"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "the vnet backend supports only lab scenario run") {
+		t.Fatalf("expected vnet backend error, got %v", err)
+	}
+}
+
+func TestLabCreateSlirpRequiresRootless(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "create", "--slirp"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--slirp requires --rootless") {
+		t.Fatalf("expected slirp error, got %v", err)
+	}
+}
+
+func TestLabDryRunRejectsUnsupportedCommandsAndFormats(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"lab", "--dry-run", "show"}, want: "rtc-emulator lab show does not support --dry-run"},
+		{args: []string{"lab", "--dry-run=yaml", "destroy"}, want: `invalid --dry-run format "yaml"`},
+		{args: []string{"lab", "--dry-run", "--rootless", "destroy"}, want: "--dry-run cannot be combined with --rootless"},
+	} {
+		cmd := newRootCmd()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(tc.args)
+
+		err := cmd.Execute()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%v: expected error %q, got %v", tc.args, tc.want, err)
+		}
+	}
+}
+
+func TestLabRootlessAnnotationsMarkHolderLifecycle(t *testing.T) {
+	want := map[string]string{"create": rootlessStart, "destroy": rootlessStop}
+	for _, sub := range newLabCmd().Commands() {
+		if got := sub.Annotations[rootlessAnnotation]; got != want[sub.Name()] {
+			t.Fatalf("lab %s rootless annotation = %q, want %q", sub.Name(), got, want[sub.Name()])
+		}
+	}
+}
+
+func TestLabRejectsNegativeLockTimeout(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "--lock-timeout=-1s", "destroy"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "lock timeout must not be negative") {
+		t.Fatalf("expected lock timeout error, got %v", err)
+	}
+}
+
+func TestPrintDryRunCommandsMarksRollback(t *testing.T) {
+	var out bytes.Buffer
+	err := printDryRunCommands(&out, "text", []lab.DryRunCommand{
+		{Command: []string{"ip", "link", "add", "rtcemu0", "type", "bridge"}},
+		{Command: []string{"iptables", "-A", "POSTROUTING", "!", "-o", "rtcemu0"}},
+		{Command: []string{"ip", "link", "del", "rtcemu0"}, Rollback: true},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "ip link add rtcemu0 type bridge\niptables -A POSTROUTING '!' -o rtcemu0\n# rollback if a later step fails:\nip link del rtcemu0\n"
+	if out.String() != want {
+		t.Fatalf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestLabAuditPrintsRunDirectoryRecords(t *testing.T) {
+	dir := t.TempDir()
+	log := `{"time":"2026-01-02T03:04:05Z","command":["ip","link","add","rtcemu0","type","bridge"],"duration_seconds":0.002,"exit_status":0}
+{"time":"2026-01-02T03:04:06Z","command":["tc","qdisc","del","dev","eth0","root"],"duration_seconds":0.001,"exit_status":2,"stderr":"Error: Cannot delete qdisc with handle of zero."}
+`
+	if err := os.WriteFile(filepath.Join(dir, "audit.jsonl"), []byte(log), 0o600); err != nil {
+		t.Fatalf("failed to write audit log: %v", err)
+	}
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "audit", "--run-dir", dir, "--failed"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "2026-01-02T03:04:06Z 0.001s exit=2 tc qdisc del dev eth0 root\n  stderr: Error: Cannot delete qdisc with handle of zero.\n"
+	if out.String() != want {
+		t.Fatalf("expected %q, got %q", want, out.String())
+	}
+}
+
 func TestLabImpairHelpListsApplyAndClear(t *testing.T) {
 	cmd := newRootCmd()
 	var out bytes.Buffer
@@ -48,6 +185,8 @@ func TestLabScenarioRunHelpListsBuiltInScenarioOptions(t *testing.T) {
 		"--impaired",
 		"--recovery",
 		"--stats-interval",
+		"--action",
+		"--ice-servers",
 	} {
 		if !strings.Contains(got, want) {
 			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
@@ -73,6 +212,9 @@ func TestLabWebRTCP2PHelpListsStatsOptions(t *testing.T) {
 		"--duration",
 		"--stats-interval",
 		"--runs-dir",
+		"--raw-stats",
+		"--ice-servers",
+		"--ice-transport-policy",
 	} {
 		if !strings.Contains(got, want) {
 			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
@@ -80,6 +222,135 @@ func TestLabWebRTCP2PHelpListsStatsOptions(t *testing.T) {
 	}
 }
 
+func TestLabWebRTCMeshHelpListsNodesOption(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "webrtc", "mesh", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"full-mesh", "--nodes", "--duration", "--stats-interval", "--raw-stats"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabWebRTCSFUHelpListsRoleOptions(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "webrtc", "sfu", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--sfu-node", "--publishers", "--subscribers", "--duration", "--raw-stats", "--signaling", "--signal-url"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabSignalServeHelpListsListenOption(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "signal", "serve", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--listen", "10.200.0.1:8089"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabWebRTCJoinHelpListsRoomOptions(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "webrtc", "join", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--room", "--signal-url", "--role", "--node"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabTURNHelpListsCredentialOptions(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "turn", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--node", "--port", "--realm", "--username", "--password"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Available Commands") {
+		t.Fatalf("expected internal serve command to be hidden, got:\n%s", got)
+	}
+}
+
+func TestLabFirewallApplyHelpListsProfileOptions(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "firewall", "apply", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--node", "--profile", "block-udp", "allow-443-only", "block-stun"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestLabLinkSetHelpListsMTUOption(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "link", "set", "--help"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"--node", "--mtu"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected help to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
 func TestLabWebRTCHelpHidesInternalPeerCommand(t *testing.T) {
 	cmd := newRootCmd()
 	var out bytes.Buffer
@@ -166,6 +437,18 @@ func TestLabImpairApplyRejectsPositionalArgs(t *testing.T) {
 	}
 }
 
+func TestLabNodeRemoveRequiresName(t *testing.T) {
+	cmd := newRootCmd()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"lab", "node", "remove"})
+
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "accepts 1 arg(s)") {
+		t.Fatalf("expected missing name error, got %v", err)
+	}
+}
+
 func TestLabImpairClearRejectsPositionalArgs(t *testing.T) {
 	cmd := newRootCmd()
 	var out bytes.Buffer
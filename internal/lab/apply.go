@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +24,16 @@ type ApplyResult struct {
 	BW     string
 }
 
+// NodeImpairment is the netem condition last applied to a node, kept so
+// lab reconcile can restore it.
+type NodeImpairment struct {
+	Node   string `json:"node"`
+	Delay  string `json:"delay,omitempty"`
+	Loss   string `json:"loss,omitempty"`
+	Jitter string `json:"jitter,omitempty"`
+	BW     string `json:"bw,omitempty"`
+}
+
 type ClearOptions struct {
 	Node string
 }
@@ -33,15 +44,20 @@ type ClearResult struct {
 }
 
 func Apply(ctx context.Context, opts ApplyOptions) (*ApplyResult, error) {
-	return applyWithDeps(ctx, opts, defaultCreateDeps())
+	return applyWithDeps(ctx, opts, defaultCreateDeps(ctx))
 }
 
 func applyWithDeps(ctx context.Context, opts ApplyOptions, deps createDeps) (*ApplyResult, error) {
-	deps = fillCreateDeps(deps)
+	deps = fillCreateDeps(ctx, deps)
 
 	if err := validateImpairmentEnvironment(deps, "lab impairment apply"); err != nil {
 		return nil, err
 	}
+	unlock, err := lockLab(ctx, deps, "lab impair apply")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	opts.Node = strings.TrimSpace(opts.Node)
 	if opts.Node == "" {
@@ -59,27 +75,18 @@ func applyWithDeps(ctx context.Context, opts ApplyOptions, deps createDeps) (*Ap
 		return nil, err
 	}
 	opts.Node = node
-
-	args := []string{
-		"netns", "exec", opts.Node,
-		"tc", "qdisc", "replace", "dev", "eth0", "root", "netem",
-	}
-	if opts.Delay != "" {
-		args = append(args, "delay", opts.Delay)
-		if opts.Jitter != "" {
-			args = append(args, opts.Jitter)
-		}
-	}
-	if opts.Loss != "" {
-		args = append(args, "loss", opts.Loss)
-	}
-	if opts.BW != "" {
-		args = append(args, "rate", opts.BW)
+	state, err := loadImpairmentState(ctx, deps)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := deps.exec.Run(ctx, "ip", args...); err != nil {
+	imp := NodeImpairment{Node: opts.Node, Delay: opts.Delay, Loss: opts.Loss, Jitter: opts.Jitter, BW: opts.BW}
+	if err := deps.exec.Run(ctx, "ip", netemReplaceArgs(imp)...); err != nil {
 		return nil, fmt.Errorf("failed to apply impairments to %s: %w", opts.Node, err)
 	}
+	if err := recordNodeImpairment(ctx, deps, state, opts.Node, &imp); err != nil {
+		return nil, err
+	}
 
 	return &ApplyResult{
 		Node:   opts.Node,
@@ -91,38 +98,123 @@ func applyWithDeps(ctx context.Context, opts ApplyOptions, deps createDeps) (*Ap
 }
 
 func Clear(ctx context.Context, opts ClearOptions) (*ClearResult, error) {
-	return clearWithDeps(ctx, opts, defaultCreateDeps())
+	return clearWithDeps(ctx, opts, defaultCreateDeps(ctx))
 }
 
 func clearWithDeps(ctx context.Context, opts ClearOptions, deps createDeps) (*ClearResult, error) {
-	deps = fillCreateDeps(deps)
+	deps = fillCreateDeps(ctx, deps)
 
 	if err := validateImpairmentEnvironment(deps, "lab impair clear"); err != nil {
 		return nil, err
 	}
+	unlock, err := lockLab(ctx, deps, "lab impair clear")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	node, err := validateImpairmentTarget(ctx, deps, opts.Node)
 	if err != nil {
 		return nil, err
 	}
+	state, err := loadImpairmentState(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
 
 	err = deps.exec.Run(ctx, "ip", "netns", "exec", node, "tc", "qdisc", "del", "dev", "eth0", "root")
-	if err != nil {
-		if isQdiscMissingError(err) {
-			return &ClearResult{Node: node, Cleared: false}, nil
-		}
+	if err != nil && !isQdiscMissingError(err) {
 		return nil, fmt.Errorf("failed to clear impairments from %s: %w", node, err)
 	}
+	if err := recordNodeImpairment(ctx, deps, state, node, nil); err != nil {
+		return nil, err
+	}
+
+	return &ClearResult{Node: node, Cleared: err == nil}, nil
+}
+
+// loadImpairmentState loads the state that recordNodeImpairment updates. It
+// returns nil when deps cannot persist state, in which case nothing is recorded.
+func loadImpairmentState(ctx context.Context, deps createDeps) (*LabState, error) {
+	if deps.saveState == nil {
+		return nil, nil
+	}
+	return loadStateForUpdate(ctx, deps)
+}
+
+// recordNodeImpairment stores imp as the node's condition in state, or forgets
+// it when imp is nil. If the state cannot be saved, the node's qdisc is put
+// back to the condition recorded before.
+func recordNodeImpairment(ctx context.Context, deps createDeps, state *LabState, node string, imp *NodeImpairment) error {
+	if state == nil {
+		return nil
+	}
+	var previous *NodeImpairment
+	if p := findNodeImpairment(state.Impairments, node); p != nil {
+		prev := *p
+		previous = &prev
+	}
+	if imp == nil && previous == nil {
+		return nil
+	}
+	state.Impairments = removeNodeImpairment(state.Impairments, node)
+	if imp != nil {
+		state.Impairments = append(state.Impairments, *imp)
+	}
+	if err := deps.saveState(ctx, state); err != nil {
+		return errors.Join(fmt.Errorf("failed to persist lab state: %w", err), restoreNodeImpairment(ctx, deps.exec, node, previous))
+	}
+	return nil
+}
+
+// restoreNodeImpairment puts back imp on node, or removes the qdisc when imp
+// is nil.
+func restoreNodeImpairment(ctx context.Context, exec Executor, node string, imp *NodeImpairment) error {
+	if imp != nil {
+		if err := exec.Run(ctx, "ip", netemReplaceArgs(*imp)...); err != nil {
+			return fmt.Errorf("failed to restore impairments on %s: %w", node, err)
+		}
+		return nil
+	}
+	err := exec.Run(ctx, "ip", "netns", "exec", node, "tc", "qdisc", "del", "dev", "eth0", "root")
+	if err != nil && !isQdiscMissingError(err) {
+		return fmt.Errorf("failed to clear impairments from %s: %w", node, err)
+	}
+	return nil
+}
+
+func netemReplaceArgs(imp NodeImpairment) []string {
+	return append([]string{
+		"netns", "exec", imp.Node,
+		"tc", "qdisc", "replace", "dev", "eth0", "root", "netem",
+	}, netemArgs(imp.Delay, imp.Jitter, imp.Loss, imp.BW)...)
+}
+
+func findNodeImpairment(items []NodeImpairment, node string) *NodeImpairment {
+	for i := range items {
+		if items[i].Node == node {
+			return &items[i]
+		}
+	}
+	return nil
+}
 
-	return &ClearResult{Node: node, Cleared: true}, nil
+func removeNodeImpairment(items []NodeImpairment, node string) []NodeImpairment {
+	out := items[:0]
+	for _, item := range items {
+		if item.Node != node {
+			out = append(out, item)
+		}
+	}
+	return out
 }
 
 func validateImpairmentEnvironment(deps createDeps, operation string) error {
 	if deps.goos != "linux" {
 		return fmt.Errorf("%s is supported only on linux: got %s", operation, deps.goos)
 	}
-	if !deps.isRoot() {
-		return fmt.Errorf("%s requires root privileges", operation)
+	if !deps.hasNetAdmin() {
+		return fmt.Errorf("%s requires CAP_NET_ADMIN: run as root or with --rootless", operation)
 	}
 	for _, cmd := range []string{"ip", "tc"} {
 		if _, err := deps.findPath(cmd); err != nil {
@@ -167,6 +259,9 @@ func isQdiscMissingError(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrQdiscNotFound) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "cannot delete qdisc with handle of zero") ||
 		strings.Contains(msg, "no qdisc")
@@ -180,3 +275,55 @@ func containsString(items []string, target string) bool {
 	}
 	return false
 }
+
+// parseTCTime converts a tc time value to microseconds. A bare number is
+// microseconds, as in tc(8).
+func parseTCTime(s string) (uint32, bool) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"usecs", 1}, {"usec", 1}, {"us", 1},
+		{"msecs", 1e3}, {"msec", 1e3}, {"ms", 1e3},
+		{"secs", 1e6}, {"sec", 1e6}, {"s", 1e6},
+	}
+	scale := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, scale = strings.TrimSuffix(s, u.suffix), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 || v*scale > float64(^uint32(0)) {
+		return 0, false
+	}
+	return uint32(v * scale), true
+}
+
+// parseTCRate converts a tc rate value to bytes per second. A bare number is
+// bits per second, as in tc(8).
+func parseTCRate(s string) (uint64, bool) {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"kibit", 1024}, {"mibit", 1024 * 1024}, {"gibit", 1024 * 1024 * 1024},
+		{"kbit", 1e3}, {"mbit", 1e6}, {"gbit", 1e9}, {"bit", 1},
+		{"kibps", 8 * 1024}, {"mibps", 8 * 1024 * 1024}, {"gibps", 8 * 1024 * 1024 * 1024},
+		{"kbps", 8e3}, {"mbps", 8e6}, {"gbps", 8e9}, {"bps", 8},
+	}
+	lower := strings.ToLower(s)
+	scale := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, scale = strings.TrimSuffix(lower, u.suffix), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(lower, 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return uint64(v * scale / 8), true
+}
@@ -13,10 +13,10 @@ func TestApplyWithDeps_RequireLinux(t *testing.T) {
 		Node:  "node1",
 		Delay: "50ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "darwin",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "darwin",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "only on linux") {
 		t.Fatalf("expected linux-only error, got: %v", err)
@@ -29,12 +29,12 @@ func TestApplyWithDeps_RequireRoot(t *testing.T) {
 		Node:  "node1",
 		Delay: "50ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return false },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return false },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
-	if err == nil || !strings.Contains(err.Error(), "requires root privileges") {
+	if err == nil || !strings.Contains(err.Error(), "requires CAP_NET_ADMIN: run as root or with --rootless") {
 		t.Fatalf("expected root requirement error, got: %v", err)
 	}
 }
@@ -45,9 +45,9 @@ func TestApplyWithDeps_RequireCommands(t *testing.T) {
 		Node:  "node1",
 		Delay: "50ms",
 	}, createDeps{
-		exec:   ex,
-		goos:   "linux",
-		isRoot: func() bool { return true },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
 		findPath: func(name string) (string, error) {
 			if name == "tc" {
 				return "", errors.New("not found")
@@ -65,10 +65,10 @@ func TestApplyWithDeps_RequireAnyFlag(t *testing.T) {
 	_, err := applyWithDeps(context.Background(), ApplyOptions{
 		Node: "node1",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "at least one impairment flag is required") {
 		t.Fatalf("expected impairment flag requirement error, got: %v", err)
@@ -81,10 +81,10 @@ func TestApplyWithDeps_RequireDelayForJitter(t *testing.T) {
 		Node:   "node1",
 		Jitter: "10ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "jitter requires delay") {
 		t.Fatalf("expected jitter validation error, got: %v", err)
@@ -97,10 +97,10 @@ func TestApplyWithDeps_StateNotFound(t *testing.T) {
 		Node:  "node1",
 		Delay: "50ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return nil, ErrStateNotFound
 		},
@@ -116,10 +116,10 @@ func TestApplyWithDeps_NodeNotManaged(t *testing.T) {
 		Node:  "node9",
 		Delay: "50ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{Nodes: []string{"node1", "node2"}}, nil
 		},
@@ -142,10 +142,10 @@ func TestApplyWithDeps_NodeNamespaceNotFound(t *testing.T) {
 		Node:  "node1",
 		Delay: "50ms",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{Nodes: []string{"node1"}}, nil
 		},
@@ -171,10 +171,10 @@ func TestApplyWithDeps_Success(t *testing.T) {
 		Loss:   "1%",
 		BW:     "2mbit",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{Nodes: []string{"node1"}}, nil
 		},
@@ -200,10 +200,10 @@ func TestApplyWithDeps_ReapplyUsesReplace(t *testing.T) {
 		},
 	}
 	deps := createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{Nodes: []string{"node1"}}, nil
 		},
@@ -235,10 +235,10 @@ func TestClearWithDeps_RequireLinux(t *testing.T) {
 	_, err := clearWithDeps(context.Background(), ClearOptions{
 		Node: "node1",
 	}, createDeps{
-		exec:     ex,
-		goos:     "darwin",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "darwin",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "only on linux") {
 		t.Fatalf("expected linux-only error, got: %v", err)
@@ -250,12 +250,12 @@ func TestClearWithDeps_RequireRoot(t *testing.T) {
 	_, err := clearWithDeps(context.Background(), ClearOptions{
 		Node: "node1",
 	}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return false },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return false },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
-	if err == nil || !strings.Contains(err.Error(), "requires root privileges") {
+	if err == nil || !strings.Contains(err.Error(), "requires CAP_NET_ADMIN: run as root or with --rootless") {
 		t.Fatalf("expected root requirement error, got: %v", err)
 	}
 }
@@ -381,10 +381,85 @@ func validImpairmentTestDeps(ex *fakeExecutor) createDeps {
 
 func impairmentTestDeps(ex *fakeExecutor, loadState func(context.Context) (*LabState, error)) createDeps {
 	return createDeps{
-		exec:      ex,
-		goos:      "linux",
-		isRoot:    func() bool { return true },
-		findPath:  func(string) (string, error) { return "/bin/x", nil },
-		loadState: loadState,
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
+		loadState:   loadState,
+	}
+}
+
+func TestParseTCUnits(t *testing.T) {
+	times := map[string]uint32{"250": 250, "1.5ms": 1500, "2s": 2000000, "40usec": 40}
+	for in, want := range times {
+		got, ok := parseTCTime(in)
+		if !ok || got != want {
+			t.Fatalf("parseTCTime(%q) = %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	rates := map[string]uint64{"8000": 1000, "500kbit": 62500, "2mbps": 2000000, "1Mbit": 125000}
+	for in, want := range rates {
+		got, ok := parseTCRate(in)
+		if !ok || got != want {
+			t.Fatalf("parseTCRate(%q) = %d, %v; want %d", in, got, ok, want)
+		}
+	}
+	if _, ok := parseTCTime("fast"); ok {
+		t.Fatal("expected invalid time to be rejected")
+	}
+}
+
+func TestApplyAndClearWithDeps_PersistImpairment(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			if callKey(name, args...) == "ip netns list" {
+				return "node1\n", nil
+			}
+			return "", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}}
+	deps := impairmentTestDeps(ex, func(context.Context) (*LabState, error) { return state, nil })
+	deps.saveState = func(_ context.Context, s *LabState) error {
+		state = s
+		return nil
+	}
+
+	if _, err := applyWithDeps(context.Background(), ApplyOptions{Node: "node1", Delay: "80ms", Loss: "2%"}, deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(state.Impairments) != 1 || state.Impairments[0] != (NodeImpairment{Node: "node1", Delay: "80ms", Loss: "2%"}) {
+		t.Fatalf("expected impairment to be recorded, got %+v", state.Impairments)
+	}
+
+	ex.runFn = func(string, ...string) error { return errors.New("Error: Cannot delete qdisc with handle of zero.") }
+	got, err := clearWithDeps(context.Background(), ClearOptions{Node: "node1"}, deps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Cleared || len(state.Impairments) != 0 {
+		t.Fatalf("expected impairment record to be dropped, got %+v impairments=%+v", got, state.Impairments)
+	}
+}
+
+func TestApplyWithDeps_SaveFailureRestoresPreviousImpairment(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	deps := impairmentTestDeps(ex, func(context.Context) (*LabState, error) {
+		return &LabState{Nodes: []string{"node1"}, Impairments: []NodeImpairment{{Node: "node1", Delay: "20ms"}}}, nil
+	})
+	deps.saveState = func(context.Context, *LabState) error { return errors.New("disk full") }
+
+	_, err := applyWithDeps(context.Background(), ApplyOptions{Node: "node1", Loss: "5%"}, deps)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected save error, got %v", err)
+	}
+	apply := indexOfCall(ex.calls, "ip netns exec node1 tc qdisc replace dev eth0 root netem loss 5%")
+	restore := indexOfCall(ex.calls, "ip netns exec node1 tc qdisc replace dev eth0 root netem delay 20ms")
+	if apply < 0 || restore < apply {
+		t.Fatalf("expected previous netem to be restored after the failed save, calls=%v", ex.calls)
 	}
 }
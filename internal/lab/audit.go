@@ -0,0 +1,170 @@
+package lab
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuditPath = "/run/rtc-emulator/audit.jsonl"
+	auditFilename    = "audit.jsonl"
+)
+
+// AuditRecord is one executed system command. ExitStatus is -1 when the
+// command failed without exiting, for example when it was not found.
+type AuditRecord struct {
+	Time            string   `json:"time"`
+	Command         []string `json:"command"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	ExitStatus      int      `json:"exit_status"`
+	Stderr          string   `json:"stderr,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// auditExecutor appends every command it runs to the lab audit log and,
+// while a scenario runs, to the run directory too. Audit write failures never
+// fail the command itself.
+type auditExecutor struct {
+	next Executor
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	runPath string
+}
+
+func newAuditExecutor(next Executor, path string) *auditExecutor {
+	return &auditExecutor{next: next, path: path, now: time.Now}
+}
+
+func (e *auditExecutor) Run(ctx context.Context, name string, args ...string) error {
+	start := e.now()
+	err := e.next.Run(ctx, name, args...)
+	e.write(e.record(start, name, args, err))
+	return err
+}
+
+func (e *auditExecutor) Output(ctx context.Context, name string, args ...string) (string, error) {
+	start := e.now()
+	out, err := e.next.Output(ctx, name, args...)
+	e.write(e.record(start, name, args, err))
+	return out, err
+}
+
+func (e *auditExecutor) record(start time.Time, name string, args []string, err error) AuditRecord {
+	rec := AuditRecord{
+		Time:            start.UTC().Format(time.RFC3339Nano),
+		Command:         append([]string{name}, args...),
+		DurationSeconds: e.now().Sub(start).Seconds(),
+	}
+	if err == nil {
+		return rec
+	}
+	rec.ExitStatus = -1
+	rec.Error = err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		rec.ExitStatus = exitErr.ExitCode()
+	}
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) {
+		rec.Stderr = cmdErr.output
+	}
+	return rec
+}
+
+func (e *auditExecutor) write(rec AuditRecord) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_ = appendAuditRecord(e.path, rec)
+	if e.runPath != "" {
+		_ = appendAuditRecord(e.runPath, rec)
+	}
+}
+
+func appendAuditRecord(path string, rec AuditRecord) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// resetAuditLog starts a new audit log for a lab being created; the previous
+// lab's log is kept until then.
+func resetAuditLog(ex Executor) {
+	if a, ok := ex.(*auditExecutor); ok {
+		_ = os.Remove(a.path)
+	}
+}
+
+// teeAuditLog also writes audit records to path until the returned func is
+// called.
+func teeAuditLog(ex Executor, path string) func() {
+	a, ok := ex.(*auditExecutor)
+	if !ok {
+		return func() {}
+	}
+	a.mu.Lock()
+	a.runPath = path
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		a.runPath = ""
+		a.mu.Unlock()
+	}
+}
+
+// ReadAuditLog returns the records of the lab audit log, or of a scenario run
+// when runDir is set.
+func ReadAuditLog(runDir string) ([]AuditRecord, error) {
+	path := defaultAuditPath
+	if runDir != "" {
+		path = filepath.Join(runDir, auditFilename)
+	}
+	return readAuditLog(path)
+}
+
+func readAuditLog(path string) ([]AuditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no audit log at %s", path)
+		}
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log %s:%d: %w", path, lineNo, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	return records, nil
+}
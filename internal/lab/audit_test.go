@@ -0,0 +1,56 @@
+package lab
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditExecutorRecordsExitStatusAndStderr(t *testing.T) {
+	dir := t.TempDir()
+	labPath := filepath.Join(dir, "audit.jsonl")
+	runPath := filepath.Join(dir, "run", auditFilename)
+	ex := newAuditExecutor(osExecutor{}, labPath)
+	ctx := context.Background()
+
+	if err := ex.Run(ctx, "sh", "-c", "true"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stopTee := teeAuditLog(ex, runPath)
+	if _, err := ex.Output(ctx, "sh", "-c", "echo out; echo oops >&2; exit 3"); err == nil {
+		t.Fatal("expected command to fail")
+	}
+	stopTee()
+	_ = ex.Run(ctx, "rtc-emulator-missing-command")
+
+	records, err := readAuditLog(labPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	if records[0].ExitStatus != 0 || records[0].Error != "" || !reflect.DeepEqual(records[0].Command, []string{"sh", "-c", "true"}) {
+		t.Fatalf("unexpected success record: %+v", records[0])
+	}
+	if records[1].ExitStatus != 3 || records[1].Stderr != "oops" {
+		t.Fatalf("unexpected failure record: %+v", records[1])
+	}
+	if records[2].ExitStatus != -1 || records[2].Error == "" {
+		t.Fatalf("expected missing command to have exit status -1, got %+v", records[2])
+	}
+
+	runRecords, err := readAuditLog(runPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(runRecords) != 1 || runRecords[0].ExitStatus != 3 {
+		t.Fatalf("expected only the teed record in the run log, got %+v", runRecords)
+	}
+
+	resetAuditLog(ex)
+	if _, err := readAuditLog(labPath); err == nil {
+		t.Fatal("expected audit log to be removed by reset")
+	}
+}
@@ -0,0 +1,95 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	BackendExec    = "exec"
+	BackendNetlink = "netlink"
+	// BackendVNet runs scenarios on an in-process pion vnet lab; it needs no
+	// root and has no persistent lab, so only `lab scenario run` supports it.
+	BackendVNet = "vnet"
+)
+
+// Typed errors returned by the netlink backend. The exec backend still
+// reports iproute2 wording, so the is*Error helpers accept both.
+var (
+	ErrLinkNotFound      = errors.New("link not found")
+	ErrNamespaceNotFound = errors.New("network namespace not found")
+	ErrQdiscNotFound     = errors.New("root qdisc not found")
+)
+
+// Settings are the lab-wide options of one command, such as the CLI's
+// persistent flags. WithSettings attaches them to a context and the deps built
+// for that context follow them.
+type Settings struct {
+	// Backend selects how lab commands touch the kernel: exec runs ip, tc,
+	// and sysctl binaries, netlink talks rtnetlink directly and only execs the
+	// rest, and vnet runs scenarios on an in-process pion vnet router.
+	Backend string
+	// DryRun makes lab commands record the system commands they would run
+	// instead of running them; DryRunCommands reads them back. Read-only
+	// probes of the host still run so the plan matches its current state.
+	DryRun bool
+	// LockTimeout is how long a mutating lab command waits for another one
+	// to finish before it gives up. Zero fails at once when the lab is locked.
+	LockTimeout time.Duration
+
+	recorder *dryRunExecutor
+}
+
+type settingsKey struct{}
+
+// WithSettings validates s and returns a context carrying it.
+func WithSettings(ctx context.Context, s Settings) (context.Context, error) {
+	backend, err := normalizeBackend(s.Backend)
+	if err != nil {
+		return nil, err
+	}
+	if s.LockTimeout < 0 {
+		return nil, fmt.Errorf("lock timeout must not be negative: got %s", s.LockTimeout)
+	}
+	s.Backend = backend
+	s.recorder = nil
+	if s.DryRun {
+		s.recorder = &dryRunExecutor{probe: newBackendExecutor(backend), namespaces: map[string]bool{}}
+	}
+	return context.WithValue(ctx, settingsKey{}, s), nil
+}
+
+func settingsFrom(ctx context.Context) Settings {
+	s, ok := ctx.Value(settingsKey{}).(Settings)
+	if !ok {
+		return Settings{Backend: BackendExec, LockTimeout: DefaultLockTimeout}
+	}
+	return s
+}
+
+func normalizeBackend(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "", BackendExec:
+		return BackendExec, nil
+	case BackendNetlink:
+		if err := netlinkSupported(); err != nil {
+			return "", err
+		}
+		return BackendNetlink, nil
+	case BackendVNet:
+		return BackendVNet, nil
+	default:
+		return "", fmt.Errorf("unsupported backend %q: use %s, %s, or %s", name, BackendExec, BackendNetlink, BackendVNet)
+	}
+}
+
+func newBackendExecutor(backend string) Executor {
+	if backend == BackendNetlink {
+		return netlinkExecutor{fallback: osExecutor{}}
+	}
+	return osExecutor{}
+}
@@ -0,0 +1,51 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWithSettingsSelectsExecutor(t *testing.T) {
+	ctx, err := WithSettings(context.Background(), Settings{Backend: "netlink"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deps := defaultCreateDeps(ctx)
+	if deps.backend != BackendNetlink {
+		t.Fatalf("backend = %q, want netlink", deps.backend)
+	}
+	if _, ok := newBackendExecutor(deps.backend).(netlinkExecutor); !ok {
+		t.Fatalf("expected netlink executor, got %T", newBackendExecutor(deps.backend))
+	}
+	if got := defaultCreateDeps(context.Background()).backend; got != BackendExec {
+		t.Fatalf("default backend = %q, want exec", got)
+	}
+	if _, ok := newBackendExecutor(BackendExec).(osExecutor); !ok {
+		t.Fatalf("expected exec executor, got %T", newBackendExecutor(BackendExec))
+	}
+
+	_, err = WithSettings(context.Background(), Settings{Backend: "ebpf"})
+	if err == nil || !strings.Contains(err.Error(), `unsupported backend "ebpf"`) {
+		t.Fatalf("expected unsupported backend error, got %v", err)
+	}
+}
+
+func TestErrorClassifiersAcceptTypedErrors(t *testing.T) {
+	wrap := func(err error) error { return fmt.Errorf("ip [link show rtcemu0]: %w", err) }
+
+	if !isBridgeNotFoundError(wrap(ErrLinkNotFound), bridgeName) {
+		t.Fatal("expected typed link error to be classified as bridge not found")
+	}
+	if !isNamespaceNotFoundError(wrap(ErrNamespaceNotFound)) {
+		t.Fatal("expected typed namespace error to be classified as namespace not found")
+	}
+	if !isQdiscMissingError(wrap(ErrQdiscNotFound)) {
+		t.Fatal("expected typed qdisc error to be classified as qdisc missing")
+	}
+	if isQdiscMissingError(errors.New("operation not permitted")) {
+		t.Fatal("expected unrelated error not to be classified as qdisc missing")
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
@@ -22,36 +21,78 @@ const (
 var managedNodePattern = regexp.MustCompile(`^node[1-9][0-9]*$`)
 
 type CreateOptions struct {
-	Nodes int
+	Nodes    int
+	NAT      []NodeNAT
+	Topology *Topology
+	IPv6     bool
+	MTU      int
+	// FirewallBackend is auto, iptables, or nftables; empty means auto.
+	FirewallBackend string
 }
 
 type Node struct {
-	Name string
-	IP   string
+	Name      string
+	IP        string
+	IPv6      string
+	NAT       string
+	PrivateIP string
+	MTU       int
 }
 
 type CreateResult struct {
 	Bridge            string
+	Subnet            string
+	SubnetIPv6        string
 	Nodes             []Node
 	InternetReachable bool
+	// InternetCheckSkipped is set when the probe did not run (dry run).
+	InternetCheckSkipped bool
+	Routers              []string
+	Links                []LabLink
+	FirewallBackend      string
 }
 
 type createDeps struct {
 	exec        Executor
 	goos        string
-	isRoot      func() bool
+	hasNetAdmin func() bool
 	findPath    func(string) (string, error)
 	loadState   func(context.Context) (*LabState, error)
 	saveState   func(context.Context, *LabState) error
 	deleteState func(context.Context) error
+	lock        func(ctx context.Context, operation string) (func(), error)
+	// backend and dryRun come from the Settings the deps were built for;
+	// dryRun is set only when it records instead of running.
+	backend string
+	dryRun  *dryRunExecutor
 }
 
-func defaultCreateDeps() createDeps {
+func defaultCreateDeps(ctx context.Context) createDeps {
+	settings := settingsFrom(ctx)
+	if settings.recorder != nil {
+		return createDeps{
+			backend:     settings.Backend,
+			dryRun:      settings.recorder,
+			exec:        settings.recorder,
+			goos:        runtime.GOOS,
+			hasNetAdmin: func() bool { return true },
+			findPath:    exec.LookPath,
+			loadState: func(ctx context.Context) (*LabState, error) {
+				return loadState(ctx, defaultStatePath)
+			},
+			saveState:   func(context.Context, *LabState) error { return nil },
+			deleteState: func(context.Context) error { return nil },
+		}
+	}
 	return createDeps{
-		exec:     osExecutor{},
-		goos:     runtime.GOOS,
-		isRoot:   func() bool { return os.Geteuid() == 0 },
-		findPath: exec.LookPath,
+		backend: settings.Backend,
+		exec:    newAuditExecutor(newBackendExecutor(settings.Backend), defaultAuditPath),
+		lock: func(ctx context.Context, operation string) (func(), error) {
+			return acquireLabLock(ctx, defaultLockPath, operation, settings.LockTimeout)
+		},
+		goos:        runtime.GOOS,
+		hasNetAdmin: hasCapNetAdmin,
+		findPath:    exec.LookPath,
 		loadState: func(ctx context.Context) (*LabState, error) {
 			return loadState(ctx, defaultStatePath)
 		},
@@ -65,52 +106,60 @@ func defaultCreateDeps() createDeps {
 }
 
 func Create(ctx context.Context, opts CreateOptions) (*CreateResult, error) {
-	return createWithDeps(ctx, opts, defaultCreateDeps())
+	return createWithDeps(ctx, opts, defaultCreateDeps(ctx))
 }
 
 func createWithDeps(ctx context.Context, opts CreateOptions, deps createDeps) (*CreateResult, error) {
-	deps = fillCreateDeps(deps)
+	deps = fillCreateDeps(ctx, deps)
 
+	if opts.Topology != nil {
+		if len(opts.NAT) > 0 || opts.IPv6 {
+			return nil, errors.New("nat and ipv6 cannot be combined with a topology")
+		}
+		return createTopologyWithDeps(ctx, opts.Topology, opts.MTU, deps)
+	}
 	if opts.Nodes < 1 || opts.Nodes > 250 {
 		return nil, fmt.Errorf("nodes must be between 1 and 250: got %d", opts.Nodes)
 	}
-	if deps.goos != "linux" {
-		return nil, fmt.Errorf("lab create is supported only on linux: got %s", deps.goos)
+	if err := validateNodeNATs(opts.NAT, opts.Nodes); err != nil {
+		return nil, err
 	}
-	if !deps.isRoot() {
-		return nil, errors.New("lab create requires root privileges")
+	if opts.IPv6 && len(opts.NAT) > 0 {
+		return nil, errors.New("ipv6 cannot be combined with nat nodes")
 	}
-	for _, cmd := range []string{"ip", "sysctl", "iptables", "ping"} {
-		if _, err := deps.findPath(cmd); err != nil {
-			return nil, fmt.Errorf("required command %q not found: %w", cmd, err)
+	if opts.MTU != 0 {
+		if err := validateMTU(opts.MTU, opts.IPv6); err != nil {
+			return nil, err
 		}
 	}
-
-	if deps.loadState != nil {
-		if _, err := deps.loadState(ctx); err == nil {
-			return nil, errors.New("existing lab detected (state file exists): run `rtc-emulator lab destroy` and retry")
-		} else if !errors.Is(err, ErrStateNotFound) {
-			return nil, fmt.Errorf("failed to check lab state: %w", err)
-		}
+	if deps.goos != "linux" {
+		return nil, fmt.Errorf("lab create is supported only on linux: got %s", deps.goos)
 	}
-
-	bridgeExists, err := bridgeExists(ctx, deps.exec, bridgeName)
+	if !deps.hasNetAdmin() {
+		return nil, errors.New("lab create requires CAP_NET_ADMIN: run as root or with --rootless")
+	}
+	if err := requireCommands(deps, "ip", "sysctl", "ping"); err != nil {
+		return nil, err
+	}
+	firewall, err := resolveFirewallBackend(deps, opts.FirewallBackend)
 	if err != nil {
 		return nil, err
 	}
-	if bridgeExists {
-		return nil, errors.New("existing lab detected (bridge rtcemu0 already exists): run `rtc-emulator lab destroy` and retry")
+	if firewall == FirewallBackendIPTables && opts.IPv6 {
+		if err := requireCommands(deps, "ip6tables"); err != nil {
+			return nil, err
+		}
 	}
 
-	nsList, err := listNamespaces(ctx, deps.exec)
+	unlock, err := lockLab(ctx, deps, "lab create")
 	if err != nil {
 		return nil, err
 	}
-	for _, ns := range nsList {
-		if isManagedNodeName(ns) {
-			return nil, errors.New("existing lab detected (node namespace already exists): run `rtc-emulator lab destroy` and retry")
-		}
+	defer unlock()
+	if err := checkNoExistingLab(ctx, deps); err != nil {
+		return nil, err
 	}
+	resetAuditLog(deps.exec)
 
 	cleanups := make([]func(context.Context), 0, opts.Nodes+8)
 	rollback := func() {
@@ -148,90 +197,72 @@ func createWithDeps(ctx context.Context, opts CreateOptions, deps createDeps) (*
 		_ = restoreIPForward(ctx, deps.exec, ipForwardBefore)
 	})
 
-	rules := managedIPTablesRules()
-	if err := ensureIPTablesRule(ctx, deps.exec, rules[0],
-		&cleanups,
-	); err != nil {
-		rollback()
-		return nil, err
-	}
-	if err := ensureIPTablesRule(ctx, deps.exec, rules[1],
-		&cleanups,
-	); err != nil {
-		rollback()
-		return nil, err
+	var rules []IPTablesRule
+	if firewall == FirewallBackendNFTables {
+		if err := setupNFTables(ctx, deps.exec, opts.IPv6, &cleanups); err != nil {
+			rollback()
+			return nil, err
+		}
+	} else {
+		rules = managedIPTablesRules()
+		for _, rule := range rules {
+			if err := ensureIPTablesRule(ctx, deps.exec, "iptables", rule, &cleanups); err != nil {
+				rollback()
+				return nil, err
+			}
+		}
 	}
-	if err := ensureIPTablesRule(ctx, deps.exec, rules[2],
-		&cleanups,
-	); err != nil {
-		rollback()
-		return nil, err
+
+	var ipv6ForwardBefore string
+	var rules6 []IPTablesRule
+	if opts.IPv6 {
+		ipv6ForwardBefore, rules6, err = setupBridgeIPv6(ctx, deps.exec, firewall == FirewallBackendIPTables, &cleanups)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
 	}
 
 	result := &CreateResult{
-		Bridge: bridgeName,
-		Nodes:  make([]Node, 0, opts.Nodes),
+		Bridge:          bridgeName,
+		Subnet:          subnetCIDR,
+		Nodes:           make([]Node, 0, opts.Nodes),
+		FirewallBackend: firewall,
+	}
+	if opts.IPv6 {
+		result.SubnetIPv6 = subnetIPv6CIDR
 	}
+	var nats []NodeNAT
 
 	for i := 1; i <= opts.Nodes; i++ {
 		nodeName := "node" + strconv.Itoa(i)
-		nodeIP := "10.200.0." + strconv.Itoa(i+1)
-		peerHost := "br-" + nodeName
-		nsIface := "veth-" + nodeName
-
-		if err := deps.exec.Run(ctx, "ip", "netns", "add", nodeName); err != nil {
-			rollback()
-			return nil, err
-		}
-		cleanups = append(cleanups, func(ctx context.Context) {
-			_ = deps.exec.Run(ctx, "ip", "netns", "del", nodeName)
-		})
-
-		if err := deps.exec.Run(ctx, "ip", "link", "add", nsIface, "type", "veth", "peer", "name", peerHost); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "link", "set", nsIface, "netns", nodeName); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "link", "set", peerHost, "master", bridgeName); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "link", "set", peerHost, "up"); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", "lo", "up"); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", nsIface, "name", "eth0"); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "addr", "add", nodeIP+"/24", "dev", "eth0"); err != nil {
-			rollback()
-			return nil, err
-		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", "eth0", "up"); err != nil {
-			rollback()
-			return nil, err
+		if nat, ok := findNodeNAT(opts.NAT, nodeName); ok {
+			created, err := createNATNode(ctx, deps.exec, i, nat.Type, firewall, &cleanups)
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			nats = append(nats, created)
+			result.Nodes = append(result.Nodes, Node{Name: nodeName, IP: created.PublicIP, NAT: nat.Type, PrivateIP: created.PrivateIP})
+			continue
 		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "route", "add", "default", "via", bridgeIP); err != nil {
+		node, err := createBridgeNode(ctx, deps.exec, i, opts.IPv6, &cleanups)
+		if err != nil {
 			rollback()
 			return nil, err
 		}
-		if err := deps.exec.Run(ctx, "ip", "netns", "exec", nodeName, "ping", "-c", "1", "-W", "1", bridgeIP); err != nil {
-			rollback()
-			return nil, fmt.Errorf("connectivity check failed for %s -> %s: %w", nodeName, bridgeIP, err)
-		}
+		result.Nodes = append(result.Nodes, node)
+	}
 
-		result.Nodes = append(result.Nodes, Node{Name: nodeName, IP: nodeIP})
+	mtus, err := applyCreateMTU(ctx, deps.exec, &LabState{NAT: nats}, result.Nodes, opts.MTU)
+	if err != nil {
+		rollback()
+		return nil, err
 	}
 
-	if err := deps.exec.Run(ctx, "ip", "netns", "exec", "node1", "ping", "-c", "1", "-W", "1", "1.1.1.1"); err == nil {
+	if isDryRun(deps.exec) {
+		result.InternetCheckSkipped = true
+	} else if err := deps.exec.Run(ctx, "ip", "netns", "exec", "node1", "ping", "-c", "1", "-W", "1", "1.1.1.1"); err == nil {
 		result.InternetReachable = true
 	}
 
@@ -242,6 +273,18 @@ func createWithDeps(ctx context.Context, opts CreateOptions, deps createDeps) (*
 			Nodes:           make([]string, 0, len(result.Nodes)),
 			Rules:           rules,
 			IPForwardBefore: ipForwardBefore,
+			NAT:             nats,
+			MTU:             mtus,
+			CreateMTU:       opts.MTU,
+			FirewallBackend: firewall,
+		}
+		if firewall == FirewallBackendNFTables {
+			state.NFTablesTable = nftTableName
+		}
+		if opts.IPv6 {
+			state.SubnetIPv6 = subnetIPv6CIDR
+			state.RulesIPv6 = rules6
+			state.IPv6ForwardBefore = ipv6ForwardBefore
 		}
 		for _, n := range result.Nodes {
 			state.Nodes = append(state.Nodes, n.Name)
@@ -252,19 +295,108 @@ func createWithDeps(ctx context.Context, opts CreateOptions, deps createDeps) (*
 		}
 	}
 
+	recordDryRunRollback(deps.exec, rollback)
 	return result, nil
 }
 
-func fillCreateDeps(deps createDeps) createDeps {
-	d := defaultCreateDeps()
+// createBridgeNode creates namespace node<i> attached to the lab bridge and
+// registers its teardown in cleanups.
+func createBridgeNode(ctx context.Context, exec Executor, i int, ipv6 bool, cleanups *[]func(context.Context)) (Node, error) {
+	nodeName := "node" + strconv.Itoa(i)
+	nodeIP := nodeIPForIndex(i)
+	peerHost := "br-" + nodeName
+	nsIface := "veth-" + nodeName
+
+	if err := exec.Run(ctx, "ip", "netns", "add", nodeName); err != nil {
+		return Node{}, err
+	}
+	*cleanups = append(*cleanups, func(ctx context.Context) {
+		_ = exec.Run(ctx, "ip", "netns", "del", nodeName)
+	})
+
+	if err := exec.Run(ctx, "ip", "link", "add", nsIface, "type", "veth", "peer", "name", peerHost); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "link", "set", nsIface, "netns", nodeName); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "link", "set", peerHost, "master", bridgeName); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "link", "set", peerHost, "up"); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", "lo", "up"); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", nsIface, "name", "eth0"); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "addr", "add", nodeIP+"/24", "dev", "eth0"); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "link", "set", "eth0", "up"); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ip", "route", "add", "default", "via", bridgeIP); err != nil {
+		return Node{}, err
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", nodeName, "ping", "-c", "1", "-W", "1", bridgeIP); err != nil {
+		return Node{}, fmt.Errorf("connectivity check failed for %s -> %s: %w", nodeName, bridgeIP, err)
+	}
+
+	node := Node{Name: nodeName, IP: nodeIP}
+	if ipv6 {
+		node.IPv6 = nodeIPv6ForIndex(i)
+		if err := configureNodeIPv6(ctx, exec, nodeName, node.IPv6); err != nil {
+			return Node{}, err
+		}
+	}
+	return node, nil
+}
+
+func checkNoExistingLab(ctx context.Context, deps createDeps) error {
+	if deps.loadState != nil {
+		if _, err := deps.loadState(ctx); err == nil {
+			return errors.New("existing lab detected (state file exists): run `rtc-emulator lab destroy` and retry")
+		} else if !errors.Is(err, ErrStateNotFound) {
+			return fmt.Errorf("failed to check lab state: %w", err)
+		}
+	}
+
+	bridgeExists, err := bridgeExists(ctx, deps.exec, bridgeName)
+	if err != nil {
+		return err
+	}
+	if bridgeExists {
+		return errors.New("existing lab detected (bridge rtcemu0 already exists): run `rtc-emulator lab destroy` and retry")
+	}
+
+	nsList, err := listNamespaces(ctx, deps.exec)
+	if err != nil {
+		return err
+	}
+	for _, ns := range nsList {
+		if isManagedNodeName(ns) || isManagedNATNamespace(ns) || isManagedRouterNamespace(ns) {
+			return errors.New("existing lab detected (node namespace already exists): run `rtc-emulator lab destroy` and retry")
+		}
+	}
+	return nil
+}
+
+func fillCreateDeps(ctx context.Context, deps createDeps) createDeps {
+	d := defaultCreateDeps(ctx)
+	if deps.backend == "" {
+		deps.backend = d.backend
+	}
 	if deps.exec == nil {
 		deps.exec = d.exec
 	}
 	if deps.goos == "" {
 		deps.goos = d.goos
 	}
-	if deps.isRoot == nil {
-		deps.isRoot = d.isRoot
+	if deps.hasNetAdmin == nil {
+		deps.hasNetAdmin = d.hasNetAdmin
 	}
 	if deps.findPath == nil {
 		deps.findPath = d.findPath
@@ -287,6 +419,21 @@ func isManagedNodeName(ns string) bool {
 	return managedNodePattern.MatchString(ns)
 }
 
+func nodeIPForIndex(i int) string {
+	return "10.200.0." + strconv.Itoa(i+1)
+}
+
+func managedNodeIP(node string) (string, error) {
+	i, err := nodeIndex(node)
+	if err != nil {
+		return "", err
+	}
+	if i > 250 {
+		return "", fmt.Errorf("node %q is outside the lab subnet", node)
+	}
+	return nodeIPForIndex(i), nil
+}
+
 func listNamespaces(ctx context.Context, exec Executor) ([]string, error) {
 	out, err := exec.Output(ctx, "ip", "netns", "list")
 	if err != nil {
@@ -311,19 +458,20 @@ func listNamespaces(ctx context.Context, exec Executor) ([]string, error) {
 func ensureIPTablesRule(
 	ctx context.Context,
 	exec Executor,
+	command string,
 	rule IPTablesRule,
 	cleanups *[]func(context.Context),
 ) error {
-	if err := exec.Run(ctx, "iptables", rule.CheckArgs...); err == nil {
+	if err := exec.Run(ctx, command, rule.CheckArgs...); err == nil {
 		return nil
 	} else if !isIPTablesRuleNotFoundError(err) {
-		return fmt.Errorf("failed to check iptables rule %v: %w", rule.CheckArgs, err)
+		return fmt.Errorf("failed to check %s rule %v: %w", command, rule.CheckArgs, err)
 	}
-	if err := exec.Run(ctx, "iptables", rule.AddArgs...); err != nil {
+	if err := exec.Run(ctx, command, rule.AddArgs...); err != nil {
 		return err
 	}
 	*cleanups = append(*cleanups, func(ctx context.Context) {
-		_ = exec.Run(ctx, "iptables", rule.DelArgs...)
+		_ = exec.Run(ctx, command, rule.DelArgs...)
 	})
 	return nil
 }
@@ -352,6 +500,9 @@ func isBridgeNotFoundError(err error, bridge string) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrLinkNotFound) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, `device "`+strings.ToLower(bridge)+`" does not exist`) ||
 		strings.Contains(msg, "does not exist") ||
@@ -371,31 +522,42 @@ func isNamespaceNotFoundError(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return true
+	}
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "no such file or directory")
 }
 
 func readIPForward(ctx context.Context, exec Executor) (string, error) {
-	out, err := exec.Output(ctx, "sysctl", "-n", "net.ipv4.ip_forward")
+	return readSysctlFlag(ctx, exec, "net.ipv4.ip_forward")
+}
+
+func restoreIPForward(ctx context.Context, exec Executor, value string) error {
+	return restoreSysctlFlag(ctx, exec, "net.ipv4.ip_forward", value)
+}
+
+func readSysctlFlag(ctx context.Context, exec Executor, key string) (string, error) {
+	out, err := exec.Output(ctx, "sysctl", "-n", key)
 	if err != nil {
-		return "", fmt.Errorf("failed to read net.ipv4.ip_forward: %w", err)
+		return "", fmt.Errorf("failed to read %s: %w", key, err)
 	}
 	v := strings.TrimSpace(out)
 	if v != "0" && v != "1" {
-		return "", fmt.Errorf("unexpected net.ipv4.ip_forward value: %q", v)
+		return "", fmt.Errorf("unexpected %s value: %q", key, v)
 	}
 	return v, nil
 }
 
-func restoreIPForward(ctx context.Context, exec Executor, value string) error {
+func restoreSysctlFlag(ctx context.Context, exec Executor, key string, value string) error {
 	if value == "" {
 		return nil
 	}
 	if value != "0" && value != "1" {
-		return fmt.Errorf("invalid net.ipv4.ip_forward restore value: %q", value)
+		return fmt.Errorf("invalid %s restore value: %q", key, value)
 	}
-	if err := exec.Run(ctx, "sysctl", "-w", "net.ipv4.ip_forward="+value); err != nil {
-		return fmt.Errorf("failed to restore net.ipv4.ip_forward=%s: %w", value, err)
+	if err := exec.Run(ctx, "sysctl", "-w", key+"="+value); err != nil {
+		return fmt.Errorf("failed to restore %s=%s: %w", key, value, err)
 	}
 	return nil
 }
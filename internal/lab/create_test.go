@@ -43,21 +43,25 @@ func containsArg(args []string, target string) bool {
 }
 
 func hasCall(calls []string, want string) bool {
-	for _, c := range calls {
+	return indexOfCall(calls, want) >= 0
+}
+
+func indexOfCall(calls []string, want string) int {
+	for i, c := range calls {
 		if c == want {
-			return true
+			return i
 		}
 	}
-	return false
+	return -1
 }
 
 func TestCreateWithDeps_ValidateNodes(t *testing.T) {
 	ex := &fakeExecutor{}
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 0}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "between 1 and 250") {
 		t.Fatalf("expected nodes validation error, got: %v", err)
@@ -67,10 +71,10 @@ func TestCreateWithDeps_ValidateNodes(t *testing.T) {
 func TestCreateWithDeps_RequireLinux(t *testing.T) {
 	ex := &fakeExecutor{}
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "darwin",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "darwin",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "only on linux") {
 		t.Fatalf("expected linux-only error, got: %v", err)
@@ -88,10 +92,10 @@ func TestCreateWithDeps_ExistingBridgeFails(t *testing.T) {
 	}
 
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "existing lab detected") {
 		t.Fatalf("expected existing-lab error, got: %v", err)
@@ -109,10 +113,10 @@ func TestCreateWithDeps_BridgeCheckError(t *testing.T) {
 	}
 
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "failed to check bridge existence") {
 		t.Fatalf("expected strict bridge check error, got: %v", err)
@@ -147,10 +151,10 @@ func TestCreateWithDeps_Success(t *testing.T) {
 	}
 
 	got, err := createWithDeps(context.Background(), CreateOptions{Nodes: 2}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -200,10 +204,10 @@ func TestCreateWithDeps_RollbackOnFailure(t *testing.T) {
 	}
 
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "connectivity check failed") {
 		t.Fatalf("expected connectivity failure, got: %v", err)
@@ -255,10 +259,10 @@ func TestCreateWithDeps_IPTablesCheckError(t *testing.T) {
 	}
 
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "failed to check iptables rule") {
 		t.Fatalf("expected strict iptables check error, got: %v", err)
@@ -284,10 +288,10 @@ func TestCreateWithDeps_NodeNamespaceFilter(t *testing.T) {
 		},
 	}
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || strings.Contains(err.Error(), "existing lab detected (node namespace") {
 		t.Fatalf("unexpected node namespace conflict: %v", err)
@@ -310,12 +314,178 @@ func TestCreateWithDeps_ManagedNodeNamespaceDetected(t *testing.T) {
 		},
 	}
 	_, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "existing lab detected (node namespace") {
 		t.Fatalf("expected node namespace detection error, got: %v", err)
 	}
 }
+
+func TestCreateWithDeps_NATNodeUsesOwnNamespace(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			if name == "iptables" && containsArg(args, "-C") {
+				return errors.New("Bad rule (does a matching rule exist in that chain?)")
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			if callKey(name, args...) == "sysctl -n net.ipv4.ip_forward" {
+				return "0\n", nil
+			}
+			return "", nil
+		},
+	}
+	var saved *LabState
+
+	got, err := createWithDeps(context.Background(), CreateOptions{
+		Nodes: 2,
+		NAT:   []NodeNAT{{Node: "node2", Type: NATSymmetric}},
+		MTU:   1300,
+	}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
+		saveState: func(_ context.Context, state *LabState) error {
+			saved = state
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Nodes[1].NAT != NATSymmetric || got.Nodes[1].IP != "10.200.0.3" || got.Nodes[1].PrivateIP != "10.201.2.2" {
+		t.Fatalf("unexpected nat node: %+v", got.Nodes[1])
+	}
+	for _, want := range []string{
+		"ip netns add nat-node2",
+		"ip link set veth-node2 netns nat-node2",
+		"ip netns exec nat-node2 ip addr add 10.200.0.3/24 dev wan0",
+		"ip netns exec nat-node2 iptables -t nat -A POSTROUTING -o wan0 -j MASQUERADE --random",
+		"ip netns exec node2 ip route add default via 10.201.2.1",
+		"ip netns exec node2 ping -c 1 -W 1 10.200.0.1",
+		"ip link set br-node1 mtu 1300",
+		"ip netns exec nat-node2 ip link set lan0 mtu 1300",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if hasCall(ex.calls, "ip netns add nat-node1") {
+		t.Fatalf("node1 should not get a nat namespace")
+	}
+	if saved == nil || len(saved.NAT) != 1 || saved.NAT[0].Namespace != "nat-node2" || len(saved.MTU) != 2 {
+		t.Fatalf("expected nat in saved state, got %+v", saved)
+	}
+}
+
+func TestCreateWithDeps_IPv6AddsDualStackAddresses(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			if (name == "iptables" || name == "ip6tables") && containsArg(args, "-C") {
+				return errors.New("Bad rule (does a matching rule exist in that chain?)")
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			switch callKey(name, args...) {
+			case "sysctl -n net.ipv4.ip_forward":
+				return "1\n", nil
+			case "sysctl -n net.ipv6.conf.all.forwarding":
+				return "0\n", nil
+			}
+			return "", nil
+		},
+	}
+	var saved *LabState
+
+	got, err := createWithDeps(context.Background(), CreateOptions{Nodes: 2, IPv6: true}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
+		saveState: func(_ context.Context, state *LabState) error {
+			saved = state
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.SubnetIPv6 != "fd00:200::/64" || got.Nodes[1].IP != "10.200.0.3" || got.Nodes[1].IPv6 != "fd00:200::3" {
+		t.Fatalf("unexpected dual-stack result: %+v", got)
+	}
+	for _, want := range []string{
+		"ip -6 addr add fd00:200::1/64 dev rtcemu0 nodad",
+		"sysctl -w net.ipv6.conf.all.forwarding=1",
+		"ip6tables -t nat -A POSTROUTING -s fd00:200::/64 ! -o rtcemu0 -j MASQUERADE",
+		"ip netns exec node2 ip -6 addr add fd00:200::3/64 dev eth0 nodad",
+		"ip netns exec node2 ip -6 route add default via fd00:200::1",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if saved == nil || saved.IPv6ForwardBefore != "0" || len(saved.RulesIPv6) != 3 {
+		t.Fatalf("expected ipv6 state to be saved, got %+v", saved)
+	}
+}
+
+func TestParseNodeNAT(t *testing.T) {
+	got, err := ParseNodeNAT("node1=Full-Cone")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Node != "node1" || got.Type != NATFullCone {
+		t.Fatalf("unexpected nat: %+v", got)
+	}
+	for spec, want := range map[string]string{
+		"node1":         "use NODE=TYPE",
+		"node1=carrier": `unsupported nat type "carrier"`,
+		"=symmetric":    "use NODE=TYPE",
+	} {
+		if _, err := ParseNodeNAT(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("ParseNodeNAT(%q) error = %v, want %q", spec, err, want)
+		}
+	}
+	err = validateNodeNATs([]NodeNAT{{Node: "node3", Type: NATSymmetric}}, 2)
+	if err == nil || !strings.Contains(err.Error(), "not one of the 2 created nodes") {
+		t.Fatalf("expected out-of-range nat node error, got %v", err)
+	}
+}
+
+func TestNATRuleCommandsFollowFirewallBackend(t *testing.T) {
+	nat := NodeNAT{Node: "node2", Type: NATFullCone, Namespace: "nat-node2", PrivateIP: "10.201.2.2"}
+	var got []string
+	for _, cmd := range natRuleCommands(nat, FirewallBackendNFTables) {
+		got = append(got, strings.Join(cmd, " "))
+	}
+	for _, want := range []string{
+		"ip netns exec nat-node2 nft add table inet rtc-emulator",
+		"ip netns exec nat-node2 nft add rule inet rtc-emulator postrouting oifname wan0 masquerade",
+		"ip netns exec nat-node2 nft add rule inet rtc-emulator prerouting iifname wan0 dnat ip to 10.201.2.2",
+	} {
+		if !hasCall(got, want) {
+			t.Fatalf("missing %q in %v", want, got)
+		}
+	}
+
+	nat.Type = NATSymmetric
+	got = nil
+	for _, cmd := range natRuleCommands(nat, FirewallBackendIPTables) {
+		got = append(got, strings.Join(cmd, " "))
+	}
+	if len(got) != 1 || got[0] != "ip netns exec nat-node2 iptables -t nat -A POSTROUTING -o wan0 -j MASQUERADE --random" {
+		t.Fatalf("unexpected iptables nat commands %v", got)
+	}
+}
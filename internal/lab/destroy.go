@@ -4,35 +4,51 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 )
 
+var managedHostLinkPattern = regexp.MustCompile(`^(br|veth|lan|eth)-node[1-9][0-9]*$|^rte[0-9]+[ab]$`)
+
+type DestroyOptions struct {
+	// Force lets the fallback without a state file delete managed
+	// namespaces and lab links that are not bridge peers.
+	Force bool
+}
+
 type DestroyResult struct {
 	BridgeDeleted         bool
 	NodesDeleted          []string
 	StateMissingFallback  bool
 	IPForwardRestored     bool
 	IPForwardRestoreValue string
+	// Found and Removed list what the fallback without a state file matched
+	// and what it deleted.
+	Found   []string
+	Removed []string
 }
 
-func Destroy(ctx context.Context) (*DestroyResult, error) {
-	return destroyWithDeps(ctx, defaultCreateDeps())
+func Destroy(ctx context.Context, opts DestroyOptions) (*DestroyResult, error) {
+	return destroyWithDeps(ctx, opts, defaultCreateDeps(ctx))
 }
 
-func destroyWithDeps(ctx context.Context, deps createDeps) (*DestroyResult, error) {
-	deps = fillCreateDeps(deps)
+func destroyWithDeps(ctx context.Context, opts DestroyOptions, deps createDeps) (*DestroyResult, error) {
+	deps = fillCreateDeps(ctx, deps)
 
 	if deps.goos != "linux" {
 		return nil, fmt.Errorf("lab destroy is supported only on linux: got %s", deps.goos)
 	}
-	if !deps.isRoot() {
-		return nil, errors.New("lab destroy requires root privileges")
+	if !deps.hasNetAdmin() {
+		return nil, errors.New("lab destroy requires CAP_NET_ADMIN: run as root or with --rootless")
 	}
-	for _, cmd := range []string{"ip", "iptables"} {
-		if _, err := deps.findPath(cmd); err != nil {
-			return nil, fmt.Errorf("required command %q not found: %w", cmd, err)
-		}
+	if err := requireCommands(deps, "ip"); err != nil {
+		return nil, err
 	}
+	unlock, err := lockLab(ctx, deps, "lab destroy")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	result := &DestroyResult{
 		NodesDeleted: make([]string, 0),
@@ -40,17 +56,43 @@ func destroyWithDeps(ctx context.Context, deps createDeps) (*DestroyResult, erro
 
 	if deps.loadState == nil {
 		result.StateMissingFallback = true
-		return destroyFallbackWithoutState(ctx, deps, result)
+		return destroyFallbackWithoutState(ctx, deps, opts.Force, result)
 	}
 
 	state, err := deps.loadState(ctx)
 	if errors.Is(err, ErrStateNotFound) {
 		result.StateMissingFallback = true
-		return destroyFallbackWithoutState(ctx, deps, result)
+		return destroyFallbackWithoutState(ctx, deps, opts.Force, result)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to load lab state: %w", err)
 	}
+	if len(state.Rules) > 0 {
+		if err := requireCommands(deps, "iptables"); err != nil {
+			return nil, err
+		}
+	}
+	if len(state.RulesIPv6) > 0 {
+		if err := requireCommands(deps, "ip6tables"); err != nil {
+			return nil, err
+		}
+	}
+	for _, fw := range state.Firewalls {
+		if err := requireCommands(deps, nodeFirewallCommands(fw)...); err != nil {
+			return nil, err
+		}
+	}
+	if state.NFTablesTable != "" {
+		if err := requireCommands(deps, "nft"); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fw := range state.Firewalls {
+		if err := removeNodeFirewall(ctx, deps.exec, fw); err != nil && !isNamespaceNotFoundError(err) {
+			return nil, err
+		}
+	}
 
 	for _, ns := range state.Nodes {
 		runErr := deps.exec.Run(ctx, "ip", "netns", "del", ns)
@@ -62,6 +104,20 @@ func destroyWithDeps(ctx context.Context, deps createDeps) (*DestroyResult, erro
 		}
 	}
 
+	for _, ns := range state.Routers {
+		runErr := deps.exec.Run(ctx, "ip", "netns", "del", ns)
+		if runErr != nil && !isNamespaceNotFoundError(runErr) {
+			return nil, runErr
+		}
+	}
+
+	for _, nat := range state.NAT {
+		runErr := deps.exec.Run(ctx, "ip", "netns", "del", nat.Namespace)
+		if runErr != nil && !isNamespaceNotFoundError(runErr) {
+			return nil, runErr
+		}
+	}
+
 	targetBridge := state.Bridge
 	if targetBridge == "" {
 		targetBridge = bridgeName
@@ -81,13 +137,26 @@ func destroyWithDeps(ctx context.Context, deps createDeps) (*DestroyResult, erro
 	}
 
 	for _, rule := range state.Rules {
-		if err := deleteIPTablesRuleAll(ctx, deps.exec, rule); err != nil {
+		if err := deleteIPTablesRuleAll(ctx, deps.exec, "iptables", rule); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range state.RulesIPv6 {
+		if err := deleteIPTablesRuleAll(ctx, deps.exec, "ip6tables", rule); err != nil {
+			return nil, err
+		}
+	}
+	if state.NFTablesTable != "" {
+		if err := deleteNFTablesTable(ctx, deps.exec, state.NFTablesTable); err != nil {
 			return nil, err
 		}
 	}
 	if err := restoreIPForward(ctx, deps.exec, state.IPForwardBefore); err != nil {
 		return nil, err
 	}
+	if err := restoreIPv6Forward(ctx, deps.exec, state.IPv6ForwardBefore); err != nil {
+		return nil, err
+	}
 	if state.IPForwardBefore != "" {
 		result.IPForwardRestored = true
 		result.IPForwardRestoreValue = state.IPForwardBefore
@@ -101,21 +170,70 @@ func destroyWithDeps(ctx context.Context, deps createDeps) (*DestroyResult, erro
 	return result, nil
 }
 
-func destroyFallbackWithoutState(ctx context.Context, deps createDeps, result *DestroyResult) (*DestroyResult, error) {
-	members, bridgeFound, err := listBridgeMembers(ctx, deps.exec, bridgeName)
+// destroyFallbackWithoutState cleans up a lab whose state file is gone, so it
+// only matches resources by name. Without force it removes the bridge, the
+// br-node peers, and the host rules, and only reports namespaces and other lab
+// links, which a name alone does not prove the lab created.
+func destroyFallbackWithoutState(ctx context.Context, deps createDeps, force bool, result *DestroyResult) (*DestroyResult, error) {
+	_, iptErr := deps.findPath("iptables")
+	_, nftErr := deps.findPath("nft")
+	if iptErr != nil && nftErr != nil {
+		return nil, fmt.Errorf("required command %q or %q not found: %w", "iptables", "nft", iptErr)
+	}
+	found := func(item string) { result.Found = append(result.Found, item) }
+	removed := func(item string) { result.Removed = append(result.Removed, item) }
+
+	namespaces, err := listNamespaces(ctx, deps.exec)
 	if err != nil {
 		return nil, err
 	}
-	for _, member := range members {
-		if !isManagedBridgePeer(member) {
+	for _, ns := range namespaces {
+		if !isManagedNodeName(ns) && !isManagedNATNamespace(ns) && !isManagedRouterNamespace(ns) && ns != doctorProbeNamespace {
 			continue
 		}
-		if err := deps.exec.Run(ctx, "ip", "link", "del", member); err != nil && !isBridgeNotFoundError(err, member) {
+		found("namespace " + ns)
+		if !force {
+			continue
+		}
+		if err := deps.exec.Run(ctx, "ip", "netns", "del", ns); err != nil && !isNamespaceNotFoundError(err) {
+			return nil, fmt.Errorf("failed to delete namespace %s: %w", ns, err)
+		}
+		removed("namespace " + ns)
+		if isManagedNodeName(ns) {
+			result.NodesDeleted = append(result.NodesDeleted, ns)
+		}
+	}
+
+	out, err := deps.exec.Output(ctx, "ip", "-o", "link", "show")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		link, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		if !isManagedHostLink(link) {
+			continue
+		}
+		found("link " + link)
+		if !force && !isManagedBridgePeer(link) {
+			continue
+		}
+		// Deleting a namespace or one end of a veth also removes its peer.
+		if err := deps.exec.Run(ctx, "ip", "link", "del", link); err != nil && !isBridgeNotFoundError(err, link) {
 			return nil, err
 		}
+		removed("link " + link)
 	}
 
+	bridgeFound, err := bridgeExists(ctx, deps.exec, bridgeName)
+	if err != nil {
+		return nil, err
+	}
 	if bridgeFound {
+		found("bridge " + bridgeName)
 		if err := deps.exec.Run(ctx, "ip", "link", "set", bridgeName, "down"); err != nil {
 			return nil, err
 		}
@@ -123,17 +241,74 @@ func destroyFallbackWithoutState(ctx context.Context, deps createDeps, result *D
 			return nil, err
 		}
 		result.BridgeDeleted = true
+		removed("bridge " + bridgeName)
 	}
 
-	for _, rule := range managedIPTablesRules() {
-		if err := deleteIPTablesRuleAll(ctx, deps.exec, rule); err != nil {
+	deleteRules := func(command string, rules []IPTablesRule) error {
+		for _, rule := range rules {
+			if err := deps.exec.Run(ctx, command, rule.CheckArgs...); err != nil {
+				if isIPTablesRuleNotFoundError(err) {
+					continue
+				}
+				return fmt.Errorf("failed to check %s rule %v: %w", command, rule.CheckArgs, err)
+			}
+			item := command + " " + strings.Join(rule.AddArgs, " ")
+			found(item)
+			if err := deps.exec.Run(ctx, command, rule.DelArgs...); err != nil && !isIPTablesRuleNotFoundError(err) {
+				return fmt.Errorf("failed to delete %s rule %v: %w", command, rule.DelArgs, err)
+			}
+			if err := deleteIPTablesRuleAll(ctx, deps.exec, command, rule); err != nil {
+				return err
+			}
+			removed(item)
+		}
+		return nil
+	}
+	if iptErr == nil {
+		if err := deleteRules("iptables", append(managedIPTablesRules(), handoverMasqueradeRule())); err != nil {
+			return nil, err
+		}
+	}
+	if nftErr == nil {
+		if err := deps.exec.Run(ctx, "nft", "list", "table", nftTableFamily, nftTableName); err == nil {
+			item := "nftables table " + nftTableFamily + " " + nftTableName
+			found(item)
+			if err := deleteNFTablesTable(ctx, deps.exec, nftTableName); err != nil {
+				return nil, err
+			}
+			removed(item)
+		}
+	}
+	if _, err := deps.findPath("ip6tables"); err == nil {
+		if err := deleteRules("ip6tables", managedIP6TablesRules()); err != nil {
 			return nil, err
 		}
 	}
 
+	// The value before the lab was saved in the state file, so forwarding
+	// is reported but left as is.
+	if v, err := readIPForward(ctx, deps.exec); err == nil && v == "1" {
+		found("sysctl net.ipv4.ip_forward=1")
+	}
 	return result, nil
 }
 
+// isManagedHostLink matches the links a lab leaves in the host namespace: the
+// bridge peers, and veth ends of nodes and topology links that were not moved
+// into a namespace yet.
+func isManagedHostLink(name string) bool {
+	return managedHostLinkPattern.MatchString(name)
+}
+
+func requireCommands(deps createDeps, cmds ...string) error {
+	for _, cmd := range cmds {
+		if _, err := deps.findPath(cmd); err != nil {
+			return fmt.Errorf("required command %q not found: %w", cmd, err)
+		}
+	}
+	return nil
+}
+
 func listBridgeMembers(ctx context.Context, exec Executor, bridge string) ([]string, bool, error) {
 	exists, err := bridgeExists(ctx, exec, bridge)
 	if err != nil {
@@ -172,20 +347,20 @@ func isManagedBridgePeer(name string) bool {
 	return isManagedNodeName(nodeSuffix)
 }
 
-func deleteIPTablesRuleAll(ctx context.Context, exec Executor, rule IPTablesRule) error {
+func deleteIPTablesRuleAll(ctx context.Context, exec Executor, command string, rule IPTablesRule) error {
 	for {
-		err := exec.Run(ctx, "iptables", rule.CheckArgs...)
+		err := exec.Run(ctx, command, rule.CheckArgs...)
 		if err != nil {
 			if isIPTablesRuleNotFoundError(err) {
 				return nil
 			}
-			return fmt.Errorf("failed to check iptables rule %v: %w", rule.CheckArgs, err)
+			return fmt.Errorf("failed to check %s rule %v: %w", command, rule.CheckArgs, err)
 		}
-		if err := exec.Run(ctx, "iptables", rule.DelArgs...); err != nil {
+		if err := exec.Run(ctx, command, rule.DelArgs...); err != nil {
 			if isIPTablesRuleNotFoundError(err) {
 				return nil
 			}
-			return fmt.Errorf("failed to delete iptables rule %v: %w", rule.DelArgs, err)
+			return fmt.Errorf("failed to delete %s rule %v: %w", command, rule.DelArgs, err)
 		}
 	}
 }
@@ -9,11 +9,11 @@ import (
 
 func TestDestroyWithDeps_RequireLinux(t *testing.T) {
 	ex := &fakeExecutor{}
-	_, err := destroyWithDeps(context.Background(), createDeps{
-		exec:     ex,
-		goos:     "darwin",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+	_, err := destroyWithDeps(context.Background(), DestroyOptions{}, createDeps{
+		exec:        ex,
+		goos:        "darwin",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 	})
 	if err == nil || !strings.Contains(err.Error(), "only on linux") {
 		t.Fatalf("expected linux-only error, got: %v", err)
@@ -29,7 +29,7 @@ func TestDestroyWithDeps_StateNotFoundFallback(t *testing.T) {
 		case "ip link show rtcemu0":
 			return nil
 		}
-		if name == "iptables" && containsArg(args, "-C") {
+		if (name == "iptables" || name == "ip6tables") && containsArg(args, "-C") {
 			checkCount[cmd]++
 			if checkCount[cmd] > 1 {
 				return errors.New("Bad rule (does a matching rule exist in that chain?)")
@@ -38,18 +38,13 @@ func TestDestroyWithDeps_StateNotFoundFallback(t *testing.T) {
 		}
 		return nil
 	}
-	ex.outputFn = func(name string, args ...string) (string, error) {
-		if callKey(name, args...) == "ip -o link show master rtcemu0" {
-			return "7: br-node1@if8: <BROADCAST> mtu 1500 master rtcemu0 state UP mode DEFAULT group default\n8: cni123@if2: <BROADCAST> mtu 1500 master rtcemu0 state UP mode DEFAULT group default\n", nil
-		}
-		return "", nil
-	}
+	ex.outputFn = fallbackTestOutput
 
-	got, err := destroyWithDeps(context.Background(), createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+	got, err := destroyWithDeps(context.Background(), DestroyOptions{}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return nil, ErrStateNotFound
 		},
@@ -63,8 +58,11 @@ func TestDestroyWithDeps_StateNotFoundFallback(t *testing.T) {
 	if !got.BridgeDeleted {
 		t.Fatalf("expected bridge cleanup in fallback")
 	}
-	if hasCall(ex.calls, "ip netns del node1") {
-		t.Fatalf("fallback must not delete namespaces")
+	if hasCall(ex.calls, "ip netns del node1") || hasCall(ex.calls, "ip link del rte1a") {
+		t.Fatalf("fallback must not delete namespaces or non-peer links without force")
+	}
+	if !containsString(got.Found, "namespace node1") || containsString(got.Removed, "namespace node1") {
+		t.Fatalf("expected node1 to be reported but kept, found=%v removed=%v", got.Found, got.Removed)
 	}
 	if !hasCall(ex.calls, "ip link del br-node1") {
 		t.Fatalf("expected managed bridge peer deletion")
@@ -72,6 +70,64 @@ func TestDestroyWithDeps_StateNotFoundFallback(t *testing.T) {
 	if hasCall(ex.calls, "ip link del cni123") {
 		t.Fatalf("must not delete non-managed bridge member")
 	}
+	if !hasCall(ex.calls, "ip6tables -D FORWARD -i rtcemu0 -j ACCEPT") {
+		t.Fatalf("expected managed ip6tables rule deletion in fallback")
+	}
+}
+
+func fallbackTestOutput(name string, args ...string) (string, error) {
+	switch callKey(name, args...) {
+	case "ip netns list":
+		return "node1 (id: 0)\nnat-node2\nother\n", nil
+	case "ip -o link show":
+		return "7: br-node1@if8: <BROADCAST> mtu 1500 master rtcemu0 state UP mode DEFAULT group default\n8: cni123@if2: <BROADCAST> mtu 1500 master rtcemu0 state UP mode DEFAULT group default\n9: rte1a@rte1b: <BROADCAST> mtu 1500\n", nil
+	}
+	return "", nil
+}
+
+func TestDestroyWithDeps_StateNotFoundFallbackForce(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if (name == "iptables" || name == "ip6tables") && containsArg(args, "-C") {
+				return errors.New("Bad rule (does a matching rule exist in that chain?)")
+			}
+			if name == "nft" {
+				return errors.New("Error: No such file or directory")
+			}
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			return nil
+		},
+		outputFn: fallbackTestOutput,
+	}
+
+	got, err := destroyWithDeps(context.Background(), DestroyOptions{Force: true}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
+		loadState: func(context.Context) (*LabState, error) {
+			return nil, ErrStateNotFound
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"ip netns del node1", "ip netns del nat-node2", "ip link del br-node1", "ip link del rte1a"} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing %q, calls=%v", want, ex.calls)
+		}
+	}
+	if hasCall(ex.calls, "ip netns del other") || hasCall(ex.calls, "ip link del cni123") {
+		t.Fatalf("must not delete unmanaged resources, calls=%v", ex.calls)
+	}
+	if len(got.NodesDeleted) != 1 || got.NodesDeleted[0] != "node1" {
+		t.Fatalf("unexpected nodes deleted: %+v", got.NodesDeleted)
+	}
+	if len(got.Found) != len(got.Removed) || containsString(got.Found, "bridge rtcemu0") {
+		t.Fatalf("expected every found item removed and no bridge, found=%v removed=%v", got.Found, got.Removed)
+	}
 }
 
 func TestDestroyWithDeps_Success(t *testing.T) {
@@ -86,7 +142,7 @@ func TestDestroyWithDeps_Success(t *testing.T) {
 				return errors.New("No such file or directory")
 			}
 
-			if name == "iptables" && containsArg(args, "-C") {
+			if (name == "iptables" || name == "ip6tables") && containsArg(args, "-C") {
 				checkCount[cmd]++
 				if checkCount[cmd] > 1 {
 					return errors.New("Bad rule (does a matching rule exist in that chain?)")
@@ -98,17 +154,26 @@ func TestDestroyWithDeps_Success(t *testing.T) {
 	}
 
 	deletedState := false
-	got, err := destroyWithDeps(context.Background(), createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+	got, err := destroyWithDeps(context.Background(), DestroyOptions{}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{
-				Bridge:          bridgeName,
-				Nodes:           []string{"node1", "node9"},
-				Rules:           managedIPTablesRules(),
-				IPForwardBefore: "0",
+				Bridge:            bridgeName,
+				Nodes:             []string{"node1", "node9"},
+				Rules:             managedIPTablesRules(),
+				IPForwardBefore:   "0",
+				NAT:               []NodeNAT{{Node: "node1", Type: NATSymmetric, Namespace: "nat-node1"}},
+				Routers:           []string{"rtr-core"},
+				RulesIPv6:         managedIP6TablesRules(),
+				IPv6ForwardBefore: "0",
+				Firewalls: []NodeFirewall{{
+					Node:    "node1",
+					Profile: FirewallBlockUDP,
+					Rules:   []IPTablesRule{firewallRule("OUTPUT", "-p", "udp", "-j", "DROP")},
+				}},
 			}, nil
 		},
 		deleteState: func(context.Context) error {
@@ -134,9 +199,24 @@ func TestDestroyWithDeps_Success(t *testing.T) {
 	if !hasCall(ex.calls, "sysctl -w net.ipv4.ip_forward=0") {
 		t.Fatalf("expected ip_forward restore command")
 	}
+	if !hasCall(ex.calls, "sysctl -w net.ipv6.conf.all.forwarding=0") {
+		t.Fatalf("expected ipv6 forwarding restore command")
+	}
+	if !hasCall(ex.calls, "ip6tables -t nat -D POSTROUTING -s fd00:200::/64 ! -o rtcemu0 -j MASQUERADE") {
+		t.Fatalf("expected ip6tables rule deletion")
+	}
 	if !hasCall(ex.calls, "ip netns del node1") {
 		t.Fatalf("expected node1 deletion")
 	}
+	if !hasCall(ex.calls, "ip netns exec node1 iptables -D OUTPUT -p udp -j DROP") {
+		t.Fatalf("expected firewall rule deletion")
+	}
+	if !hasCall(ex.calls, "ip netns del nat-node1") {
+		t.Fatalf("expected nat namespace deletion")
+	}
+	if !hasCall(ex.calls, "ip netns del rtr-core") {
+		t.Fatalf("expected router namespace deletion")
+	}
 }
 
 func TestDestroyWithDeps_BridgeCheckError(t *testing.T) {
@@ -149,11 +229,11 @@ func TestDestroyWithDeps_BridgeCheckError(t *testing.T) {
 		},
 	}
 
-	_, err := destroyWithDeps(context.Background(), createDeps{
-		exec:     ex,
-		goos:     "linux",
-		isRoot:   func() bool { return true },
-		findPath: func(string) (string, error) { return "/bin/x", nil },
+	_, err := destroyWithDeps(context.Background(), DestroyOptions{}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
 		loadState: func(context.Context) (*LabState, error) {
 			return &LabState{
 				Bridge:          bridgeName,
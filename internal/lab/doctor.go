@@ -0,0 +1,309 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DoctorOK   = "ok"
+	DoctorWarn = "warn"
+	DoctorFail = "fail"
+
+	doctorProbeNamespace = "rtcemu-doctor"
+)
+
+// DoctorCheck is one preflight or health check. Fix is a command or step that
+// resolves a warn or fail status.
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+type DoctorResult struct {
+	Checks []DoctorCheck `json:"checks"`
+}
+
+// Failures returns the number of checks with fail status.
+func (r *DoctorResult) Failures() int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == DoctorFail {
+			n++
+		}
+	}
+	return n
+}
+
+type doctorDeps struct {
+	readFile func(string) ([]byte, error)
+	stat     func(string) error
+}
+
+// Doctor checks that this host can run a lab and that any existing lab
+// matches its state file. It only reads, apart from a throwaway namespace used
+// to probe for netem when the module cannot be found on disk.
+func Doctor(ctx context.Context) (*DoctorResult, error) {
+	deps := defaultCreateDeps(ctx)
+	deps.exec = newBackendExecutor(deps.backend)
+	return doctorWithDeps(ctx, deps, doctorDeps{
+		readFile: os.ReadFile,
+		stat: func(path string) error {
+			_, err := os.Stat(path)
+			return err
+		},
+	})
+}
+
+func doctorWithDeps(ctx context.Context, deps createDeps, dd doctorDeps) (*DoctorResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+	result := &DoctorResult{}
+	add := func(c DoctorCheck) { result.Checks = append(result.Checks, c) }
+
+	if deps.goos != "linux" {
+		add(DoctorCheck{Name: "platform", Status: DoctorFail, Detail: "labs need linux: got " + deps.goos})
+		return result, nil
+	}
+	add(DoctorCheck{Name: "platform", Status: DoctorOK, Detail: "linux"})
+
+	privileged := deps.hasNetAdmin()
+	add(doctorPrivilegeCheck(privileged, deps, dd))
+
+	var missing []string
+	for _, cmd := range []string{"ip", "tc", "sysctl", "ping"} {
+		if _, err := deps.findPath(cmd); err != nil {
+			missing = append(missing, cmd)
+		}
+	}
+	if len(missing) > 0 {
+		add(DoctorCheck{Name: "binaries", Status: DoctorFail, Detail: "missing " + strings.Join(missing, ", "), Fix: "install iproute2 and iputils-ping"})
+	} else {
+		add(DoctorCheck{Name: "binaries", Status: DoctorOK, Detail: "ip, tc, sysctl, ping found"})
+	}
+	add(doctorFirewallCheck(ctx, deps))
+
+	netem := doctorModuleCheck("sch_netem", DoctorFail, dd, "")
+	if netem.Status != DoctorOK && privileged && len(missing) == 0 {
+		netem = doctorProbeNetem(ctx, deps.exec, netem)
+	}
+	add(netem)
+	add(doctorModuleCheck("ifb", DoctorWarn, dd, "/sys/class/net/ifb0"))
+	add(doctorModuleCheck("br_netfilter", DoctorWarn, dd, "/proc/sys/net/bridge"))
+
+	for _, c := range doctorSysctlChecks(dd) {
+		add(c)
+	}
+
+	if len(missing) == 0 {
+		for _, c := range doctorLabChecks(ctx, deps) {
+			add(c)
+		}
+	}
+	return result, nil
+}
+
+func doctorPrivilegeCheck(privileged bool, deps createDeps, dd doctorDeps) DoctorCheck {
+	if privileged {
+		return DoctorCheck{Name: "privileges", Status: DoctorOK, Detail: "CAP_NET_ADMIN"}
+	}
+	check := DoctorCheck{Name: "privileges", Status: DoctorFail, Detail: "no CAP_NET_ADMIN", Fix: "run with sudo"}
+	_, unshareErr := deps.findPath("unshare")
+	_, nsenterErr := deps.findPath("nsenter")
+	b, err := dd.readFile("/proc/sys/user/max_user_namespaces")
+	if unshareErr == nil && nsenterErr == nil && err == nil && strings.TrimSpace(string(b)) != "0" {
+		check.Fix = "run with sudo, or use lab --rootless"
+	}
+	return check
+}
+
+func doctorFirewallCheck(ctx context.Context, deps createDeps) DoctorCheck {
+	backend, err := resolveFirewallBackend(deps, FirewallBackendAuto)
+	if err != nil {
+		return DoctorCheck{Name: "firewall", Status: DoctorFail, Detail: err.Error(), Fix: "install iptables or nftables"}
+	}
+	check := DoctorCheck{Name: "firewall", Status: DoctorOK, Detail: "backend " + backend}
+	if backend == FirewallBackendIPTables {
+		if _, err := deps.findPath("ip6tables"); err != nil {
+			check.Status = DoctorWarn
+			check.Detail += "; ip6tables missing, needed for --ipv6"
+			check.Fix = "install ip6tables or use --firewall-backend nftables"
+		}
+	}
+	if _, err := deps.findPath("nft"); err == nil && forwardPolicyDrops(ctx, deps.exec) {
+		check.Status = DoctorWarn
+		check.Detail += "; ip filter FORWARD has policy drop, so --firewall-backend nftables cannot forward lab traffic"
+		check.Fix = "use --firewall-backend iptables (the auto default when iptables is installed)"
+	}
+	return check
+}
+
+// forwardPolicyDrops reports whether the `ip filter FORWARD` chain, as left by
+// Docker or iptables-nft, drops packets that no rule accepts.
+func forwardPolicyDrops(ctx context.Context, exec Executor) bool {
+	out, err := exec.Output(ctx, "nft", "list", "chain", "ip", "filter", "FORWARD")
+	return err == nil && strings.Contains(out, "policy drop")
+}
+
+// doctorModuleCheck looks for a kernel module in /sys/module, then in the
+// modules.builtin and modules.dep lists of the running kernel. present is a
+// path that exists only when the feature is built in or loaded.
+func doctorModuleCheck(name string, severity string, dd doctorDeps, present string) DoctorCheck {
+	check := DoctorCheck{Name: "module " + name, Status: DoctorOK}
+	if dd.stat("/sys/module/"+name) == nil || (present != "" && dd.stat(present) == nil) {
+		check.Detail = "loaded"
+		return check
+	}
+	release, _ := dd.readFile("/proc/sys/kernel/osrelease")
+	dir := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+	if b, err := dd.readFile(filepath.Join(dir, "modules.builtin")); err == nil && moduleListed(b, name) {
+		check.Detail = "built in"
+		return check
+	}
+	if b, err := dd.readFile(filepath.Join(dir, "modules.dep")); err == nil && moduleListed(b, name) {
+		check.Detail = "available, loaded on first use"
+		return check
+	}
+	check.Status = severity
+	check.Detail = "not found for kernel " + strings.TrimSpace(string(release))
+	check.Fix = fmt.Sprintf("install the kernel modules package (for example linux-modules-extra-$(uname -r)) and run sudo modprobe %s", name)
+	return check
+}
+
+func moduleListed(list []byte, name string) bool {
+	for _, line := range strings.Split(string(list), "\n") {
+		path, _, _ := strings.Cut(line, ":")
+		base := filepath.Base(strings.TrimSpace(path))
+		if base == name+".ko" || strings.HasPrefix(base, name+".ko.") {
+			return true
+		}
+	}
+	return false
+}
+
+// doctorProbeNetem installs netem on loopback in a throwaway namespace; the
+// module may be built in without showing up in /sys/module.
+func doctorProbeNetem(ctx context.Context, exec Executor, notFound DoctorCheck) DoctorCheck {
+	if err := exec.Run(ctx, "ip", "netns", "add", doctorProbeNamespace); err != nil {
+		return notFound
+	}
+	defer func() { _ = exec.Run(ctx, "ip", "netns", "del", doctorProbeNamespace) }()
+	err := exec.Run(ctx, "ip", "netns", "exec", doctorProbeNamespace, "tc", "qdisc", "add", "dev", "lo", "root", "netem", "delay", "1ms")
+	if err != nil {
+		notFound.Detail = "tc cannot create a netem qdisc: " + err.Error()
+		return notFound
+	}
+	return DoctorCheck{Name: notFound.Name, Status: DoctorOK, Detail: "built in"}
+}
+
+func doctorSysctlChecks(dd doctorDeps) []DoctorCheck {
+	read := func(key string) (string, bool) {
+		b, err := dd.readFile("/proc/sys/" + strings.ReplaceAll(key, ".", "/"))
+		return strings.TrimSpace(string(b)), err == nil
+	}
+	var checks []DoctorCheck
+	if v, ok := read("net.ipv4.ip_forward"); ok {
+		checks = append(checks, DoctorCheck{Name: "sysctl net.ipv4.ip_forward", Status: DoctorOK, Detail: v + "; lab create enables it and destroy restores it"})
+	}
+	if v, ok := read("net.ipv6.conf.all.disable_ipv6"); ok && v == "1" {
+		checks = append(checks, DoctorCheck{Name: "sysctl net.ipv6.conf.all.disable_ipv6", Status: DoctorWarn, Detail: "IPv6 is disabled, so --ipv6 labs fail", Fix: "sudo sysctl -w net.ipv6.conf.all.disable_ipv6=0"})
+	}
+	if v, ok := read("net.bridge.bridge-nf-call-iptables"); ok && v == "1" {
+		checks = append(checks, DoctorCheck{Name: "sysctl net.bridge.bridge-nf-call-iptables", Status: DoctorOK, Detail: "1; traffic between nodes passes the host FORWARD chain, which lab create allows"})
+	}
+	return checks
+}
+
+// doctorLabChecks compares the state file with the namespaces and bridge
+// peers on the host.
+func doctorLabChecks(ctx context.Context, deps createDeps) []DoctorCheck {
+	namespaces, err := listNamespaces(ctx, deps.exec)
+	if err != nil {
+		return []DoctorCheck{{Name: "lab", Status: DoctorFail, Detail: err.Error()}}
+	}
+	var managed []string
+	for _, ns := range namespaces {
+		if isManagedNodeName(ns) || isManagedNATNamespace(ns) || isManagedRouterNamespace(ns) || ns == doctorProbeNamespace {
+			managed = append(managed, ns)
+		}
+	}
+	var peers []string
+	if out, err := deps.exec.Output(ctx, "ip", "-o", "link", "show"); err == nil {
+		peers = managedBridgePeers(out)
+	}
+	bridge, _ := bridgeExists(ctx, deps.exec, bridgeName)
+
+	state, err := deps.loadState(ctx)
+	switch {
+	case errors.Is(err, ErrStateNotFound):
+		var leftovers []string
+		leftovers = append(leftovers, managed...)
+		leftovers = append(leftovers, peers...)
+		if bridge {
+			leftovers = append(leftovers, bridgeName)
+		}
+		if len(leftovers) > 0 {
+			return []DoctorCheck{{Name: "lab", Status: DoctorFail, Detail: "no lab state, but found leftover " + strings.Join(leftovers, ", "), Fix: "sudo rtc-emulator lab destroy --force"}}
+		}
+		return []DoctorCheck{{Name: "lab", Status: DoctorOK, Detail: "no lab running"}}
+	case err != nil:
+		return []DoctorCheck{{Name: "lab", Status: DoctorWarn, Detail: "cannot read lab state: " + err.Error(), Fix: "run lab doctor with sudo"}}
+	}
+
+	expected := append([]string(nil), state.Nodes...)
+	expected = append(expected, state.Routers...)
+	for _, nat := range state.NAT {
+		expected = append(expected, nat.Namespace)
+	}
+	var problems []string
+	for _, ns := range expected {
+		if !containsString(namespaces, ns) {
+			problems = append(problems, "namespace "+ns+" is missing")
+		}
+	}
+	for _, ns := range managed {
+		if !containsString(expected, ns) {
+			problems = append(problems, "namespace "+ns+" is not in the state file")
+		}
+	}
+	for _, peer := range peers {
+		if !containsString(state.Nodes, strings.TrimPrefix(peer, "br-")) {
+			problems = append(problems, "veth "+peer+" is not in the state file")
+		}
+	}
+	if state.Bridge != "" && !bridge {
+		problems = append(problems, "bridge "+state.Bridge+" is missing")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		fix := "sudo rtc-emulator lab reconcile"
+		if len(state.Links) > 0 {
+			// Reconcile refuses topology labs, so they can only be rebuilt.
+			fix = "sudo rtc-emulator lab destroy, then create the lab again"
+		}
+		return []DoctorCheck{{Name: "lab", Status: DoctorFail, Detail: strings.Join(problems, "; "), Fix: fix}}
+	}
+	return []DoctorCheck{{Name: "lab", Status: DoctorOK, Detail: "state matches " + strconv.Itoa(len(state.Nodes)) + " node(s)"}}
+}
+
+func managedBridgePeers(linkShow string) []string {
+	var peers []string
+	for _, line := range strings.Split(linkShow, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		if isManagedBridgePeer(name) {
+			peers = append(peers, name)
+		}
+	}
+	return peers
+}
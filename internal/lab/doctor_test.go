@@ -0,0 +1,135 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func doctorTestDeps(files map[string]string) doctorDeps {
+	return doctorDeps{
+		readFile: func(path string) ([]byte, error) {
+			if v, ok := files[path]; ok {
+				return []byte(v), nil
+			}
+			return nil, os.ErrNotExist
+		},
+		stat: func(path string) error {
+			if _, ok := files[path]; ok {
+				return nil
+			}
+			return os.ErrNotExist
+		},
+	}
+}
+
+func findDoctorCheck(t *testing.T, result *DoctorResult, name string) DoctorCheck {
+	t.Helper()
+	for _, c := range result.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	t.Fatalf("missing check %q in %+v", name, result.Checks)
+	return DoctorCheck{}
+}
+
+func TestDoctorWithDeps_ReportsLeftoversAndModules(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			switch callKey(name, args...) {
+			case "ip netns list":
+				return "node3 (id: 0)\nother\n", nil
+			case "ip -o link show":
+				return "1: lo: <LOOPBACK,UP> mtu 65536\n7: br-node3@if2: <BROADCAST> mtu 1500\n", nil
+			case "nft list chain ip filter FORWARD":
+				return "table ip filter {\n\tchain FORWARD {\n\t\ttype filter hook forward priority filter; policy drop;\n\t}\n}\n", nil
+			}
+			return "", nil
+		},
+	}
+	deps := createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(cmd string) (string, error) { return "/bin/" + cmd, nil },
+		loadState:   func(context.Context) (*LabState, error) { return nil, ErrStateNotFound },
+	}
+	files := map[string]string{
+		"/sys/module/br_netfilter":                 "",
+		"/proc/sys/kernel/osrelease":               "6.8.0\n",
+		"/lib/modules/6.8.0/modules.dep":           "kernel/net/sched/sch_netem.ko.zst: \n",
+		"/proc/sys/net/ipv6/conf/all/disable_ipv6": "1\n",
+	}
+
+	got, err := doctorWithDeps(context.Background(), deps, doctorTestDeps(files))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c := findDoctorCheck(t, got, "module sch_netem"); c.Status != DoctorOK || c.Detail != "available, loaded on first use" {
+		t.Fatalf("unexpected netem check: %+v", c)
+	}
+	if c := findDoctorCheck(t, got, "module ifb"); c.Status != DoctorWarn || !strings.Contains(c.Fix, "modprobe ifb") {
+		t.Fatalf("unexpected ifb check: %+v", c)
+	}
+	if c := findDoctorCheck(t, got, "sysctl net.ipv6.conf.all.disable_ipv6"); c.Status != DoctorWarn {
+		t.Fatalf("unexpected ipv6 check: %+v", c)
+	}
+	if c := findDoctorCheck(t, got, "firewall"); c.Status != DoctorWarn || !strings.Contains(c.Detail, "policy drop") {
+		t.Fatalf("expected forward policy warning, got %+v", c)
+	}
+	c := findDoctorCheck(t, got, "lab")
+	if c.Status != DoctorFail || c.Detail != "no lab state, but found leftover node3, br-node3" {
+		t.Fatalf("unexpected lab check: %+v", c)
+	}
+	if got.Failures() != 1 {
+		t.Fatalf("expected 1 failure, got %d", got.Failures())
+	}
+	if hasCall(ex.calls, "ip netns add rtcemu-doctor") {
+		t.Fatalf("netem probe should not run when the module is on disk: %v", ex.calls)
+	}
+}
+
+func TestDoctorWithDeps_ComparesStateWithNamespaces(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			if callKey(name, args...) == "ip netns list" {
+				return "node1\nnode3\n", nil
+			}
+			return "", nil
+		},
+	}
+	deps := createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return false },
+		findPath:    func(cmd string) (string, error) { return "/bin/" + cmd, nil },
+		loadState: func(context.Context) (*LabState, error) {
+			return &LabState{Bridge: bridgeName, Nodes: []string{"node1", "node2"}}, nil
+		},
+	}
+	files := map[string]string{
+		"/sys/module/sch_netem":              "",
+		"/proc/sys/user/max_user_namespaces": "1000\n",
+	}
+
+	got, err := doctorWithDeps(context.Background(), deps, doctorTestDeps(files))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c := findDoctorCheck(t, got, "privileges"); c.Status != DoctorFail || c.Fix != "run with sudo, or use lab --rootless" {
+		t.Fatalf("unexpected privileges check: %+v", c)
+	}
+	want := "namespace node2 is missing; namespace node3 is not in the state file"
+	if c := findDoctorCheck(t, got, "lab"); c.Status != DoctorFail || c.Detail != want || c.Fix != "sudo rtc-emulator lab reconcile" {
+		t.Fatalf("expected %q with a reconcile fix, got %+v", want, c)
+	}
+}
@@ -0,0 +1,149 @@
+package lab
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DryRunCommand is one system command a dry run would have executed.
+// Rollback commands run only if a later step of the same command fails.
+type DryRunCommand struct {
+	Command  []string `json:"command"`
+	Rollback bool     `json:"rollback,omitempty"`
+}
+
+// DryRunCommands returns the commands recorded so far for a context whose
+// Settings enable DryRun, in order.
+func DryRunCommands(ctx context.Context) []DryRunCommand {
+	rec := settingsFrom(ctx).recorder
+	if rec == nil {
+		return nil
+	}
+	return rec.recorded()
+}
+
+type dryRunExecutor struct {
+	probe Executor
+
+	mu       sync.Mutex
+	commands []DryRunCommand
+	rollback bool
+	// namespaces added during the dry run do not exist, so probes inside
+	// them are recorded rather than run.
+	namespaces map[string]bool
+}
+
+func (e *dryRunExecutor) Run(ctx context.Context, name string, args ...string) error {
+	if e.isProbe(name, args) {
+		return e.probe.Run(ctx, name, args...)
+	}
+	e.record(name, args)
+	return nil
+}
+
+func (e *dryRunExecutor) Output(ctx context.Context, name string, args ...string) (string, error) {
+	if e.isProbe(name, args) {
+		return e.probe.Output(ctx, name, args...)
+	}
+	e.record(name, args)
+	return "", nil
+}
+
+func (e *dryRunExecutor) recorded() []DryRunCommand {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]DryRunCommand(nil), e.commands...)
+}
+
+func (e *dryRunExecutor) record(name string, args []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if name == "ip" && len(args) >= 3 && args[0] == "netns" && args[1] == "add" {
+		e.namespaces[args[2]] = true
+	}
+	e.commands = append(e.commands, DryRunCommand{
+		Command:  append([]string{name}, args...),
+		Rollback: e.rollback,
+	})
+}
+
+// recordRollback records the commands run by a command's rollback, which
+// a successful dry run would otherwise never show.
+func (e *dryRunExecutor) recordRollback(rollback func()) {
+	e.mu.Lock()
+	e.rollback = true
+	e.mu.Unlock()
+	rollback()
+	e.mu.Lock()
+	e.rollback = false
+	e.mu.Unlock()
+}
+
+func recordDryRunRollback(exec Executor, rollback func()) {
+	if rec, ok := exec.(*dryRunExecutor); ok {
+		rec.recordRollback(rollback)
+	}
+}
+
+// isDryRun reports whether exec only records commands, so probes of what
+// they would have done cannot be trusted.
+func isDryRun(exec Executor) bool {
+	_, ok := exec.(*dryRunExecutor)
+	return ok
+}
+
+func (e *dryRunExecutor) isProbe(name string, args []string) bool {
+	if name == "ip" && len(args) >= 4 && args[0] == "netns" && args[1] == "exec" {
+		e.mu.Lock()
+		added := e.namespaces[args[2]]
+		e.mu.Unlock()
+		return !added && e.isProbe(args[3], args[4:])
+	}
+	switch name {
+	case "sysctl":
+		return len(args) > 0 && args[0] == "-n"
+	case "iptables", "ip6tables":
+		for _, arg := range args {
+			if arg == "-C" || arg == "-S" || arg == "-L" || arg == "-V" {
+				return true
+			}
+		}
+	case "nft":
+		return len(args) > 0 && args[0] == "list"
+	case "tc":
+		return containsString(args, "show")
+	case "ip":
+		for i, arg := range args {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			return i+1 >= len(args) || args[i+1] == "show" || args[i+1] == "list" || args[i+1] == "ls"
+		}
+	}
+	return false
+}
+
+// dryRunScenarioDeps keeps a dry-run scenario from sleeping, starting peers,
+// or writing run logs.
+func dryRunScenarioDeps(deps scenarioRunDeps, rec *dryRunExecutor) scenarioRunDeps {
+	deps.dryRun = true
+	deps.sleep = func(time.Duration) {}
+	deps.mkdirAll = func(string, os.FileMode) error { return nil }
+	deps.openFile = func(string, int, os.FileMode) (io.WriteCloser, error) {
+		return discardCloser{}, nil
+	}
+	deps.runCommand = func(_ context.Context, name string, args []string, _ io.Writer, _ io.Writer) error {
+		rec.record(name, args)
+		return nil
+	}
+	return deps
+}
+
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardCloser) Close() error                { return nil }
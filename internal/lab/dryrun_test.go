@@ -0,0 +1,116 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDryRunExecutorPassesOnlyProbesThrough(t *testing.T) {
+	probe := &fakeExecutor{}
+	rec := &dryRunExecutor{probe: probe, namespaces: map[string]bool{}}
+	ctx := context.Background()
+
+	for _, c := range [][]string{
+		{"ip", "link", "show", "rtcemu0"},
+		{"ip", "-o", "link", "show", "master", "rtcemu0"},
+		{"ip", "netns", "list"},
+		{"sysctl", "-n", "net.ipv4.ip_forward"},
+		{"iptables", "-t", "nat", "-C", "POSTROUTING", "-j", "MASQUERADE"},
+		{"ip", "netns", "exec", "node1", "tc", "qdisc", "show", "dev", "eth0"},
+		{"ip", "netns", "add", "node2"},
+		{"ip", "netns", "exec", "node2", "sysctl", "-n", "net.ipv6.conf.all.forwarding"},
+		{"sysctl", "-w", "net.ipv4.ip_forward=1"},
+		{"ip", "netns", "exec", "node1", "ping", "-c", "1", "10.200.0.1"},
+	} {
+		_ = rec.Run(ctx, c[0], c[1:]...)
+	}
+
+	if len(probe.calls) != 6 {
+		t.Fatalf("expected 6 probes to run, got %v", probe.calls)
+	}
+	var recorded []string
+	for _, c := range rec.recorded() {
+		recorded = append(recorded, strings.Join(c.Command, " "))
+	}
+	want := []string{
+		"ip netns add node2",
+		"ip netns exec node2 sysctl -n net.ipv6.conf.all.forwarding",
+		"sysctl -w net.ipv4.ip_forward=1",
+		"ip netns exec node1 ping -c 1 10.200.0.1",
+	}
+	if strings.Join(recorded, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected recorded commands %v, got %v", want, recorded)
+	}
+}
+
+func TestCreateWithDeps_DryRunRecordsRollback(t *testing.T) {
+	probe := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			return errors.New("Bad rule (does a matching rule exist in that chain?)")
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			if name == "sysctl" {
+				return "0\n", nil
+			}
+			return "", nil
+		},
+	}
+	rec := &dryRunExecutor{probe: probe, namespaces: map[string]bool{}}
+	deps := createDeps{
+		exec:        rec,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath: func(cmd string) (string, error) {
+			if cmd == "nft" {
+				return "", errors.New("not found")
+			}
+			return "/bin/" + cmd, nil
+		},
+		loadState: func(context.Context) (*LabState, error) { return nil, ErrStateNotFound },
+		saveState: func(context.Context, *LabState) error { return nil },
+	}
+
+	result, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1}, deps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.InternetReachable || !result.InternetCheckSkipped {
+		t.Fatalf("expected the internet check to be skipped, got %+v", result)
+	}
+	commands := rec.recorded()
+	if got := strings.Join(commands[0].Command, " "); got != "ip link add rtcemu0 type bridge" || commands[0].Rollback {
+		t.Fatalf("unexpected first command: %+v", commands[0])
+	}
+	last := commands[len(commands)-1]
+	if got := strings.Join(last.Command, " "); got != "ip link del rtcemu0" || !last.Rollback {
+		t.Fatalf("expected bridge deletion as last rollback command, got %+v", last)
+	}
+	for _, c := range probe.calls {
+		if strings.Contains(c, " add ") || strings.Contains(c, "-w") {
+			t.Fatalf("mutating command reached the system: %s", c)
+		}
+	}
+}
+
+func TestWithSettingsDryRunBuildsRecordingDeps(t *testing.T) {
+	ctx, err := WithSettings(context.Background(), Settings{DryRun: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deps := defaultCreateDeps(ctx)
+	if deps.dryRun == nil || deps.exec != Executor(deps.dryRun) || deps.lock != nil {
+		t.Fatalf("expected recording deps without a lock, got %+v", deps)
+	}
+	_ = deps.exec.Run(ctx, "ip", "netns", "add", "node1")
+	if got := DryRunCommands(ctx); len(got) != 1 || strings.Join(got[0].Command, " ") != "ip netns add node1" {
+		t.Fatalf("unexpected recorded commands: %+v", got)
+	}
+	if got := DryRunCommands(context.Background()); got != nil {
+		t.Fatalf("expected no commands without dry-run settings, got %+v", got)
+	}
+}
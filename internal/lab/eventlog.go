@@ -23,6 +23,12 @@ type EventRecord struct {
 	Condition ImpairmentCondition `json:"condition"`
 	Status    string              `json:"status"`
 	Error     string              `json:"error"`
+
+	ReconnectSeconds  float64 `json:"reconnect_seconds,omitempty"`
+	Firewall          string  `json:"firewall,omitempty"`
+	MTU               int     `json:"mtu,omitempty"`
+	IP                string  `json:"ip,omitempty"`
+	DisruptionSeconds float64 `json:"disruption_seconds,omitempty"`
 }
 
 type ImpairmentCondition struct {
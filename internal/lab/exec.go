@@ -15,15 +15,31 @@ type Executor interface {
 
 type osExecutor struct{}
 
+// commandError is a failed command with what it printed: combined output
+// for Run, stderr for Output.
+type commandError struct {
+	name   string
+	args   []string
+	output string
+	err    error
+}
+
+func (e *commandError) Error() string {
+	if e.output == "" {
+		return fmt.Sprintf("%s %v: %v", e.name, e.args, e.err)
+	}
+	return fmt.Sprintf("%s %v: %s (%v)", e.name, e.args, e.output, e.err)
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
+
 func (e osExecutor) Run(ctx context.Context, name string, args ...string) error {
 	cmd := exec.CommandContext(ctx, name, args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		msg := strings.TrimSpace(string(out))
-		if msg == "" {
-			return fmt.Errorf("%s %v: %w", name, args, err)
-		}
-		return fmt.Errorf("%s %v: %s (%w)", name, args, msg, err)
+		return &commandError{name: name, args: args, output: strings.TrimSpace(string(out)), err: err}
 	}
 	return nil
 }
@@ -36,11 +52,7 @@ func (e osExecutor) Output(ctx context.Context, name string, args ...string) (st
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
-		msg := strings.TrimSpace(stderr.String())
-		if msg == "" {
-			return "", fmt.Errorf("%s %v: %w", name, args, err)
-		}
-		return "", fmt.Errorf("%s %v: %s (%w)", name, args, msg, err)
+		return "", &commandError{name: name, args: args, output: strings.TrimSpace(stderr.String()), err: err}
 	}
 
 	return stdout.String(), nil
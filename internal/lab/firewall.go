@@ -0,0 +1,399 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	FirewallBlockUDP     = "block-udp"
+	FirewallAllow443Only = "allow-443-only"
+	FirewallBlockSTUN    = "block-stun"
+)
+
+type FirewallApplyOptions struct {
+	Node    string
+	Profile string
+}
+
+type FirewallApplyResult struct {
+	Node    string
+	Profile string
+	Rules   int
+}
+
+type FirewallClearOptions struct {
+	Node string
+}
+
+type FirewallClearResult struct {
+	Node    string
+	Profile string
+	Cleared bool
+}
+
+type NodeFirewall struct {
+	Node    string         `json:"node"`
+	Profile string         `json:"profile"`
+	Rules   []IPTablesRule `json:"rules"`
+	// RulesIPv6 holds the ip6tables copy of Rules on dual-stack labs.
+	RulesIPv6 []IPTablesRule `json:"rules_ipv6,omitempty"`
+	// NFTables is set when the profile lives in the node's own nftables
+	// table instead of Rules and RulesIPv6.
+	NFTables bool `json:"nftables,omitempty"`
+}
+
+func ApplyFirewall(ctx context.Context, opts FirewallApplyOptions) (*FirewallApplyResult, error) {
+	return applyFirewallWithDeps(ctx, opts, defaultCreateDeps(ctx))
+}
+
+func applyFirewallWithDeps(ctx context.Context, opts FirewallApplyOptions, deps createDeps) (*FirewallApplyResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if err := validateFirewallEnvironment(deps, "lab firewall apply"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab firewall apply")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	opts.Profile = strings.ToLower(strings.TrimSpace(opts.Profile))
+	rules, err := firewallProfileRules(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	node, err := validateImpairmentTarget(ctx, deps, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	fw := NodeFirewall{Node: node, Profile: opts.Profile, NFTables: state.FirewallBackend == FirewallBackendNFTables}
+	var rules6 []IPTablesRule
+	switch {
+	case fw.NFTables:
+		err = requireCommands(deps, "nft")
+	case state.SubnetIPv6 != "":
+		err = requireCommands(deps, "iptables", "ip6tables")
+		rules6 = rules
+	default:
+		err = requireCommands(deps, "iptables")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *NodeFirewall
+	i := indexOfFirewall(state.Firewalls, node)
+	if i >= 0 {
+		prev := state.Firewalls[i]
+		previous = &prev
+	}
+	// nftables chains are named after the profile, so reapplying the same
+	// profile replaces its chains in place instead of adding a second copy.
+	inPlace := previous != nil && previous.NFTables && fw.NFTables && previous.Profile == fw.Profile
+	if inPlace {
+		if err := deleteNodeFirewallRules(ctx, deps.exec, *previous); err != nil {
+			return nil, err
+		}
+	}
+
+	// The new rules go in before the old ones come out, so replacing a
+	// profile never leaves the node unfiltered.
+	if err := addNodeFirewallRules(ctx, deps.exec, &fw, rules, rules6); err != nil {
+		return nil, fmt.Errorf("failed to apply firewall profile %s to %s: %w", opts.Profile, node, err)
+	}
+	if previous != nil && !inPlace {
+		if err := deleteNodeFirewallRules(ctx, deps.exec, *previous); err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("failed to remove firewall profile %s from %s: %w", previous.Profile, node, err),
+				deleteNodeFirewallRules(ctx, deps.exec, fw),
+			)
+		}
+	}
+	if i >= 0 {
+		state.Firewalls = append(state.Firewalls[:i], state.Firewalls[i+1:]...)
+	}
+	state.Firewalls = append(state.Firewalls, fw)
+	if err := deps.saveState(ctx, state); err != nil {
+		var rollbackErr error
+		if !inPlace {
+			if previous != nil {
+				restored := NodeFirewall{Node: node, Profile: previous.Profile, NFTables: previous.NFTables}
+				rollbackErr = addNodeFirewallRules(ctx, deps.exec, &restored, previous.Rules, previous.RulesIPv6)
+			}
+			rollbackErr = errors.Join(rollbackErr, deleteNodeFirewallRules(ctx, deps.exec, fw))
+		}
+		return nil, errors.Join(fmt.Errorf("failed to persist lab state: %w", err), rollbackErr)
+	}
+
+	return &FirewallApplyResult{Node: node, Profile: opts.Profile, Rules: len(rules)}, nil
+}
+
+func ClearFirewall(ctx context.Context, opts FirewallClearOptions) (*FirewallClearResult, error) {
+	return clearFirewallWithDeps(ctx, opts, defaultCreateDeps(ctx))
+}
+
+func clearFirewallWithDeps(ctx context.Context, opts FirewallClearOptions, deps createDeps) (*FirewallClearResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if err := validateFirewallEnvironment(deps, "lab firewall clear"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab firewall clear")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	node, err := validateImpairmentTarget(ctx, deps, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+
+	i := indexOfFirewall(state.Firewalls, node)
+	if i < 0 {
+		return &FirewallClearResult{Node: node}, nil
+	}
+	fw := state.Firewalls[i]
+	if err := requireCommands(deps, nodeFirewallCommands(fw)...); err != nil {
+		return nil, err
+	}
+	if err := removeNodeFirewall(ctx, deps.exec, fw); err != nil {
+		return nil, err
+	}
+	state.Firewalls = append(state.Firewalls[:i], state.Firewalls[i+1:]...)
+	if err := deps.saveState(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist lab state: %w", err)
+	}
+
+	return &FirewallClearResult{Node: node, Profile: fw.Profile, Cleared: true}, nil
+}
+
+func validateFirewallEnvironment(deps createDeps, operation string) error {
+	if deps.goos != "linux" {
+		return fmt.Errorf("%s is supported only on linux: got %s", operation, deps.goos)
+	}
+	if !deps.hasNetAdmin() {
+		return fmt.Errorf("%s requires CAP_NET_ADMIN: run as root or with --rootless", operation)
+	}
+	// iptables or nft is checked once the state says which backend the lab uses.
+	return requireCommands(deps, "ip")
+}
+
+func loadStateForUpdate(ctx context.Context, deps createDeps) (*LabState, error) {
+	if deps.saveState == nil {
+		return nil, errors.New("lab state saver is not configured")
+	}
+	if deps.loadState == nil {
+		return nil, errors.New("lab state loader is not configured")
+	}
+	state, err := deps.loadState(ctx)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil, errors.New("lab state not found: run `rtc-emulator lab create` first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lab state: %w", err)
+	}
+	return state, nil
+}
+
+func validateFirewallProfile(profile string) error {
+	_, err := firewallProfileRules(profile)
+	return err
+}
+
+func firewallProfileRules(profile string) ([]IPTablesRule, error) {
+	switch profile {
+	case FirewallBlockUDP:
+		return []IPTablesRule{
+			firewallRule("OUTPUT", "-p", "udp", "!", "-o", "lo", "-j", "DROP"),
+			firewallRule("INPUT", "-p", "udp", "!", "-i", "lo", "-j", "DROP"),
+		}, nil
+	case FirewallAllow443Only:
+		return []IPTablesRule{
+			firewallRule("OUTPUT", "-o", "lo", "-j", "ACCEPT"),
+			firewallRule("OUTPUT", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"),
+			firewallRule("OUTPUT", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+			firewallRule("OUTPUT", "-j", "DROP"),
+			firewallRule("INPUT", "-i", "lo", "-j", "ACCEPT"),
+			firewallRule("INPUT", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"),
+			firewallRule("INPUT", "-p", "tcp", "--dport", "443", "-j", "ACCEPT"),
+			firewallRule("INPUT", "-j", "DROP"),
+		}, nil
+	case FirewallBlockSTUN:
+		var rules []IPTablesRule
+		for _, proto := range []string{"udp", "tcp"} {
+			rules = append(rules,
+				firewallRule("OUTPUT", "-p", proto, "-m", "multiport", "--dports", "3478,5349", "-j", "DROP"),
+				firewallRule("INPUT", "-p", proto, "-m", "multiport", "--sports", "3478,5349", "-j", "DROP"),
+			)
+		}
+		return rules, nil
+	default:
+		return nil, fmt.Errorf("unsupported firewall profile %q: use %s, %s, or %s", profile, FirewallBlockUDP, FirewallAllow443Only, FirewallBlockSTUN)
+	}
+}
+
+func firewallRule(chain string, spec ...string) IPTablesRule {
+	args := func(op string) []string {
+		return append([]string{op, chain}, spec...)
+	}
+	return IPTablesRule{CheckArgs: args("-C"), AddArgs: args("-A"), DelArgs: args("-D")}
+}
+
+func nodeIPTablesArgs(node, command string, args []string) []string {
+	return append([]string{"netns", "exec", node, command}, args...)
+}
+
+// firewallNFTablesRules is the nftables form of firewallProfileRules, keyed by
+// the input and output chains of the profile. The inet table covers IPv4 and
+// IPv6 alike.
+func firewallNFTablesRules(profile string) map[string][]string {
+	input, output := profile+"-input", profile+"-output"
+	switch profile {
+	case FirewallBlockUDP:
+		return map[string][]string{
+			output: {"meta l4proto udp oifname != lo drop"},
+			input:  {"meta l4proto udp iifname != lo drop"},
+		}
+	case FirewallAllow443Only:
+		return map[string][]string{
+			output: {"oifname lo accept", "ct state related,established accept", "tcp dport 443 accept", "drop"},
+			input:  {"iifname lo accept", "ct state related,established accept", "tcp dport 443 accept", "drop"},
+		}
+	case FirewallBlockSTUN:
+		return map[string][]string{
+			output: {"udp dport { 3478, 5349 } drop", "tcp dport { 3478, 5349 } drop"},
+			input:  {"udp sport { 3478, 5349 } drop", "tcp sport { 3478, 5349 } drop"},
+		}
+	default:
+		return nil
+	}
+}
+
+// firewallNFTablesChains returns the base chains of a profile in the order
+// they are created.
+func firewallNFTablesChains(profile string) []string {
+	return []string{profile + "-output", profile + "-input"}
+}
+
+// nodeFirewallNFTablesCommands installs profile in the node's lab table.
+func nodeFirewallNFTablesCommands(profile string) [][]string {
+	rules := firewallNFTablesRules(profile)
+	cmds := [][]string{{"add", "table", nftTableFamily, nftTableName}}
+	for _, chain := range firewallNFTablesChains(profile) {
+		hook := strings.TrimPrefix(chain, profile+"-")
+		cmds = append(cmds, nftChainArgs(chain, "type filter hook "+hook+" priority 0 ; policy accept ;"))
+		for _, rule := range rules[chain] {
+			cmds = append(cmds, nftRuleArgs(chain, rule))
+		}
+	}
+	return cmds
+}
+
+func nodeNFTablesArgs(node string, args []string) []string {
+	return append([]string{"netns", "exec", node, "nft"}, args...)
+}
+
+// nodeFirewallCommands lists the commands needed to change fw.
+func nodeFirewallCommands(fw NodeFirewall) []string {
+	switch {
+	case fw.NFTables:
+		return []string{"nft"}
+	case len(fw.RulesIPv6) > 0:
+		return []string{"iptables", "ip6tables"}
+	default:
+		return []string{"iptables"}
+	}
+}
+
+// addNodeFirewallRules appends rules (iptables) and rules6 (ip6tables) on
+// fw.Node and records them in fw, or installs the nftables chains of
+// fw.Profile when fw.NFTables is set; on failure it removes what it added.
+func addNodeFirewallRules(ctx context.Context, exec Executor, fw *NodeFirewall, rules, rules6 []IPTablesRule) error {
+	if fw.NFTables {
+		for _, args := range nodeFirewallNFTablesCommands(fw.Profile) {
+			if err := exec.Run(ctx, "ip", nodeNFTablesArgs(fw.Node, args)...); err != nil {
+				return errors.Join(err, deleteNodeFirewallRules(ctx, exec, *fw))
+			}
+		}
+		return nil
+	}
+	for _, family := range []struct {
+		command string
+		rules   []IPTablesRule
+		added   *[]IPTablesRule
+	}{{"iptables", rules, &fw.Rules}, {"ip6tables", rules6, &fw.RulesIPv6}} {
+		for _, rule := range family.rules {
+			if err := exec.Run(ctx, "ip", nodeIPTablesArgs(fw.Node, family.command, rule.AddArgs)...); err != nil {
+				return errors.Join(err, deleteNodeFirewallRules(ctx, exec, *fw))
+			}
+			*family.added = append(*family.added, rule)
+		}
+	}
+	return nil
+}
+
+// deleteNodeFirewallRules removes the rules or nftables chains of fw's
+// profile. The node's nftables table itself is left for removeNodeFirewall.
+func deleteNodeFirewallRules(ctx context.Context, exec Executor, fw NodeFirewall) error {
+	if fw.NFTables {
+		for _, chain := range firewallNFTablesChains(fw.Profile) {
+			for _, args := range [][]string{
+				{"flush", "chain", nftTableFamily, nftTableName, chain},
+				{"delete", "chain", nftTableFamily, nftTableName, chain},
+			} {
+				if err := exec.Run(ctx, "ip", nodeNFTablesArgs(fw.Node, args)...); err != nil && !isNFTablesTableNotFoundError(err) {
+					return fmt.Errorf("failed to delete firewall chain %s on %s: %w", chain, fw.Node, err)
+				}
+			}
+		}
+		return nil
+	}
+	for _, family := range []struct {
+		command string
+		rules   []IPTablesRule
+	}{{"ip6tables", fw.RulesIPv6}, {"iptables", fw.Rules}} {
+		for i := len(family.rules) - 1; i >= 0; i-- {
+			rule := family.rules[i]
+			if err := exec.Run(ctx, "ip", nodeIPTablesArgs(fw.Node, family.command, rule.DelArgs)...); err != nil && !isIPTablesRuleNotFoundError(err) {
+				return fmt.Errorf("failed to delete firewall rule %s %v on %s: %w", family.command, rule.DelArgs, fw.Node, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeNodeFirewall drops fw from its node, including the node's nftables
+// table when the profile lives there.
+func removeNodeFirewall(ctx context.Context, exec Executor, fw NodeFirewall) error {
+	if err := deleteNodeFirewallRules(ctx, exec, fw); err != nil {
+		return err
+	}
+	if !fw.NFTables {
+		return nil
+	}
+	err := exec.Run(ctx, "ip", nodeNFTablesArgs(fw.Node, []string{"delete", "table", nftTableFamily, nftTableName})...)
+	if err != nil && !isNFTablesTableNotFoundError(err) {
+		return fmt.Errorf("failed to delete nftables table %s on %s: %w", nftTableName, fw.Node, err)
+	}
+	return nil
+}
+
+func indexOfFirewall(firewalls []NodeFirewall, node string) int {
+	for i, fw := range firewalls {
+		if fw.Node == node {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,189 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func firewallTestDeps(ex *fakeExecutor, state *LabState) createDeps {
+	deps := impairmentTestDeps(ex, func(context.Context) (*LabState, error) {
+		return state, nil
+	})
+	deps.saveState = func(_ context.Context, saved *LabState) error {
+		*state = *saved
+		return nil
+	}
+	return deps
+}
+
+func TestApplyFirewallWithDeps_RunsRulesInNodeAndTracksState(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\nnode2\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1", "node2"}}
+
+	got, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-udp"}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Rules != 2 || got.Profile != FirewallBlockUDP {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 iptables -A OUTPUT -p udp ! -o lo -j DROP") {
+		t.Fatalf("missing udp drop rule in %v", ex.calls)
+	}
+	if len(state.Firewalls) != 1 || state.Firewalls[0].Node != "node1" || len(state.Firewalls[0].Rules) != 2 {
+		t.Fatalf("unexpected tracked firewalls: %+v", state.Firewalls)
+	}
+
+	if _, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-stun"}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected reapply error: %v", err)
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 iptables -D OUTPUT -p udp ! -o lo -j DROP") {
+		t.Fatalf("expected previous profile rules to be removed")
+	}
+	if len(state.Firewalls) != 1 || state.Firewalls[0].Profile != FirewallBlockSTUN {
+		t.Fatalf("expected profile replacement, got %+v", state.Firewalls)
+	}
+
+	cleared, err := clearFirewallWithDeps(context.Background(), FirewallClearOptions{Node: "node1"}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+	if !cleared.Cleared || cleared.Profile != FirewallBlockSTUN || len(state.Firewalls) != 0 {
+		t.Fatalf("unexpected clear result %+v, state %+v", cleared, state.Firewalls)
+	}
+}
+
+func TestApplyFirewallWithDeps_ReplacesProfileWithoutGap(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}}
+	if _, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-udp"}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ex.calls = nil
+	deps := firewallTestDeps(ex, state)
+	deps.saveState = func(context.Context, *LabState) error {
+		return errors.New("disk full")
+	}
+	_, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-stun"}, deps)
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected save error, got %v", err)
+	}
+
+	addNew := indexOfCall(ex.calls, "ip netns exec node1 iptables -A OUTPUT -p udp -m multiport --dports 3478,5349 -j DROP")
+	delOld := indexOfCall(ex.calls, "ip netns exec node1 iptables -D OUTPUT -p udp ! -o lo -j DROP")
+	readdOld := indexOfCall(ex.calls, "ip netns exec node1 iptables -A OUTPUT -p udp ! -o lo -j DROP")
+	delNew := indexOfCall(ex.calls, "ip netns exec node1 iptables -D OUTPUT -p udp -m multiport --dports 3478,5349 -j DROP")
+	if addNew < 0 || delOld < addNew || readdOld < delOld || delNew < readdOld {
+		t.Fatalf("unexpected replace/rollback order in %v", ex.calls)
+	}
+}
+
+func TestApplyFirewallWithDeps_AddsIPv6RulesOnDualStackLab(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}, SubnetIPv6: "fd00:200::/64"}
+
+	if _, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-udp"}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 ip6tables -A OUTPUT -p udp ! -o lo -j DROP") {
+		t.Fatalf("missing ip6tables udp drop rule in %v", ex.calls)
+	}
+	if len(state.Firewalls) != 1 || len(state.Firewalls[0].RulesIPv6) != 2 {
+		t.Fatalf("expected ipv6 rules to be tracked, got %+v", state.Firewalls)
+	}
+
+	if _, err := clearFirewallWithDeps(context.Background(), FirewallClearOptions{Node: "node1"}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 ip6tables -D INPUT -p udp ! -i lo -j DROP") {
+		t.Fatalf("expected ipv6 rules to be removed, got %v", ex.calls)
+	}
+}
+
+func TestApplyFirewallWithDeps_NFTablesBackendUsesNodeTable(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}, FirewallBackend: FirewallBackendNFTables}
+	deps := firewallTestDeps(ex, state)
+	deps.findPath = func(cmd string) (string, error) {
+		if cmd == "iptables" {
+			return "", errors.New("not found")
+		}
+		return "/bin/" + cmd, nil
+	}
+
+	if _, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "allow-443-only"}, deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"ip netns exec node1 nft add table inet rtc-emulator",
+		"ip netns exec node1 nft add chain inet rtc-emulator allow-443-only-input { type filter hook input priority 0 ; policy accept ; }",
+		"ip netns exec node1 nft add rule inet rtc-emulator allow-443-only-input tcp dport 443 accept",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing %q in %v", want, ex.calls)
+		}
+	}
+	if len(state.Firewalls) != 1 || !state.Firewalls[0].NFTables || len(state.Firewalls[0].Rules) != 0 {
+		t.Fatalf("expected an nftables profile to be tracked, got %+v", state.Firewalls)
+	}
+
+	ex.calls = nil
+	if _, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-udp"}, deps); err != nil {
+		t.Fatalf("unexpected reapply error: %v", err)
+	}
+	addNew := indexOfCall(ex.calls, "ip netns exec node1 nft add rule inet rtc-emulator block-udp-output meta l4proto udp oifname != lo drop")
+	delOld := indexOfCall(ex.calls, "ip netns exec node1 nft delete chain inet rtc-emulator allow-443-only-input")
+	if addNew < 0 || delOld < addNew {
+		t.Fatalf("expected new chains before old ones are removed, calls=%v", ex.calls)
+	}
+
+	if _, err := clearFirewallWithDeps(context.Background(), FirewallClearOptions{Node: "node1"}, deps); err != nil {
+		t.Fatalf("unexpected clear error: %v", err)
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 nft delete table inet rtc-emulator") || len(state.Firewalls) != 0 {
+		t.Fatalf("expected node table to be dropped, calls=%v state=%+v", ex.calls, state.Firewalls)
+	}
+}
+
+func TestApplyFirewallWithDeps_RejectsUnknownProfile(t *testing.T) {
+	state := &LabState{Nodes: []string{"node1"}}
+	_, err := applyFirewallWithDeps(context.Background(), FirewallApplyOptions{Node: "node1", Profile: "block-tcp"}, firewallTestDeps(&fakeExecutor{}, state))
+	if err == nil || !strings.Contains(err.Error(), `unsupported firewall profile "block-tcp"`) {
+		t.Fatalf("expected profile error, got %v", err)
+	}
+}
+
+func TestClearFirewallWithDeps_AbsentProfile(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}}
+	got, err := clearFirewallWithDeps(context.Background(), FirewallClearOptions{Node: "node1"}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Cleared {
+		t.Fatalf("expected absent firewall, got %+v", got)
+	}
+}
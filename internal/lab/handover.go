@@ -0,0 +1,203 @@
+package lab
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	handoverSubnetCIDR  = "10.203.0.0/24"
+	handoverGatewayCIDR = "10.203.0.1/24"
+	handoverGateway     = "10.203.0.1"
+	nftHandoverChain    = "handover"
+)
+
+type HandoverResult struct {
+	Node   string
+	FromIP string
+	ToIP   string
+}
+
+type NodeHandover struct {
+	Node string `json:"node"`
+	IP   string `json:"ip"`
+}
+
+func handoverIPForIndex(i int) string {
+	return "10.203.0." + strconv.Itoa(i+1)
+}
+
+// handoverNodeWithDeps moves a bridge node between its lab address and a
+// second address in 10.203.0.0/24, like a phone switching from Wi-Fi to
+// cellular. The host routes between both subnets on the same bridge.
+func handoverNodeWithDeps(ctx context.Context, node string, deps createDeps) (*HandoverResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	unlock, err := lockLab(ctx, deps, "lab handover")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	node, err = validateImpairmentTarget(ctx, deps, node)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := findNodeNAT(state.NAT, node); ok || len(state.Links) > 0 {
+		return nil, fmt.Errorf("handover is supported only for bridge nodes without nat: %s", node)
+	}
+	i, err := nodeIndex(node)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, gateway := nodeIPForIndex(i), handoverIPForIndex(i), handoverGateway
+	if current, ok := findNodeHandover(state.Handovers, node); ok && current.IP == to {
+		from, to, gateway = to, nodeIPForIndex(i), bridgeIP
+	}
+	bridge := state.Bridge
+	if bridge == "" {
+		bridge = bridgeName
+	}
+
+	wasActive := len(state.Handovers) > 0
+	state.Handovers = removeNodeHandover(state.Handovers, node)
+	if to != nodeIPForIndex(i) {
+		state.Handovers = append(state.Handovers, NodeHandover{Node: node, IP: to})
+	}
+
+	// Handed-over addresses are outside the lab subnet, so they need their
+	// own masquerade rule while any node uses one.
+	var cleanups []func(context.Context)
+	rollback := func() {
+		for j := len(cleanups) - 1; j >= 0; j-- {
+			cleanups[j](ctx)
+		}
+	}
+	if !wasActive && len(state.Handovers) > 0 {
+		if err := addHandoverMasquerade(ctx, deps.exec, state, &cleanups); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to hand over %s from %s to %s: %w", node, from, to, err)
+		}
+	}
+
+	steps := [][]string{
+		{"ip", "addr", "replace", handoverGatewayCIDR, "dev", bridge},
+		{"ip", "netns", "exec", node, "ip", "addr", "add", to + "/24", "dev", "eth0"},
+		{"ip", "netns", "exec", node, "ip", "addr", "del", from + "/24", "dev", "eth0"},
+		{"ip", "netns", "exec", node, "ip", "route", "replace", "default", "via", gateway},
+	}
+	for _, step := range steps {
+		if err := deps.exec.Run(ctx, step[0], step[1:]...); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to hand over %s from %s to %s: %w", node, from, to, err)
+		}
+	}
+
+	var restoreErr error
+	if wasActive && len(state.Handovers) == 0 {
+		restoreErr = removeHandoverMasquerade(ctx, deps.exec, state)
+	}
+	if err := deps.saveState(ctx, state); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to persist lab state: %w", err)
+	}
+	if restoreErr != nil {
+		return nil, restoreErr
+	}
+
+	return &HandoverResult{Node: node, FromIP: from, ToIP: to}, nil
+}
+
+func handoverMasqueradeRule() IPTablesRule {
+	return IPTablesRule{
+		CheckArgs: []string{"-t", "nat", "-C", "POSTROUTING", "-s", handoverSubnetCIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+		AddArgs:   []string{"-t", "nat", "-A", "POSTROUTING", "-s", handoverSubnetCIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+		DelArgs:   []string{"-t", "nat", "-D", "POSTROUTING", "-s", handoverSubnetCIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+	}
+}
+
+// handoverNFTablesCommands keep the rule in its own chain so it can be
+// dropped without looking up rule handles.
+func handoverNFTablesCommands() [][]string {
+	return [][]string{
+		nftChainArgs(nftHandoverChain, "type nat hook postrouting priority 100 ;"),
+		nftRuleArgs(nftHandoverChain, "ip saddr "+handoverSubnetCIDR+" oifname != "+bridgeName+" masquerade"),
+	}
+}
+
+// addHandoverMasquerade installs the handover masquerade and records it in
+// state: iptables rules join state.Rules, so destroy and reconcile treat them
+// like the lab's own; the nftables chain lives in the lab table.
+func addHandoverMasquerade(ctx context.Context, exec Executor, state *LabState, cleanups *[]func(context.Context)) error {
+	if state.FirewallBackend == FirewallBackendNFTables {
+		*cleanups = append(*cleanups, func(ctx context.Context) {
+			_ = deleteNFTablesChain(ctx, exec, nftHandoverChain)
+		})
+		for _, args := range handoverNFTablesCommands() {
+			if err := exec.Run(ctx, "nft", args...); err != nil {
+				return fmt.Errorf("failed to add handover masquerade: %w", err)
+			}
+		}
+		return nil
+	}
+	rule := handoverMasqueradeRule()
+	if err := ensureIPTablesRule(ctx, exec, "iptables", rule, cleanups); err != nil {
+		return fmt.Errorf("failed to add handover masquerade: %w", err)
+	}
+	if indexOfIPTablesRule(state.Rules, rule) < 0 {
+		state.Rules = append(state.Rules, rule)
+	}
+	return nil
+}
+
+func removeHandoverMasquerade(ctx context.Context, exec Executor, state *LabState) error {
+	if state.FirewallBackend == FirewallBackendNFTables {
+		if err := deleteNFTablesChain(ctx, exec, nftHandoverChain); err != nil {
+			return fmt.Errorf("failed to remove handover masquerade: %w", err)
+		}
+		return nil
+	}
+	rule := handoverMasqueradeRule()
+	if err := exec.Run(ctx, "iptables", rule.DelArgs...); err != nil && !isIPTablesRuleNotFoundError(err) {
+		return fmt.Errorf("failed to remove handover masquerade: %w", err)
+	}
+	if k := indexOfIPTablesRule(state.Rules, rule); k >= 0 {
+		state.Rules = append(state.Rules[:k], state.Rules[k+1:]...)
+	}
+	return nil
+}
+
+func indexOfIPTablesRule(rules []IPTablesRule, rule IPTablesRule) int {
+	want := strings.Join(rule.AddArgs, " ")
+	for k, r := range rules {
+		if strings.Join(r.AddArgs, " ") == want {
+			return k
+		}
+	}
+	return -1
+}
+
+func findNodeHandover(handovers []NodeHandover, node string) (NodeHandover, bool) {
+	for _, h := range handovers {
+		if h.Node == node {
+			return h, true
+		}
+	}
+	return NodeHandover{}, false
+}
+
+func removeNodeHandover(handovers []NodeHandover, node string) []NodeHandover {
+	out := handovers[:0]
+	for _, h := range handovers {
+		if h.Node != node {
+			out = append(out, h)
+		}
+	}
+	return out
+}
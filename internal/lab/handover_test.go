@@ -0,0 +1,133 @@
+package lab
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestHandoverNodeWithDeps_TogglesBetweenAddresses(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\nnode2\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1", "node2"}}
+
+	got, err := handoverNodeWithDeps(context.Background(), "node2", firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.FromIP != "10.200.0.3" || got.ToIP != "10.203.0.3" {
+		t.Fatalf("unexpected handover: %+v", got)
+	}
+	for _, want := range []string{
+		"ip addr replace 10.203.0.1/24 dev rtcemu0",
+		"ip netns exec node2 ip addr add 10.203.0.3/24 dev eth0",
+		"ip netns exec node2 ip addr del 10.200.0.3/24 dev eth0",
+		"ip netns exec node2 ip route replace default via 10.203.0.1",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if ip, _ := labNodeIP(state, "node2"); ip != "10.203.0.3" {
+		t.Fatalf("expected handed over node ip, got %s", ip)
+	}
+	if indexOfIPTablesRule(state.Rules, handoverMasqueradeRule()) < 0 {
+		t.Fatalf("expected handover masquerade recorded, got %+v", state.Rules)
+	}
+
+	back, err := handoverNodeWithDeps(context.Background(), "node2", firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if back.ToIP != "10.200.0.3" || len(state.Handovers) != 0 {
+		t.Fatalf("expected handover back to lab address, got %+v state %+v", back, state.Handovers)
+	}
+	if !hasCall(ex.calls, "ip netns exec node2 ip route replace default via 10.200.0.1") {
+		t.Fatalf("expected default route back via bridge")
+	}
+	if !hasCall(ex.calls, "iptables -t nat -D POSTROUTING -s 10.203.0.0/24 ! -o rtcemu0 -j MASQUERADE") || len(state.Rules) != 0 {
+		t.Fatalf("expected handover masquerade removed, calls=%v rules=%+v", ex.calls, state.Rules)
+	}
+}
+
+func TestHandoverNodeWithDeps_NFTablesMasqueradeChain(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\nnode2\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1", "node2"}, FirewallBackend: FirewallBackendNFTables}
+
+	if _, err := handoverNodeWithDeps(context.Background(), "node1", firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasCall(ex.calls, "nft add rule inet rtc-emulator handover ip saddr 10.203.0.0/24 oifname != rtcemu0 masquerade") {
+		t.Fatalf("expected handover masquerade chain, got %v", ex.calls)
+	}
+	if _, err := handoverNodeWithDeps(context.Background(), "node2", firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	chains := 0
+	for _, c := range ex.calls {
+		if strings.HasPrefix(c, "nft add chain inet rtc-emulator handover") {
+			chains++
+		}
+	}
+	if chains != 1 {
+		t.Fatalf("expected one chain while handovers overlap, got %v", ex.calls)
+	}
+
+	for _, node := range []string{"node1", "node2"} {
+		if _, err := handoverNodeWithDeps(context.Background(), node, firewallTestDeps(ex, state)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !hasCall(ex.calls, "nft delete chain inet rtc-emulator handover") || len(state.Handovers) != 0 {
+		t.Fatalf("expected handover chain dropped after the last restore, got %v", ex.calls)
+	}
+}
+
+func TestHandoverNodeWithDeps_LocksBeforeValidating(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1"}}
+	deps := firewallTestDeps(ex, state)
+	var operation string
+	deps.lock = func(_ context.Context, op string) (func(), error) {
+		operation = op
+		return func() {}, nil
+	}
+	load := deps.loadState
+	deps.loadState = func(ctx context.Context) (*LabState, error) {
+		if operation == "" {
+			t.Fatal("expected the lab lock before loading state")
+		}
+		return load(ctx)
+	}
+
+	if _, err := handoverNodeWithDeps(context.Background(), "node1", deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if operation != "lab handover" {
+		t.Fatalf("lock operation = %q, want lab handover", operation)
+	}
+}
+
+func TestHandoverNodeWithDeps_RejectsNATNode(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}, NAT: []NodeNAT{{Node: "node1", Type: NATSymmetric}}}
+	_, err := handoverNodeWithDeps(context.Background(), "node1", firewallTestDeps(ex, state))
+	if err == nil || !strings.Contains(err.Error(), "handover is supported only for bridge nodes") {
+		t.Fatalf("expected nat rejection, got %v", err)
+	}
+}
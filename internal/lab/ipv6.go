@@ -0,0 +1,90 @@
+package lab
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const (
+	bridgeIPv6CIDR = "fd00:200::1/64"
+	bridgeIPv6     = "fd00:200::1"
+	subnetIPv6CIDR = "fd00:200::/64"
+)
+
+func nodeIPv6ForIndex(i int) string {
+	return "fd00:200::" + strconv.FormatInt(int64(i+1), 16)
+}
+
+func readIPv6Forward(ctx context.Context, exec Executor) (string, error) {
+	return readSysctlFlag(ctx, exec, "net.ipv6.conf.all.forwarding")
+}
+
+func restoreIPv6Forward(ctx context.Context, exec Executor, value string) error {
+	return restoreSysctlFlag(ctx, exec, "net.ipv6.conf.all.forwarding", value)
+}
+
+func managedIP6TablesRules() []IPTablesRule {
+	return []IPTablesRule{
+		{
+			CheckArgs: []string{"-t", "nat", "-C", "POSTROUTING", "-s", subnetIPv6CIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+			AddArgs:   []string{"-t", "nat", "-A", "POSTROUTING", "-s", subnetIPv6CIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+			DelArgs:   []string{"-t", "nat", "-D", "POSTROUTING", "-s", subnetIPv6CIDR, "!", "-o", bridgeName, "-j", "MASQUERADE"},
+		},
+		{
+			CheckArgs: []string{"-C", "FORWARD", "-i", bridgeName, "-j", "ACCEPT"},
+			AddArgs:   []string{"-A", "FORWARD", "-i", bridgeName, "-j", "ACCEPT"},
+			DelArgs:   []string{"-D", "FORWARD", "-i", bridgeName, "-j", "ACCEPT"},
+		},
+		{
+			CheckArgs: []string{"-C", "FORWARD", "-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+			AddArgs:   []string{"-A", "FORWARD", "-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+			DelArgs:   []string{"-D", "FORWARD", "-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		},
+	}
+}
+
+// setupBridgeIPv6 adds the bridge address and forwarding; ip6tables rules are
+// installed only when iptables manages host rules, nftables covers both
+// families in one table.
+func setupBridgeIPv6(ctx context.Context, exec Executor, withIP6Tables bool, cleanups *[]func(context.Context)) (string, []IPTablesRule, error) {
+	if err := exec.Run(ctx, "ip", "-6", "addr", "add", bridgeIPv6CIDR, "dev", bridgeName, "nodad"); err != nil {
+		return "", nil, err
+	}
+	forwardBefore, err := readIPv6Forward(ctx, exec)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := exec.Run(ctx, "sysctl", "-w", "net.ipv6.conf.all.forwarding=1"); err != nil {
+		return "", nil, err
+	}
+	*cleanups = append(*cleanups, func(ctx context.Context) {
+		_ = restoreIPv6Forward(ctx, exec, forwardBefore)
+	})
+	if !withIP6Tables {
+		return forwardBefore, nil, nil
+	}
+	rules := managedIP6TablesRules()
+	for _, rule := range rules {
+		if err := ensureIPTablesRule(ctx, exec, "ip6tables", rule, cleanups); err != nil {
+			return "", nil, err
+		}
+	}
+	return forwardBefore, rules, nil
+}
+
+func configureNodeIPv6(ctx context.Context, exec Executor, node string, ip string) error {
+	steps := [][]string{
+		{"ip", "netns", "exec", node, "ip", "-6", "addr", "add", ip + "/64", "dev", "eth0", "nodad"},
+		{"ip", "netns", "exec", node, "ip", "-6", "route", "add", "default", "via", bridgeIPv6},
+	}
+	for _, step := range steps {
+		if err := exec.Run(ctx, step[0], step[1:]...); err != nil {
+			return err
+		}
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", node, "ping", "-6", "-c", "1", "-W", "1", bridgeIPv6); err != nil {
+		return fmt.Errorf("ipv6 connectivity check failed for %s -> %s: %w", node, bridgeIPv6, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	defaultMTU = 1500
+	minMTU     = 576
+	minIPv6MTU = 1280
+	maxMTU     = 9000
+)
+
+type LinkSetOptions struct {
+	Node string
+	MTU  int
+}
+
+type LinkSetResult struct {
+	Node        string
+	MTU         int
+	PreviousMTU int
+}
+
+type NodeMTU struct {
+	Node string `json:"node"`
+	MTU  int    `json:"mtu"`
+}
+
+func SetLink(ctx context.Context, opts LinkSetOptions) (*LinkSetResult, error) {
+	return setLinkWithDeps(ctx, opts, defaultCreateDeps(ctx))
+}
+
+func setLinkWithDeps(ctx context.Context, opts LinkSetOptions, deps createDeps) (*LinkSetResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if deps.goos != "linux" {
+		return nil, fmt.Errorf("lab link set is supported only on linux: got %s", deps.goos)
+	}
+	if !deps.hasNetAdmin() {
+		return nil, errors.New("lab link set requires CAP_NET_ADMIN: run as root or with --rootless")
+	}
+	if err := requireCommands(deps, "ip"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab link set")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	node, err := validateImpairmentTarget(ctx, deps, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMTU(opts.MTU, state.SubnetIPv6 != ""); err != nil {
+		return nil, err
+	}
+
+	previous := nodeMTU(state, node)
+	if err := setNodeMTU(ctx, deps.exec, state, node, opts.MTU); err != nil {
+		return nil, err
+	}
+	recordNodeMTU(state, node, opts.MTU)
+	if err := deps.saveState(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist lab state: %w", err)
+	}
+
+	return &LinkSetResult{Node: node, MTU: opts.MTU, PreviousMTU: previous}, nil
+}
+
+func validateMTU(mtu int, ipv6 bool) error {
+	low := minMTU
+	if ipv6 {
+		low = minIPv6MTU
+	}
+	if mtu < low || mtu > maxMTU {
+		return fmt.Errorf("mtu must be between %d and %d: got %d", low, maxMTU, mtu)
+	}
+	return nil
+}
+
+// nodeLinkPeer returns the namespace and interface on the other end of the
+// node's eth0. An empty namespace means the host.
+func nodeLinkPeer(state *LabState, node string) (string, string) {
+	if nat, ok := findNodeNAT(state.NAT, node); ok {
+		return nat.Namespace, natLANInterface
+	}
+	for _, link := range state.Links {
+		if link.A == node && link.AInterface == "eth0" {
+			return link.B, link.BInterface
+		}
+		if link.B == node && link.BInterface == "eth0" {
+			return link.A, link.AInterface
+		}
+	}
+	return "", "br-" + node
+}
+
+// setNodeMTU changes both ends of the node's eth0 so oversized packets are
+// dropped in either direction, like on a real tunnel.
+func setNodeMTU(ctx context.Context, exec Executor, state *LabState, node string, mtu int) error {
+	value := strconv.Itoa(mtu)
+	if err := exec.Run(ctx, "ip", "netns", "exec", node, "ip", "link", "set", "eth0", "mtu", value); err != nil {
+		return fmt.Errorf("failed to set mtu %d on %s: %w", mtu, node, err)
+	}
+	peerNS, peerIface := nodeLinkPeer(state, node)
+	args := []string{"link", "set", peerIface, "mtu", value}
+	if peerNS != "" {
+		args = append([]string{"netns", "exec", peerNS, "ip"}, args...)
+	}
+	if err := exec.Run(ctx, "ip", args...); err != nil {
+		return fmt.Errorf("failed to set mtu %d on %s peer %s: %w", mtu, node, peerIface, err)
+	}
+	return nil
+}
+
+func nodeMTU(state *LabState, node string) int {
+	for _, m := range state.MTU {
+		if m.Node == node {
+			return m.MTU
+		}
+	}
+	return defaultMTU
+}
+
+func recordNodeMTU(state *LabState, node string, mtu int) {
+	for i := range state.MTU {
+		if state.MTU[i].Node == node {
+			state.MTU[i].MTU = mtu
+			return
+		}
+	}
+	state.MTU = append(state.MTU, NodeMTU{Node: node, MTU: mtu})
+}
+
+// applyCreateMTU sets mtu on every created node; zero keeps the default.
+func applyCreateMTU(ctx context.Context, exec Executor, state *LabState, nodes []Node, mtu int) ([]NodeMTU, error) {
+	if mtu == 0 {
+		return nil, nil
+	}
+	for i := range nodes {
+		if err := setNodeMTU(ctx, exec, state, nodes[i].Name, mtu); err != nil {
+			return nil, err
+		}
+		nodes[i].MTU = mtu
+		recordNodeMTU(state, nodes[i].Name, mtu)
+	}
+	return state.MTU, nil
+}
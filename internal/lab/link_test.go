@@ -0,0 +1,56 @@
+package lab
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSetLinkWithDeps_SetsMTUOnBothEndsAndTracksState(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\nnode2\nnat-node2\n", nil
+		},
+	}
+	state := &LabState{
+		Nodes: []string{"node1", "node2"},
+		NAT:   []NodeNAT{{Node: "node2", Type: NATSymmetric, Namespace: "nat-node2"}},
+	}
+
+	got, err := setLinkWithDeps(context.Background(), LinkSetOptions{Node: "node1", MTU: 1200}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.MTU != 1200 || got.PreviousMTU != defaultMTU {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+	if _, err := setLinkWithDeps(context.Background(), LinkSetOptions{Node: "node2", MTU: 1280}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"ip netns exec node1 ip link set eth0 mtu 1200",
+		"ip link set br-node1 mtu 1200",
+		"ip netns exec node2 ip link set eth0 mtu 1280",
+		"ip netns exec nat-node2 ip link set lan0 mtu 1280",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if nodeMTU(state, "node1") != 1200 || nodeMTU(state, "node2") != 1280 {
+		t.Fatalf("unexpected tracked mtu: %+v", state.MTU)
+	}
+}
+
+func TestSetLinkWithDeps_RejectsMTUOutOfRange(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Nodes: []string{"node1"}, SubnetIPv6: subnetIPv6CIDR}
+	_, err := setLinkWithDeps(context.Background(), LinkSetOptions{Node: "node1", MTU: 1200}, firewallTestDeps(ex, state))
+	if err == nil || !strings.Contains(err.Error(), "mtu must be between 1280 and 9000") {
+		t.Fatalf("expected ipv6 mtu error, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package lab
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLockPath = "/run/rtc-emulator/lab.lock"
+
+// DefaultLockTimeout is the lock wait of a context without Settings.
+const DefaultLockTimeout = 30 * time.Second
+
+// LabLockedError reports the command holding the lab lock when the wait
+// timed out.
+type LabLockedError struct {
+	PID       int
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *LabLockedError) Error() string {
+	holder := "another rtc-emulator process"
+	if e.PID != 0 {
+		holder = "pid " + strconv.Itoa(e.PID)
+		if e.Operation != "" {
+			holder += " (" + e.Operation + ")"
+		}
+	}
+	return fmt.Sprintf("lab is locked by %s: gave up after %s, raise --lock-timeout to wait longer", holder, e.Timeout)
+}
+
+// lockLab serializes commands that change the lab or its state file. The
+// returned func releases the lock.
+func lockLab(ctx context.Context, deps createDeps, operation string) (func(), error) {
+	if deps.lock == nil {
+		return func() {}, nil
+	}
+	return deps.lock(ctx, operation)
+}
+
+// lockHolder reads the pid and command the current holder wrote to the lock
+// file.
+func lockHolder(path string) (int, string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, ""
+	}
+	pid, operation, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+	n, _ := strconv.Atoi(pid)
+	return n, operation
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLabLock_ReportsHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lab.lock")
+	unlock, err := acquireLabLock(context.Background(), path, "lab create", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = acquireLabLock(context.Background(), path, "lab impair apply", 100*time.Millisecond)
+	var locked *LabLockedError
+	if !errors.As(err, &locked) || locked.PID != os.Getpid() || locked.Operation != "lab create" {
+		t.Fatalf("expected lock held by this process for lab create, got: %v", err)
+	}
+
+	unlock()
+	unlock, err = acquireLabLock(context.Background(), path, "lab impair apply", 0)
+	if err != nil {
+		t.Fatalf("expected lock after release, got: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package lab
+
+import (
+	"context"
+	"time"
+)
+
+// acquireLabLock is a no-op: labs run only on linux.
+func acquireLabLock(context.Context, string, string, time.Duration) (func(), error) {
+	return func() {}, nil
+}
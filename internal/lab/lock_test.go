@@ -0,0 +1,91 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApplyWithDeps_HoldsLabLock(t *testing.T) {
+	var events []string
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			events = append(events, "run")
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	deps := impairmentTestDeps(ex, func(context.Context) (*LabState, error) {
+		return &LabState{Nodes: []string{"node1"}}, nil
+	})
+	deps.lock = func(_ context.Context, operation string) (func(), error) {
+		events = append(events, "lock "+operation)
+		return func() { events = append(events, "unlock") }, nil
+	}
+	if _, err := applyWithDeps(context.Background(), ApplyOptions{Node: "node1", Delay: "10ms"}, deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 3 || events[0] != "lock lab impair apply" || events[2] != "unlock" {
+		t.Fatalf("expected tc to run under the lab lock, got %v", events)
+	}
+
+	deps.lock = func(context.Context, string) (func(), error) {
+		return nil, &LabLockedError{PID: 42, Operation: "lab create", Timeout: time.Second}
+	}
+	_, err := applyWithDeps(context.Background(), ApplyOptions{Node: "node1", Delay: "10ms"}, deps)
+	if err == nil || err.Error() != "lab is locked by pid 42 (lab create): gave up after 1s, raise --lock-timeout to wait longer" {
+		t.Fatalf("expected lock error, got: %v", err)
+	}
+}
+
+func TestSaveStateAtomic_ConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lab.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			nodes := []string{strings.Repeat("x", i*512)}
+			if err := saveStateAtomic(context.Background(), path, &LabState{Nodes: nodes}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var st LabState
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatalf("expected a complete state file, got %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("expected no temp files left, got %d entries", len(entries))
+	}
+}
+
+func TestWithSettingsLockTimeout(t *testing.T) {
+	if got := settingsFrom(context.Background()).LockTimeout; got != DefaultLockTimeout {
+		t.Fatalf("default lock timeout = %s, want %s", got, DefaultLockTimeout)
+	}
+	ctx, err := WithSettings(context.Background(), Settings{LockTimeout: 0})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := settingsFrom(ctx).LockTimeout; got != 0 {
+		t.Fatalf("lock timeout = %s, want 0 to fail at once", got)
+	}
+	if _, err := WithSettings(context.Background(), Settings{LockTimeout: -time.Second}); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Fatalf("expected negative lock timeout error, got %v", err)
+	}
+}
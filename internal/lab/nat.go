@@ -0,0 +1,216 @@
+package lab
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NAT types, named after the RFC 3489 behaviour they produce.
+const (
+	// NATFullCone keeps the source port and forwards every inbound packet to
+	// the node.
+	NATFullCone = "full-cone"
+	// NATPortRestricted keeps the source port and admits only packets from the
+	// exact address and port the node sent to, as conntrack tracks the whole
+	// flow. Address-restricted cone (any port of a contacted host) is not
+	// emulated.
+	NATPortRestricted = "port-restricted"
+	// NATSymmetric picks a random port per destination and admits only
+	// replies, like NATPortRestricted.
+	NATSymmetric = "symmetric"
+)
+
+const (
+	natNamespacePrefix = "nat-"
+	natWANInterface    = "wan0"
+	natLANInterface    = "lan0"
+)
+
+type NodeNAT struct {
+	Node      string `json:"node"`
+	Type      string `json:"type"`
+	Namespace string `json:"namespace"`
+	PublicIP  string `json:"public_ip"`
+	PrivateIP string `json:"private_ip"`
+}
+
+func ParseNodeNAT(spec string) (NodeNAT, error) {
+	node, natType, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	node = strings.TrimSpace(node)
+	natType = strings.ToLower(strings.TrimSpace(natType))
+	if !ok || node == "" || natType == "" {
+		return NodeNAT{}, fmt.Errorf("invalid nat %q: use NODE=TYPE", spec)
+	}
+	nat := NodeNAT{Node: node, Type: natType}
+	if err := validateNATType(nat.Type); err != nil {
+		return NodeNAT{}, err
+	}
+	return nat, nil
+}
+
+func validateNATType(natType string) error {
+	switch natType {
+	case NATFullCone, NATPortRestricted, NATSymmetric:
+		return nil
+	default:
+		return fmt.Errorf("unsupported nat type %q: use %s, %s, or %s", natType, NATFullCone, NATPortRestricted, NATSymmetric)
+	}
+}
+
+func validateNodeNATs(nats []NodeNAT, nodes int) error {
+	seen := make(map[string]bool, len(nats))
+	for _, nat := range nats {
+		if err := validateNATType(nat.Type); err != nil {
+			return err
+		}
+		i, err := nodeIndex(nat.Node)
+		if err != nil || i > nodes {
+			return fmt.Errorf("nat node %q is not one of the %d created nodes", nat.Node, nodes)
+		}
+		if seen[nat.Node] {
+			return fmt.Errorf("nat node %q is listed more than once", nat.Node)
+		}
+		seen[nat.Node] = true
+	}
+	return nil
+}
+
+func findNodeNAT(nats []NodeNAT, node string) (NodeNAT, bool) {
+	for _, nat := range nats {
+		if nat.Node == node {
+			return nat, true
+		}
+	}
+	return NodeNAT{}, false
+}
+
+func nodeIndex(node string) (int, error) {
+	if !isManagedNodeName(node) {
+		return 0, fmt.Errorf("node %q is not a managed node name", node)
+	}
+	return strconv.Atoi(strings.TrimPrefix(node, "node"))
+}
+
+func isManagedNATNamespace(ns string) bool {
+	return strings.HasPrefix(ns, natNamespacePrefix) && isManagedNodeName(strings.TrimPrefix(ns, natNamespacePrefix))
+}
+
+func natGatewayIP(i int) string {
+	return "10.201." + strconv.Itoa(i) + ".1"
+}
+
+func natPrivateIP(i int) string {
+	return "10.201." + strconv.Itoa(i) + ".2"
+}
+
+func natIPTablesArgs(nat NodeNAT) [][]string {
+	masquerade := []string{"-t", "nat", "-A", "POSTROUTING", "-o", natWANInterface, "-j", "MASQUERADE"}
+	switch nat.Type {
+	case NATFullCone:
+		return [][]string{
+			masquerade,
+			{"-t", "nat", "-A", "PREROUTING", "-i", natWANInterface, "-j", "DNAT", "--to-destination", nat.PrivateIP},
+		}
+	case NATSymmetric:
+		return [][]string{append(masquerade, "--random")}
+	default:
+		return [][]string{masquerade}
+	}
+}
+
+// natNFTablesArgs is the nftables form of natIPTablesArgs, installed as the
+// lab table inside the NAT namespace.
+func natNFTablesArgs(nat NodeNAT) [][]string {
+	masquerade := "oifname " + natWANInterface + " masquerade"
+	if nat.Type == NATSymmetric {
+		masquerade += " random"
+	}
+	cmds := [][]string{
+		{"add", "table", nftTableFamily, nftTableName},
+		nftChainArgs("postrouting", "type nat hook postrouting priority 100 ;"),
+		nftRuleArgs("postrouting", masquerade),
+	}
+	if nat.Type == NATFullCone {
+		cmds = append(cmds,
+			nftChainArgs("prerouting", "type nat hook prerouting priority -100 ;"),
+			nftRuleArgs("prerouting", "iifname "+natWANInterface+" dnat ip to "+nat.PrivateIP),
+		)
+	}
+	return cmds
+}
+
+// natRuleCommands returns the commands that install nat's translation rules
+// with the lab's firewall backend.
+func natRuleCommands(nat NodeNAT, firewall string) [][]string {
+	command, rules := "iptables", natIPTablesArgs(nat)
+	if firewall == FirewallBackendNFTables {
+		command, rules = "nft", natNFTablesArgs(nat)
+	}
+	cmds := make([][]string, 0, len(rules))
+	for _, args := range rules {
+		cmds = append(cmds, append([]string{"ip", "netns", "exec", nat.Namespace, command}, args...))
+	}
+	return cmds
+}
+
+func createNATNode(ctx context.Context, exec Executor, i int, natType, firewall string, cleanups *[]func(context.Context)) (NodeNAT, error) {
+	node := "node" + strconv.Itoa(i)
+	nat := NodeNAT{
+		Node:      node,
+		Type:      natType,
+		Namespace: natNamespacePrefix + node,
+		PublicIP:  nodeIPForIndex(i),
+		PrivateIP: natPrivateIP(i),
+	}
+	gateway := natGatewayIP(i)
+	wan := "veth-" + node
+	lan := "lan-" + node
+	eth := "eth-" + node
+
+	for _, ns := range []string{nat.Namespace, node} {
+		if err := exec.Run(ctx, "ip", "netns", "add", ns); err != nil {
+			return nat, err
+		}
+		*cleanups = append(*cleanups, func(ctx context.Context) {
+			_ = exec.Run(ctx, "ip", "netns", "del", ns)
+		})
+	}
+
+	steps := [][]string{
+		{"ip", "link", "add", wan, "type", "veth", "peer", "name", "br-" + node},
+		{"ip", "link", "set", wan, "netns", nat.Namespace},
+		{"ip", "link", "set", "br-" + node, "master", bridgeName},
+		{"ip", "link", "set", "br-" + node, "up"},
+		{"ip", "link", "add", lan, "type", "veth", "peer", "name", eth},
+		{"ip", "link", "set", lan, "netns", nat.Namespace},
+		{"ip", "link", "set", eth, "netns", node},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "link", "set", "lo", "up"},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "link", "set", wan, "name", natWANInterface},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "addr", "add", nat.PublicIP + "/24", "dev", natWANInterface},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "link", "set", natWANInterface, "up"},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "link", "set", lan, "name", natLANInterface},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "addr", "add", gateway + "/24", "dev", natLANInterface},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "link", "set", natLANInterface, "up"},
+		{"ip", "netns", "exec", nat.Namespace, "ip", "route", "add", "default", "via", bridgeIP},
+		{"ip", "netns", "exec", nat.Namespace, "sysctl", "-w", "net.ipv4.ip_forward=1"},
+	}
+	steps = append(steps, natRuleCommands(nat, firewall)...)
+	steps = append(steps,
+		[]string{"ip", "netns", "exec", node, "ip", "link", "set", "lo", "up"},
+		[]string{"ip", "netns", "exec", node, "ip", "link", "set", eth, "name", "eth0"},
+		[]string{"ip", "netns", "exec", node, "ip", "addr", "add", nat.PrivateIP + "/24", "dev", "eth0"},
+		[]string{"ip", "netns", "exec", node, "ip", "link", "set", "eth0", "up"},
+		[]string{"ip", "netns", "exec", node, "ip", "route", "add", "default", "via", gateway},
+	)
+	for _, step := range steps {
+		if err := exec.Run(ctx, step[0], step[1:]...); err != nil {
+			return nat, err
+		}
+	}
+	if err := exec.Run(ctx, "ip", "netns", "exec", node, "ping", "-c", "1", "-W", "1", bridgeIP); err != nil {
+		return nat, fmt.Errorf("connectivity check failed for %s -> %s through %s nat: %w", node, bridgeIP, natType, err)
+	}
+	return nat, nil
+}
@@ -0,0 +1,565 @@
+//go:build linux
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const netnsRunDir = "/run/netns"
+
+func netlinkSupported() error {
+	return nil
+}
+
+// netlinkExecutor understands the ip, tc, and sysctl invocations lab code
+// issues and performs them over rtnetlink and /proc/sys instead. Anything it
+// does not recognize (ping, iptables, unusual flags) goes to fallback, wrapped
+// in `ip netns exec` when it targets a namespace.
+type netlinkExecutor struct {
+	fallback Executor
+}
+
+func (e netlinkExecutor) Run(ctx context.Context, name string, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, handled, err := e.dispatch("", name, args)
+	if !handled {
+		return e.fallback.Run(ctx, name, args...)
+	}
+	if err != nil {
+		return fmt.Errorf("%s %v: %w", name, args, err)
+	}
+	return nil
+}
+
+func (e netlinkExecutor) Output(ctx context.Context, name string, args ...string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	out, handled, err := e.dispatch("", name, args)
+	if !handled {
+		return e.fallback.Output(ctx, name, args...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s %v: %w", name, args, err)
+	}
+	return out, nil
+}
+
+// dispatch reports handled=false when the command must be executed instead.
+func (e netlinkExecutor) dispatch(ns string, name string, args []string) (string, bool, error) {
+	switch name {
+	case "ip":
+		return e.dispatchIP(ns, args)
+	case "tc":
+		return dispatchTC(ns, args)
+	case "sysctl":
+		return dispatchSysctl(ns, args)
+	default:
+		return "", false, nil
+	}
+}
+
+func (e netlinkExecutor) dispatchIP(ns string, args []string) (string, bool, error) {
+	oneline := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-4", "-6":
+		case "-o":
+			oneline = true
+		default:
+			return "", false, nil
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return "", false, nil
+	}
+	if args[0] == "netns" {
+		if ns != "" {
+			return "", false, nil
+		}
+		return e.dispatchNetns(args[1:])
+	}
+
+	var out string
+	var handled bool
+	err := withHandle(ns, func(h *netlink.Handle) error {
+		var err error
+		switch args[0] {
+		case "link":
+			out, handled, err = ipLink(h, args[1:], oneline)
+		case "addr":
+			handled, err = ipAddr(h, args[1:])
+		case "route":
+			handled, err = ipRoute(h, args[1:])
+		}
+		return err
+	})
+	if errors.Is(err, ErrNamespaceNotFound) {
+		return "", true, err
+	}
+	return out, handled, err
+}
+
+func (e netlinkExecutor) dispatchNetns(args []string) (string, bool, error) {
+	if len(args) == 0 {
+		return "", false, nil
+	}
+	switch {
+	case args[0] == "add" && len(args) == 2:
+		return "", true, addNamedNetns(args[1])
+	case args[0] == "del" && len(args) == 2:
+		return "", true, deleteNamedNetns(args[1])
+	case args[0] == "list" && len(args) == 1:
+		out, err := listNamedNetns()
+		return out, true, err
+	case args[0] == "exec" && len(args) >= 3:
+		out, handled, err := e.dispatch(args[1], args[2], args[3:])
+		return out, handled, err
+	default:
+		return "", false, nil
+	}
+}
+
+func addNamedNetns(name string) error {
+	return lockedThread(func() error {
+		created, err := netns.NewNamed(name)
+		if err != nil {
+			return err
+		}
+		created.Close()
+		return nil
+	})
+}
+
+func deleteNamedNetns(name string) error {
+	if _, err := os.Stat(filepath.Join(netnsRunDir, name)); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s: %w", name, ErrNamespaceNotFound)
+	}
+	return netns.DeleteNamed(name)
+}
+
+func listNamedNetns() (string, error) {
+	entries, err := os.ReadDir(netnsRunDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.Name())
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// lockedThread runs fn on a dedicated, locked OS thread and switches the
+// thread back to its original namespace afterwards. If that fails the
+// goroutine exits still locked, so the runtime terminates the thread rather
+// than reusing it in the wrong namespace.
+func lockedThread(fn func() error) error {
+	return runLockedThread(fn, netns.Get, netns.Set)
+}
+
+func runLockedThread(fn func() error, get func() (netns.NsHandle, error), set func(netns.NsHandle) error) error {
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := get()
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- fmt.Errorf("failed to get current namespace: %w", err)
+			return
+		}
+		defer orig.Close()
+
+		fnErr := fn()
+		if err := set(orig); err != nil {
+			done <- errors.Join(fnErr, fmt.Errorf("failed to restore namespace: %w", err))
+			return
+		}
+		runtime.UnlockOSThread()
+		done <- fnErr
+	}()
+	return <-done
+}
+
+func inNamespace(ns string, fn func() error) error {
+	if ns == "" {
+		return fn()
+	}
+	target, err := openNamedNetns(ns)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	return lockedThread(func() error {
+		if err := netns.Set(target); err != nil {
+			return fmt.Errorf("failed to enter namespace %s: %w", ns, err)
+		}
+		return fn()
+	})
+}
+
+func openNamedNetns(name string) (netns.NsHandle, error) {
+	h, err := netns.GetFromName(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, fmt.Errorf("%s: %w", name, ErrNamespaceNotFound)
+	}
+	return h, err
+}
+
+func withHandle(ns string, fn func(*netlink.Handle) error) error {
+	var h *netlink.Handle
+	var err error
+	if ns == "" {
+		h, err = netlink.NewHandle()
+	} else {
+		var target netns.NsHandle
+		target, err = openNamedNetns(ns)
+		if err != nil {
+			return err
+		}
+		defer target.Close()
+		h, err = netlink.NewHandleAt(target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open netlink handle: %w", err)
+	}
+	defer h.Close()
+	return fn(h)
+}
+
+func linkByName(h *netlink.Handle, name string) (netlink.Link, error) {
+	link, err := h.LinkByName(name)
+	var notFound netlink.LinkNotFoundError
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("device %q: %w", name, ErrLinkNotFound)
+	}
+	return link, err
+}
+
+func ipLink(h *netlink.Handle, args []string, oneline bool) (string, bool, error) {
+	switch {
+	case len(args) == 4 && args[0] == "add" && args[2] == "type" && args[3] == "bridge":
+		return "", true, h.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: args[1]}})
+	case len(args) == 7 && args[0] == "add" && args[2] == "type" && args[3] == "veth" && args[4] == "peer" && args[5] == "name":
+		return "", true, h.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: args[1]}, PeerName: args[6]})
+	case len(args) == 2 && args[0] == "del":
+		link, err := linkByName(h, args[1])
+		if err != nil {
+			return "", true, err
+		}
+		return "", true, h.LinkDel(link)
+	case len(args) == 2 && args[0] == "show":
+		link, err := linkByName(h, args[1])
+		if err != nil {
+			return "", true, err
+		}
+		return formatLink(link, ""), true, nil
+	case len(args) == 3 && args[0] == "show" && args[1] == "master" && oneline:
+		out, err := listMasterLinks(h, args[2])
+		return out, true, err
+	case len(args) >= 3 && args[0] == "set":
+		return ipLinkSet(h, args[1], args[2:])
+	default:
+		return "", false, nil
+	}
+}
+
+func ipLinkSet(h *netlink.Handle, name string, args []string) (string, bool, error) {
+	var ops []func(netlink.Link) error
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "up":
+			ops = append(ops, h.LinkSetUp)
+		case "down":
+			ops = append(ops, h.LinkSetDown)
+		case "master", "netns", "name", "mtu":
+			if i+1 >= len(args) {
+				return "", false, nil
+			}
+			key, value := args[i], args[i+1]
+			i++
+			switch key {
+			case "master":
+				ops = append(ops, func(link netlink.Link) error {
+					master, err := linkByName(h, value)
+					if err != nil {
+						return err
+					}
+					return h.LinkSetMaster(link, master)
+				})
+			case "netns":
+				ops = append(ops, func(link netlink.Link) error {
+					target, err := openNamedNetns(value)
+					if err != nil {
+						return err
+					}
+					defer target.Close()
+					return h.LinkSetNsFd(link, int(target))
+				})
+			case "name":
+				ops = append(ops, func(link netlink.Link) error {
+					return h.LinkSetName(link, value)
+				})
+			case "mtu":
+				mtu, err := strconv.Atoi(value)
+				if err != nil {
+					return "", false, nil
+				}
+				ops = append(ops, func(link netlink.Link) error {
+					return h.LinkSetMTU(link, mtu)
+				})
+			}
+		default:
+			return "", false, nil
+		}
+	}
+
+	link, err := linkByName(h, name)
+	if err != nil {
+		return "", true, err
+	}
+	for _, op := range ops {
+		if err := op(link); err != nil {
+			return "", true, err
+		}
+	}
+	return "", true, nil
+}
+
+func listMasterLinks(h *netlink.Handle, master string) (string, error) {
+	bridge, err := linkByName(h, master)
+	if err != nil {
+		return "", err
+	}
+	links, err := h.LinkList()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, link := range links {
+		if link.Attrs().MasterIndex == bridge.Attrs().Index {
+			b.WriteString(formatLink(link, master))
+		}
+	}
+	return b.String(), nil
+}
+
+// formatLink mimics the leading fields of `ip -o link show` so the callers'
+// parsers work unchanged.
+func formatLink(link netlink.Link, master string) string {
+	attrs := link.Attrs()
+	line := fmt.Sprintf("%d: %s: <%s> mtu %d", attrs.Index, attrs.Name, strings.ToUpper(strings.ReplaceAll(attrs.Flags.String(), "|", ",")), attrs.MTU)
+	if master != "" {
+		line += " master " + master
+	}
+	return line + "\n"
+}
+
+func ipAddr(h *netlink.Handle, args []string) (bool, error) {
+	if len(args) < 4 || args[2] != "dev" {
+		return false, nil
+	}
+	nodad := false
+	if len(args) == 5 && args[4] == "nodad" {
+		nodad = true
+	} else if len(args) != 4 {
+		return false, nil
+	}
+	addr, err := netlink.ParseAddr(args[1])
+	if err != nil {
+		return false, nil
+	}
+	if nodad {
+		addr.Flags |= unix.IFA_F_NODAD
+	}
+
+	var op func(netlink.Link, *netlink.Addr) error
+	switch args[0] {
+	case "add":
+		op = h.AddrAdd
+	case "del":
+		op = h.AddrDel
+	case "replace":
+		op = h.AddrReplace
+	default:
+		return false, nil
+	}
+	link, err := linkByName(h, args[3])
+	if err != nil {
+		return true, err
+	}
+	return true, op(link, addr)
+}
+
+func ipRoute(h *netlink.Handle, args []string) (bool, error) {
+	if (len(args) != 4 && len(args) != 6) || args[2] != "via" {
+		return false, nil
+	}
+	route := &netlink.Route{Gw: net.ParseIP(args[3])}
+	if route.Gw == nil {
+		return false, nil
+	}
+	if args[1] != "default" {
+		_, dst, err := net.ParseCIDR(args[1])
+		if err != nil {
+			return false, nil
+		}
+		route.Dst = dst
+	}
+	if len(args) == 6 {
+		if args[4] != "dev" {
+			return false, nil
+		}
+		link, err := linkByName(h, args[5])
+		if err != nil {
+			return true, err
+		}
+		route.LinkIndex = link.Attrs().Index
+	}
+
+	switch args[0] {
+	case "add":
+		return true, h.RouteAdd(route)
+	case "replace":
+		return true, h.RouteReplace(route)
+	default:
+		return false, nil
+	}
+}
+
+func dispatchTC(ns string, args []string) (string, bool, error) {
+	if len(args) < 5 || args[0] != "qdisc" || args[2] != "dev" || args[4] != "root" {
+		return "", false, nil
+	}
+	dev := args[3]
+	switch {
+	case args[1] == "replace" && len(args) >= 6 && args[5] == "netem":
+		attrs, ok := parseNetemArgs(args[6:])
+		if !ok {
+			return "", false, nil
+		}
+		return "", true, withHandle(ns, func(h *netlink.Handle) error {
+			link, err := linkByName(h, dev)
+			if err != nil {
+				return err
+			}
+			qdisc := netlink.NewNetem(netlink.QdiscAttrs{
+				LinkIndex: link.Attrs().Index,
+				Handle:    netlink.MakeHandle(1, 0),
+				Parent:    netlink.HANDLE_ROOT,
+			}, attrs)
+			return h.QdiscReplace(qdisc)
+		})
+	case args[1] == "del" && len(args) == 5:
+		return "", true, withHandle(ns, func(h *netlink.Handle) error {
+			link, err := linkByName(h, dev)
+			if err != nil {
+				return err
+			}
+			qdiscs, err := h.QdiscList(link)
+			if err != nil {
+				return err
+			}
+			for _, q := range qdiscs {
+				if q.Attrs().Parent == netlink.HANDLE_ROOT && q.Attrs().Handle != 0 {
+					return h.QdiscDel(q)
+				}
+			}
+			return fmt.Errorf("%s: %w", dev, ErrQdiscNotFound)
+		})
+	default:
+		return "", false, nil
+	}
+}
+
+// parseNetemArgs accepts the subset of tc-netem syntax netemArgs produces.
+func parseNetemArgs(args []string) (netlink.NetemQdiscAttrs, bool) {
+	var attrs netlink.NetemQdiscAttrs
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return attrs, false
+		}
+		switch args[i] {
+		case "delay":
+			us, ok := parseTCTime(args[i+1])
+			if !ok {
+				return attrs, false
+			}
+			attrs.Latency = us
+			i++
+			if i+1 < len(args) {
+				if jitter, ok := parseTCTime(args[i+1]); ok {
+					attrs.Jitter = jitter
+					i++
+				}
+			}
+		case "loss":
+			v, err := strconv.ParseFloat(strings.TrimSuffix(args[i+1], "%"), 32)
+			if err != nil || v < 0 || v > 100 {
+				return attrs, false
+			}
+			attrs.Loss = float32(v)
+			i++
+		case "rate":
+			bps, ok := parseTCRate(args[i+1])
+			if !ok {
+				return attrs, false
+			}
+			attrs.Rate64 = bps
+			i++
+		default:
+			return attrs, false
+		}
+	}
+	return attrs, true
+}
+
+func dispatchSysctl(ns string, args []string) (string, bool, error) {
+	if len(args) != 2 {
+		return "", false, nil
+	}
+	switch args[0] {
+	case "-n":
+		var out string
+		err := inNamespace(ns, func() error {
+			data, err := os.ReadFile(sysctlPath(args[1]))
+			out = string(data)
+			return err
+		})
+		return out, true, err
+	case "-w":
+		key, value, ok := strings.Cut(args[1], "=")
+		if !ok {
+			return "", false, nil
+		}
+		return "", true, inNamespace(ns, func() error {
+			return os.WriteFile(sysctlPath(key), []byte(value), 0o644)
+		})
+	default:
+		return "", false, nil
+	}
+}
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
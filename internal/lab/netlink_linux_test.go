@@ -0,0 +1,83 @@
+//go:build linux
+
+package lab
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseNetemArgs(t *testing.T) {
+	attrs, ok := parseNetemArgs(netemArgs("100ms", "20ms", "5%", "1mbit"))
+	if !ok {
+		t.Fatal("expected netem args to parse")
+	}
+	if attrs.Latency != 100000 || attrs.Jitter != 20000 {
+		t.Fatalf("unexpected delay/jitter: %d/%d", attrs.Latency, attrs.Jitter)
+	}
+	if attrs.Loss != 5 {
+		t.Fatalf("unexpected loss: %v", attrs.Loss)
+	}
+	if attrs.Rate64 != 125000 {
+		t.Fatalf("unexpected rate: %d", attrs.Rate64)
+	}
+
+	if _, ok := parseNetemArgs([]string{"delay", "100ms", "distribution", "normal"}); ok {
+		t.Fatal("expected unsupported netem option to fall back to tc")
+	}
+}
+
+func TestNetlinkExecutorFallsBackForUnknownCommands(t *testing.T) {
+	ex := &fakeExecutor{}
+	e := netlinkExecutor{fallback: ex}
+
+	if err := e.Run(t.Context(), "ip", "netns", "exec", "node1", "ping", "-c", "1", "10.200.0.1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := e.Output(t.Context(), "iptables", "-S"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ex.calls) != 2 || ex.calls[0] != "ip netns exec node1 ping -c 1 10.200.0.1" || ex.calls[1] != "iptables -S" {
+		t.Fatalf("unexpected fallback calls: %v", ex.calls)
+	}
+}
+
+func TestLockedThreadDiscardsThreadWhenRestoreFails(t *testing.T) {
+	tid := 0
+	err := runLockedThread(func() error {
+		tid = unix.Gettid()
+		return errors.New("fn failed")
+	}, func() (netns.NsHandle, error) {
+		return netns.None(), nil
+	}, func(netns.NsHandle) error {
+		return errors.New("setns denied")
+	})
+	if err == nil || !strings.Contains(err.Error(), "fn failed") || !strings.Contains(err.Error(), "failed to restore namespace: setns denied") {
+		t.Fatalf("expected fn and restore errors, got %v", err)
+	}
+	if tid == 0 || tid == unix.Gettid() {
+		t.Fatalf("expected fn to run on its own thread, got tid %d", tid)
+	}
+
+	// The thread either exits or, if it is the main thread, is parked by the
+	// runtime; in both cases no later goroutine may run on it.
+	for i := 0; i < 50; i++ {
+		err := runLockedThread(func() error {
+			if unix.Gettid() == tid {
+				return errors.New("thread with the unrestored namespace was reused")
+			}
+			return nil
+		}, func() (netns.NsHandle, error) {
+			return netns.None(), nil
+		}, func(netns.NsHandle) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
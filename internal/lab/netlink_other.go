@@ -0,0 +1,25 @@
+//go:build !linux
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"runtime"
+)
+
+func netlinkSupported() error {
+	return errors.New("netlink backend is supported only on linux: got " + runtime.GOOS)
+}
+
+type netlinkExecutor struct {
+	fallback Executor
+}
+
+func (e netlinkExecutor) Run(ctx context.Context, name string, args ...string) error {
+	return e.fallback.Run(ctx, name, args...)
+}
+
+func (e netlinkExecutor) Output(ctx context.Context, name string, args ...string) (string, error) {
+	return e.fallback.Output(ctx, name, args...)
+}
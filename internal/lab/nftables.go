@@ -0,0 +1,112 @@
+package lab
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	FirewallBackendAuto     = "auto"
+	FirewallBackendIPTables = "iptables"
+	FirewallBackendNFTables = "nftables"
+
+	nftTableFamily = "inet"
+	nftTableName   = "rtc-emulator"
+)
+
+// resolveFirewallBackend picks how host NAT and forwarding rules are
+// installed. Auto prefers iptables whenever it is installed, including the
+// nf_tables shim: its rules land in the `ip filter FORWARD` chain that other
+// tools such as Docker give a drop policy, which a separate nftables table
+// cannot override.
+func resolveFirewallBackend(deps createDeps, requested string) (string, error) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	switch requested {
+	case FirewallBackendIPTables:
+		return FirewallBackendIPTables, requireCommands(deps, "iptables")
+	case FirewallBackendNFTables:
+		return FirewallBackendNFTables, requireCommands(deps, "nft")
+	case "", FirewallBackendAuto:
+	default:
+		return "", fmt.Errorf("unsupported firewall backend %q: use %s, %s, or %s", requested, FirewallBackendAuto, FirewallBackendIPTables, FirewallBackendNFTables)
+	}
+
+	_, iptErr := deps.findPath("iptables")
+	if iptErr == nil {
+		return FirewallBackendIPTables, nil
+	}
+	if _, err := deps.findPath("nft"); err == nil {
+		return FirewallBackendNFTables, nil
+	}
+	return "", fmt.Errorf("required command %q or %q not found: %w", "iptables", "nft", iptErr)
+}
+
+func nftChainArgs(chain string, spec string) []string {
+	return append([]string{"add", "chain", nftTableFamily, nftTableName, chain, "{"}, append(strings.Fields(spec), "}")...)
+}
+
+func nftRuleArgs(chain string, rule string) []string {
+	return append([]string{"add", "rule", nftTableFamily, nftTableName, chain}, strings.Fields(rule)...)
+}
+
+// managedNFTablesCommands mirrors managedIPTablesRules (and the IPv6 set)
+// inside a dedicated table so destroy can drop everything at once.
+func managedNFTablesCommands(ipv6 bool) [][]string {
+	cmds := [][]string{
+		{"add", "table", nftTableFamily, nftTableName},
+		nftChainArgs("forward", "type filter hook forward priority 0 ; policy accept ;"),
+		nftChainArgs("postrouting", "type nat hook postrouting priority 100 ;"),
+		nftRuleArgs("forward", "iifname "+bridgeName+" accept"),
+		nftRuleArgs("forward", "oifname "+bridgeName+" ct state related,established accept"),
+		nftRuleArgs("postrouting", "ip saddr "+subnetCIDR+" oifname != "+bridgeName+" masquerade"),
+	}
+	if ipv6 {
+		cmds = append(cmds, nftRuleArgs("postrouting", "ip6 saddr "+subnetIPv6CIDR+" oifname != "+bridgeName+" masquerade"))
+	}
+	return cmds
+}
+
+func setupNFTables(ctx context.Context, exec Executor, ipv6 bool, cleanups *[]func(context.Context)) error {
+	if err := deleteNFTablesTable(ctx, exec, nftTableName); err != nil {
+		return err
+	}
+	*cleanups = append(*cleanups, func(ctx context.Context) {
+		_ = deleteNFTablesTable(ctx, exec, nftTableName)
+	})
+	for _, args := range managedNFTablesCommands(ipv6) {
+		if err := exec.Run(ctx, "nft", args...); err != nil {
+			return fmt.Errorf("failed to install nftables rules: %w", err)
+		}
+	}
+	return nil
+}
+
+func deleteNFTablesTable(ctx context.Context, exec Executor, table string) error {
+	err := exec.Run(ctx, "nft", "delete", "table", nftTableFamily, table)
+	if err != nil && !isNFTablesTableNotFoundError(err) {
+		return fmt.Errorf("failed to delete nftables table %s: %w", table, err)
+	}
+	return nil
+}
+
+// deleteNFTablesChain flushes and drops a chain of the lab table; a missing
+// chain or table is not an error.
+func deleteNFTablesChain(ctx context.Context, exec Executor, chain string) error {
+	for _, args := range [][]string{
+		{"flush", "chain", nftTableFamily, nftTableName, chain},
+		{"delete", "chain", nftTableFamily, nftTableName, chain},
+	} {
+		if err := exec.Run(ctx, "nft", args...); err != nil && !isNFTablesTableNotFoundError(err) {
+			return fmt.Errorf("failed to delete nftables chain %s: %w", chain, err)
+		}
+	}
+	return nil
+}
+
+func isNFTablesTableNotFoundError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "no such file or directory")
+}
@@ -0,0 +1,146 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveFirewallBackend(t *testing.T) {
+	cases := []struct {
+		name      string
+		requested string
+		missing   []string
+		version   string
+		want      string
+		wantErr   string
+	}{
+		{name: "nft only", missing: []string{"iptables"}, want: FirewallBackendNFTables},
+		{name: "iptables only", missing: []string{"nft"}, want: FirewallBackendIPTables},
+		{name: "both installed", want: FirewallBackendIPTables},
+		{name: "explicit iptables", requested: "iptables", version: "iptables v1.8.9 (nf_tables)\n", want: FirewallBackendIPTables},
+		{name: "explicit nftables missing", requested: "nftables", missing: []string{"nft"}, wantErr: `required command "nft" not found`},
+		{name: "neither", missing: []string{"iptables", "nft"}, wantErr: `required command "iptables" or "nft" not found`},
+		{name: "unknown", requested: "pf", wantErr: `unsupported firewall backend "pf"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ex := &fakeExecutor{outputFn: func(name string, args ...string) (string, error) {
+				return tc.version, nil
+			}}
+			deps := createDeps{
+				exec: ex,
+				findPath: func(cmd string) (string, error) {
+					if containsString(tc.missing, cmd) {
+						return "", errors.New("not found")
+					}
+					return "/bin/" + cmd, nil
+				},
+			}
+			got, err := resolveFirewallBackend(deps, tc.requested)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateWithDeps_NFTablesBackend(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			cmd := callKey(name, args...)
+			if cmd == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			if cmd == "nft delete table inet rtc-emulator" {
+				return errors.New("Error: Could not process rule: No such file or directory")
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			if strings.HasPrefix(callKey(name, args...), "sysctl -n") {
+				return "0\n", nil
+			}
+			return "", nil
+		},
+	}
+	var state LabState
+	deps := firewallTestDeps(ex, &state)
+	deps.findPath = func(cmd string) (string, error) {
+		if cmd == "iptables" || cmd == "ip6tables" {
+			return "", errors.New("not found")
+		}
+		return "/bin/" + cmd, nil
+	}
+	deps.loadState = func(context.Context) (*LabState, error) { return nil, ErrStateNotFound }
+
+	got, err := createWithDeps(context.Background(), CreateOptions{Nodes: 1, IPv6: true}, deps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.FirewallBackend != FirewallBackendNFTables {
+		t.Fatalf("expected nftables backend, got %q", got.FirewallBackend)
+	}
+	for _, want := range []string{
+		"nft add table inet rtc-emulator",
+		"nft add chain inet rtc-emulator postrouting { type nat hook postrouting priority 100 ; }",
+		"nft add rule inet rtc-emulator forward iifname rtcemu0 accept",
+		"nft add rule inet rtc-emulator postrouting ip saddr 10.200.0.0/24 oifname != rtcemu0 masquerade",
+		"nft add rule inet rtc-emulator postrouting ip6 saddr fd00:200::/64 oifname != rtcemu0 masquerade",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	for _, c := range ex.calls {
+		if strings.HasPrefix(c, "iptables") || strings.HasPrefix(c, "ip6tables") {
+			t.Fatalf("unexpected iptables call with nftables backend: %s", c)
+		}
+	}
+	if state.NFTablesTable != "rtc-emulator" || state.FirewallBackend != FirewallBackendNFTables || len(state.Rules) != 0 || len(state.RulesIPv6) != 0 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+}
+
+func TestDestroyWithDeps_DeletesNFTablesTable(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			return nil
+		},
+	}
+	deps := createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath: func(cmd string) (string, error) {
+			if cmd == "iptables" {
+				return "", errors.New("not found")
+			}
+			return "/bin/" + cmd, nil
+		},
+		loadState: func(context.Context) (*LabState, error) {
+			return &LabState{Bridge: bridgeName, Nodes: []string{"node1"}, FirewallBackend: FirewallBackendNFTables, NFTablesTable: nftTableName}, nil
+		},
+		deleteState: func(context.Context) error { return nil },
+	}
+
+	if _, err := destroyWithDeps(context.Background(), DestroyOptions{}, deps); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hasCall(ex.calls, "nft delete table inet rtc-emulator") {
+		t.Fatalf("expected nftables table deletion, got %v", ex.calls)
+	}
+}
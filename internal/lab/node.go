@@ -0,0 +1,203 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxLabNodes = 250
+
+type NodeAddOptions struct {
+	Name string
+}
+
+type NodeRemoveOptions struct {
+	Name string
+}
+
+type NodeRemoveResult struct {
+	Name         string
+	NATNamespace string
+}
+
+func AddNode(ctx context.Context, opts NodeAddOptions) (*Node, error) {
+	return addNodeWithDeps(ctx, opts, defaultCreateDeps(ctx))
+}
+
+func addNodeWithDeps(ctx context.Context, opts NodeAddOptions, deps createDeps) (*Node, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if err := validateNodeEnvironment(deps, "lab node add", "ip", "ping"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab node add")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.Links) > 0 {
+		return nil, errors.New("lab node add is not supported for topology labs")
+	}
+	i, err := nextNodeIndex(state, strings.TrimSpace(opts.Name))
+	if err != nil {
+		return nil, err
+	}
+	name := "node" + strconv.Itoa(i)
+	namespaces, err := listNamespaces(ctx, deps.exec)
+	if err != nil {
+		return nil, err
+	}
+	if containsString(namespaces, name) || containsString(namespaces, natNamespacePrefix+name) {
+		return nil, fmt.Errorf("namespace for %s already exists: run `rtc-emulator lab node remove %s` or delete it manually", name, name)
+	}
+
+	var cleanups []func(context.Context)
+	rollback := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i](ctx)
+		}
+	}
+	node, err := createBridgeNode(ctx, deps.exec, i, state.SubnetIPv6 != "", &cleanups)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	// The new node gets the MTU the lab was created with, like its peers.
+	if state.CreateMTU != 0 {
+		if err := setNodeMTU(ctx, deps.exec, state, name, state.CreateMTU); err != nil {
+			rollback()
+			return nil, err
+		}
+		recordNodeMTU(state, name, state.CreateMTU)
+	}
+
+	state.Nodes = append(state.Nodes, name)
+	if err := deps.saveState(ctx, state); err != nil {
+		rollback()
+		return nil, fmt.Errorf("failed to persist lab state: %w", err)
+	}
+	recordDryRunRollback(deps.exec, rollback)
+	return &node, nil
+}
+
+func RemoveNode(ctx context.Context, opts NodeRemoveOptions) (*NodeRemoveResult, error) {
+	return removeNodeWithDeps(ctx, opts, defaultCreateDeps(ctx))
+}
+
+func removeNodeWithDeps(ctx context.Context, opts NodeRemoveOptions, deps createDeps) (*NodeRemoveResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if err := validateNodeEnvironment(deps, "lab node remove", "ip"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab node remove")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	name := strings.TrimSpace(opts.Name)
+	if name == "" {
+		return nil, errors.New("node name is required")
+	}
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.Links) > 0 {
+		return nil, errors.New("lab node remove is not supported for topology labs")
+	}
+	if !containsString(state.Nodes, name) {
+		return nil, fmt.Errorf("node %q is not managed by current lab", name)
+	}
+
+	result := &NodeRemoveResult{Name: name}
+	if err := deps.exec.Run(ctx, "ip", "netns", "del", name); err != nil && !isNamespaceNotFoundError(err) {
+		return nil, fmt.Errorf("failed to delete namespace %s: %w", name, err)
+	}
+	if nat, ok := findNodeNAT(state.NAT, name); ok {
+		if err := deps.exec.Run(ctx, "ip", "netns", "del", nat.Namespace); err != nil && !isNamespaceNotFoundError(err) {
+			return nil, fmt.Errorf("failed to delete namespace %s: %w", nat.Namespace, err)
+		}
+		result.NATNamespace = nat.Namespace
+	}
+
+	forgetNode(state, name)
+	if err := deps.saveState(ctx, state); err != nil {
+		return nil, fmt.Errorf("failed to persist lab state: %w", err)
+	}
+	return result, nil
+}
+
+func validateNodeEnvironment(deps createDeps, operation string, cmds ...string) error {
+	if deps.goos != "linux" {
+		return fmt.Errorf("%s is supported only on linux: got %s", operation, deps.goos)
+	}
+	if !deps.hasNetAdmin() {
+		return fmt.Errorf("%s requires CAP_NET_ADMIN: run as root or with --rootless", operation)
+	}
+	return requireCommands(deps, cmds...)
+}
+
+// nextNodeIndex returns the index for name, or the lowest free index when name
+// is empty. The index fixes the node address, so names must be nodeN.
+func nextNodeIndex(state *LabState, name string) (int, error) {
+	if name != "" {
+		i, err := nodeIndex(name)
+		if err != nil {
+			return 0, err
+		}
+		if i > maxLabNodes {
+			return 0, fmt.Errorf("node %q is outside the lab subnet", name)
+		}
+		if containsString(state.Nodes, name) {
+			return 0, fmt.Errorf("node %q already exists", name)
+		}
+		return i, nil
+	}
+	for i := 1; i <= maxLabNodes; i++ {
+		if !containsString(state.Nodes, "node"+strconv.Itoa(i)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("lab already has %d nodes", maxLabNodes)
+}
+
+func forgetNode(state *LabState, name string) {
+	nodes := state.Nodes[:0]
+	for _, n := range state.Nodes {
+		if n != name {
+			nodes = append(nodes, n)
+		}
+	}
+	state.Nodes = nodes
+
+	nats := state.NAT[:0]
+	for _, nat := range state.NAT {
+		if nat.Node != name {
+			nats = append(nats, nat)
+		}
+	}
+	state.NAT = nats
+
+	if i := indexOfFirewall(state.Firewalls, name); i >= 0 {
+		state.Firewalls = append(state.Firewalls[:i], state.Firewalls[i+1:]...)
+	}
+
+	mtus := state.MTU[:0]
+	for _, m := range state.MTU {
+		if m.Node != name {
+			mtus = append(mtus, m)
+		}
+	}
+	state.MTU = mtus
+
+	state.Handovers = removeNodeHandover(state.Handovers, name)
+	state.Impairments = removeNodeImpairment(state.Impairments, name)
+}
@@ -0,0 +1,117 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAddNodeWithDeps_AllocatesLowestFreeIndex(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\nnode3\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1", "node3"}}
+
+	got, err := addNodeWithDeps(context.Background(), NodeAddOptions{}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Name != "node2" || got.IP != "10.200.0.3" {
+		t.Fatalf("unexpected node: %+v", got)
+	}
+	for _, want := range []string{
+		"ip netns add node2",
+		"ip link set br-node2 master rtcemu0",
+		"ip netns exec node2 ip addr add 10.200.0.3/24 dev eth0",
+		"ip netns exec node2 ping -c 1 -W 1 10.200.0.1",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if strings.Join(state.Nodes, ",") != "node1,node3,node2" {
+		t.Fatalf("unexpected saved nodes: %v", state.Nodes)
+	}
+}
+
+func TestAddNodeWithDeps_RollsBackOnFailure(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip netns exec node2 ping -c 1 -W 1 10.200.0.1" {
+				return errors.New("unreachable")
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1"}}
+
+	_, err := addNodeWithDeps(context.Background(), NodeAddOptions{Name: "node2"}, firewallTestDeps(ex, state))
+	if err == nil || !strings.Contains(err.Error(), "connectivity check failed for node2") {
+		t.Fatalf("expected connectivity error, got %v", err)
+	}
+	if !hasCall(ex.calls, "ip netns del node2") {
+		t.Fatalf("expected rollback of node2 namespace")
+	}
+	if len(state.Nodes) != 1 {
+		t.Fatalf("state must not change on failure: %v", state.Nodes)
+	}
+}
+
+func TestAddNodeWithDeps_AppliesCreateMTU(t *testing.T) {
+	ex := &fakeExecutor{
+		outputFn: func(name string, args ...string) (string, error) {
+			return "node1\n", nil
+		},
+	}
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1"}, CreateMTU: 1200, MTU: []NodeMTU{{Node: "node1", MTU: 1200}}}
+
+	if _, err := addNodeWithDeps(context.Background(), NodeAddOptions{}, firewallTestDeps(ex, state)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		"ip netns exec node2 ip link set eth0 mtu 1200",
+		"ip link set br-node2 mtu 1200",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if nodeMTU(state, "node2") != 1200 {
+		t.Fatalf("expected node2 mtu to be recorded, got %+v", state.MTU)
+	}
+}
+
+func TestRemoveNodeWithDeps_DeletesNamespacesAndForgetsNode(t *testing.T) {
+	ex := &fakeExecutor{}
+	state := &LabState{
+		Bridge:    bridgeName,
+		Nodes:     []string{"node1", "node2"},
+		NAT:       []NodeNAT{{Node: "node2", Type: NATPortRestricted, Namespace: "nat-node2"}},
+		Firewalls: []NodeFirewall{{Node: "node2", Profile: FirewallBlockUDP}},
+		MTU:       []NodeMTU{{Node: "node1", MTU: 1400}, {Node: "node2", MTU: 1400}},
+	}
+
+	got, err := removeNodeWithDeps(context.Background(), NodeRemoveOptions{Name: "node2"}, firewallTestDeps(ex, state))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.NATNamespace != "nat-node2" {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+	if !hasCall(ex.calls, "ip netns del node2") || !hasCall(ex.calls, "ip netns del nat-node2") {
+		t.Fatalf("expected namespace deletion, got %v", ex.calls)
+	}
+	if len(state.Nodes) != 1 || len(state.NAT) != 0 || len(state.Firewalls) != 0 || len(state.MTU) != 1 {
+		t.Fatalf("expected node2 to be forgotten, got %+v", state)
+	}
+
+	if _, err := removeNodeWithDeps(context.Background(), NodeRemoveOptions{Name: "node2"}, firewallTestDeps(ex, state)); err == nil {
+		t.Fatalf("expected error removing unknown node")
+	}
+}
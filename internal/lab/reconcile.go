@@ -0,0 +1,533 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReconcileFix is one change lab reconcile made, or tried to make when Error
+// is set.
+type ReconcileFix struct {
+	Item   string `json:"item"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ReconcileResult struct {
+	Fixes []ReconcileFix `json:"fixes"`
+}
+
+// Reconcile compares the lab state file with the kernel and recreates or
+// removes what drifted: the bridge, forwarding, host rules, node namespaces
+// and veths, node addresses and routes, MTU, firewall rules, and netem qdiscs.
+func Reconcile(ctx context.Context) (*ReconcileResult, error) {
+	return reconcileWithDeps(ctx, defaultCreateDeps(ctx))
+}
+
+type reconciler struct {
+	exec   Executor
+	state  *LabState
+	result *ReconcileResult
+	errs   []error
+}
+
+// run executes cmds in order and records them as one fix.
+func (r *reconciler) run(ctx context.Context, item, action string, cmds ...[]string) bool {
+	for _, cmd := range cmds {
+		if err := r.exec.Run(ctx, cmd[0], cmd[1:]...); err != nil {
+			r.record(item, action, err)
+			return false
+		}
+	}
+	r.record(item, action, nil)
+	return true
+}
+
+func (r *reconciler) record(item, action string, err error) {
+	fix := ReconcileFix{Item: item, Action: action}
+	if err != nil {
+		fix.Error = err.Error()
+		r.errs = append(r.errs, fmt.Errorf("failed to reconcile %s: %w", item, err))
+	}
+	r.result.Fixes = append(r.result.Fixes, fix)
+}
+
+func reconcileWithDeps(ctx context.Context, deps createDeps) (*ReconcileResult, error) {
+	deps = fillCreateDeps(ctx, deps)
+
+	if err := validateNodeEnvironment(deps, "lab reconcile", "ip", "tc", "sysctl", "ping"); err != nil {
+		return nil, err
+	}
+	unlock, err := lockLab(ctx, deps, "lab reconcile")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	state, err := loadStateForUpdate(ctx, deps)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.Links) > 0 {
+		return nil, errors.New("lab reconcile is not supported for topology labs")
+	}
+
+	r := &reconciler{exec: deps.exec, state: state, result: &ReconcileResult{}}
+	recreatedBridge, err := r.bridge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.hostRules(ctx); err != nil {
+		return nil, err
+	}
+
+	namespaces, err := listNamespaces(ctx, deps.exec)
+	if err != nil {
+		return nil, err
+	}
+	linkShow, err := deps.exec.Output(ctx, "ip", "-o", "link", "show")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	var members []string
+	if !recreatedBridge {
+		if members, _, err = listBridgeMembers(ctx, deps.exec, bridgeName); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := false
+	handedOver := len(state.Handovers) > 0
+	for _, node := range state.Nodes {
+		if r.node(ctx, node, namespaces, linkShow, members) {
+			changed = true
+		}
+	}
+	if handedOver && len(state.Handovers) == 0 {
+		// Recreated nodes are back on their lab address.
+		r.record("handover masquerade", "removed", removeHandoverMasquerade(ctx, deps.exec, state))
+	}
+	r.leftovers(ctx, namespaces, managedBridgePeers(linkShow))
+
+	if changed {
+		if err := deps.saveState(ctx, state); err != nil {
+			r.errs = append(r.errs, fmt.Errorf("failed to persist lab state: %w", err))
+		}
+	}
+	return r.result, errors.Join(r.errs...)
+}
+
+// bridge recreates the lab bridge or restores its addresses and link state.
+func (r *reconciler) bridge(ctx context.Context) (bool, error) {
+	want := []string{bridgeCIDR}
+	if r.state.SubnetIPv6 != "" {
+		want = append(want, bridgeIPv6CIDR)
+	}
+	if len(r.state.Handovers) > 0 {
+		want = append(want, handoverGatewayCIDR)
+	}
+	addrCmd := func(cidr string) []string {
+		if strings.Contains(cidr, ":") {
+			return []string{"ip", "-6", "addr", "add", cidr, "dev", bridgeName, "nodad"}
+		}
+		return []string{"ip", "addr", "add", cidr, "dev", bridgeName}
+	}
+
+	exists, err := bridgeExists(ctx, r.exec, bridgeName)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		cmds := [][]string{{"ip", "link", "add", bridgeName, "type", "bridge"}}
+		for _, cidr := range want {
+			cmds = append(cmds, addrCmd(cidr))
+		}
+		cmds = append(cmds, []string{"ip", "link", "set", bridgeName, "up"})
+		r.run(ctx, "bridge "+bridgeName, "recreated", cmds...)
+		return true, nil
+	}
+
+	addrs, err := r.exec.Output(ctx, "ip", "-o", "addr", "show", "dev", bridgeName)
+	if err != nil {
+		return false, fmt.Errorf("failed to list addresses of %s: %w", bridgeName, err)
+	}
+	for _, cidr := range want {
+		if !containsString(strings.Fields(addrs), cidr) {
+			r.run(ctx, "bridge "+bridgeName, "added address "+cidr, addrCmd(cidr))
+		}
+	}
+	link, err := r.exec.Output(ctx, "ip", "-o", "link", "show", bridgeName)
+	if err != nil {
+		return false, fmt.Errorf("failed to show %s: %w", bridgeName, err)
+	}
+	if !linkIsUp(link) {
+		r.run(ctx, "bridge "+bridgeName, "set up", []string{"ip", "link", "set", bridgeName, "up"})
+	}
+	return false, nil
+}
+
+// hostRules restores forwarding and the host firewall rules of the lab.
+func (r *reconciler) hostRules(ctx context.Context) error {
+	sysctls := []string{"net.ipv4.ip_forward"}
+	if r.state.SubnetIPv6 != "" {
+		sysctls = append(sysctls, "net.ipv6.conf.all.forwarding")
+	}
+	for _, key := range sysctls {
+		v, err := readSysctlFlag(ctx, r.exec, key)
+		if err != nil {
+			return err
+		}
+		if v != "1" {
+			r.run(ctx, "sysctl "+key, "set to 1", []string{"sysctl", "-w", key + "=1"})
+		}
+	}
+
+	if r.state.FirewallBackend == FirewallBackendNFTables {
+		if err := r.exec.Run(ctx, "nft", "list", "table", nftTableFamily, nftTableName); err != nil {
+			if !isNFTablesTableNotFoundError(err) {
+				return fmt.Errorf("failed to check nftables table %s: %w", nftTableName, err)
+			}
+			var cleanups []func(context.Context)
+			err := setupNFTables(ctx, r.exec, r.state.SubnetIPv6 != "", &cleanups)
+			r.record("nftables table "+nftTableFamily+" "+nftTableName, "recreated", err)
+			if err != nil {
+				return nil
+			}
+		}
+		if len(r.state.Handovers) > 0 {
+			r.handoverChain(ctx)
+		}
+		return nil
+	}
+	for _, set := range []struct {
+		command string
+		rules   []IPTablesRule
+	}{{"iptables", r.state.Rules}, {"ip6tables", r.state.RulesIPv6}} {
+		for _, rule := range set.rules {
+			r.ensureRule(ctx, set.command+" "+strings.Join(rule.AddArgs, " "), []string{set.command}, rule)
+		}
+	}
+	return nil
+}
+
+// firewall restores the firewall profile of an existing node.
+func (r *reconciler) firewall(ctx context.Context, fw NodeFirewall) {
+	item := fw.Node + " firewall " + fw.Profile
+	var checks [][]string
+	if fw.NFTables {
+		for _, chain := range firewallNFTablesChains(fw.Profile) {
+			checks = append(checks, nodeNFTablesArgs(fw.Node, []string{"list", "chain", nftTableFamily, nftTableName, chain}))
+		}
+	}
+	for _, family := range []struct {
+		command string
+		rules   []IPTablesRule
+	}{{"iptables", fw.Rules}, {"ip6tables", fw.RulesIPv6}} {
+		for _, rule := range family.rules {
+			checks = append(checks, nodeIPTablesArgs(fw.Node, family.command, rule.CheckArgs))
+		}
+	}
+	for _, check := range checks {
+		err := r.exec.Run(ctx, "ip", check...)
+		if err == nil {
+			continue
+		}
+		if !isIPTablesRuleNotFoundError(err) && !isNFTablesTableNotFoundError(err) {
+			r.record(item, "check", err)
+			return
+		}
+		// The rules of a profile only work in order (an ACCEPT appended after
+		// the DROP never matches), so a partial profile is rebuilt as a whole.
+		restored := NodeFirewall{Node: fw.Node, Profile: fw.Profile, NFTables: fw.NFTables}
+		err = deleteNodeFirewallRules(ctx, r.exec, fw)
+		if err == nil {
+			err = addNodeFirewallRules(ctx, r.exec, &restored, fw.Rules, fw.RulesIPv6)
+		}
+		r.record(item, "restored", err)
+		return
+	}
+}
+
+// natTable restores the nftables table of a NAT namespace. Its rules are only
+// checked as a whole, since nft cannot test for a single rule.
+func (r *reconciler) natTable(ctx context.Context, nat NodeNAT) {
+	item := nat.Namespace + " nftables table " + nftTableFamily + " " + nftTableName
+	err := r.exec.Run(ctx, "ip", "netns", "exec", nat.Namespace, "nft", "list", "table", nftTableFamily, nftTableName)
+	if err == nil {
+		return
+	}
+	if !isNFTablesTableNotFoundError(err) {
+		r.record(item, "check", err)
+		return
+	}
+	r.run(ctx, item, "restored", natRuleCommands(nat, FirewallBackendNFTables)...)
+}
+
+// handoverChain restores the nftables masquerade of active handovers.
+func (r *reconciler) handoverChain(ctx context.Context) {
+	item := "nftables chain " + nftHandoverChain
+	err := r.exec.Run(ctx, "nft", "list", "chain", nftTableFamily, nftTableName, nftHandoverChain)
+	if err == nil {
+		return
+	}
+	if !isNFTablesTableNotFoundError(err) {
+		r.record(item, "check", err)
+		return
+	}
+	var cmds [][]string
+	for _, args := range handoverNFTablesCommands() {
+		cmds = append(cmds, append([]string{"nft"}, args...))
+	}
+	r.run(ctx, item, "restored", cmds...)
+}
+
+// ensureRule adds rule with the command in prefix unless its check passes.
+func (r *reconciler) ensureRule(ctx context.Context, item string, prefix []string, rule IPTablesRule) {
+	check := append(append([]string(nil), prefix...), rule.CheckArgs...)
+	err := r.exec.Run(ctx, check[0], check[1:]...)
+	if err == nil {
+		return
+	}
+	if !isIPTablesRuleNotFoundError(err) {
+		r.record(item, "check", err)
+		return
+	}
+	r.run(ctx, item, "restored", append(append([]string(nil), prefix...), rule.AddArgs...))
+}
+
+// node converges one node and reports whether the state file changed.
+func (r *reconciler) node(ctx context.Context, node string, namespaces []string, linkShow string, members []string) bool {
+	i, err := nodeIndex(node)
+	if err != nil {
+		r.record(node, "check", err)
+		return false
+	}
+	nat, isNAT := findNodeNAT(r.state.NAT, node)
+	peer := "br-" + node
+	present := containsString(namespaces, node) && containsString(managedBridgePeers(linkShow), peer)
+	if isNAT {
+		present = present && containsString(namespaces, nat.Namespace)
+	}
+	var eth0 string
+	if present {
+		eth0, err = r.exec.Output(ctx, "ip", "netns", "exec", node, "ip", "-o", "link", "show", "eth0")
+		present = err == nil
+	}
+	if !present {
+		return r.recreateNode(ctx, node, i, nat, isNAT, namespaces, linkShow)
+	}
+
+	if !containsString(members, peer) {
+		r.run(ctx, "veth "+peer, "attached to "+bridgeName, []string{"ip", "link", "set", peer, "master", bridgeName})
+	}
+	if !linkIsUp(linkLine(linkShow, peer)) {
+		r.run(ctx, "veth "+peer, "set up", []string{"ip", "link", "set", peer, "up"})
+	}
+	if !linkIsUp(eth0) {
+		r.run(ctx, node+" eth0", "set up", []string{"ip", "netns", "exec", node, "ip", "link", "set", "eth0", "up"})
+	}
+	if mtu := nodeMTU(r.state, node); linkMTU(eth0) != mtu {
+		r.record(node+" eth0", "set mtu "+strconv.Itoa(mtu), setNodeMTU(ctx, r.exec, r.state, node, mtu))
+	}
+
+	if _, handedOver := findNodeHandover(r.state.Handovers, node); !handedOver {
+		addr, gateway := nodeIPForIndex(i)+"/24", bridgeIP
+		if isNAT {
+			addr, gateway = nat.PrivateIP+"/24", natGatewayIP(i)
+		}
+		r.address(ctx, node, "", addr, gateway)
+		if r.state.SubnetIPv6 != "" && !isNAT {
+			r.address(ctx, node, "-6", nodeIPv6ForIndex(i)+"/64", bridgeIPv6)
+		}
+	}
+	switch {
+	case isNAT && r.state.FirewallBackend == FirewallBackendNFTables:
+		r.natTable(ctx, nat)
+	case isNAT:
+		for _, args := range natIPTablesArgs(nat) {
+			check := append([]string(nil), args...)
+			for k, arg := range check {
+				if arg == "-A" {
+					check[k] = "-C"
+				}
+			}
+			r.ensureRule(ctx, nat.Namespace+" iptables "+strings.Join(args, " "),
+				[]string{"ip", "netns", "exec", nat.Namespace, "iptables"},
+				IPTablesRule{CheckArgs: check, AddArgs: args})
+		}
+	}
+	if k := indexOfFirewall(r.state.Firewalls, node); k >= 0 {
+		r.firewall(ctx, r.state.Firewalls[k])
+	}
+	r.qdisc(ctx, node)
+	return false
+}
+
+// address restores the node's eth0 address and default route for one family.
+func (r *reconciler) address(ctx context.Context, node, family, addr, gateway string) {
+	ip := []string{"ip", "netns", "exec", node, "ip"}
+	if family != "" {
+		ip = append(ip, family)
+	}
+	cmd := func(args ...string) []string {
+		return append(append([]string(nil), ip...), args...)
+	}
+	show := cmd("-o", "addr", "show", "dev", "eth0")
+	out, err := r.exec.Output(ctx, show[0], show[1:]...)
+	if err != nil {
+		r.record(node+" eth0", "check address", err)
+		return
+	}
+	if !containsString(strings.Fields(out), addr) {
+		add := cmd("addr", "add", addr, "dev", "eth0")
+		if family == "-6" {
+			add = append(add, "nodad")
+		}
+		r.run(ctx, node+" eth0", "added address "+addr, add)
+	}
+	show = cmd("route", "show", "default")
+	out, err = r.exec.Output(ctx, show[0], show[1:]...)
+	if err != nil {
+		r.record(node+" route", "check default route", err)
+		return
+	}
+	if !strings.Contains(out, "via "+gateway+" ") && !strings.HasSuffix(strings.TrimSpace(out), "via "+gateway) {
+		r.run(ctx, node+" route", "set default via "+gateway, cmd("route", "replace", "default", "via", gateway))
+	}
+}
+
+// qdisc restores the recorded netem on the node, or removes a netem that lab
+// impairment apply did not record.
+func (r *reconciler) qdisc(ctx context.Context, node string) {
+	out, err := r.exec.Output(ctx, "ip", "netns", "exec", node, "tc", "qdisc", "show", "dev", "eth0")
+	if err != nil {
+		r.record(node+" qdisc", "check", err)
+		return
+	}
+	hasNetem := strings.Contains(out, "netem")
+	imp := findNodeImpairment(r.state.Impairments, node)
+	switch {
+	case imp != nil && !hasNetem:
+		r.applyImpairment(ctx, node, imp)
+	case imp == nil && hasNetem:
+		r.run(ctx, node+" qdisc", "removed netem not in state", []string{"ip", "netns", "exec", node, "tc", "qdisc", "del", "dev", "eth0", "root"})
+	}
+}
+
+func (r *reconciler) applyImpairment(ctx context.Context, node string, imp *NodeImpairment) {
+	cmd := append([]string{"ip", "netns", "exec", node, "tc", "qdisc", "replace", "dev", "eth0", "root", "netem"},
+		netemArgs(imp.Delay, imp.Jitter, imp.Loss, imp.BW)...)
+	r.run(ctx, node+" qdisc", "restored netem "+strings.Join(cmd[11:], " "), cmd)
+}
+
+// recreateNode deletes what is left of a broken node and builds it again with
+// the MTU, firewall rules, and netem recorded for it. A handover is dropped
+// because the node comes back on its lab address.
+func (r *reconciler) recreateNode(ctx context.Context, node string, i int, nat NodeNAT, isNAT bool, namespaces []string, linkShow string) bool {
+	for _, ns := range []string{node, natNamespacePrefix + node} {
+		if containsString(namespaces, ns) {
+			if err := r.exec.Run(ctx, "ip", "netns", "del", ns); err != nil && !isNamespaceNotFoundError(err) {
+				r.record(node, "recreated", err)
+				return false
+			}
+		}
+	}
+	if peer := "br-" + node; containsString(managedBridgePeers(linkShow), peer) {
+		if err := r.exec.Run(ctx, "ip", "link", "del", peer); err != nil && !isBridgeNotFoundError(err, peer) {
+			r.record(node, "recreated", err)
+			return false
+		}
+	}
+
+	var cleanups []func(context.Context)
+	var err error
+	if isNAT {
+		_, err = createNATNode(ctx, r.exec, i, nat.Type, r.state.FirewallBackend, &cleanups)
+	} else {
+		_, err = createBridgeNode(ctx, r.exec, i, r.state.SubnetIPv6 != "", &cleanups)
+	}
+	if err != nil {
+		for k := len(cleanups) - 1; k >= 0; k-- {
+			cleanups[k](ctx)
+		}
+		r.record(node, "recreated", err)
+		return false
+	}
+	r.record(node, "recreated", nil)
+
+	if mtu := nodeMTU(r.state, node); mtu != defaultMTU {
+		r.record(node+" eth0", "set mtu "+strconv.Itoa(mtu), setNodeMTU(ctx, r.exec, r.state, node, mtu))
+	}
+	if k := indexOfFirewall(r.state.Firewalls, node); k >= 0 {
+		fw := r.state.Firewalls[k]
+		restored := NodeFirewall{Node: node, Profile: fw.Profile, NFTables: fw.NFTables}
+		r.record(node+" firewall "+fw.Profile, "restored", addNodeFirewallRules(ctx, r.exec, &restored, fw.Rules, fw.RulesIPv6))
+	}
+	if imp := findNodeImpairment(r.state.Impairments, node); imp != nil {
+		r.applyImpairment(ctx, node, imp)
+	}
+	if _, ok := findNodeHandover(r.state.Handovers, node); ok {
+		r.state.Handovers = removeNodeHandover(r.state.Handovers, node)
+		return true
+	}
+	return false
+}
+
+// leftovers removes managed namespaces and bridge peers that no node in the
+// state file owns.
+func (r *reconciler) leftovers(ctx context.Context, namespaces []string, peers []string) {
+	owned := func(name string) bool {
+		if containsString(r.state.Nodes, name) {
+			return true
+		}
+		_, ok := findNodeNAT(r.state.NAT, strings.TrimPrefix(name, natNamespacePrefix))
+		return ok && isManagedNATNamespace(name)
+	}
+	for _, ns := range namespaces {
+		managed := isManagedNodeName(ns) || isManagedNATNamespace(ns) || isManagedRouterNamespace(ns) || ns == doctorProbeNamespace
+		if managed && !owned(ns) {
+			r.run(ctx, "namespace "+ns, "removed", []string{"ip", "netns", "del", ns})
+		}
+	}
+	for _, peer := range peers {
+		if !containsString(r.state.Nodes, strings.TrimPrefix(peer, "br-")) {
+			r.run(ctx, "veth "+peer, "removed", []string{"ip", "link", "del", peer})
+		}
+	}
+}
+
+// linkLine returns the line of ip -o link show output for name.
+func linkLine(linkShow, name string) string {
+	for _, line := range strings.Split(linkShow, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if n, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@"); n == name {
+			return line
+		}
+	}
+	return ""
+}
+
+func linkIsUp(line string) bool {
+	_, rest, ok := strings.Cut(line, "<")
+	if !ok {
+		return false
+	}
+	flags, _, _ := strings.Cut(rest, ">")
+	return containsString(strings.Split(flags, ","), "UP")
+}
+
+func linkMTU(line string) int {
+	fields := strings.Fields(line)
+	for k := 0; k+1 < len(fields); k++ {
+		if fields[k] == "mtu" {
+			mtu, _ := strconv.Atoi(fields[k+1])
+			return mtu
+		}
+	}
+	return 0
+}
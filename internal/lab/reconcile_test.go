@@ -0,0 +1,200 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// reconcileTestExecutor answers probes of a two-node lab; outputs and failing
+// commands override the healthy answers.
+func reconcileTestExecutor(outputs map[string]string, failing map[string]string) *fakeExecutor {
+	healthy := map[string]string{
+		"ip -o addr show dev rtcemu0":                  "3: rtcemu0    inet 10.200.0.1/24 brd 10.200.0.255 scope global rtcemu0",
+		"ip -o link show rtcemu0":                      "3: rtcemu0: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP",
+		"sysctl -n net.ipv4.ip_forward":                "1",
+		"ip netns list":                                "node2\nnode1\n",
+		"ip -o link show":                              "7: br-node1@if2: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master rtcemu0\n9: br-node2@if2: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master rtcemu0\n",
+		"ip -o link show master rtcemu0":               "7: br-node1@if2: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master rtcemu0\n9: br-node2@if2: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 master rtcemu0\n",
+		"ip netns exec node1 ip -o link show eth0":     "2: eth0@if7: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP",
+		"ip netns exec node2 ip -o link show eth0":     "2: eth0@if9: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP",
+		"ip netns exec node1 ip -o addr show dev eth0": "2: eth0    inet 10.200.0.2/24 scope global eth0",
+		"ip netns exec node2 ip -o addr show dev eth0": "2: eth0    inet 10.200.0.3/24 scope global eth0",
+		"ip netns exec node1 ip route show default":    "default via 10.200.0.1 dev eth0",
+		"ip netns exec node2 ip route show default":    "default via 10.200.0.1 dev eth0",
+		"ip netns exec node1 tc qdisc show dev eth0":   "qdisc noqueue 0: root refcnt 2",
+		"ip netns exec node2 tc qdisc show dev eth0":   "qdisc netem 8001: root refcnt 2 limit 1000 delay 80ms",
+	}
+	for k, v := range outputs {
+		healthy[k] = v
+	}
+	return &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if msg, ok := failing[callKey(name, args...)]; ok {
+				return errors.New(msg)
+			}
+			return nil
+		},
+		outputFn: func(name string, args ...string) (string, error) {
+			key := callKey(name, args...)
+			if msg, ok := failing[key]; ok {
+				return "", errors.New(msg)
+			}
+			return healthy[key], nil
+		},
+	}
+}
+
+func reconcileTestDeps(ex *fakeExecutor, state *LabState, saved **LabState) createDeps {
+	return createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(cmd string) (string, error) { return "/bin/" + cmd, nil },
+		loadState:   func(context.Context) (*LabState, error) { return state, nil },
+		saveState: func(_ context.Context, s *LabState) error {
+			*saved = s
+			return nil
+		},
+	}
+}
+
+func reconcileTestState() *LabState {
+	return &LabState{
+		Bridge:      bridgeName,
+		Subnet:      subnetCIDR,
+		Nodes:       []string{"node1", "node2"},
+		Rules:       managedIPTablesRules(),
+		Impairments: []NodeImpairment{{Node: "node2", Delay: "80ms"}},
+	}
+}
+
+func TestReconcileWithDeps_NothingToFix(t *testing.T) {
+	ex := reconcileTestExecutor(nil, nil)
+	var saved *LabState
+	got, err := reconcileWithDeps(context.Background(), reconcileTestDeps(ex, reconcileTestState(), &saved))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Fixes) != 0 || saved != nil {
+		t.Fatalf("expected no fixes, got %+v", got.Fixes)
+	}
+	for _, c := range ex.calls {
+		if strings.Contains(c, " add ") || strings.Contains(c, " del ") || strings.Contains(c, " -A ") {
+			t.Fatalf("expected only probes, got %q in %v", c, ex.calls)
+		}
+	}
+}
+
+func TestReconcileWithDeps_RepairsDrift(t *testing.T) {
+	ex := reconcileTestExecutor(map[string]string{
+		"ip -o addr show dev rtcemu0":                  "",
+		"ip netns list":                                "node1\nnode7\n",
+		"ip -o link show":                              "7: br-node1@if2: <BROADCAST,MULTICAST> mtu 1500\n9: br-node2@if2: <BROADCAST,MULTICAST,UP> mtu 1500\n",
+		"ip -o link show master rtcemu0":               "",
+		"ip netns exec node1 ip -o link show eth0":     "2: eth0@if7: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1400 qdisc netem state UP",
+		"ip netns exec node1 ip route show default":    "",
+		"ip netns exec node1 tc qdisc show dev eth0":   "qdisc netem 8001: root refcnt 2 limit 1000 loss 5%",
+		"ip netns exec node1 ip -o addr show dev eth0": "",
+	}, map[string]string{
+		"iptables -C FORWARD -i rtcemu0 -j ACCEPT": "iptables: Bad rule (does a matching rule exist in that chain?).",
+	})
+	state := reconcileTestState()
+	state.Handovers = []NodeHandover{{Node: "node2", IP: handoverIPForIndex(2)}}
+	state.Firewalls = []NodeFirewall{{
+		Node:      "node2",
+		Profile:   FirewallBlockUDP,
+		Rules:     []IPTablesRule{firewallRule("OUTPUT", "-p", "udp", "-j", "DROP")},
+		RulesIPv6: []IPTablesRule{firewallRule("OUTPUT", "-p", "udp", "-j", "DROP")},
+	}}
+	var saved *LabState
+	got, err := reconcileWithDeps(context.Background(), reconcileTestDeps(ex, state, &saved))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{
+		"ip addr add 10.200.0.1/24 dev rtcemu0",
+		"ip addr add 10.203.0.1/24 dev rtcemu0",
+		"iptables -A FORWARD -i rtcemu0 -j ACCEPT",
+		"ip link set br-node1 master rtcemu0",
+		"ip link set br-node1 up",
+		"ip netns exec node1 ip link set eth0 mtu 1500",
+		"ip netns exec node1 ip addr add 10.200.0.2/24 dev eth0",
+		"ip netns exec node1 ip route replace default via 10.200.0.1",
+		"ip netns exec node1 tc qdisc del dev eth0 root",
+		"ip link del br-node2",
+		"ip netns add node2",
+		"ip netns exec node2 iptables -A OUTPUT -p udp -j DROP",
+		"ip netns exec node2 ip6tables -A OUTPUT -p udp -j DROP",
+		"ip netns exec node2 tc qdisc replace dev eth0 root netem delay 80ms",
+		"ip netns del node7",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing %q, calls=%v", want, ex.calls)
+		}
+	}
+	if saved == nil || len(saved.Handovers) != 0 {
+		t.Fatalf("expected recreated node2 to drop its handover, saved=%+v", saved)
+	}
+	var items []string
+	for _, fix := range got.Fixes {
+		items = append(items, fix.Item+": "+fix.Action)
+	}
+	if !containsString(items, "node2: recreated") || !containsString(items, "namespace node7: removed") {
+		t.Fatalf("unexpected fixes: %v", items)
+	}
+}
+
+func TestReconcileWithDeps_RebuildsPartialFirewallInOrder(t *testing.T) {
+	ex := reconcileTestExecutor(nil, map[string]string{
+		"ip netns exec node1 iptables -C INPUT -p tcp --dport 443 -j ACCEPT": "iptables: Bad rule (does a matching rule exist in that chain?).",
+	})
+	rules, err := firewallProfileRules(FirewallAllow443Only)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := reconcileTestState()
+	state.Firewalls = []NodeFirewall{{Node: "node1", Profile: FirewallAllow443Only, Rules: rules}}
+	var saved *LabState
+	got, err := reconcileWithDeps(context.Background(), reconcileTestDeps(ex, state, &saved))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	del := indexOfCall(ex.calls, "ip netns exec node1 iptables -D INPUT -j DROP")
+	accept := indexOfCall(ex.calls, "ip netns exec node1 iptables -A INPUT -p tcp --dport 443 -j ACCEPT")
+	drop := indexOfCall(ex.calls, "ip netns exec node1 iptables -A INPUT -j DROP")
+	if del < 0 || accept < del || drop < accept {
+		t.Fatalf("expected the profile to be removed and re-added in order, calls=%v", ex.calls)
+	}
+	if len(got.Fixes) != 1 || got.Fixes[0].Item != "node1 firewall allow-443-only" || got.Fixes[0].Action != "restored" {
+		t.Fatalf("unexpected fixes: %+v", got.Fixes)
+	}
+}
+
+func TestReconcileWithDeps_ReportsFailedFix(t *testing.T) {
+	ex := reconcileTestExecutor(map[string]string{
+		"ip netns exec node2 tc qdisc show dev eth0": "qdisc noqueue 0: root refcnt 2",
+	}, map[string]string{
+		"ip netns exec node2 tc qdisc replace dev eth0 root netem delay 80ms": "Error: Specified qdisc kind is unknown.",
+	})
+	var saved *LabState
+	got, err := reconcileWithDeps(context.Background(), reconcileTestDeps(ex, reconcileTestState(), &saved))
+	if err == nil || !strings.Contains(err.Error(), "failed to reconcile node2 qdisc") {
+		t.Fatalf("expected qdisc error, got: %v", err)
+	}
+	if len(got.Fixes) != 1 || got.Fixes[0].Error == "" {
+		t.Fatalf("expected one failed fix, got %+v", got.Fixes)
+	}
+}
+
+func TestReconcileWithDeps_RejectsTopologyLab(t *testing.T) {
+	state := &LabState{Nodes: []string{"a"}, Links: []LabLink{{A: "a", B: "b"}}}
+	var saved *LabState
+	_, err := reconcileWithDeps(context.Background(), reconcileTestDeps(&fakeExecutor{}, state, &saved))
+	if err == nil || !strings.Contains(err.Error(), "not supported for topology labs") {
+		t.Fatalf("expected topology error, got: %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// rootlessEnv is set on the CLI re-executed inside the rootless namespaces.
+const rootlessEnv = "RTC_EMULATOR_ROOTLESS"
+
+var errRootlessNotRunning = errors.New("no rootless lab is running: create one with lab --rootless create")
+
+type RootlessOptions struct {
+	// Args is the command line re-executed inside the namespaces.
+	Args []string
+	// Start creates the namespaces when none are running (lab create).
+	Start bool
+	// Stop tears the namespaces down after a successful run (lab destroy).
+	Stop bool
+	// Slirp gives the namespaces an internet uplink through slirp4netns.
+	Slirp bool
+}
+
+// RootlessExitError carries the exit status of the re-executed CLI, which
+// has already reported its own error.
+type RootlessExitError struct {
+	Code int
+}
+
+func (e *RootlessExitError) Error() string {
+	return fmt.Sprintf("rootless command exited with status %d", e.Code)
+}
+
+type rootlessState struct {
+	HolderPID int `json:"holder_pid"`
+	SlirpPID  int `json:"slirp_pid,omitempty"`
+}
+
+type rootlessDeps struct {
+	findPath    func(string) (string, error)
+	startHolder func() (int, error)
+	startSlirp  func(holderPID int) (int, error)
+	alive       func(pid int) bool
+	kill        func(pid int) error
+	// enter runs the CLI with args inside the holder's namespaces and
+	// returns its exit status.
+	enter     func(ctx context.Context, holderPID int, args []string) (int, error)
+	loadState func() (*rootlessState, error)
+	saveState func(*rootlessState) error
+	delState  func() error
+}
+
+// InRootlessNamespace reports whether this process was re-executed by
+// RunRootless.
+func InRootlessNamespace() bool {
+	return os.Getenv(rootlessEnv) != ""
+}
+
+// RunRootless runs a lab command as an unprivileged user. The lab lives in a
+// user, network, and mount namespace pinned by a holder process; inside it the
+// CLI is root with CAP_NET_ADMIN, and /run is a private tmpfs that holds the
+// lab state and network namespaces.
+func RunRootless(ctx context.Context, opts RootlessOptions) error {
+	return runRootlessWithDeps(ctx, opts, defaultRootlessDeps())
+}
+
+func runRootlessWithDeps(ctx context.Context, opts RootlessOptions, deps rootlessDeps) error {
+	required := []string{"unshare", "nsenter"}
+	if opts.Slirp {
+		required = append(required, "slirp4netns")
+	}
+	for _, cmd := range required {
+		if _, err := deps.findPath(cmd); err != nil {
+			return fmt.Errorf("required command %q not found: %w", cmd, err)
+		}
+	}
+
+	st, err := deps.loadState()
+	if err != nil && !errors.Is(err, errRootlessNotRunning) {
+		return err
+	}
+	if st != nil && !deps.alive(st.HolderPID) {
+		_ = deps.delState()
+		st = nil
+	}
+	started := false
+	if st == nil {
+		if !opts.Start {
+			return errRootlessNotRunning
+		}
+		if st, err = startRootlessNamespaces(opts.Slirp, deps); err != nil {
+			return err
+		}
+		started = true
+	}
+
+	code, err := deps.enter(ctx, st.HolderPID, opts.Args)
+	if err == nil && code != 0 {
+		err = &RootlessExitError{Code: code}
+	}
+	if (err == nil && opts.Stop) || (err != nil && started) {
+		if stopErr := stopRootlessNamespaces(st, deps); stopErr != nil {
+			return errors.Join(err, stopErr)
+		}
+	}
+	return err
+}
+
+func startRootlessNamespaces(slirp bool, deps rootlessDeps) (*rootlessState, error) {
+	pid, err := deps.startHolder()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rootless namespaces: %w", err)
+	}
+	st := &rootlessState{HolderPID: pid}
+	if slirp {
+		if st.SlirpPID, err = deps.startSlirp(pid); err != nil {
+			_ = deps.kill(pid)
+			return nil, fmt.Errorf("failed to start slirp4netns: %w", err)
+		}
+	}
+	if err := deps.saveState(st); err != nil {
+		_ = stopRootlessNamespaces(st, deps)
+		return nil, err
+	}
+	return st, nil
+}
+
+func stopRootlessNamespaces(st *rootlessState, deps rootlessDeps) error {
+	var err error
+	if st.SlirpPID != 0 {
+		if killErr := deps.kill(st.SlirpPID); killErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to stop slirp4netns: %w", killErr))
+		}
+	}
+	if killErr := deps.kill(st.HolderPID); killErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to stop rootless namespace holder: %w", killErr))
+	}
+	return errors.Join(err, deps.delState())
+}
+
+// rootlessStatePath is outside the namespaces, so it must be writable by the
+// invoking user.
+func rootlessStatePath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "rtc-emulator-"+strconv.Itoa(os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, "rtc-emulator")
+	}
+	return filepath.Join(dir, "rootless.json")
+}
+
+func loadRootlessState(path string) (*rootlessState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errRootlessNotRunning
+		}
+		return nil, fmt.Errorf("failed to read rootless state %s: %w", path, err)
+	}
+	var st rootlessState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse rootless state %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+func saveRootlessState(path string, st *rootlessState) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create rootless state dir for %s: %w", path, err)
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rootless state: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write rootless state %s: %w", path, err)
+	}
+	return nil
+}
+
+func deleteRootlessState(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove rootless state %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+//go:build linux
+
+package lab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const capNetAdmin = 12
+
+// hasCapNetAdmin reports whether the effective capability set includes
+// CAP_NET_ADMIN, which root has and which the rootless namespaces grant.
+func hasCapNetAdmin() bool {
+	b, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return os.Geteuid() == 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		hex, ok := strings.CutPrefix(line, "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(hex), 16, 64)
+		return err == nil && caps&(1<<capNetAdmin) != 0
+	}
+	return false
+}
+
+// rootlessHolderScript runs as root in the new namespaces: a private /run
+// keeps lab state and `ip netns` mounts out of the host's /run.
+const rootlessHolderScript = "mount -t tmpfs tmpfs /run && mkdir -p /run/netns && echo ready && exec sleep infinity"
+
+func defaultRootlessDeps() rootlessDeps {
+	path := rootlessStatePath()
+	return rootlessDeps{
+		findPath:    exec.LookPath,
+		startHolder: startRootlessHolder,
+		startSlirp:  startSlirp,
+		alive: func(pid int) bool {
+			return pid > 0 && syscall.Kill(pid, 0) == nil
+		},
+		kill: func(pid int) error {
+			if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+				return err
+			}
+			return nil
+		},
+		enter:     enterRootlessNamespaces,
+		loadState: func() (*rootlessState, error) { return loadRootlessState(path) },
+		saveState: func(st *rootlessState) error { return saveRootlessState(path, st) },
+		delState:  func() error { return deleteRootlessState(path) },
+	}
+}
+
+func startRootlessHolder() (int, error) {
+	cmd := exec.Command("unshare", "--user", "--map-root-user", "--net", "--mount", "--propagation", "private", "sh", "-c", rootlessHolderScript)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ready" {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, errors.New(msg)
+		}
+		return 0, fmt.Errorf("namespace holder exited before it was ready: %w", err)
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+	return pid, nil
+}
+
+func startSlirp(holderPID int) (int, error) {
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+	cmd := exec.Command("slirp4netns", "--configure", "--mtu=65520", "--disable-host-loopback", "--ready-fd=3", strconv.Itoa(holderPID), "tap0")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	_ = w.Close()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return 0, errors.New("slirp4netns exited before it was ready")
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+	return pid, nil
+}
+
+// enterRootlessNamespaces re-executes the CLI inside the holder's namespaces.
+// The child shares the terminal, so it gets Ctrl-C itself and this process
+// waits for it instead of being killed first.
+func enterRootlessNamespaces(_ context.Context, holderPID int, args []string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve executable: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+	nsArgs := []string{"--target", strconv.Itoa(holderPID), "--user", "--net", "--mount", "--preserve-credentials", "--wd=" + wd, "--", exe}
+	cmd := exec.Command("nsenter", append(nsArgs, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), rootlessEnv+"=1")
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to enter rootless namespaces: %w", err)
+	}
+	return 0, nil
+}
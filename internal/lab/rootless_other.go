@@ -0,0 +1,32 @@
+//go:build !linux
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+func hasCapNetAdmin() bool {
+	return os.Geteuid() == 0
+}
+
+func defaultRootlessDeps() rootlessDeps {
+	unsupported := errors.New("rootless mode is supported only on linux: got " + runtime.GOOS)
+	return rootlessDeps{
+		findPath:    exec.LookPath,
+		startHolder: func() (int, error) { return 0, unsupported },
+		startSlirp:  func(int) (int, error) { return 0, unsupported },
+		alive:       func(int) bool { return false },
+		kill:        func(int) error { return unsupported },
+		enter: func(context.Context, int, []string) (int, error) {
+			return 0, unsupported
+		},
+		loadState: func() (*rootlessState, error) { return nil, errRootlessNotRunning },
+		saveState: func(*rootlessState) error { return unsupported },
+		delState:  func() error { return nil },
+	}
+}
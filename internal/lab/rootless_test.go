@@ -0,0 +1,104 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeRootless struct {
+	state   *rootlessState
+	alive   map[int]bool
+	killed  []int
+	entered [][]string
+	code    int
+}
+
+func (f *fakeRootless) deps() rootlessDeps {
+	return rootlessDeps{
+		findPath:    func(cmd string) (string, error) { return "/bin/" + cmd, nil },
+		startHolder: func() (int, error) { f.alive[100] = true; return 100, nil },
+		startSlirp:  func(int) (int, error) { f.alive[101] = true; return 101, nil },
+		alive:       func(pid int) bool { return f.alive[pid] },
+		kill: func(pid int) error {
+			f.killed = append(f.killed, pid)
+			delete(f.alive, pid)
+			return nil
+		},
+		enter: func(_ context.Context, pid int, args []string) (int, error) {
+			f.entered = append(f.entered, args)
+			return f.code, nil
+		},
+		loadState: func() (*rootlessState, error) {
+			if f.state == nil {
+				return nil, errRootlessNotRunning
+			}
+			return f.state, nil
+		},
+		saveState: func(st *rootlessState) error { f.state = st; return nil },
+		delState:  func() error { f.state = nil; return nil },
+	}
+}
+
+func TestRunRootless_CreateStartsNamespacesAndDestroyStopsThem(t *testing.T) {
+	f := &fakeRootless{alive: map[int]bool{}}
+
+	if err := runRootlessWithDeps(context.Background(), RootlessOptions{Args: []string{"lab", "--rootless", "create"}, Start: true, Slirp: true}, f.deps()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(f.state, &rootlessState{HolderPID: 100, SlirpPID: 101}) {
+		t.Fatalf("unexpected state: %+v", f.state)
+	}
+	if err := runRootlessWithDeps(context.Background(), RootlessOptions{Args: []string{"lab", "--rootless", "destroy"}, Stop: true}, f.deps()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(f.killed, []int{101, 100}) || f.state != nil {
+		t.Fatalf("expected slirp and holder to be stopped, killed=%v state=%+v", f.killed, f.state)
+	}
+	if len(f.entered) != 2 || f.entered[1][2] != "destroy" {
+		t.Fatalf("unexpected commands: %v", f.entered)
+	}
+}
+
+func TestRunRootless_RequiresRunningNamespaces(t *testing.T) {
+	f := &fakeRootless{alive: map[int]bool{}, state: &rootlessState{HolderPID: 42}}
+
+	err := runRootlessWithDeps(context.Background(), RootlessOptions{Args: []string{"lab", "--rootless", "show"}}, f.deps())
+	if !errors.Is(err, errRootlessNotRunning) {
+		t.Fatalf("expected not running error, got %v", err)
+	}
+	if f.state != nil || len(f.entered) != 0 {
+		t.Fatalf("expected stale state to be dropped without running, state=%+v entered=%v", f.state, f.entered)
+	}
+}
+
+func TestRunRootless_FailedCreateStopsNewNamespaces(t *testing.T) {
+	f := &fakeRootless{alive: map[int]bool{}, code: 1}
+
+	err := runRootlessWithDeps(context.Background(), RootlessOptions{Args: []string{"lab", "--rootless", "create"}, Start: true}, f.deps())
+	var exitErr *RootlessExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+		t.Fatalf("expected exit status 1, got %v", err)
+	}
+	if !reflect.DeepEqual(f.killed, []int{100}) || f.state != nil {
+		t.Fatalf("expected holder to be stopped, killed=%v state=%+v", f.killed, f.state)
+	}
+}
+
+func TestRunRootless_RequiresSlirp4netns(t *testing.T) {
+	f := &fakeRootless{alive: map[int]bool{}}
+	deps := f.deps()
+	deps.findPath = func(cmd string) (string, error) {
+		if cmd == "slirp4netns" {
+			return "", errors.New("not found")
+		}
+		return "/bin/" + cmd, nil
+	}
+
+	err := runRootlessWithDeps(context.Background(), RootlessOptions{Start: true, Slirp: true}, deps)
+	if err == nil || !strings.Contains(err.Error(), `required command "slirp4netns" not found`) {
+		t.Fatalf("expected slirp4netns error, got %v", err)
+	}
+}
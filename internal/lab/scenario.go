@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,6 +16,12 @@ import (
 const (
 	ScenarioWebRTCUplinkCongestion = "webrtc-uplink-congestion"
 
+	ScenarioActionICERestart = "ice-restart"
+	ScenarioActionFirewall   = "firewall"
+	ScenarioActionMTU        = "mtu"
+	ScenarioActionHandover   = "handover"
+	scenarioFirewallOff      = "off"
+
 	defaultRunsDir       = "runs"
 	defaultScenarioNode  = "node1"
 	defaultScenarioPeer  = "node2"
@@ -39,14 +46,24 @@ type ScenarioRunOptions struct {
 	ImpairedDuration time.Duration
 	RecoveryDuration time.Duration
 	StatsInterval    time.Duration
+	RawStats         bool
+	Actions          []ScenarioAction
+	ICE              WebRTCICEOptions
+}
+
+type ScenarioAction struct {
+	Phase string
+	Name  string
+	Value string
 }
 
 type ScenarioRunResult struct {
-	RunID      string
-	RunDir     string
-	LatestDir  string
-	EventsPath string
-	StatsPath  string
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
 }
 
 type scenarioRunDeps struct {
@@ -57,10 +74,59 @@ type scenarioRunDeps struct {
 	executable func() (string, error)
 	runCommand func(context.Context, string, []string, io.Writer, io.Writer) error
 	sleep      func(time.Duration)
+	// vnet, when set, runs the scenario on an in-process lab instead of the
+	// namespaces recorded in lab state.
+	vnet *vnetLab
+	// dryRun skips the steps that need running peers or existing run logs.
+	dryRun bool
 }
 
 func RunScenario(ctx context.Context, opts ScenarioRunOptions) (*ScenarioRunResult, error) {
-	return runScenarioWithDeps(ctx, opts, defaultCreateDeps(), scenarioRunDeps{})
+	deps := defaultCreateDeps(ctx)
+	if deps.backend == BackendVNet {
+		return runVNetScenario(ctx, opts, scenarioRunDeps{})
+	}
+	runDeps := scenarioRunDeps{}
+	if deps.dryRun != nil {
+		runDeps = dryRunScenarioDeps(runDeps, deps.dryRun)
+	}
+	return runScenarioWithDeps(ctx, opts, deps, runDeps)
+}
+
+// runVNetScenario builds a two-node vnet lab for the duration of the run.
+func runVNetScenario(ctx context.Context, opts ScenarioRunOptions, runDeps scenarioRunDeps) (*ScenarioRunResult, error) {
+	opts = normalizeScenarioRunOptions(opts)
+	if err := validateScenarioRunOptions(opts); err != nil {
+		return nil, err
+	}
+	if err := validateVNetScenarioOptions(opts); err != nil {
+		return nil, err
+	}
+	vlab, err := newVNetLab([]string{opts.Node, opts.Peer})
+	if err != nil {
+		return nil, err
+	}
+	runDeps.vnet = vlab
+	result, runErr := runScenarioWithDeps(ctx, opts, createDeps{}, runDeps)
+	if err := vlab.close(); err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("failed to stop vnet lab: %w", err))
+	}
+	return result, runErr
+}
+
+func validateVNetScenarioOptions(opts ScenarioRunOptions) error {
+	for _, action := range opts.Actions {
+		if action.Name != ScenarioActionICERestart {
+			return fmt.Errorf("scenario action %s is not supported by the vnet backend", action.Name)
+		}
+	}
+	if len(opts.ICE.Servers) > 0 {
+		return errors.New("ice servers are not reachable from the vnet backend")
+	}
+	if _, err := parseVNetCondition(ApplyOptions{Delay: opts.Delay, Loss: opts.Loss, Jitter: opts.Jitter, BW: opts.BW}); err != nil {
+		return err
+	}
+	return nil
 }
 
 func runScenarioWithDeps(
@@ -70,7 +136,7 @@ func runScenarioWithDeps(
 	runDeps scenarioRunDeps,
 ) (*ScenarioRunResult, error) {
 	opts = normalizeScenarioRunOptions(opts)
-	deps = fillCreateDeps(deps)
+	deps = fillCreateDeps(ctx, deps)
 	runDeps = fillScenarioRunDeps(runDeps)
 
 	if err := validateScenarioRunOptions(opts); err != nil {
@@ -82,10 +148,31 @@ func runScenarioWithDeps(
 		NodeB:         opts.Peer,
 		Duration:      opts.BaselineDuration + opts.ImpairedDuration + opts.RecoveryDuration,
 		StatsInterval: opts.StatsInterval,
+		RawStats:      opts.RawStats,
+		ICE:           normalizeWebRTCICEOptions(opts.ICE),
 	}
-	if err := validateWebRTCP2POptions(ctx, webRTCOpts, deps); err != nil {
+	if runDeps.vnet != nil {
+		if err := validateWebRTCP2PSettings(webRTCOpts); err != nil {
+			return nil, err
+		}
+	} else if err := validateWebRTCP2POptions(ctx, webRTCOpts, deps); err != nil {
+		return nil, err
+	} else if err := validateScenarioMTUActions(ctx, deps, opts.Actions); err != nil {
 		return nil, err
 	}
+	applyImpairment := func(o ApplyOptions) error {
+		_, err := applyWithDeps(ctx, o, deps)
+		return err
+	}
+	clearImpairment := func() (*ClearResult, error) {
+		return clearWithDeps(ctx, ClearOptions{Node: opts.Node}, deps)
+	}
+	if runDeps.vnet != nil {
+		applyImpairment = runDeps.vnet.apply
+		clearImpairment = func() (*ClearResult, error) {
+			return runDeps.vnet.clear(ClearOptions{Node: opts.Node})
+		}
+	}
 
 	startedAt := runDeps.now().UTC()
 	runID, err := runDeps.newRunID(startedAt)
@@ -97,12 +184,15 @@ func runScenarioWithDeps(
 	if err != nil {
 		return nil, err
 	}
+	defer teeAuditLog(deps.exec, filepath.Join(logger.runDir, auditFilename))()
 	if err := runDeps.mkdirAll(signalDir(logger.runDir), 0o755); err != nil {
 		return nil, errors.Join(fmt.Errorf("failed to create WebRTC signal directory: %w", err), logger.close())
 	}
-	latestDir, err := updateLatestRunSymlink(opts.RunsDir, runID)
-	if err != nil {
-		return nil, errors.Join(err, logger.close())
+	var latestDir string
+	if !runDeps.dryRun {
+		if latestDir, err = updateLatestRunSymlink(opts.RunsDir, runID); err != nil {
+			return nil, errors.Join(err, logger.close())
+		}
 	}
 	result := &ScenarioRunResult{
 		RunID:      logger.runID,
@@ -111,6 +201,12 @@ func runScenarioWithDeps(
 		EventsPath: logger.eventsPath,
 		StatsPath:  filepath.Join(logger.runDir, "stats.jsonl"),
 	}
+	if opts.RawStats {
+		result.RawStatsPaths = []string{
+			filepath.Join(logger.runDir, peerRawStatsFilename(opts.Node)),
+			filepath.Join(logger.runDir, peerRawStatsFilename(opts.Peer)),
+		}
+	}
 
 	condition := ImpairmentCondition{
 		Delay:  opts.Delay,
@@ -136,6 +232,109 @@ func runScenarioWithDeps(
 	}
 
 	var runErr error
+	peersReady := false
+	controlID := 0
+	firewallApplied := false
+	setFirewall := func(profile string) error {
+		if profile == scenarioFirewallOff {
+			_, err := clearFirewallWithDeps(ctx, FirewallClearOptions{Node: opts.Node}, deps)
+			if err == nil {
+				firewallApplied = false
+			}
+			return err
+		}
+		_, err := applyFirewallWithDeps(ctx, FirewallApplyOptions{Node: opts.Node, Profile: profile}, deps)
+		if err == nil {
+			firewallApplied = true
+		}
+		return err
+	}
+	restoreMTU := 0
+	setMTU := func(mtu int) error {
+		result, err := setLinkWithDeps(ctx, LinkSetOptions{Node: opts.Node, MTU: mtu}, deps)
+		if err == nil && restoreMTU == 0 {
+			restoreMTU = result.PreviousMTU
+		}
+		return err
+	}
+	handedOver := false
+	runActions := func(phase string) time.Duration {
+		startedAt := runDeps.now()
+		for _, action := range opts.Actions {
+			if action.Phase != phase {
+				continue
+			}
+			event := EventRecord{
+				RunID:     runID,
+				Event:     "scenario_action",
+				Scenario:  opts.Scenario,
+				Phase:     phase,
+				Node:      opts.Node,
+				Interface: opts.Interface,
+				Action:    action.Name,
+				Condition: condition,
+			}
+			var actionErr error
+			switch {
+			case action.Name == ScenarioActionFirewall:
+				event.Firewall = action.Value
+				actionErr = setFirewall(action.Value)
+				event.Status = statusForError(actionErr)
+				event.Error = errorString(actionErr)
+			case action.Name == ScenarioActionMTU:
+				event.MTU, _ = strconv.Atoi(action.Value)
+				actionErr = setMTU(event.MTU)
+				event.Status = statusForError(actionErr)
+				event.Error = errorString(actionErr)
+			case action.Name == ScenarioActionHandover:
+				startedAt := runDeps.now()
+				handover, err := handoverNodeWithDeps(ctx, opts.Node, deps)
+				actionErr = err
+				if err == nil {
+					handedOver = !handedOver
+					event.IP = handover.ToIP
+					switchSeconds := runDeps.now().Sub(startedAt).Seconds()
+					if peersReady {
+						controlID++
+						result, err := requestPeerControl(ctx, logger.runDir, opts.Node, controlID, peerControlICERestart)
+						if result != nil {
+							event.ReconnectSeconds = result.ReconnectSeconds
+						}
+						if err == nil {
+							event.DisruptionSeconds = switchSeconds + result.ReconnectSeconds
+						}
+						actionErr = err
+					}
+				}
+				event.Status = statusForError(actionErr)
+				event.Error = errorString(actionErr)
+			case !peersReady:
+				event.Status = "skipped"
+			default:
+				controlID++
+				result, err := requestPeerControl(ctx, logger.runDir, opts.Node, controlID, action.Name)
+				if result != nil {
+					event.ReconnectSeconds = result.ReconnectSeconds
+				}
+				actionErr = err
+				event.Status = statusForError(err)
+				event.Error = errorString(err)
+			}
+			if actionErr != nil {
+				runErr = errors.Join(runErr, fmt.Errorf("%s action %s failed: %w", phase, action.Name, actionErr))
+			}
+			event.Time = runDeps.now().UTC().Format(time.RFC3339Nano)
+			if err := logger.write(event); err != nil {
+				runErr = errors.Join(runErr, err)
+			}
+		}
+		return runDeps.now().Sub(startedAt)
+	}
+	sleepPhase := func(duration time.Duration, elapsed time.Duration) {
+		if duration > elapsed {
+			runDeps.sleep(duration - elapsed)
+		}
+	}
 	executable, err := runDeps.executable()
 	if err != nil {
 		runErr = errors.Join(runErr, fmt.Errorf("failed to resolve current executable: %w", err))
@@ -144,51 +343,132 @@ func runScenarioWithDeps(
 	defer cancelPeers()
 	var waitPeers func() error
 	if err == nil {
-		waitPeers = startWebRTCPeerProcesses(peerCtx, webRTCOpts, runID, logger.runDir, executable, webRTCP2PDeps{
-			runCommand: runDeps.runCommand,
-		}, cancelPeers)
-		if readyErr := waitForWebRTCPeerReadiness(ctx, logger.runDir, []string{opts.Node, opts.Peer}, webRTCSignalTimeout); readyErr != nil {
-			runErr = errors.Join(runErr, readyErr)
-			cancelPeers()
+		if runDeps.vnet != nil {
+			waitPeers = runDeps.vnet.startPeers(peerCtx, webRTCOpts, runID, logger.runDir, cancelPeers)
+		} else {
+			waitPeers = startWebRTCPeerProcesses(peerCtx, webRTCOpts, runID, logger.runDir, executable, webRTCP2PDeps{
+				runCommand: runDeps.runCommand,
+			}, cancelPeers)
+		}
+		// A dry run has no peers, so peer actions are skipped.
+		if !runDeps.dryRun {
+			if readyErr := waitForWebRTCPeerReadiness(ctx, logger.runDir, []string{opts.Node, opts.Peer}, webRTCSignalTimeout); readyErr != nil {
+				runErr = errors.Join(runErr, readyErr)
+				cancelPeers()
+			} else {
+				peersReady = true
+			}
 		}
 	}
 
 	if err := record("baseline", "start", "ok", nil); err != nil {
 		return result, errors.Join(err, logger.close())
 	}
-	runDeps.sleep(opts.BaselineDuration)
+	sleepPhase(opts.BaselineDuration, runActions("baseline"))
 
-	_, applyErr := applyWithDeps(ctx, ApplyOptions{
+	applyErr := applyImpairment(ApplyOptions{
 		Node:   opts.Node,
 		Delay:  opts.Delay,
 		Loss:   opts.Loss,
 		Jitter: opts.Jitter,
 		BW:     opts.BW,
-	}, deps)
+	})
 	if applyErr != nil {
 		runErr = errors.Join(runErr, fmt.Errorf("impaired phase failed: %w", applyErr))
 	}
 	if err := record("impaired", "apply", statusForError(applyErr), applyErr); err != nil {
 		runErr = errors.Join(runErr, err)
 	}
-	runDeps.sleep(opts.ImpairedDuration)
+	sleepPhase(opts.ImpairedDuration, runActions("impaired"))
 
 	if applyErr == nil {
-		recoveryResult, recoveryErr := clearWithDeps(ctx, ClearOptions{Node: opts.Node}, deps)
+		recoveryResult, recoveryErr := clearImpairment()
 		if recoveryErr != nil {
 			runErr = errors.Join(runErr, fmt.Errorf("recovery phase failed: %w", recoveryErr))
 		}
 		if err := record("recovery", "clear", statusForClear(recoveryResult, recoveryErr), recoveryErr); err != nil {
 			runErr = errors.Join(runErr, err)
 		}
-		runDeps.sleep(opts.RecoveryDuration)
+		sleepPhase(opts.RecoveryDuration, runActions("recovery"))
 	} else if err := record("recovery", "skip", "skipped", nil); err != nil {
 		runErr = errors.Join(runErr, err)
 	} else {
 		runDeps.sleep(opts.RecoveryDuration)
 	}
 
-	cleanupResult, cleanupErr := clearWithDeps(ctx, ClearOptions{Node: opts.Node}, deps)
+	if firewallApplied {
+		firewallErr := setFirewall(scenarioFirewallOff)
+		if firewallErr != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("cleanup phase failed: %w", firewallErr))
+		}
+		if err := logger.write(EventRecord{
+			RunID:     runID,
+			Event:     "scenario_action",
+			Scenario:  opts.Scenario,
+			Phase:     "cleanup",
+			Time:      runDeps.now().UTC().Format(time.RFC3339Nano),
+			Node:      opts.Node,
+			Interface: opts.Interface,
+			Action:    ScenarioActionFirewall,
+			Condition: condition,
+			Status:    statusForError(firewallErr),
+			Error:     errorString(firewallErr),
+			Firewall:  scenarioFirewallOff,
+		}); err != nil {
+			runErr = errors.Join(runErr, err)
+		}
+	}
+
+	if handedOver {
+		var ip string
+		handover, handoverErr := handoverNodeWithDeps(ctx, opts.Node, deps)
+		if handoverErr != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("cleanup phase failed: %w", handoverErr))
+		} else {
+			ip = handover.ToIP
+		}
+		if err := logger.write(EventRecord{
+			RunID:     runID,
+			Event:     "scenario_action",
+			Scenario:  opts.Scenario,
+			Phase:     "cleanup",
+			Time:      runDeps.now().UTC().Format(time.RFC3339Nano),
+			Node:      opts.Node,
+			Interface: opts.Interface,
+			Action:    ScenarioActionHandover,
+			Condition: condition,
+			Status:    statusForError(handoverErr),
+			Error:     errorString(handoverErr),
+			IP:        ip,
+		}); err != nil {
+			runErr = errors.Join(runErr, err)
+		}
+	}
+
+	if restoreMTU != 0 {
+		mtuErr := setMTU(restoreMTU)
+		if mtuErr != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("cleanup phase failed: %w", mtuErr))
+		}
+		if err := logger.write(EventRecord{
+			RunID:     runID,
+			Event:     "scenario_action",
+			Scenario:  opts.Scenario,
+			Phase:     "cleanup",
+			Time:      runDeps.now().UTC().Format(time.RFC3339Nano),
+			Node:      opts.Node,
+			Interface: opts.Interface,
+			Action:    ScenarioActionMTU,
+			Condition: condition,
+			Status:    statusForError(mtuErr),
+			Error:     errorString(mtuErr),
+			MTU:       restoreMTU,
+		}); err != nil {
+			runErr = errors.Join(runErr, err)
+		}
+	}
+
+	cleanupResult, cleanupErr := clearImpairment()
 	if cleanupErr != nil {
 		runErr = errors.Join(runErr, fmt.Errorf("cleanup phase failed: %w", cleanupErr))
 	}
@@ -201,12 +481,14 @@ func runScenarioWithDeps(
 		}
 	}
 	cancelPeers()
-	mergeErr := mergeStatsLogs(result.StatsPath, []string{
-		filepath.Join(logger.runDir, peerStatsFilename(opts.Node)),
-		filepath.Join(logger.runDir, peerStatsFilename(opts.Peer)),
-	})
-	if mergeErr != nil {
-		runErr = errors.Join(runErr, fmt.Errorf("failed to merge peer stats logs: %w", mergeErr))
+	if !runDeps.dryRun {
+		mergeErr := mergeStatsLogs(result.StatsPath, []string{
+			filepath.Join(logger.runDir, peerStatsFilename(opts.Node)),
+			filepath.Join(logger.runDir, peerStatsFilename(opts.Peer)),
+		})
+		if mergeErr != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("failed to merge peer stats logs: %w", mergeErr))
+		}
 	}
 	if err := logger.close(); err != nil {
 		runErr = errors.Join(runErr, err)
@@ -284,6 +566,89 @@ func validateScenarioRunOptions(opts ScenarioRunOptions) error {
 	if opts.Delay == "" && opts.Loss == "" && opts.BW == "" {
 		return errors.New("at least one impairment condition is required")
 	}
+	for _, action := range opts.Actions {
+		if err := validateScenarioAction(action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ParseScenarioAction(spec string) (ScenarioAction, error) {
+	phase, rest, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return ScenarioAction{}, fmt.Errorf("invalid scenario action %q: use PHASE:ACTION[=VALUE]", spec)
+	}
+	name, value, _ := strings.Cut(rest, "=")
+	action := ScenarioAction{
+		Phase: strings.TrimSpace(phase),
+		Name:  strings.TrimSpace(name),
+		Value: strings.TrimSpace(value),
+	}
+	if err := validateScenarioAction(action); err != nil {
+		return ScenarioAction{}, err
+	}
+	return action, nil
+}
+
+func validateScenarioAction(action ScenarioAction) error {
+	switch action.Phase {
+	case "baseline", "impaired", "recovery":
+	default:
+		return fmt.Errorf("unsupported scenario action phase %q: use baseline, impaired, or recovery", action.Phase)
+	}
+	switch action.Name {
+	case ScenarioActionICERestart, ScenarioActionHandover:
+		if action.Value != "" {
+			return fmt.Errorf("scenario action %s does not take a value", action.Name)
+		}
+	case ScenarioActionFirewall:
+		if action.Value == scenarioFirewallOff {
+			return nil
+		}
+		if err := validateFirewallProfile(action.Value); err != nil {
+			return fmt.Errorf("scenario action %s: %w", action.Name, err)
+		}
+	case ScenarioActionMTU:
+		mtu, err := strconv.Atoi(action.Value)
+		if err != nil {
+			return fmt.Errorf("scenario action %s requires a numeric value: got %q", action.Name, action.Value)
+		}
+		if err := validateMTU(mtu, false); err != nil {
+			return fmt.Errorf("scenario action %s: %w", action.Name, err)
+		}
+	default:
+		return fmt.Errorf("unsupported scenario action %q", action.Name)
+	}
+	return nil
+}
+
+// validateScenarioMTUActions checks mtu actions against the IPv6 minimum when
+// the lab is dual-stack, which ParseScenarioAction cannot know, so a bad value
+// fails before the baseline phase runs.
+func validateScenarioMTUActions(ctx context.Context, deps createDeps, actions []ScenarioAction) error {
+	var mtus []int
+	for _, action := range actions {
+		if action.Name == ScenarioActionMTU {
+			mtu, err := strconv.Atoi(action.Value)
+			if err != nil {
+				return fmt.Errorf("scenario action %s requires a numeric value: got %q", action.Name, action.Value)
+			}
+			mtus = append(mtus, mtu)
+		}
+	}
+	if len(mtus) == 0 || deps.loadState == nil {
+		return nil
+	}
+	state, err := deps.loadState(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load lab state: %w", err)
+	}
+	for _, mtu := range mtus {
+		if err := validateMTU(mtu, state.SubnetIPv6 != ""); err != nil {
+			return fmt.Errorf("scenario action %s: %w", ScenarioActionMTU, err)
+		}
+	}
 	return nil
 }
 
@@ -200,6 +200,247 @@ func TestRunScenarioWithDeps_LogWriteFailureReturnsErrorAndCleansUp(t *testing.T
 	}
 }
 
+func TestRunScenarioWithDeps_ICERestartActionLogsReconnectTime(t *testing.T) {
+	ex := scenarioTestExecutor(nil)
+	runsDir := filepath.Join(t.TempDir(), "runs")
+	runDeps := fixedScenarioRunDeps("run-ice-restart")
+	var slept []time.Duration
+	runDeps.sleep = func(d time.Duration) { slept = append(slept, d) }
+	runDeps.runCommand = func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+		if err := fakeScenarioWebRTCPeerCommand(ctx, name, args, stdout, stderr); err != nil {
+			return err
+		}
+		runDir := argValue(args, "--run-dir")
+		node := argValue(args, "--node")
+		if argValue(args, "--role") != webRTCPeerRoleOfferer {
+			return nil
+		}
+		for {
+			if _, err := os.Stat(peerControlRequestPath(runDir, node, 1)); err == nil {
+				return writeJSONFileAtomic(peerControlResultPath(runDir, node, 1), peerControlResult{
+					ID:               1,
+					Action:           peerControlICERestart,
+					Node:             node,
+					ReconnectSeconds: 0.25,
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(webRTCSignalPollInterval):
+			}
+		}
+	}
+
+	action, err := ParseScenarioAction("impaired:ice-restart")
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	got, err := runScenarioWithDeps(
+		context.Background(),
+		ScenarioRunOptions{Scenario: ScenarioWebRTCUplinkCongestion, RunsDir: runsDir, Actions: []ScenarioAction{action}},
+		validImpairmentTestDeps(ex),
+		runDeps,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := readScenarioEvents(t, got.EventsPath)
+	assertPhases(t, events, []string{"baseline", "impaired", "impaired", "recovery", "cleanup"})
+	restart := events[2]
+	if restart.Event != "scenario_action" || restart.Action != ScenarioActionICERestart || restart.Status != "ok" {
+		t.Fatalf("unexpected ice restart event: %+v", restart)
+	}
+	if restart.ReconnectSeconds != 0.25 {
+		t.Fatalf("reconnect seconds = %v, want 0.25", restart.ReconnectSeconds)
+	}
+	if len(slept) != 3 || slept[1] != defaultImpaired {
+		t.Fatalf("unexpected phase sleeps: %v", slept)
+	}
+}
+
+func TestRunScenarioWithDeps_HandoverActionLogsDisruption(t *testing.T) {
+	ex := scenarioTestExecutor(nil)
+	state := &LabState{Bridge: bridgeName, Nodes: []string{"node1", "node2"}}
+	runsDir := filepath.Join(t.TempDir(), "runs")
+	runDeps := fixedScenarioRunDeps("run-handover")
+	runDeps.runCommand = func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+		if err := fakeScenarioWebRTCPeerCommand(ctx, name, args, stdout, stderr); err != nil {
+			return err
+		}
+		runDir := argValue(args, "--run-dir")
+		node := argValue(args, "--node")
+		if argValue(args, "--role") != webRTCPeerRoleOfferer {
+			return nil
+		}
+		for {
+			if _, err := os.Stat(peerControlRequestPath(runDir, node, 1)); err == nil {
+				return writeJSONFileAtomic(peerControlResultPath(runDir, node, 1), peerControlResult{
+					ID:               1,
+					Action:           peerControlICERestart,
+					Node:             node,
+					ReconnectSeconds: 0.5,
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(webRTCSignalPollInterval):
+			}
+		}
+	}
+
+	got, err := runScenarioWithDeps(
+		context.Background(),
+		ScenarioRunOptions{
+			Scenario: ScenarioWebRTCUplinkCongestion,
+			RunsDir:  runsDir,
+			Actions:  []ScenarioAction{{Phase: "impaired", Name: ScenarioActionHandover}},
+		},
+		firewallTestDeps(ex, state),
+		runDeps,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := readScenarioEvents(t, got.EventsPath)
+	assertPhases(t, events, []string{"baseline", "impaired", "impaired", "recovery", "cleanup", "cleanup"})
+	handover := events[2]
+	if handover.Action != ScenarioActionHandover || handover.IP != "10.203.0.2" || handover.Status != "ok" {
+		t.Fatalf("unexpected handover event: %+v", handover)
+	}
+	if handover.ReconnectSeconds != 0.5 || handover.DisruptionSeconds != 0.5 {
+		t.Fatalf("unexpected disruption window: %+v", handover)
+	}
+	if events[4].Action != ScenarioActionHandover || events[4].IP != "10.200.0.2" {
+		t.Fatalf("expected handover back at cleanup, got %+v", events[4])
+	}
+	if len(state.Handovers) != 0 {
+		t.Fatalf("expected node back on its lab address, got %+v", state.Handovers)
+	}
+}
+
+func TestRunScenarioWithDeps_FirewallActionIsClearedAtCleanup(t *testing.T) {
+	ex := scenarioTestExecutor(nil)
+	ex.outputFn = func(name string, args ...string) (string, error) {
+		return "node1\nnode2\n", nil
+	}
+	state := &LabState{Nodes: []string{"node1", "node2"}}
+	runsDir := filepath.Join(t.TempDir(), "runs")
+
+	got, err := runScenarioWithDeps(
+		context.Background(),
+		ScenarioRunOptions{
+			Scenario: ScenarioWebRTCUplinkCongestion,
+			RunsDir:  runsDir,
+			Actions:  []ScenarioAction{{Phase: "impaired", Name: ScenarioActionFirewall, Value: FirewallBlockUDP}},
+		},
+		firewallTestDeps(ex, state),
+		fixedScenarioRunDeps("run-firewall"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := readScenarioEvents(t, got.EventsPath)
+	assertPhases(t, events, []string{"baseline", "impaired", "impaired", "recovery", "cleanup", "cleanup"})
+	if events[2].Action != ScenarioActionFirewall || events[2].Firewall != FirewallBlockUDP || events[2].Status != "ok" {
+		t.Fatalf("unexpected firewall event: %+v", events[2])
+	}
+	if events[4].Firewall != "off" || events[4].Status != "ok" {
+		t.Fatalf("expected firewall cleanup event, got %+v", events[4])
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 iptables -A INPUT -p udp ! -i lo -j DROP") ||
+		!hasCall(ex.calls, "ip netns exec node1 iptables -D INPUT -p udp ! -i lo -j DROP") {
+		t.Fatalf("expected firewall apply and cleanup calls, got %v", ex.calls)
+	}
+	if len(state.Firewalls) != 0 {
+		t.Fatalf("expected firewall state to be cleared, got %+v", state.Firewalls)
+	}
+}
+
+func TestRunScenarioWithDeps_MTUActionIsRestoredAtCleanup(t *testing.T) {
+	ex := scenarioTestExecutor(nil)
+	state := &LabState{Nodes: []string{"node1", "node2"}, MTU: []NodeMTU{{Node: "node1", MTU: 1400}}}
+	runsDir := filepath.Join(t.TempDir(), "runs")
+
+	got, err := runScenarioWithDeps(
+		context.Background(),
+		ScenarioRunOptions{
+			Scenario: ScenarioWebRTCUplinkCongestion,
+			RunsDir:  runsDir,
+			Actions:  []ScenarioAction{{Phase: "impaired", Name: ScenarioActionMTU, Value: "1200"}},
+		},
+		firewallTestDeps(ex, state),
+		fixedScenarioRunDeps("run-mtu"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := readScenarioEvents(t, got.EventsPath)
+	assertPhases(t, events, []string{"baseline", "impaired", "impaired", "recovery", "cleanup", "cleanup"})
+	if events[2].Action != ScenarioActionMTU || events[2].MTU != 1200 || events[2].Status != "ok" {
+		t.Fatalf("unexpected mtu event: %+v", events[2])
+	}
+	if events[4].Action != ScenarioActionMTU || events[4].MTU != 1400 || events[4].Status != "ok" {
+		t.Fatalf("expected mtu restore event, got %+v", events[4])
+	}
+	if !hasCall(ex.calls, "ip netns exec node1 ip link set eth0 mtu 1200") ||
+		!hasCall(ex.calls, "ip netns exec node1 ip link set eth0 mtu 1400") {
+		t.Fatalf("expected mtu change and restore, got %v", ex.calls)
+	}
+	if nodeMTU(state, "node1") != 1400 {
+		t.Fatalf("expected restored mtu in state, got %+v", state.MTU)
+	}
+}
+
+func TestRunScenarioWithDeps_RejectsMTUBelowIPv6MinimumBeforeBaseline(t *testing.T) {
+	ex := scenarioTestExecutor(nil)
+	state := &LabState{Nodes: []string{"node1", "node2"}, SubnetIPv6: "fd00:200::/64"}
+	runsDir := filepath.Join(t.TempDir(), "runs")
+
+	_, err := runScenarioWithDeps(
+		context.Background(),
+		ScenarioRunOptions{
+			Scenario: ScenarioWebRTCUplinkCongestion,
+			RunsDir:  runsDir,
+			Actions:  []ScenarioAction{{Phase: "impaired", Name: ScenarioActionMTU, Value: "1000"}},
+		},
+		firewallTestDeps(ex, state),
+		fixedScenarioRunDeps("run-mtu-ipv6"),
+	)
+	if err == nil || !strings.Contains(err.Error(), "mtu must be between 1280 and 9000") {
+		t.Fatalf("expected ipv6 mtu validation error, got %v", err)
+	}
+	for _, call := range ex.calls {
+		if strings.Contains(call, "ip link set") {
+			t.Fatalf("expected no link change before validation failed, got %v", ex.calls)
+		}
+	}
+	if _, statErr := os.Stat(runsDir); !os.IsNotExist(statErr) {
+		t.Fatalf("expected no run directory, got %v", statErr)
+	}
+}
+
+func TestParseScenarioActionRejectsUnknownPhaseAndAction(t *testing.T) {
+	for spec, want := range map[string]string{
+		"ice-restart":            "use PHASE:ACTION[=VALUE]",
+		"cleanup:ice-restart":    `unsupported scenario action phase "cleanup"`,
+		"impaired:reboot":        `unsupported scenario action "reboot"`,
+		"impaired:ice-restart=1": "does not take a value",
+		"impaired:firewall=nope": `unsupported firewall profile "nope"`,
+		"impaired:mtu=small":     "requires a numeric value",
+		"impaired:mtu=100":       "mtu must be between 576 and 9000",
+	} {
+		if _, err := ParseScenarioAction(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("ParseScenarioAction(%q) error = %v, want %q", spec, err, want)
+		}
+	}
+}
+
 func scenarioTestExecutor(runFn func(name string, args ...string) error) *fakeExecutor {
 	return &fakeExecutor{
 		runFn: runFn,
@@ -0,0 +1,214 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultSignalListen      = bridgeIP + ":8089"
+	signalServerWriteTimeout = 5 * time.Second
+)
+
+type SignalServeOptions struct {
+	Listen string
+}
+
+type SignalServer struct {
+	listener net.Listener
+	server   *http.Server
+	served   chan error
+
+	mu    sync.Mutex
+	rooms map[string]*signalRoom
+}
+
+type signalRoom struct {
+	history [][]byte
+	clients map[*signalClient]bool
+}
+
+type signalClient struct {
+	peer string
+	conn *websocket.Conn
+	send chan []byte
+}
+
+func ServeSignaling(ctx context.Context, opts SignalServeOptions, onReady func(url string)) error {
+	listen := strings.TrimSpace(opts.Listen)
+	if listen == "" {
+		listen = defaultSignalListen
+	}
+	server, err := StartSignalServer(listen)
+	if err != nil {
+		return err
+	}
+	if onReady != nil {
+		onReady(server.URL())
+	}
+	select {
+	case <-ctx.Done():
+		return server.Close()
+	case err := <-server.served:
+		return errors.Join(fmt.Errorf("signaling server stopped: %w", err), server.Close())
+	}
+}
+
+func StartSignalServer(listen string) (*SignalServer, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for signaling on %s: %w", listen, err)
+	}
+	s := &SignalServer{
+		listener: listener,
+		served:   make(chan error, 1),
+		rooms:    make(map[string]*signalRoom),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rooms/{room...}", s.handleRoom)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: webRTCSignalTimeout}
+	go func() {
+		s.served <- s.server.Serve(listener)
+	}()
+	return s, nil
+}
+
+func (s *SignalServer) URL() string {
+	return "ws://" + s.listener.Addr().String()
+}
+
+func (s *SignalServer) Close() error {
+	err := s.server.Close()
+	s.mu.Lock()
+	for _, room := range s.rooms {
+		for client := range room.clients {
+			_ = client.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+var signalUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+func (s *SignalServer) handleRoom(w http.ResponseWriter, r *http.Request) {
+	room := strings.Trim(r.PathValue("room"), "/")
+	if room == "" {
+		http.Error(w, "room is required", http.StatusBadRequest)
+		return
+	}
+	peer := strings.TrimSpace(r.URL.Query().Get("peer"))
+	if peer == "" {
+		peer = r.RemoteAddr
+	}
+	conn, err := signalUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	client := &signalClient{peer: peer, conn: conn, send: make(chan []byte, signalMessageBuffer)}
+	s.join(room, client)
+	defer s.leave(room, client)
+	go client.writeLoop()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg, err := stampSignalMessage(data, peer)
+		if err != nil {
+			continue
+		}
+		s.relay(room, client, msg)
+	}
+}
+
+func (s *SignalServer) join(name string, client *signalClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room := s.rooms[name]
+	if room == nil {
+		room = &signalRoom{clients: make(map[*signalClient]bool)}
+		s.rooms[name] = room
+	}
+	for _, msg := range room.history {
+		client.enqueue(msg)
+	}
+	room.clients[client] = true
+}
+
+func (s *SignalServer) leave(name string, client *signalClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room := s.rooms[name]; room != nil {
+		delete(room.clients, client)
+		if len(room.clients) == 0 {
+			delete(s.rooms, name)
+		}
+	}
+	close(client.send)
+}
+
+func (s *SignalServer) relay(name string, from *signalClient, msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room := s.rooms[name]
+	if room == nil {
+		return
+	}
+	room.history = append(room.history, msg)
+	for client := range room.clients {
+		if client != from {
+			client.enqueue(msg)
+		}
+	}
+}
+
+func (c *signalClient) enqueue(msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		_ = c.conn.Close()
+	}
+}
+
+func (c *signalClient) writeLoop() {
+	defer c.conn.Close()
+	for msg := range c.send {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(signalServerWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return
+		}
+	}
+}
+
+func stampSignalMessage(data []byte, peer string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["type"]; !ok {
+		return nil, errors.New("signaling message type is required")
+	}
+	from, err := json.Marshal(peer)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = from
+	return json.Marshal(fields)
+}
@@ -0,0 +1,153 @@
+package lab
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestSignalServerRelaysDescriptionsAndReplaysToLateJoiners(t *testing.T) {
+	server, err := StartSignalServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected start error: %v", err)
+	}
+	defer server.Close()
+
+	offerer, err := dialWebSocketSignaler(t.Context(), server.URL(), "run-1/node1-node2", "node1")
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+	defer offerer.close()
+	offer := &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0 offer"}
+	if err := offerer.sendDescription(t.Context(), offer); err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+
+	answerer, err := dialWebSocketSignaler(t.Context(), server.URL(), "run-1/node1-node2", "node2")
+	if err != nil {
+		t.Fatalf("unexpected dial error: %v", err)
+	}
+	defer answerer.close()
+	got, err := answerer.waitDescription(t.Context(), webrtc.SDPTypeOffer)
+	if err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	if !reflect.DeepEqual(got, offer) {
+		t.Fatalf("offer = %#v, want %#v", got, offer)
+	}
+
+	answer := &webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "v=0 answer"}
+	if err := answerer.sendDescription(t.Context(), answer); err != nil {
+		t.Fatalf("unexpected send error: %v", err)
+	}
+	got, err = offerer.waitDescription(t.Context(), webrtc.SDPTypeAnswer)
+	if err != nil {
+		t.Fatalf("unexpected wait error: %v", err)
+	}
+	if !reflect.DeepEqual(got, answer) {
+		t.Fatalf("answer = %#v, want %#v", got, answer)
+	}
+}
+
+func TestStampSignalMessageKeepsUnknownFieldsAndSetsSender(t *testing.T) {
+	msg, err := stampSignalMessage([]byte(`{"type":"custom","from":"spoofed","payload":{"n":1}}`), "node1")
+	if err != nil {
+		t.Fatalf("unexpected stamp error: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(msg, &got); err != nil {
+		t.Fatalf("failed to decode stamped message: %v", err)
+	}
+	want := map[string]any{"type": "custom", "from": "node1", "payload": map[string]any{"n": float64(1)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("stamped = %#v, want %#v", got, want)
+	}
+
+	if _, err := stampSignalMessage([]byte(`{"sdp":{}}`), "node1"); err == nil {
+		t.Fatal("expected missing type to be rejected")
+	}
+}
+
+func TestValidateWebRTCSignaling(t *testing.T) {
+	tests := []struct {
+		mode string
+		url  string
+		want string
+	}{
+		{mode: WebRTCSignalingFile},
+		{mode: WebRTCSignalingWS},
+		{mode: WebRTCSignalingWS, url: "ws://10.200.0.1:8089"},
+		{mode: WebRTCSignalingFile, url: "ws://10.200.0.1:8089", want: "signal url requires ws signaling"},
+		{mode: WebRTCSignalingWS, url: "http://10.200.0.1:8089", want: "scheme must be ws or wss"},
+		{mode: "grpc", want: `unsupported signaling mode "grpc"`},
+	}
+	for _, tt := range tests {
+		err := validateWebRTCSignaling(tt.mode, tt.url)
+		if tt.want == "" {
+			if err != nil {
+				t.Fatalf("validateWebRTCSignaling(%q, %q) unexpected error: %v", tt.mode, tt.url, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("validateWebRTCSignaling(%q, %q) error = %v, want %q", tt.mode, tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestSignalRoomURLKeepsRoomPathAndPeer(t *testing.T) {
+	got, err := signalRoomURL("ws://10.200.0.1:8089", pairSignalRoom("run-1", "node1", "node2"), "node1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "ws://10.200.0.1:8089/rooms/run-1/node1-node2?peer=node1"; got != want {
+		t.Fatalf("url = %q, want %q", got, want)
+	}
+}
+
+func TestFileSignalerNumbersRenegotiatedDescriptions(t *testing.T) {
+	dir := t.TempDir()
+	offerer, err := newWebRTCSignaler(t.Context(), "", "", "node1", dir)
+	if err != nil {
+		t.Fatalf("unexpected signaler error: %v", err)
+	}
+	answerer, err := newWebRTCSignaler(t.Context(), "", "", "node2", dir)
+	if err != nil {
+		t.Fatalf("unexpected signaler error: %v", err)
+	}
+
+	for _, sdp := range []string{"first", "restart"} {
+		if err := offerer.sendDescription(t.Context(), &webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: sdp}); err != nil {
+			t.Fatalf("unexpected send error: %v", err)
+		}
+	}
+	for _, want := range []string{"first", "restart"} {
+		got, err := answerer.waitDescription(t.Context(), webrtc.SDPTypeOffer)
+		if err != nil {
+			t.Fatalf("unexpected wait error: %v", err)
+		}
+		if got.SDP != want {
+			t.Fatalf("offer sdp = %q, want %q", got.SDP, want)
+		}
+	}
+	for _, name := range []string{"offer.json", "offer.1.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %s: %v", name, err)
+		}
+	}
+}
+
+func TestCandidateUfragReadsCandidateExtension(t *testing.T) {
+	candidate := webrtc.ICECandidateInit{Candidate: "candidate:1 1 udp 2130706431 10.200.0.2 50000 typ host ufrag abcd"}
+	if got := candidateUfrag(candidate); got != "abcd" {
+		t.Fatalf("candidate ufrag = %q, want abcd", got)
+	}
+	if got := iceUfrag("v=0\r\na=ice-ufrag:wxyz\r\na=ice-pwd:secret\r\n"); got != "wxyz" {
+		t.Fatalf("sdp ufrag = %q, want wxyz", got)
+	}
+}
@@ -20,11 +20,24 @@ type IPTablesRule struct {
 }
 
 type LabState struct {
-	Bridge          string         `json:"bridge"`
-	Subnet          string         `json:"subnet"`
-	Nodes           []string       `json:"nodes"`
-	Rules           []IPTablesRule `json:"rules"`
-	IPForwardBefore string         `json:"ip_forward_before"`
+	Bridge            string           `json:"bridge"`
+	Subnet            string           `json:"subnet"`
+	Nodes             []string         `json:"nodes"`
+	Rules             []IPTablesRule   `json:"rules"`
+	IPForwardBefore   string           `json:"ip_forward_before"`
+	NAT               []NodeNAT        `json:"nat,omitempty"`
+	Firewalls         []NodeFirewall   `json:"firewalls,omitempty"`
+	Routers           []string         `json:"routers,omitempty"`
+	Links             []LabLink        `json:"links,omitempty"`
+	SubnetIPv6        string           `json:"subnet_ipv6,omitempty"`
+	RulesIPv6         []IPTablesRule   `json:"rules_ipv6,omitempty"`
+	IPv6ForwardBefore string           `json:"ipv6_forward_before,omitempty"`
+	MTU               []NodeMTU        `json:"mtu,omitempty"`
+	CreateMTU         int              `json:"create_mtu,omitempty"`
+	Handovers         []NodeHandover   `json:"handovers,omitempty"`
+	FirewallBackend   string           `json:"firewall_backend,omitempty"`
+	NFTablesTable     string           `json:"nftables_table,omitempty"`
+	Impairments       []NodeImpairment `json:"impairments,omitempty"`
 }
 
 func loadState(_ context.Context, path string) (*LabState, error) {
@@ -51,13 +64,32 @@ func saveStateAtomic(_ context.Context, path string, state *LabState) error {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, 0o600); err != nil {
-		return fmt.Errorf("failed to write state temp file %s: %w", tmp, err)
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path via a rename so readers never see a partial
+// file. A unique temp name keeps concurrent writers from renaming each other's
+// half-written file into place.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file for %s: %w", path, err)
+	}
+	tmp := f.Name()
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
 	}
 	if err := os.Rename(tmp, path); err != nil {
 		_ = os.Remove(tmp)
-		return fmt.Errorf("failed to commit state file %s: %w", path, err)
+		return fmt.Errorf("failed to commit %s: %w", path, err)
 	}
 	return nil
 }
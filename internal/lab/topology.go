@@ -0,0 +1,457 @@
+package lab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	routerNamespacePrefix = "rtr-"
+	maxTopologyLinks      = 250
+)
+
+var topologyRouterPattern = regexp.MustCompile(`^[a-z][a-z0-9]{0,9}$`)
+
+type Topology struct {
+	Nodes   []string       `json:"nodes"`
+	Routers []string       `json:"routers"`
+	Links   []TopologyLink `json:"links"`
+}
+
+type TopologyLink struct {
+	A      string `json:"a"`
+	B      string `json:"b"`
+	Delay  string `json:"delay,omitempty"`
+	Jitter string `json:"jitter,omitempty"`
+	Loss   string `json:"loss,omitempty"`
+	BW     string `json:"bw,omitempty"`
+}
+
+type LabLink struct {
+	A          string `json:"a"`
+	AInterface string `json:"a_interface"`
+	AIP        string `json:"a_ip"`
+	B          string `json:"b"`
+	BInterface string `json:"b_interface"`
+	BIP        string `json:"b_ip"`
+	Subnet     string `json:"subnet"`
+	Delay      string `json:"delay,omitempty"`
+	Jitter     string `json:"jitter,omitempty"`
+	Loss       string `json:"loss,omitempty"`
+	BW         string `json:"bw,omitempty"`
+}
+
+func LoadTopology(path string) (*Topology, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topology file %s: %w", path, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var topo Topology
+	if err := dec.Decode(&topo); err != nil {
+		return nil, fmt.Errorf("failed to parse topology file %s: %w", path, err)
+	}
+	if err := validateTopology(&topo); err != nil {
+		return nil, fmt.Errorf("invalid topology file %s: %w", path, err)
+	}
+	return &topo, nil
+}
+
+func validateTopology(topo *Topology) error {
+	if len(topo.Nodes) == 0 {
+		return errors.New("at least one node is required")
+	}
+	if len(topo.Links) == 0 && len(topo.Nodes) > 1 {
+		return errors.New("links are required to connect more than one node")
+	}
+	if len(topo.Links) > maxTopologyLinks {
+		return fmt.Errorf("at most %d links are supported: got %d", maxTopologyLinks, len(topo.Links))
+	}
+
+	seen := make(map[string]bool, len(topo.Nodes)+len(topo.Routers))
+	for _, node := range topo.Nodes {
+		if !isManagedNodeName(node) {
+			return fmt.Errorf("node %q must be named nodeN", node)
+		}
+		if seen[node] {
+			return fmt.Errorf("node %q is listed more than once", node)
+		}
+		seen[node] = true
+	}
+	for _, router := range topo.Routers {
+		if !topologyRouterPattern.MatchString(router) || isManagedNodeName(router) {
+			return fmt.Errorf("router %q must be 1-10 lowercase letters or digits and must not be named nodeN", router)
+		}
+		if seen[router] {
+			return fmt.Errorf("router %q is listed more than once", router)
+		}
+		seen[router] = true
+	}
+
+	pairs := make(map[string]bool, len(topo.Links))
+	linked := make(map[string]bool, len(seen))
+	for i, link := range topo.Links {
+		if !seen[link.A] || !seen[link.B] {
+			return fmt.Errorf("link %d connects unknown endpoint %q-%q", i+1, link.A, link.B)
+		}
+		if link.A == link.B {
+			return fmt.Errorf("link %d connects %q to itself", i+1, link.A)
+		}
+		pair := link.A + "|" + link.B
+		if link.B < link.A {
+			pair = link.B + "|" + link.A
+		}
+		if pairs[pair] {
+			return fmt.Errorf("link %d duplicates %q-%q", i+1, link.A, link.B)
+		}
+		pairs[pair] = true
+		if link.Jitter != "" && link.Delay == "" {
+			return fmt.Errorf("link %d: jitter requires delay", i+1)
+		}
+		linked[link.A] = true
+		linked[link.B] = true
+	}
+	if len(topo.Nodes) > 1 || len(topo.Routers) > 0 {
+		for name := range seen {
+			if !linked[name] {
+				return fmt.Errorf("%q has no links", name)
+			}
+		}
+	}
+
+	links := buildLabLinks(topo)
+	for _, node := range topo.Nodes {
+		hops := topologyFirstHops(topologyNamespace(topo, node), links)
+		for _, other := range topo.Nodes {
+			if other == node {
+				continue
+			}
+			if _, ok := hops[other]; !ok {
+				return fmt.Errorf("node %q cannot reach %q through routers", node, other)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Topology) hasImpairments() bool {
+	for _, link := range t.Links {
+		if link.Delay != "" || link.Loss != "" || link.BW != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func topologyNamespace(topo *Topology, name string) string {
+	if containsString(topo.Routers, name) {
+		return routerNamespacePrefix + name
+	}
+	return name
+}
+
+func isManagedRouterNamespace(ns string) bool {
+	return strings.HasPrefix(ns, routerNamespacePrefix) && topologyRouterPattern.MatchString(strings.TrimPrefix(ns, routerNamespacePrefix))
+}
+
+// buildLabLinks assigns each link its own 10.202.<k>.0/24 subnet and names
+// interfaces eth0, eth1, ... in the order links appear for each namespace.
+func buildLabLinks(topo *Topology) []LabLink {
+	ifaces := make(map[string]int)
+	nextIface := func(ns string) string {
+		name := "eth" + strconv.Itoa(ifaces[ns])
+		ifaces[ns]++
+		return name
+	}
+	links := make([]LabLink, 0, len(topo.Links))
+	for i, link := range topo.Links {
+		k := strconv.Itoa(i + 1)
+		a := topologyNamespace(topo, link.A)
+		b := topologyNamespace(topo, link.B)
+		links = append(links, LabLink{
+			A:          a,
+			AInterface: nextIface(a),
+			AIP:        "10.202." + k + ".1",
+			B:          b,
+			BInterface: nextIface(b),
+			BIP:        "10.202." + k + ".2",
+			Subnet:     "10.202." + k + ".0/24",
+			Delay:      link.Delay,
+			Jitter:     link.Jitter,
+			Loss:       link.Loss,
+			BW:         link.BW,
+		})
+	}
+	return links
+}
+
+type topologyHop struct {
+	link  int
+	iface string
+	via   string
+}
+
+// topologyFirstHops walks the link graph from src and returns, for every
+// reachable namespace, the link src must send through to get there. Only
+// routers forward traffic, so paths never transit another node.
+func topologyFirstHops(src string, links []LabLink) map[string]topologyHop {
+	hops := map[string]topologyHop{}
+	queue := []string{src}
+	visited := map[string]bool{src: true}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur != src && !isManagedRouterNamespace(cur) {
+			continue
+		}
+		for i, link := range links {
+			var next string
+			hop := hops[cur]
+			switch cur {
+			case link.A:
+				next = link.B
+				if cur == src {
+					hop = topologyHop{link: i, iface: link.AInterface, via: link.BIP}
+				}
+			case link.B:
+				next = link.A
+				if cur == src {
+					hop = topologyHop{link: i, iface: link.BInterface, via: link.AIP}
+				}
+			default:
+				continue
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			hops[next] = hop
+			queue = append(queue, next)
+		}
+	}
+	return hops
+}
+
+// topologyRoutes returns the static routes ns needs for every link subnet it
+// is not attached to.
+func topologyRoutes(ns string, links []LabLink) [][]string {
+	hops := topologyFirstHops(ns, links)
+	var routes [][]string
+	for _, link := range links {
+		if link.A == ns || link.B == ns {
+			continue
+		}
+		hop, ok := hops[link.A]
+		if !ok {
+			hop, ok = hops[link.B]
+		}
+		if !ok {
+			continue
+		}
+		routes = append(routes, []string{"ip", "route", "add", link.Subnet, "via", hop.via, "dev", hop.iface})
+	}
+	return routes
+}
+
+func topologyNodeIP(links []LabLink, node string) (string, bool) {
+	for _, link := range links {
+		if link.A == node {
+			return link.AIP, true
+		}
+		if link.B == node {
+			return link.BIP, true
+		}
+	}
+	return "", false
+}
+
+// labNodeIP resolves the address other nodes use to reach node.
+func labNodeIP(state *LabState, node string) (string, error) {
+	if state != nil {
+		if h, ok := findNodeHandover(state.Handovers, node); ok {
+			return h.IP, nil
+		}
+	}
+	if state != nil && len(state.Links) > 0 {
+		if ip, ok := topologyNodeIP(state.Links, node); ok {
+			return ip, nil
+		}
+		return "", fmt.Errorf("node %q has no topology links", node)
+	}
+	return managedNodeIP(node)
+}
+
+func netemArgs(delay, jitter, loss, bw string) []string {
+	var args []string
+	if delay != "" {
+		args = append(args, "delay", delay)
+		if jitter != "" {
+			args = append(args, jitter)
+		}
+	}
+	if loss != "" {
+		args = append(args, "loss", loss)
+	}
+	if bw != "" {
+		args = append(args, "rate", bw)
+	}
+	return args
+}
+
+func createTopologyWithDeps(ctx context.Context, topo *Topology, mtu int, deps createDeps) (*CreateResult, error) {
+	if err := validateTopology(topo); err != nil {
+		return nil, err
+	}
+	if mtu != 0 {
+		if err := validateMTU(mtu, false); err != nil {
+			return nil, err
+		}
+	}
+	if deps.goos != "linux" {
+		return nil, fmt.Errorf("lab create is supported only on linux: got %s", deps.goos)
+	}
+	if !deps.hasNetAdmin() {
+		return nil, errors.New("lab create requires CAP_NET_ADMIN: run as root or with --rootless")
+	}
+	required := []string{"ip", "sysctl", "ping"}
+	if topo.hasImpairments() {
+		required = append(required, "tc")
+	}
+	for _, cmd := range required {
+		if _, err := deps.findPath(cmd); err != nil {
+			return nil, fmt.Errorf("required command %q not found: %w", cmd, err)
+		}
+	}
+	unlock, err := lockLab(ctx, deps, "lab create")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := checkNoExistingLab(ctx, deps); err != nil {
+		return nil, err
+	}
+	resetAuditLog(deps.exec)
+
+	links := buildLabLinks(topo)
+	routers := make([]string, 0, len(topo.Routers))
+	for _, router := range topo.Routers {
+		routers = append(routers, routerNamespacePrefix+router)
+	}
+
+	var cleanups []func(context.Context)
+	rollback := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i](ctx)
+		}
+	}
+
+	for _, ns := range append(append([]string{}, topo.Nodes...), routers...) {
+		if err := deps.exec.Run(ctx, "ip", "netns", "add", ns); err != nil {
+			rollback()
+			return nil, err
+		}
+		cleanups = append(cleanups, func(ctx context.Context) {
+			_ = deps.exec.Run(ctx, "ip", "netns", "del", ns)
+		})
+		if err := deps.exec.Run(ctx, "ip", "netns", "exec", ns, "ip", "link", "set", "lo", "up"); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+	for _, ns := range routers {
+		if err := deps.exec.Run(ctx, "ip", "netns", "exec", ns, "sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	for i, link := range links {
+		if err := createTopologyLink(ctx, deps.exec, i+1, link); err != nil {
+			rollback()
+			return nil, err
+		}
+	}
+
+	for _, ns := range append(append([]string{}, topo.Nodes...), routers...) {
+		for _, route := range topologyRoutes(ns, links) {
+			if err := deps.exec.Run(ctx, "ip", append([]string{"netns", "exec", ns}, route...)...); err != nil {
+				rollback()
+				return nil, fmt.Errorf("failed to add route %s in %s: %w", strings.Join(route[3:], " "), ns, err)
+			}
+		}
+	}
+
+	result := &CreateResult{
+		Nodes:   make([]Node, 0, len(topo.Nodes)),
+		Routers: routers,
+		Links:   links,
+	}
+	for _, node := range topo.Nodes {
+		ip, _ := topologyNodeIP(links, node)
+		result.Nodes = append(result.Nodes, Node{Name: node, IP: ip})
+	}
+	for _, node := range result.Nodes[1:] {
+		src := result.Nodes[0].Name
+		if err := deps.exec.Run(ctx, "ip", "netns", "exec", src, "ping", "-c", "1", "-W", "1", node.IP); err != nil {
+			rollback()
+			return nil, fmt.Errorf("connectivity check failed for %s -> %s (%s): %w", src, node.Name, node.IP, err)
+		}
+	}
+
+	mtus, err := applyCreateMTU(ctx, deps.exec, &LabState{Links: links}, result.Nodes, mtu)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+
+	if deps.saveState != nil {
+		state := &LabState{
+			Nodes:   topo.Nodes,
+			Routers: routers,
+			Links:   links,
+			MTU:     mtus,
+		}
+		if err := deps.saveState(ctx, state); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to persist lab state: %w", err)
+		}
+	}
+
+	recordDryRunRollback(deps.exec, rollback)
+	return result, nil
+}
+
+func createTopologyLink(ctx context.Context, exec Executor, k int, link LabLink) error {
+	vethA := "rte" + strconv.Itoa(k) + "a"
+	vethB := "rte" + strconv.Itoa(k) + "b"
+	steps := [][]string{
+		{"ip", "link", "add", vethA, "type", "veth", "peer", "name", vethB},
+		{"ip", "link", "set", vethA, "netns", link.A},
+		{"ip", "link", "set", vethB, "netns", link.B},
+		{"ip", "netns", "exec", link.A, "ip", "link", "set", vethA, "name", link.AInterface},
+		{"ip", "netns", "exec", link.A, "ip", "addr", "add", link.AIP + "/24", "dev", link.AInterface},
+		{"ip", "netns", "exec", link.A, "ip", "link", "set", link.AInterface, "up"},
+		{"ip", "netns", "exec", link.B, "ip", "link", "set", vethB, "name", link.BInterface},
+		{"ip", "netns", "exec", link.B, "ip", "addr", "add", link.BIP + "/24", "dev", link.BInterface},
+		{"ip", "netns", "exec", link.B, "ip", "link", "set", link.BInterface, "up"},
+	}
+	if netem := netemArgs(link.Delay, link.Jitter, link.Loss, link.BW); len(netem) > 0 {
+		for _, end := range [][2]string{{link.A, link.AInterface}, {link.B, link.BInterface}} {
+			steps = append(steps, append([]string{"ip", "netns", "exec", end[0], "tc", "qdisc", "replace", "dev", end[1], "root", "netem"}, netem...))
+		}
+	}
+	for _, step := range steps {
+		if err := exec.Run(ctx, step[0], step[1:]...); err != nil {
+			return fmt.Errorf("failed to create link %s-%s: %w", link.A, link.B, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testTopology() *Topology {
+	return &Topology{
+		Nodes:   []string{"node1", "node2"},
+		Routers: []string{"access", "core"},
+		Links: []TopologyLink{
+			{A: "node1", B: "access"},
+			{A: "access", B: "core", Delay: "40ms", Loss: "1%"},
+			{A: "core", B: "node2"},
+		},
+	}
+}
+
+func TestCreateWithDeps_TopologyBuildsRoutedHops(t *testing.T) {
+	ex := &fakeExecutor{
+		runFn: func(name string, args ...string) error {
+			if callKey(name, args...) == "ip link show rtcemu0" {
+				return errors.New("Device \"rtcemu0\" does not exist")
+			}
+			return nil
+		},
+	}
+	var saved *LabState
+
+	got, err := createWithDeps(context.Background(), CreateOptions{Topology: testTopology()}, createDeps{
+		exec:        ex,
+		goos:        "linux",
+		hasNetAdmin: func() bool { return true },
+		findPath:    func(string) (string, error) { return "/bin/x", nil },
+		saveState: func(_ context.Context, state *LabState) error {
+			saved = state
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.Nodes) != 2 || got.Nodes[0].IP != "10.202.1.1" || got.Nodes[1].IP != "10.202.3.2" {
+		t.Fatalf("unexpected nodes: %+v", got.Nodes)
+	}
+	for _, want := range []string{
+		"ip netns add rtr-access",
+		"ip netns exec rtr-core sysctl -w net.ipv4.ip_forward=1",
+		"ip link set rte2a netns rtr-access",
+		"ip netns exec rtr-access ip addr add 10.202.2.1/24 dev eth1",
+		"ip netns exec rtr-access tc qdisc replace dev eth1 root netem delay 40ms loss 1%",
+		"ip netns exec rtr-core tc qdisc replace dev eth0 root netem delay 40ms loss 1%",
+		"ip netns exec node1 ip route add 10.202.3.0/24 via 10.202.1.2 dev eth0",
+		"ip netns exec rtr-core ip route add 10.202.1.0/24 via 10.202.2.1 dev eth0",
+		"ip netns exec node1 ping -c 1 -W 1 10.202.3.2",
+	} {
+		if !hasCall(ex.calls, want) {
+			t.Fatalf("missing command %q in %v", want, ex.calls)
+		}
+	}
+	if hasCall(ex.calls, "ip link add rtcemu0 type bridge") {
+		t.Fatalf("topology lab should not create the bridge")
+	}
+	if saved == nil || len(saved.Routers) != 2 || len(saved.Links) != 3 || saved.Bridge != "" {
+		t.Fatalf("unexpected saved state: %+v", saved)
+	}
+}
+
+func TestValidateTopologyRejectsInvalidSpecs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		topo Topology
+		want string
+	}{
+		"unknown endpoint": {
+			topo: Topology{Nodes: []string{"node1", "node2"}, Links: []TopologyLink{{A: "node1", B: "edge"}}},
+			want: `unknown endpoint "node1"-"edge"`,
+		},
+		"node transit": {
+			topo: Topology{
+				Nodes: []string{"node1", "node2", "node3"},
+				Links: []TopologyLink{{A: "node1", B: "node2"}, {A: "node2", B: "node3"}},
+			},
+			want: `node "node1" cannot reach "node3" through routers`,
+		},
+		"jitter without delay": {
+			topo: Topology{Nodes: []string{"node1", "node2"}, Links: []TopologyLink{{A: "node1", B: "node2", Jitter: "5ms"}}},
+			want: "jitter requires delay",
+		},
+		"bad router name": {
+			topo: Topology{Nodes: []string{"node1"}, Routers: []string{"node7"}},
+			want: `router "node7"`,
+		},
+	} {
+		if err := validateTopology(&tc.topo); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: error = %v, want %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestLoadTopologyRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topology.json")
+	if err := os.WriteFile(path, []byte(`{"nodes":["node1"],"switches":["s1"]}`), 0o644); err != nil {
+		t.Fatalf("failed to write topology: %v", err)
+	}
+	if _, err := LoadTopology(path); err == nil || !strings.Contains(err.Error(), `unknown field "switches"`) {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package lab
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pion/turn/v5"
+)
+
+const (
+	defaultTURNPort     = 3478
+	defaultTURNRealm    = "rtc-emulator"
+	defaultTURNUsername = "rtcemu"
+)
+
+type TURNOptions struct {
+	Node     string
+	Port     int
+	Realm    string
+	Username string
+	Password string
+	// SecretDir receives the 0600 credential file shared with the server and
+	// with ICE clients; empty means the lab state directory.
+	SecretDir string
+}
+
+type TURNServeOptions struct {
+	ListenIP string
+	Port     int
+	Realm    string
+	Username string
+	Password string
+	// PasswordFile holds the password when Password is empty.
+	PasswordFile string
+}
+
+type TURNServerInfo struct {
+	STUNURL    string
+	TURNURLs   []string
+	Username   string
+	Credential string
+	// CredentialFile holds Credential for --ice-credential-file.
+	CredentialFile string
+}
+
+func RunTURN(ctx context.Context, opts TURNOptions, stdout io.Writer, stderr io.Writer) error {
+	return runTURNWithDeps(ctx, opts, defaultWebRTCP2PDeps(ctx), stdout, stderr)
+}
+
+func runTURNWithDeps(ctx context.Context, opts TURNOptions, deps webRTCP2PDeps, stdout io.Writer, stderr io.Writer) error {
+	opts, err := normalizeTURNOptions(opts)
+	if err != nil {
+		return err
+	}
+	deps = fillWebRTCP2PDeps(ctx, deps)
+	if err := validateTURNOptions(opts); err != nil {
+		return err
+	}
+	if err := validateWebRTCNodes(ctx, deps.createDeps, "lab turn", []string{opts.Node}); err != nil {
+		return err
+	}
+	state, err := deps.loadState(ctx)
+	if err != nil {
+		state = nil
+	}
+	if state != nil {
+		if nat, ok := findNodeNAT(state.NAT, opts.Node); ok {
+			return fmt.Errorf("node %q is behind %s nat: run the TURN server on a node without nat", opts.Node, nat.Type)
+		}
+	}
+	listenIP, err := labNodeIP(state, opts.Node)
+	if err != nil {
+		return err
+	}
+	executable, err := deps.executable()
+	if err != nil {
+		return fmt.Errorf("failed to resolve current executable: %w", err)
+	}
+	passwordFile, err := writeTURNPasswordFile(deps, opts)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(passwordFile)
+	if err := deps.runCommand(ctx, "ip", turnNetNSArgs(opts.Node, executable, TURNServeOptions{
+		ListenIP:     listenIP,
+		Port:         opts.Port,
+		Realm:        opts.Realm,
+		Username:     opts.Username,
+		PasswordFile: passwordFile,
+	}), stdout, stderr); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("turn server on %s failed: %w", opts.Node, err)
+	}
+	return nil
+}
+
+// writeTURNPasswordFile hands the password to the server process, and to ICE
+// clients through --ice-credential-file, in a 0600 file instead of on a
+// command line. The file lives as long as the server.
+func writeTURNPasswordFile(deps webRTCP2PDeps, opts TURNOptions) (string, error) {
+	if err := deps.mkdirAll(opts.SecretDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create turn credential directory: %w", err)
+	}
+	path := filepath.Join(opts.SecretDir, "turn-"+opts.Node+".credential")
+	if err := writeFileAtomic(path, []byte(opts.Password)); err != nil {
+		return "", fmt.Errorf("failed to write turn credential file: %w", err)
+	}
+	return path, nil
+}
+
+func normalizeTURNOptions(opts TURNOptions) (TURNOptions, error) {
+	opts.Node = strings.TrimSpace(opts.Node)
+	opts.Realm = strings.TrimSpace(opts.Realm)
+	opts.Username = strings.TrimSpace(opts.Username)
+	if opts.Port == 0 {
+		opts.Port = defaultTURNPort
+	}
+	if opts.Realm == "" {
+		opts.Realm = defaultTURNRealm
+	}
+	if opts.Username == "" {
+		opts.Username = defaultTURNUsername
+	}
+	if opts.SecretDir == "" {
+		opts.SecretDir = filepath.Dir(defaultStatePath)
+	}
+	if opts.Password == "" {
+		password, err := generateTURNPassword()
+		if err != nil {
+			return opts, fmt.Errorf("failed to generate turn credentials: %w", err)
+		}
+		opts.Password = password
+	}
+	return opts, nil
+}
+
+func validateTURNOptions(opts TURNOptions) error {
+	if opts.Node == "" {
+		return errors.New("node is required")
+	}
+	if opts.Port < 1 || opts.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535: got %d", opts.Port)
+	}
+	return nil
+}
+
+func generateTURNPassword() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func turnNetNSArgs(node string, executable string, opts TURNServeOptions) []string {
+	return []string{
+		"netns", "exec", node,
+		executable,
+		"lab", "turn", "serve",
+		"--listen-ip", opts.ListenIP,
+		"--port", strconv.Itoa(opts.Port),
+		"--realm", opts.Realm,
+		"--username", opts.Username,
+		"--password-file", opts.PasswordFile,
+	}
+}
+
+func ServeTURN(ctx context.Context, opts TURNServeOptions, onReady func(TURNServerInfo)) error {
+	ip := net.ParseIP(strings.TrimSpace(opts.ListenIP))
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid turn listen ip %q", opts.ListenIP)
+	}
+	if opts.Password == "" && opts.PasswordFile != "" {
+		password, err := readSecretFile(opts.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read turn password: %w", err)
+		}
+		opts.Password = password
+	}
+	if opts.Username == "" || opts.Password == "" {
+		return errors.New("turn username and password are required")
+	}
+	addr := net.JoinHostPort(ip.String(), strconv.Itoa(opts.Port))
+
+	udpConn, err := net.ListenPacket("udp4", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for turn on udp %s: %w", addr, err)
+	}
+	tcpListener, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to listen for turn on tcp %s: %w", addr, err), udpConn.Close())
+	}
+
+	key := turn.GenerateAuthKey(opts.Username, opts.Realm, opts.Password)
+	relay := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: ip, Address: ip.String()}
+	}
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: opts.Realm,
+		AuthHandler: func(ra *turn.RequestAttributes) (string, []byte, bool) {
+			if ra.Username != opts.Username {
+				return "", nil, false
+			}
+			return ra.Username, key, true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: udpConn, RelayAddressGenerator: relay()}},
+		ListenerConfigs:   []turn.ListenerConfig{{Listener: tcpListener, RelayAddressGenerator: relay()}},
+	})
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to start turn server: %w", err), udpConn.Close(), tcpListener.Close())
+	}
+
+	if onReady != nil {
+		onReady(TURNServerInfo{
+			STUNURL: "stun:" + addr,
+			TURNURLs: []string{
+				"turn:" + addr + "?transport=udp",
+				"turn:" + addr + "?transport=tcp",
+			},
+			Username:       opts.Username,
+			Credential:     opts.Password,
+			CredentialFile: opts.PasswordFile,
+		})
+	}
+	<-ctx.Done()
+	return server.Close()
+}
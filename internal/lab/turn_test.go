@@ -0,0 +1,99 @@
+package lab
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunTURNWithDepsServesFromNodeNamespace(t *testing.T) {
+	var gotName string
+	var gotArgs []string
+	var password string
+	deps := webRTCP2PDeps{
+		createDeps: createDeps{
+			exec: &fakeExecutor{
+				outputFn: func(name string, args ...string) (string, error) {
+					if callKey(name, args...) == "ip netns list" {
+						return "node1\nnode2\nnode3\n", nil
+					}
+					return "", nil
+				},
+			},
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
+			loadState: func(context.Context) (*LabState, error) {
+				return &LabState{Nodes: []string{"node1", "node2", "node3"}}, nil
+			},
+		},
+		mkdirAll:   os.MkdirAll,
+		executable: func() (string, error) { return "/tmp/rtc-emulator", nil },
+		runCommand: func(_ context.Context, name string, args []string, _ io.Writer, _ io.Writer) error {
+			gotName = name
+			gotArgs = args
+			info, err := os.Stat(argValue(args, "--password-file"))
+			if err != nil {
+				return err
+			}
+			if info.Mode().Perm() != 0o600 {
+				t.Errorf("expected a 0600 password file, got %v", info.Mode().Perm())
+			}
+			b, err := os.ReadFile(argValue(args, "--password-file"))
+			password = string(b)
+			return err
+		},
+	}
+
+	secretDir := t.TempDir()
+	if err := runTURNWithDeps(t.Context(), TURNOptions{Node: "node3", SecretDir: secretDir}, deps, io.Discard, io.Discard); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(password) != 24 {
+		t.Fatalf("expected generated password in the password file, got %q", password)
+	}
+	passwordFile := argValue(gotArgs, "--password-file")
+	if passwordFile != filepath.Join(secretDir, "turn-node3.credential") {
+		t.Fatalf("unexpected password file %s", passwordFile)
+	}
+	if _, err := os.Stat(passwordFile); !os.IsNotExist(err) {
+		t.Fatalf("expected password file %s to be removed, err=%v", passwordFile, err)
+	}
+	want := []string{
+		"netns", "exec", "node3",
+		"/tmp/rtc-emulator",
+		"lab", "turn", "serve",
+		"--listen-ip", "10.200.0.4",
+		"--port", "3478",
+		"--realm", "rtc-emulator",
+		"--username", "rtcemu",
+		"--password-file", passwordFile,
+	}
+	if gotName != "ip" || !reflect.DeepEqual(gotArgs, want) {
+		t.Fatalf("command = %s %#v, want ip %#v", gotName, gotArgs, want)
+	}
+}
+
+func TestRunTURNWithDepsRejectsUnmanagedNode(t *testing.T) {
+	deps := webRTCP2PDeps{
+		createDeps: createDeps{
+			exec:        &fakeExecutor{},
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
+			loadState: func(context.Context) (*LabState, error) {
+				return &LabState{Nodes: []string{"node1"}}, nil
+			},
+		},
+	}
+
+	err := runTURNWithDeps(t.Context(), TURNOptions{Node: "node3"}, deps, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), `node "node3" is not managed by current lab`) {
+		t.Fatalf("expected unmanaged node error, got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v4/vnet"
+)
+
+const (
+	vnetUnlimitedRate = 10_000_000_000 // bit/s
+	vnetBurstBytes    = 1600
+	vnetQueueBytes    = 1000 * 1500
+	vnetJitterStep    = 10 * time.Millisecond
+)
+
+// vnetLab is an in-process lab on a pion vnet router. It uses the same node
+// names and addresses as the netns lab but needs no privileges.
+type vnetLab struct {
+	router  *vnet.Router
+	nodes   []*vnetNode
+	started bool
+}
+
+// vnetNode holds the filter chain in front of a node's virtual NIC:
+// delay, then loss, then a token bucket queue.
+type vnetNode struct {
+	name  string
+	ip    string
+	net   *vnet.Net
+	delay *vnet.DelayFilter
+	loss  *vnet.LossFilter
+	tbf   *vnet.TBFQueue
+	queue *vnet.Queue
+
+	mu         sync.Mutex
+	stopJitter func()
+	shapedBy   string
+}
+
+type vnetCondition struct {
+	delay  time.Duration
+	jitter time.Duration
+	loss   int
+	rate   int
+}
+
+func newVNetLab(nodes []string) (*vnetLab, error) {
+	router, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          subnetCIDR,
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vnet router: %w", err)
+	}
+	lab := &vnetLab{router: router}
+	for _, name := range nodes {
+		node, err := newVNetNode(name)
+		if err != nil {
+			lab.close()
+			return nil, err
+		}
+		lab.nodes = append(lab.nodes, node)
+		if err := router.AddNet(node.delay); err != nil {
+			lab.close()
+			return nil, fmt.Errorf("failed to attach %s to vnet router: %w", name, err)
+		}
+	}
+	if err := router.Start(); err != nil {
+		lab.close()
+		return nil, fmt.Errorf("failed to start vnet router: %w", err)
+	}
+	lab.started = true
+	return lab, nil
+}
+
+func newVNetNode(name string) (*vnetNode, error) {
+	ip, err := managedNodeIP(name)
+	if err != nil {
+		return nil, err
+	}
+	netw, err := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{ip}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vnet node %s: %w", name, err)
+	}
+	node := &vnetNode{name: name, ip: ip, net: netw}
+	node.tbf = vnet.NewTBFQueue(vnetUnlimitedRate, vnetBurstBytes, vnetQueueBytes)
+	if node.queue, err = vnet.NewQueue(netw, node.tbf); err != nil {
+		return nil, fmt.Errorf("failed to create vnet queue for %s: %w", name, err)
+	}
+	if node.loss, err = vnet.NewLossFilter(node.queue, 0); err != nil {
+		_ = node.queue.Close()
+		return nil, fmt.Errorf("failed to create vnet loss filter for %s: %w", name, err)
+	}
+	if node.delay, err = vnet.NewDelayFilter(node.loss); err != nil {
+		_ = node.queue.Close()
+		return nil, fmt.Errorf("failed to create vnet delay filter for %s: %w", name, err)
+	}
+	return node, nil
+}
+
+func (l *vnetLab) node(name string) (*vnetNode, error) {
+	for _, n := range l.nodes {
+		if n.name == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("node %q is not part of the vnet lab", name)
+}
+
+// apply shapes what the node sends. vnet filters act on the receiving NIC,
+// so the condition is installed on every other node; with the two-node
+// scenario lab that is exactly the node's uplink, like netem on its eth0.
+func (l *vnetLab) apply(opts ApplyOptions) error {
+	if _, err := l.node(opts.Node); err != nil {
+		return err
+	}
+	cond, err := parseVNetCondition(opts)
+	if err != nil {
+		return err
+	}
+	for _, n := range l.nodes {
+		if n.name != opts.Node {
+			if err := n.set(cond, opts.Node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *vnetLab) clear(opts ClearOptions) (*ClearResult, error) {
+	if _, err := l.node(opts.Node); err != nil {
+		return nil, err
+	}
+	result := &ClearResult{Node: opts.Node}
+	for _, n := range l.nodes {
+		n.mu.Lock()
+		shaped := n.shapedBy == opts.Node
+		n.mu.Unlock()
+		if !shaped {
+			continue
+		}
+		if err := n.set(vnetCondition{}, ""); err != nil {
+			return nil, err
+		}
+		result.Cleared = true
+	}
+	return result, nil
+}
+
+func (n *vnetNode) set(cond vnetCondition, shapedBy string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.stopJitter != nil {
+		n.stopJitter()
+		n.stopJitter = nil
+	}
+	if err := n.loss.SetLossRate(cond.loss, true); err != nil {
+		return fmt.Errorf("failed to set vnet loss on %s: %w", n.name, err)
+	}
+	rate := cond.rate
+	if rate == 0 {
+		rate = vnetUnlimitedRate
+	}
+	n.tbf.SetRate(rate)
+	n.delay.SetDelay(cond.delay)
+	if cond.jitter > 0 {
+		n.stopJitter = startVNetJitter(n.delay, cond.delay, cond.jitter)
+	}
+	n.shapedBy = shapedBy
+	return nil
+}
+
+// startVNetJitter approximates netem jitter by re-drawing the filter delay
+// every few milliseconds; vnet has no per-packet jitter.
+func startVNetJitter(filter *vnet.DelayFilter, base time.Duration, jitter time.Duration) func() {
+	done := make(chan struct{})
+	go func() {
+		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+		ticker := time.NewTicker(vnetJitterStep)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d := base + time.Duration(rng.Int63n(int64(2*jitter)+1)) - jitter
+				filter.SetDelay(max(d, 0))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func parseVNetCondition(opts ApplyOptions) (vnetCondition, error) {
+	var cond vnetCondition
+	if opts.Delay != "" {
+		us, ok := parseTCTime(opts.Delay)
+		if !ok {
+			return cond, fmt.Errorf("invalid delay %q", opts.Delay)
+		}
+		cond.delay = time.Duration(us) * time.Microsecond
+	}
+	if opts.Jitter != "" {
+		us, ok := parseTCTime(opts.Jitter)
+		if !ok {
+			return cond, fmt.Errorf("invalid jitter %q", opts.Jitter)
+		}
+		cond.jitter = time.Duration(us) * time.Microsecond
+	}
+	if opts.Loss != "" {
+		v, err := strconv.ParseFloat(strings.TrimSuffix(opts.Loss, "%"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return cond, fmt.Errorf("invalid loss %q", opts.Loss)
+		}
+		if v != math.Trunc(v) {
+			return cond, fmt.Errorf("vnet backend supports whole-percent loss only: got %q", opts.Loss)
+		}
+		cond.loss = int(v)
+	}
+	if opts.BW != "" {
+		bytesPerSecond, ok := parseTCRate(opts.BW)
+		if !ok {
+			return cond, fmt.Errorf("invalid bandwidth %q", opts.BW)
+		}
+		cond.rate = int(bytesPerSecond * 8)
+	}
+	return cond, nil
+}
+
+// startPeers runs the offerer and answerer in this process on their vnet
+// nodes, with the same options the netns backend passes to `lab webrtc peer`.
+func (l *vnetLab) startPeers(ctx context.Context, opts WebRTCP2POptions, runID string, runDir string, cancel context.CancelFunc) func() error {
+	type peerRun struct {
+		node string
+		role string
+		err  error
+	}
+	runs := []*peerRun{
+		{node: opts.NodeB, role: webRTCPeerRoleAnswerer},
+		{node: opts.NodeA, role: webRTCPeerRoleOfferer},
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		peer := opts.NodeA
+		if run.node == opts.NodeA {
+			peer = opts.NodeB
+		}
+		node, err := l.node(run.node)
+		if err != nil {
+			run.err = err
+			cancel()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.err = runWebRTCPeerOnNet(ctx, WebRTCPeerOptions{
+				Role:          run.role,
+				RunID:         runID,
+				RunDir:        runDir,
+				Node:          run.node,
+				Peer:          peer,
+				Duration:      opts.Duration,
+				StatsInterval: opts.StatsInterval,
+				RawStats:      opts.RawStats,
+				ICE:           opts.ICE,
+			}, node.net)
+			if run.err != nil {
+				cancel()
+			}
+		}()
+	}
+
+	return func() error {
+		wg.Wait()
+		var runErr error
+		for _, run := range runs {
+			if run.err != nil {
+				runErr = errors.Join(runErr, fmt.Errorf("webrtc peer %s/%s failed: %w", run.node, run.role, run.err))
+			}
+		}
+		return runErr
+	}
+}
+
+func (l *vnetLab) close() error {
+	var err error
+	if l.started {
+		err = l.router.Stop()
+	}
+	for _, n := range l.nodes {
+		n.mu.Lock()
+		if n.stopJitter != nil {
+			n.stopJitter()
+			n.stopJitter = nil
+		}
+		n.mu.Unlock()
+		err = errors.Join(err, n.delay.Close(), n.queue.Close())
+	}
+	return err
+}
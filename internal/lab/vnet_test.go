@@ -0,0 +1,72 @@
+package lab
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVNetCondition(t *testing.T) {
+	got, err := parseVNetCondition(ApplyOptions{Delay: "100ms", Jitter: "5ms", Loss: "2%", BW: "1mbit"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := vnetCondition{delay: 100 * time.Millisecond, jitter: 5 * time.Millisecond, loss: 2, rate: 1_000_000}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	if _, err := parseVNetCondition(ApplyOptions{Loss: "0.5%"}); err == nil || !strings.Contains(err.Error(), "whole-percent loss only") {
+		t.Fatalf("expected whole-percent loss error, got %v", err)
+	}
+}
+
+func TestValidateVNetScenarioOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		opts    ScenarioRunOptions
+		wantErr string
+	}{
+		{name: "ice restart", opts: ScenarioRunOptions{Actions: []ScenarioAction{{Phase: "impaired", Name: ScenarioActionICERestart}}}},
+		{name: "mtu", opts: ScenarioRunOptions{Actions: []ScenarioAction{{Phase: "impaired", Name: ScenarioActionMTU, Value: "1200"}}}, wantErr: "scenario action mtu is not supported by the vnet backend"},
+		{name: "ice servers", opts: ScenarioRunOptions{ICE: WebRTCICEOptions{Servers: []string{"stun:10.200.0.1:3478"}}}, wantErr: "ice servers are not reachable"},
+		{name: "fractional loss", opts: ScenarioRunOptions{Loss: "1.5%"}, wantErr: "whole-percent loss only"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateVNetScenarioOptions(tc.opts)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVNetLabApplyShapesOtherNodes(t *testing.T) {
+	l, err := newVNetLab([]string{"node1", "node2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = l.close() })
+
+	if err := l.apply(ApplyOptions{Node: "node1", Delay: "50ms"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.nodes[0].shapedBy != "" || l.nodes[1].shapedBy != "node1" {
+		t.Fatalf("expected only node2 to be shaped by node1, got %q and %q", l.nodes[0].shapedBy, l.nodes[1].shapedBy)
+	}
+
+	got, err := l.clear(ClearOptions{Node: "node1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.Cleared || l.nodes[1].shapedBy != "" {
+		t.Fatalf("expected node1 condition to be cleared, got %+v shapedBy=%q", got, l.nodes[1].shapedBy)
+	}
+}
@@ -0,0 +1,119 @@
+package lab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const peerControlICERestart = "ice-restart"
+
+type peerControlRequest struct {
+	ID     int    `json:"id"`
+	Action string `json:"action"`
+	Time   string `json:"time"`
+}
+
+type peerControlResult struct {
+	ID               int     `json:"id"`
+	Action           string  `json:"action"`
+	Node             string  `json:"node"`
+	RequestedAt      string  `json:"requested_at"`
+	CompletedAt      string  `json:"completed_at"`
+	ReconnectSeconds float64 `json:"reconnect_seconds"`
+	Error            string  `json:"error,omitempty"`
+}
+
+func watchPeerControl(ctx context.Context, session *webRTCPeerSession, opts WebRTCPeerOptions) {
+	for id := 1; ; {
+		b, err := os.ReadFile(peerControlRequestPath(opts.RunDir, opts.Node, id))
+		if err == nil {
+			var req peerControlRequest
+			result := peerControlResult{ID: id, Node: opts.Node}
+			if err := json.Unmarshal(b, &req); err != nil {
+				result.Error = fmt.Sprintf("failed to parse control request: %v", err)
+			} else {
+				result.Action = req.Action
+				result.RequestedAt = req.Time
+				reconnect, err := handlePeerControl(ctx, session, req)
+				result.ReconnectSeconds = reconnect.Seconds()
+				result.Error = errorString(err)
+			}
+			result.CompletedAt = time.Now().UTC().Format(time.RFC3339Nano)
+			_ = writeJSONFileAtomic(peerControlResultPath(opts.RunDir, opts.Node, id), result)
+			id++
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(webRTCSignalPollInterval):
+		}
+	}
+}
+
+func handlePeerControl(ctx context.Context, session *webRTCPeerSession, req peerControlRequest) (time.Duration, error) {
+	switch req.Action {
+	case peerControlICERestart:
+		return session.restartICE(ctx)
+	default:
+		return 0, fmt.Errorf("unsupported peer control action %q", req.Action)
+	}
+}
+
+func requestPeerControl(ctx context.Context, runDir string, node string, id int, action string) (*peerControlResult, error) {
+	req := peerControlRequest{
+		ID:     id,
+		Action: action,
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	if err := writeJSONFileAtomic(peerControlRequestPath(runDir, node, id), req); err != nil {
+		return nil, fmt.Errorf("failed to request %s on %s: %w", action, node, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*webRTCSignalTimeout)
+	defer cancel()
+	path := peerControlResultPath(runDir, node, id)
+	for {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			var result peerControlResult
+			if err := json.Unmarshal(b, &result); err != nil {
+				return nil, fmt.Errorf("failed to parse control result %s: %w", path, err)
+			}
+			if result.Error != "" {
+				return &result, fmt.Errorf("%s on %s failed: %s", action, node, result.Error)
+			}
+			return &result, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read control result %s: %w", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for %s on %s: %w", action, node, ctx.Err())
+		case <-time.After(webRTCSignalPollInterval):
+		}
+	}
+}
+
+func peerControlRequestPath(runDir string, node string, id int) string {
+	return filepath.Join(signalDir(runDir), "control."+node+"."+strconv.Itoa(id)+".json")
+}
+
+func peerControlResultPath(runDir string, node string, id int) string {
+	return filepath.Join(signalDir(runDir), "control."+node+"."+strconv.Itoa(id)+".result.json")
+}
+
+func writeJSONFileAtomic(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+	return writeFileAtomic(path, b)
+}
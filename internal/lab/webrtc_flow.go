@@ -0,0 +1,257 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type webRTCPeerFlow struct {
+	event         string
+	scenario      string
+	runsDir       string
+	nodes         []string
+	signalDirs    []string
+	statsFiles    []string
+	rawStatsFiles []string
+	signaling     string
+	signalURL     string
+	ice           WebRTCICEOptions
+	procs         func(run webRTCPeerRun) []webRTCPeerProcess
+}
+
+type webRTCPeerRun struct {
+	runID      string
+	runDir     string
+	executable string
+	signalURL  string
+}
+
+type webRTCPeerFlowResult struct {
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
+}
+
+func runWebRTCPeerFlow(ctx context.Context, flow webRTCPeerFlow, deps webRTCP2PDeps) (*webRTCPeerFlowResult, error) {
+	startedAt := deps.now().UTC()
+	runID, err := deps.newRunID(startedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate run id: %w", err)
+	}
+	runDir := filepath.Join(flow.runsDir, runID)
+	for _, dir := range flow.signalDirs {
+		if err := deps.mkdirAll(filepath.Join(runDir, dir), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create WebRTC run directory %s: %w", runDir, err)
+		}
+	}
+	if err := writeICECredentialFile(runDir, flow.ice); err != nil {
+		return nil, err
+	}
+	latestDir, err := updateLatestRunSymlink(flow.runsDir, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := newEventLogger(flow.runsDir, runID, scenarioRunDeps{
+		now:      deps.now,
+		newRunID: deps.newRunID,
+		mkdirAll: deps.mkdirAll,
+		openFile: deps.openFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &webRTCPeerFlowResult{
+		RunID:      runID,
+		RunDir:     runDir,
+		LatestDir:  latestDir,
+		EventsPath: logger.eventsPath,
+		StatsPath:  filepath.Join(runDir, "stats.jsonl"),
+	}
+	statsPaths := make([]string, 0, len(flow.statsFiles))
+	for _, name := range flow.statsFiles {
+		statsPaths = append(statsPaths, filepath.Join(runDir, name))
+	}
+	for _, name := range flow.rawStatsFiles {
+		result.RawStatsPaths = append(result.RawStatsPaths, filepath.Join(runDir, name))
+	}
+
+	record := func(phase string, status string, opErr error) error {
+		return logger.write(EventRecord{
+			RunID:    runID,
+			Event:    flow.event,
+			Scenario: flow.scenario,
+			Phase:    phase,
+			Time:     deps.now().UTC().Format(time.RFC3339Nano),
+			Action:   "run",
+			Status:   status,
+			Error:    errorString(opErr),
+		})
+	}
+
+	var runErr error
+	if err := record("webrtc_start", "ok", nil); err != nil {
+		return result, errors.Join(err, logger.close())
+	}
+
+	executable, err := deps.executable()
+	if err != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("failed to resolve current executable: %w", err))
+	} else if signalURL, stopSignaling, err := startRunSignaling(flow.signaling, flow.signalURL, deps); err != nil {
+		runErr = errors.Join(runErr, err)
+	} else {
+		peerCtx, cancel := context.WithCancel(ctx)
+		wait := startWebRTCPeerCommands(peerCtx, flow.procs(webRTCPeerRun{
+			runID:      runID,
+			runDir:     runDir,
+			executable: executable,
+			signalURL:  signalURL,
+		}), deps, cancel)
+		readyErr := waitForWebRTCPeerReadiness(peerCtx, runDir, flow.nodes, webRTCSignalTimeout)
+		if readyErr != nil {
+			cancel()
+		} else {
+			readyErr = record("connected", "ok", nil)
+			if readyErr != nil {
+				cancel()
+			}
+		}
+		if err := errors.Join(readyErr, wait()); err != nil {
+			runErr = errors.Join(runErr, err)
+		}
+		cancel()
+		if err := stopSignaling(); err != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("failed to stop signaling server: %w", err))
+		}
+	}
+
+	mergeErr := mergeStatsLogs(result.StatsPath, statsPaths)
+	if mergeErr != nil {
+		runErr = errors.Join(runErr, fmt.Errorf("failed to merge peer stats logs: %w", mergeErr))
+	}
+	if err := record("stats_complete", statusForError(mergeErr), mergeErr); err != nil {
+		runErr = errors.Join(runErr, err)
+	}
+	if err := record("cleanup", "ok", nil); err != nil {
+		runErr = errors.Join(runErr, err)
+	}
+	if err := logger.close(); err != nil {
+		runErr = errors.Join(runErr, err)
+	}
+
+	return result, runErr
+}
+
+type webRTCPeerLink struct {
+	peer      string
+	role      string
+	dir       string
+	room      string
+	configure func(pc *webrtc.PeerConnection, done <-chan struct{}) error
+}
+
+func runWebRTCPeerLinks(ctx context.Context, opts WebRTCPeerOptions, links []webRTCPeerLink) error {
+	if len(links) == 0 {
+		return errors.New("at least one peer link is required")
+	}
+
+	sessions := make([]*webRTCPeerSession, len(links))
+	errs := make([]error, len(links))
+	var wg sync.WaitGroup
+	for i, link := range links {
+		i, link := i, link
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, err := opts.ICE.configuration()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			signaler, err := newWebRTCSignaler(ctx, opts.SignalURL, link.room, opts.Node, link.dir)
+			if err == nil {
+				sessions[i], err = openWebRTCPeerSession(ctx, link.role, signaler, config, nil, link.configure)
+			}
+			errs[i] = err
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("peer %s: %w", link.peer, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, session := range sessions {
+			if session != nil {
+				session.close()
+			}
+		}
+	}()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := checkPeerLinksConnected(links, sessions); err != nil {
+		return err
+	}
+	peers := make([]string, 0, len(links))
+	for _, link := range links {
+		peers = append(peers, link.peer)
+	}
+	if err := writePeerConnectedMarker(opts.RunDir, WebRTCPeerOptions{
+		RunID: opts.RunID,
+		Node:  opts.Node,
+		Peer:  strings.Join(peers, ","),
+	}, sessions[0].state); err != nil {
+		return err
+	}
+
+	statsErrs := make([]error, len(links))
+	for i, link := range links {
+		i, link := i, link
+		rawPath := ""
+		if opts.RawStats {
+			rawPath = filepath.Join(opts.RunDir, peerPairRawStatsFilename(opts.Node, link.peer))
+		}
+		linkOpts := opts
+		linkOpts.Role = link.role
+		linkOpts.Peer = link.peer
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statsErrs[i] = runPeerStatsLogs(ctx, sessions[i], filepath.Join(opts.RunDir, peerPairStatsFilename(opts.Node, link.peer)), rawPath, linkOpts)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(statsErrs...)
+}
+
+// checkPeerLinksConnected keeps the connected marker honest: a pair can drop
+// between its own handshake and the others finishing theirs.
+func checkPeerLinksConnected(links []webRTCPeerLink, sessions []*webRTCPeerSession) error {
+	var errs []error
+	for i, link := range links {
+		pcState, _ := sessions[i].state.snapshot()
+		if pcState != webrtc.PeerConnectionStateConnected.String() {
+			errs = append(errs, fmt.Errorf("peer %s: peer connection is %s, not connected", link.peer, pcState))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func peerPairStatsFilename(node string, peer string) string {
+	return "stats." + node + "." + peer + ".jsonl"
+}
+
+func peerPairRawStatsFilename(node string, peer string) string {
+	return "stats.raw." + node + "." + peer + ".jsonl"
+}
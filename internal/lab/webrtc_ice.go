@@ -0,0 +1,133 @@
+package lab
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	WebRTCICETransportPolicyAll   = "all"
+	WebRTCICETransportPolicyRelay = "relay"
+
+	iceCredentialFilename = "ice-credential"
+)
+
+type WebRTCICEOptions struct {
+	Servers    []string
+	Username   string
+	Credential string
+	// CredentialFile holds the credential when Credential is empty, so peer
+	// processes never carry it on their command line.
+	CredentialFile  string
+	TransportPolicy string
+}
+
+func normalizeWebRTCICEOptions(opts WebRTCICEOptions) WebRTCICEOptions {
+	opts.Servers = normalizeNodeList(opts.Servers)
+	opts.Username = strings.TrimSpace(opts.Username)
+	opts.CredentialFile = strings.TrimSpace(opts.CredentialFile)
+	opts.TransportPolicy = strings.ToLower(strings.TrimSpace(opts.TransportPolicy))
+	if opts.TransportPolicy == "" {
+		opts.TransportPolicy = WebRTCICETransportPolicyAll
+	}
+	return opts
+}
+
+func validateWebRTCICEOptions(opts WebRTCICEOptions) error {
+	switch opts.TransportPolicy {
+	case "", WebRTCICETransportPolicyAll, WebRTCICETransportPolicyRelay:
+	default:
+		return fmt.Errorf("unsupported ice transport policy %q: use all or relay", opts.TransportPolicy)
+	}
+	hasTURN := false
+	for _, server := range opts.Servers {
+		uri, err := stun.ParseURI(server)
+		if err != nil {
+			return fmt.Errorf("invalid ice server %q: %w", server, err)
+		}
+		if uri.Scheme == stun.SchemeTypeTURN || uri.Scheme == stun.SchemeTypeTURNS {
+			hasTURN = true
+		}
+	}
+	if hasTURN && (opts.Username == "" || (opts.Credential == "" && opts.CredentialFile == "")) {
+		return errors.New("turn ice servers require ice username and credential")
+	}
+	if opts.TransportPolicy == WebRTCICETransportPolicyRelay && !hasTURN {
+		return errors.New("relay ice transport policy requires a turn ice server")
+	}
+	return nil
+}
+
+func (o WebRTCICEOptions) configuration() (webrtc.Configuration, error) {
+	config := webrtc.Configuration{}
+	if len(o.Servers) > 0 {
+		credential := o.Credential
+		if credential == "" && o.CredentialFile != "" {
+			secret, err := readSecretFile(o.CredentialFile)
+			if err != nil {
+				return config, fmt.Errorf("failed to read ice credential: %w", err)
+			}
+			credential = secret
+		}
+		config.ICEServers = []webrtc.ICEServer{{
+			URLs:       o.Servers,
+			Username:   o.Username,
+			Credential: credential,
+		}}
+	}
+	if o.TransportPolicy == WebRTCICETransportPolicyRelay {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	return config, nil
+}
+
+// writeICECredentialFile stores an inline credential in the run directory
+// where args points the peer processes.
+func writeICECredentialFile(runDir string, o WebRTCICEOptions) error {
+	if o.Credential == "" {
+		return nil
+	}
+	if err := writeFileAtomic(filepath.Join(runDir, iceCredentialFilename), []byte(o.Credential)); err != nil {
+		return fmt.Errorf("failed to write ice credential: %w", err)
+	}
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// args passes the credential by file: an inline one is expected in runDir
+// via writeICECredentialFile.
+func (o WebRTCICEOptions) args(runDir string) []string {
+	var args []string
+	if len(o.Servers) > 0 {
+		args = append(args, "--ice-servers", strings.Join(o.Servers, ","))
+	}
+	if o.Username != "" {
+		args = append(args, "--ice-username", o.Username)
+	}
+	if o.Credential != "" {
+		args = append(args, "--ice-credential-file", filepath.Join(runDir, iceCredentialFilename))
+	} else if o.CredentialFile != "" {
+		args = append(args, "--ice-credential-file", o.CredentialFile)
+	}
+	if o.TransportPolicy == WebRTCICETransportPolicyRelay {
+		args = append(args, "--ice-transport-policy", o.TransportPolicy)
+	}
+	return args
+}
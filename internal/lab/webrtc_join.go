@@ -0,0 +1,144 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	webRTCJoinEventName = "webrtc_join"
+	webRTCJoinPeerName  = "external"
+)
+
+type WebRTCJoinOptions struct {
+	RunsDir       string
+	Node          string
+	Role          string
+	Room          string
+	SignalURL     string
+	Duration      time.Duration
+	StatsInterval time.Duration
+	RawStats      bool
+	ICE           WebRTCICEOptions
+}
+
+type WebRTCJoinResult struct {
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
+}
+
+func RunWebRTCJoin(ctx context.Context, opts WebRTCJoinOptions) (*WebRTCJoinResult, error) {
+	return runWebRTCJoinWithDeps(ctx, opts, defaultWebRTCP2PDeps(ctx))
+}
+
+func runWebRTCJoinWithDeps(ctx context.Context, opts WebRTCJoinOptions, deps webRTCP2PDeps) (*WebRTCJoinResult, error) {
+	opts = normalizeWebRTCJoinOptions(opts)
+	deps = fillWebRTCP2PDeps(ctx, deps)
+	if err := validateWebRTCJoinOptions(opts); err != nil {
+		return nil, err
+	}
+	if err := validateWebRTCNodes(ctx, deps.createDeps, "lab webrtc join", []string{opts.Node}); err != nil {
+		return nil, err
+	}
+
+	flow := webRTCPeerFlow{
+		event:      webRTCJoinEventName,
+		scenario:   "webrtc-join",
+		runsDir:    opts.RunsDir,
+		nodes:      []string{opts.Node},
+		signalDirs: []string{"signal"},
+		statsFiles: []string{peerStatsFilename(opts.Node)},
+		signaling:  WebRTCSignalingWS,
+		signalURL:  opts.SignalURL,
+		ice:        opts.ICE,
+		procs: func(run webRTCPeerRun) []webRTCPeerProcess {
+			return []webRTCPeerProcess{{
+				node:  opts.Node,
+				label: opts.Role,
+				args: webRTCPeerNetNSArgs(opts.Node, run.executable, WebRTCPeerOptions{
+					Role:          opts.Role,
+					RunID:         run.runID,
+					RunDir:        run.runDir,
+					Node:          opts.Node,
+					Peer:          webRTCJoinPeerName,
+					Duration:      opts.Duration,
+					StatsInterval: opts.StatsInterval,
+					RawStats:      opts.RawStats,
+					SignalURL:     run.signalURL,
+					SignalRoom:    opts.Room,
+					ICE:           opts.ICE,
+				}),
+			}}
+		},
+	}
+	if opts.RawStats {
+		flow.rawStatsFiles = []string{peerRawStatsFilename(opts.Node)}
+	}
+
+	result, err := runWebRTCPeerFlow(ctx, flow, deps)
+	if result == nil {
+		return nil, err
+	}
+	return &WebRTCJoinResult{
+		RunID:         result.RunID,
+		RunDir:        result.RunDir,
+		LatestDir:     result.LatestDir,
+		EventsPath:    result.EventsPath,
+		StatsPath:     result.StatsPath,
+		RawStatsPaths: result.RawStatsPaths,
+	}, err
+}
+
+func normalizeWebRTCJoinOptions(opts WebRTCJoinOptions) WebRTCJoinOptions {
+	opts.RunsDir = strings.TrimSpace(opts.RunsDir)
+	opts.Node = strings.TrimSpace(opts.Node)
+	opts.Role = strings.TrimSpace(opts.Role)
+	opts.Room = strings.Trim(strings.TrimSpace(opts.Room), "/")
+	opts.SignalURL = strings.TrimSpace(opts.SignalURL)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
+	if opts.RunsDir == "" {
+		opts.RunsDir = defaultRunsDir
+	}
+	if opts.Node == "" {
+		opts.Node = defaultWebRTCNodeA
+	}
+	if opts.Role == "" {
+		opts.Role = webRTCPeerRoleAnswerer
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultWebRTCDuration
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultWebRTCStatsInterval
+	}
+	return opts
+}
+
+func validateWebRTCJoinOptions(opts WebRTCJoinOptions) error {
+	if opts.Role != webRTCPeerRoleOfferer && opts.Role != webRTCPeerRoleAnswerer {
+		return fmt.Errorf("unsupported webrtc peer role %q", opts.Role)
+	}
+	if opts.Room == "" {
+		return errors.New("room is required")
+	}
+	if opts.SignalURL == "" {
+		return errors.New("signal url is required")
+	}
+	if err := validateSignalURL(opts.SignalURL); err != nil {
+		return err
+	}
+	if opts.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if opts.StatsInterval <= 0 {
+		return errors.New("stats interval must be positive")
+	}
+	return validateWebRTCICEOptions(opts.ICE)
+}
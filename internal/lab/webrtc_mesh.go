@@ -0,0 +1,299 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const webRTCMeshEventName = "webrtc_mesh"
+
+var defaultWebRTCMeshNodes = []string{"node1", "node2", "node3"}
+
+type WebRTCMeshOptions struct {
+	RunsDir       string
+	Nodes         []string
+	Duration      time.Duration
+	StatsInterval time.Duration
+	RawStats      bool
+	Signaling     string
+	SignalURL     string
+	ICE           WebRTCICEOptions
+}
+
+type WebRTCMeshResult struct {
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
+}
+
+type WebRTCMeshPeerOptions struct {
+	RunID         string
+	RunDir        string
+	Node          string
+	Nodes         []string
+	Duration      time.Duration
+	StatsInterval time.Duration
+	RawStats      bool
+	SignalURL     string
+	ICE           WebRTCICEOptions
+}
+
+func RunWebRTCMesh(ctx context.Context, opts WebRTCMeshOptions) (*WebRTCMeshResult, error) {
+	return runWebRTCMeshWithDeps(ctx, opts, defaultWebRTCP2PDeps(ctx))
+}
+
+func runWebRTCMeshWithDeps(ctx context.Context, opts WebRTCMeshOptions, deps webRTCP2PDeps) (*WebRTCMeshResult, error) {
+	opts = normalizeWebRTCMeshOptions(opts)
+	deps = fillWebRTCP2PDeps(ctx, deps)
+	if err := validateWebRTCMeshOptions(opts); err != nil {
+		return nil, err
+	}
+	if err := validateWebRTCNodes(ctx, deps.createDeps, "lab webrtc mesh", opts.Nodes); err != nil {
+		return nil, err
+	}
+
+	flow := webRTCPeerFlow{
+		event:     webRTCMeshEventName,
+		scenario:  "webrtc-mesh",
+		runsDir:   opts.RunsDir,
+		nodes:     opts.Nodes,
+		signaling: opts.Signaling,
+		signalURL: opts.SignalURL,
+		ice:       opts.ICE,
+		procs: func(run webRTCPeerRun) []webRTCPeerProcess {
+			procs := make([]webRTCPeerProcess, 0, len(opts.Nodes))
+			for _, node := range opts.Nodes {
+				procs = append(procs, webRTCPeerProcess{
+					node:  node,
+					label: "mesh",
+					args: webRTCMeshPeerNetNSArgs(node, run.executable, WebRTCMeshPeerOptions{
+						RunID:         run.runID,
+						RunDir:        run.runDir,
+						Node:          node,
+						Nodes:         opts.Nodes,
+						Duration:      opts.Duration,
+						StatsInterval: opts.StatsInterval,
+						RawStats:      opts.RawStats,
+						SignalURL:     run.signalURL,
+						ICE:           opts.ICE,
+					}),
+				})
+			}
+			return procs
+		},
+	}
+	for _, pair := range meshPairs(opts.Nodes) {
+		flow.signalDirs = append(flow.signalDirs, pairSignalSubdir(pair[0], pair[1]))
+	}
+	for _, node := range opts.Nodes {
+		for _, peer := range opts.Nodes {
+			if node == peer {
+				continue
+			}
+			flow.statsFiles = append(flow.statsFiles, peerPairStatsFilename(node, peer))
+			if opts.RawStats {
+				flow.rawStatsFiles = append(flow.rawStatsFiles, peerPairRawStatsFilename(node, peer))
+			}
+		}
+	}
+
+	result, err := runWebRTCPeerFlow(ctx, flow, deps)
+	if result == nil {
+		return nil, err
+	}
+	return &WebRTCMeshResult{
+		RunID:         result.RunID,
+		RunDir:        result.RunDir,
+		LatestDir:     result.LatestDir,
+		EventsPath:    result.EventsPath,
+		StatsPath:     result.StatsPath,
+		RawStatsPaths: result.RawStatsPaths,
+	}, err
+}
+
+func normalizeWebRTCMeshOptions(opts WebRTCMeshOptions) WebRTCMeshOptions {
+	opts.RunsDir = strings.TrimSpace(opts.RunsDir)
+	if opts.RunsDir == "" {
+		opts.RunsDir = defaultRunsDir
+	}
+	opts.Nodes = normalizeNodeList(opts.Nodes)
+	if len(opts.Nodes) == 0 {
+		opts.Nodes = append([]string(nil), defaultWebRTCMeshNodes...)
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultWebRTCDuration
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultWebRTCStatsInterval
+	}
+	opts.Signaling, opts.SignalURL = normalizeWebRTCSignaling(opts.Signaling, opts.SignalURL)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
+	return opts
+}
+
+func normalizeNodeList(nodes []string) []string {
+	out := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		node = strings.TrimSpace(node)
+		if node == "" {
+			continue
+		}
+		out = append(out, node)
+	}
+	return out
+}
+
+func validateWebRTCMeshOptions(opts WebRTCMeshOptions) error {
+	if len(opts.Nodes) < 2 {
+		return errors.New("mesh requires at least 2 nodes")
+	}
+	seen := make(map[string]bool, len(opts.Nodes))
+	for _, node := range opts.Nodes {
+		if seen[node] {
+			return fmt.Errorf("node %q is listed more than once", node)
+		}
+		seen[node] = true
+	}
+	if opts.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if opts.StatsInterval <= 0 {
+		return errors.New("stats interval must be positive")
+	}
+	if err := validateWebRTCSignaling(opts.Signaling, opts.SignalURL); err != nil {
+		return err
+	}
+	return validateWebRTCICEOptions(opts.ICE)
+}
+
+func RunWebRTCMeshPeer(ctx context.Context, opts WebRTCMeshPeerOptions) error {
+	opts = normalizeWebRTCMeshPeerOptions(opts)
+	if err := validateWebRTCMeshPeerOptions(opts); err != nil {
+		return err
+	}
+
+	index := indexOfString(opts.Nodes, opts.Node)
+	links := make([]webRTCPeerLink, 0, len(opts.Nodes)-1)
+	for _, peer := range opts.Nodes {
+		if peer == opts.Node {
+			continue
+		}
+		link := webRTCPeerLink{
+			peer: peer,
+			role: webRTCPeerRoleAnswerer,
+			dir:  pairSignalDir(opts.RunDir, peer, opts.Node),
+			room: pairSignalRoom(opts.RunID, peer, opts.Node),
+		}
+		if index < indexOfString(opts.Nodes, peer) {
+			link.role = webRTCPeerRoleOfferer
+			link.dir = pairSignalDir(opts.RunDir, opts.Node, peer)
+			link.room = pairSignalRoom(opts.RunID, opts.Node, peer)
+		}
+		links = append(links, link)
+	}
+
+	return runWebRTCPeerLinks(ctx, WebRTCPeerOptions{
+		RunID:         opts.RunID,
+		RunDir:        opts.RunDir,
+		Node:          opts.Node,
+		Duration:      opts.Duration,
+		StatsInterval: opts.StatsInterval,
+		RawStats:      opts.RawStats,
+		SignalURL:     opts.SignalURL,
+		ICE:           opts.ICE,
+	}, links)
+}
+
+func normalizeWebRTCMeshPeerOptions(opts WebRTCMeshPeerOptions) WebRTCMeshPeerOptions {
+	opts.RunID = strings.TrimSpace(opts.RunID)
+	opts.RunDir = strings.TrimSpace(opts.RunDir)
+	opts.Node = strings.TrimSpace(opts.Node)
+	opts.Nodes = normalizeNodeList(opts.Nodes)
+	opts.SignalURL = strings.TrimSpace(opts.SignalURL)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
+	if opts.Duration <= 0 {
+		opts.Duration = defaultWebRTCDuration
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultWebRTCStatsInterval
+	}
+	return opts
+}
+
+func validateWebRTCMeshPeerOptions(opts WebRTCMeshPeerOptions) error {
+	if opts.RunID == "" {
+		return errors.New("run id is required")
+	}
+	if opts.RunDir == "" {
+		return errors.New("run dir is required")
+	}
+	if opts.Node == "" {
+		return errors.New("node is required")
+	}
+	if !containsString(opts.Nodes, opts.Node) {
+		return fmt.Errorf("node %q is not part of the mesh", opts.Node)
+	}
+	return validateWebRTCMeshOptions(WebRTCMeshOptions{
+		Nodes:         opts.Nodes,
+		Duration:      opts.Duration,
+		StatsInterval: opts.StatsInterval,
+		Signaling:     WebRTCSignalingWS,
+		SignalURL:     opts.SignalURL,
+		ICE:           opts.ICE,
+	})
+}
+
+func webRTCMeshPeerNetNSArgs(node string, executable string, opts WebRTCMeshPeerOptions) []string {
+	args := []string{
+		"netns", "exec", node,
+		executable,
+		"lab", "webrtc", "mesh-peer",
+		"--run-id", opts.RunID,
+		"--run-dir", opts.RunDir,
+		"--node", opts.Node,
+		"--nodes", strings.Join(opts.Nodes, ","),
+		"--duration", opts.Duration.String(),
+		"--stats-interval", opts.StatsInterval.String(),
+	}
+	if opts.RawStats {
+		args = append(args, "--raw-stats")
+	}
+	if opts.SignalURL != "" {
+		args = append(args, "--signal-url", opts.SignalURL)
+	}
+	return append(args, opts.ICE.args(opts.RunDir)...)
+}
+
+func meshPairs(nodes []string) [][2]string {
+	pairs := make([][2]string, 0, len(nodes)*(len(nodes)-1)/2)
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			pairs = append(pairs, [2]string{nodes[i], nodes[j]})
+		}
+	}
+	return pairs
+}
+
+func pairSignalSubdir(offerer string, answerer string) string {
+	return filepath.Join("signal", offerer+"-"+answerer)
+}
+
+func pairSignalDir(runDir string, offerer string, answerer string) string {
+	return filepath.Join(runDir, pairSignalSubdir(offerer, answerer))
+}
+
+func indexOfString(items []string, target string) int {
+	for i, item := range items {
+		if item == target {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,173 @@
+package lab
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebRTCMeshPeerNetNSArgs(t *testing.T) {
+	args := webRTCMeshPeerNetNSArgs("node2", "/tmp/rtc-emulator", WebRTCMeshPeerOptions{
+		RunID:         "run-1",
+		RunDir:        "runs/run-1",
+		Node:          "node2",
+		Nodes:         []string{"node1", "node2", "node3"},
+		Duration:      3 * time.Second,
+		StatsInterval: 500 * time.Millisecond,
+	})
+
+	want := []string{
+		"netns", "exec", "node2",
+		"/tmp/rtc-emulator",
+		"lab", "webrtc", "mesh-peer",
+		"--run-id", "run-1",
+		"--run-dir", "runs/run-1",
+		"--node", "node2",
+		"--nodes", "node1,node2,node3",
+		"--duration", "3s",
+		"--stats-interval", "500ms",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %#v, want %#v", args, want)
+	}
+}
+
+func TestValidateWebRTCMeshOptions(t *testing.T) {
+	valid := WebRTCMeshOptions{
+		Nodes:         []string{"node1", "node2", "node3"},
+		Duration:      time.Second,
+		StatsInterval: time.Second,
+	}
+	if err := validateWebRTCMeshOptions(valid); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	invalid := valid
+	invalid.Nodes = []string{"node1"}
+	if err := validateWebRTCMeshOptions(invalid); err == nil || !strings.Contains(err.Error(), "at least 2 nodes") {
+		t.Fatalf("expected minimum node error, got %v", err)
+	}
+
+	invalid.Nodes = []string{"node1", "node2", "node1"}
+	if err := validateWebRTCMeshOptions(invalid); err == nil || !strings.Contains(err.Error(), "listed more than once") {
+		t.Fatalf("expected duplicate node error, got %v", err)
+	}
+}
+
+func TestRunWebRTCMeshWithDepsStartsPeerPerNodeAndMergesPairStats(t *testing.T) {
+	runsDir := t.TempDir()
+	runID := "run-mesh"
+	runDir := filepath.Join(runsDir, runID)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	deps := webRTCP2PDeps{
+		createDeps: createDeps{
+			exec: &fakeExecutor{
+				outputFn: func(name string, args ...string) (string, error) {
+					if callKey(name, args...) == "ip netns list" {
+						return "node1\nnode2\nnode3\n", nil
+					}
+					return "", nil
+				},
+			},
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
+			loadState: func(context.Context) (*LabState, error) {
+				return &LabState{Nodes: []string{"node1", "node2", "node3"}}, nil
+			},
+		},
+		now:      func() time.Time { now = now.Add(time.Second); return now },
+		newRunID: func(time.Time) (string, error) { return runID, nil },
+		mkdirAll: os.MkdirAll,
+		openFile: func(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+			return os.OpenFile(path, flag, perm)
+		},
+		executable: func() (string, error) { return "/tmp/rtc-emulator", nil },
+		runCommand: func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+			node := argValue(args, "--node")
+			nodes := strings.Split(argValue(args, "--nodes"), ",")
+			if err := writePeerConnectedMarker(runDir, WebRTCPeerOptions{RunID: runID, Node: node}, &webRTCPeerRuntimeState{
+				peerConnection: "connected",
+				iceConnection:  "connected",
+			}); err != nil {
+				return err
+			}
+			for _, peer := range nodes {
+				if peer == node {
+					continue
+				}
+				if err := writeOneStatsRecord(filepath.Join(runDir, peerPairStatsFilename(node, peer)), WebRTCStatsRecord{
+					RunID: runID,
+					Time:  "2026-01-01T00:00:01Z",
+					Node:  node,
+					Peer:  peer,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	result, err := runWebRTCMeshWithDeps(t.Context(), WebRTCMeshOptions{
+		RunsDir:       runsDir,
+		Nodes:         []string{"node1", "node2", "node3"},
+		Duration:      time.Second,
+		StatsInterval: time.Second,
+	}, deps)
+	if err != nil {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+
+	for _, pair := range [][2]string{{"node1", "node2"}, {"node1", "node3"}, {"node2", "node3"}} {
+		dir := pairSignalDir(runDir, pair[0], pair[1])
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Fatalf("expected pair signal directory %s, err=%v", dir, err)
+		}
+	}
+
+	events := readEventRecords(t, result.EventsPath)
+	var phases []string
+	for _, event := range events {
+		phases = append(phases, event.Phase+" "+event.Status)
+	}
+	wantPhases := []string{"webrtc_start ok", "connected ok", "stats_complete ok", "cleanup ok"}
+	if !reflect.DeepEqual(phases, wantPhases) {
+		t.Fatalf("event phases = %#v, want %#v", phases, wantPhases)
+	}
+
+	stats := readStatsRecords(t, result.StatsPath)
+	var pairs []string
+	for _, record := range stats {
+		pairs = append(pairs, record.Node+"->"+record.Peer)
+	}
+	sort.Strings(pairs)
+	wantPairs := []string{"node1->node2", "node1->node3", "node2->node1", "node2->node3", "node3->node1", "node3->node2"}
+	if !reflect.DeepEqual(pairs, wantPairs) {
+		t.Fatalf("merged stats pairs = %#v, want %#v", pairs, wantPairs)
+	}
+}
+
+func TestCheckPeerLinksConnectedRequiresEveryPair(t *testing.T) {
+	links := []webRTCPeerLink{{peer: "node2"}, {peer: "node3"}}
+	sessions := []*webRTCPeerSession{
+		{state: &webRTCPeerRuntimeState{peerConnection: "connected"}},
+		{state: &webRTCPeerRuntimeState{peerConnection: "disconnected"}},
+	}
+	err := checkPeerLinksConnected(links, sessions)
+	if err == nil || !strings.Contains(err.Error(), "peer node3: peer connection is disconnected") {
+		t.Fatalf("expected node3 to block the connected marker, got: %v", err)
+	}
+
+	sessions[1].state.setPeerConnection("connected")
+	if err := checkPeerLinksConnected(links, sessions); err != nil {
+		t.Fatalf("expected all pairs connected, got: %v", err)
+	}
+}
@@ -31,33 +31,39 @@ type WebRTCP2POptions struct {
 	NodeB         string
 	Duration      time.Duration
 	StatsInterval time.Duration
+	RawStats      bool
+	Signaling     string
+	SignalURL     string
+	ICE           WebRTCICEOptions
 }
 
 type WebRTCP2PResult struct {
-	RunID      string
-	RunDir     string
-	LatestDir  string
-	EventsPath string
-	StatsPath  string
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
 }
 
 type webRTCP2PDeps struct {
 	createDeps
-	now        func() time.Time
-	newRunID   func(time.Time) (string, error)
-	mkdirAll   func(string, os.FileMode) error
-	openFile   func(string, int, os.FileMode) (io.WriteCloser, error)
-	executable func() (string, error)
-	runCommand func(context.Context, string, []string, io.Writer, io.Writer) error
+	now               func() time.Time
+	newRunID          func(time.Time) (string, error)
+	mkdirAll          func(string, os.FileMode) error
+	openFile          func(string, int, os.FileMode) (io.WriteCloser, error)
+	executable        func() (string, error)
+	runCommand        func(context.Context, string, []string, io.Writer, io.Writer) error
+	startSignalServer func(string) (*SignalServer, error)
 }
 
 func RunWebRTCP2P(ctx context.Context, opts WebRTCP2POptions) (*WebRTCP2PResult, error) {
-	return runWebRTCP2PWithDeps(ctx, opts, defaultWebRTCP2PDeps())
+	return runWebRTCP2PWithDeps(ctx, opts, defaultWebRTCP2PDeps(ctx))
 }
 
-func defaultWebRTCP2PDeps() webRTCP2PDeps {
+func defaultWebRTCP2PDeps(ctx context.Context) webRTCP2PDeps {
 	return webRTCP2PDeps{
-		createDeps: defaultCreateDeps(),
+		createDeps: defaultCreateDeps(ctx),
 		now:        time.Now,
 		newRunID:   generateRunID,
 		mkdirAll:   os.MkdirAll,
@@ -71,12 +77,13 @@ func defaultWebRTCP2PDeps() webRTCP2PDeps {
 			cmd.Stderr = stderr
 			return cmd.Run()
 		},
+		startSignalServer: StartSignalServer,
 	}
 }
 
 func runWebRTCP2PWithDeps(ctx context.Context, opts WebRTCP2POptions, deps webRTCP2PDeps) (*WebRTCP2PResult, error) {
 	opts = normalizeWebRTCP2POptions(opts)
-	deps = fillWebRTCP2PDeps(deps)
+	deps = fillWebRTCP2PDeps(ctx, deps)
 	if err := validateWebRTCP2POptions(ctx, opts, deps.createDeps); err != nil {
 		return nil, err
 	}
@@ -90,6 +97,9 @@ func runWebRTCP2PWithDeps(ctx context.Context, opts WebRTCP2POptions, deps webRT
 	if err := deps.mkdirAll(filepath.Join(runDir, "signal"), 0o755); err != nil {
 		return nil, fmt.Errorf("failed to create WebRTC run directory %s: %w", runDir, err)
 	}
+	if err := writeICECredentialFile(runDir, opts.ICE); err != nil {
+		return nil, err
+	}
 	latestDir, err := updateLatestRunSymlink(opts.RunsDir, runID)
 	if err != nil {
 		return nil, err
@@ -111,6 +121,12 @@ func runWebRTCP2PWithDeps(ctx context.Context, opts WebRTCP2POptions, deps webRT
 		EventsPath: logger.eventsPath,
 		StatsPath:  filepath.Join(runDir, "stats.jsonl"),
 	}
+	if opts.RawStats {
+		result.RawStatsPaths = []string{
+			filepath.Join(runDir, peerRawStatsFilename(opts.NodeA)),
+			filepath.Join(runDir, peerRawStatsFilename(opts.NodeB)),
+		}
+	}
 
 	record := func(phase string, status string, opErr error) error {
 		return logger.write(EventRecord{
@@ -133,12 +149,18 @@ func runWebRTCP2PWithDeps(ctx context.Context, opts WebRTCP2POptions, deps webRT
 	executable, err := deps.executable()
 	if err != nil {
 		runErr = errors.Join(runErr, fmt.Errorf("failed to resolve current executable: %w", err))
+	} else if signalURL, stopSignaling, err := startRunSignaling(opts.Signaling, opts.SignalURL, deps); err != nil {
+		runErr = errors.Join(runErr, err)
 	} else {
+		opts.SignalURL = signalURL
 		if err := runWebRTCPeerProcesses(ctx, opts, runID, runDir, executable, deps, func() error {
 			return record("connected", "ok", nil)
 		}); err != nil {
 			runErr = errors.Join(runErr, err)
 		}
+		if err := stopSignaling(); err != nil {
+			runErr = errors.Join(runErr, fmt.Errorf("failed to stop signaling server: %w", err))
+		}
 	}
 
 	mergeErr := mergeStatsLogs(result.StatsPath, []string{
@@ -180,12 +202,14 @@ func normalizeWebRTCP2POptions(opts WebRTCP2POptions) WebRTCP2POptions {
 	if opts.StatsInterval <= 0 {
 		opts.StatsInterval = defaultWebRTCStatsInterval
 	}
+	opts.Signaling, opts.SignalURL = normalizeWebRTCSignaling(opts.Signaling, opts.SignalURL)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
 	return opts
 }
 
-func fillWebRTCP2PDeps(deps webRTCP2PDeps) webRTCP2PDeps {
-	deps.createDeps = fillCreateDeps(deps.createDeps)
-	d := defaultWebRTCP2PDeps()
+func fillWebRTCP2PDeps(ctx context.Context, deps webRTCP2PDeps) webRTCP2PDeps {
+	deps.createDeps = fillCreateDeps(ctx, deps.createDeps)
+	d := defaultWebRTCP2PDeps(ctx)
 	if deps.now == nil {
 		deps.now = d.now
 	}
@@ -204,10 +228,21 @@ func fillWebRTCP2PDeps(deps webRTCP2PDeps) webRTCP2PDeps {
 	if deps.runCommand == nil {
 		deps.runCommand = d.runCommand
 	}
+	if deps.startSignalServer == nil {
+		deps.startSignalServer = d.startSignalServer
+	}
 	return deps
 }
 
 func validateWebRTCP2POptions(ctx context.Context, opts WebRTCP2POptions, deps createDeps) error {
+	if err := validateWebRTCP2PSettings(opts); err != nil {
+		return err
+	}
+	return validateWebRTCNodes(ctx, deps, "lab webrtc p2p", []string{opts.NodeA, opts.NodeB})
+}
+
+// validateWebRTCP2PSettings checks options that do not depend on the lab.
+func validateWebRTCP2PSettings(opts WebRTCP2POptions) error {
 	if opts.NodeA == opts.NodeB {
 		return errors.New("node-a and node-b must be different")
 	}
@@ -217,11 +252,18 @@ func validateWebRTCP2POptions(ctx context.Context, opts WebRTCP2POptions, deps c
 	if opts.StatsInterval <= 0 {
 		return errors.New("stats interval must be positive")
 	}
+	if err := validateWebRTCSignaling(opts.Signaling, opts.SignalURL); err != nil {
+		return err
+	}
+	return validateWebRTCICEOptions(opts.ICE)
+}
+
+func validateWebRTCNodes(ctx context.Context, deps createDeps, operation string, nodes []string) error {
 	if deps.goos != "linux" {
-		return fmt.Errorf("lab webrtc p2p is supported only on linux: got %s", deps.goos)
+		return fmt.Errorf("%s is supported only on linux: got %s", operation, deps.goos)
 	}
-	if !deps.isRoot() {
-		return errors.New("lab webrtc p2p requires root privileges")
+	if !deps.hasNetAdmin() {
+		return fmt.Errorf("%s requires CAP_NET_ADMIN: run as root or with --rootless", operation)
 	}
 	if _, err := deps.findPath("ip"); err != nil {
 		return fmt.Errorf("required command %q not found: %w", "ip", err)
@@ -231,12 +273,12 @@ func validateWebRTCP2POptions(ctx context.Context, opts WebRTCP2POptions, deps c
 	}
 	state, err := deps.loadState(ctx)
 	if errors.Is(err, ErrStateNotFound) {
-		return errors.New("lab state not found: run `rtc-emulator lab create --nodes 2` first")
+		return fmt.Errorf("lab state not found: run `rtc-emulator lab create --nodes %d` first", len(nodes))
 	}
 	if err != nil {
 		return fmt.Errorf("failed to load lab state: %w", err)
 	}
-	for _, node := range []string{opts.NodeA, opts.NodeB} {
+	for _, node := range nodes {
 		if !containsString(state.Nodes, node) {
 			return fmt.Errorf("node %q is not managed by current lab", node)
 		}
@@ -245,7 +287,7 @@ func validateWebRTCP2POptions(ctx context.Context, opts WebRTCP2POptions, deps c
 	if err != nil {
 		return err
 	}
-	for _, node := range []string{opts.NodeA, opts.NodeB} {
+	for _, node := range nodes {
 		if !containsString(namespaces, node) {
 			return fmt.Errorf("node %q namespace not found", node)
 		}
@@ -287,37 +329,67 @@ func startWebRTCPeerProcesses(
 	deps webRTCP2PDeps,
 	cancel context.CancelFunc,
 ) func() error {
-	type peerProc struct {
-		node   string
-		role   string
-		peer   string
+	procs := make([]webRTCPeerProcess, 0, 2)
+	for _, p := range []struct {
+		node string
+		role string
+		peer string
+	}{
+		{node: opts.NodeB, role: webRTCPeerRoleAnswerer, peer: opts.NodeA},
+		{node: opts.NodeA, role: webRTCPeerRoleOfferer, peer: opts.NodeB},
+	} {
+		procs = append(procs, webRTCPeerProcess{
+			node:  p.node,
+			label: p.role,
+			args: webRTCPeerNetNSArgs(p.node, executable, WebRTCPeerOptions{
+				Role:          p.role,
+				RunID:         runID,
+				RunDir:        runDir,
+				Node:          p.node,
+				Peer:          p.peer,
+				Duration:      opts.Duration,
+				StatsInterval: opts.StatsInterval,
+				RawStats:      opts.RawStats,
+				SignalURL:     opts.SignalURL,
+				ICE:           opts.ICE,
+			}),
+		})
+	}
+	return startWebRTCPeerCommands(ctx, procs, deps, cancel)
+}
+
+type webRTCPeerProcess struct {
+	node  string
+	label string
+	args  []string
+}
+
+func startWebRTCPeerCommands(
+	ctx context.Context,
+	procs []webRTCPeerProcess,
+	deps webRTCP2PDeps,
+	cancel context.CancelFunc,
+) func() error {
+	type peerRun struct {
+		webRTCPeerProcess
 		err    error
 		stdout bytes.Buffer
 		stderr bytes.Buffer
 	}
 
-	procs := []*peerProc{
-		{node: opts.NodeB, role: webRTCPeerRoleAnswerer, peer: opts.NodeA},
-		{node: opts.NodeA, role: webRTCPeerRoleOfferer, peer: opts.NodeB},
+	runs := make([]*peerRun, 0, len(procs))
+	for _, proc := range procs {
+		runs = append(runs, &peerRun{webRTCPeerProcess: proc})
 	}
 
 	var wg sync.WaitGroup
-	for _, proc := range procs {
-		proc := proc
+	for _, run := range runs {
+		run := run
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			args := webRTCPeerNetNSArgs(proc.node, executable, WebRTCPeerOptions{
-				Role:          proc.role,
-				RunID:         runID,
-				RunDir:        runDir,
-				Node:          proc.node,
-				Peer:          proc.peer,
-				Duration:      opts.Duration,
-				StatsInterval: opts.StatsInterval,
-			})
-			proc.err = deps.runCommand(ctx, "ip", args, &proc.stdout, &proc.stderr)
-			if proc.err != nil {
+			run.err = deps.runCommand(ctx, "ip", run.args, &run.stdout, &run.stderr)
+			if run.err != nil {
 				cancel()
 			}
 		}()
@@ -327,9 +399,9 @@ func startWebRTCPeerProcesses(
 		wg.Wait()
 
 		var runErr error
-		for _, proc := range procs {
-			if proc.err != nil {
-				runErr = errors.Join(runErr, fmt.Errorf("webrtc peer %s/%s failed: %w stdout=%q stderr=%q", proc.node, proc.role, proc.err, proc.stdout.String(), proc.stderr.String()))
+		for _, run := range runs {
+			if run.err != nil {
+				runErr = errors.Join(runErr, fmt.Errorf("webrtc peer %s/%s failed: %w stdout=%q stderr=%q", run.node, run.label, run.err, run.stdout.String(), run.stderr.String()))
 			}
 		}
 		return runErr
@@ -337,7 +409,7 @@ func startWebRTCPeerProcesses(
 }
 
 func webRTCPeerNetNSArgs(node string, executable string, opts WebRTCPeerOptions) []string {
-	return []string{
+	args := []string{
 		"netns", "exec", node,
 		executable,
 		"lab", "webrtc", "peer",
@@ -349,12 +421,26 @@ func webRTCPeerNetNSArgs(node string, executable string, opts WebRTCPeerOptions)
 		"--duration", opts.Duration.String(),
 		"--stats-interval", opts.StatsInterval.String(),
 	}
+	if opts.RawStats {
+		args = append(args, "--raw-stats")
+	}
+	if opts.SignalURL != "" {
+		args = append(args, "--signal-url", opts.SignalURL)
+	}
+	if opts.SignalRoom != "" {
+		args = append(args, "--signal-room", opts.SignalRoom)
+	}
+	return append(args, opts.ICE.args(opts.RunDir)...)
 }
 
 func peerStatsFilename(node string) string {
 	return "stats." + node + ".jsonl"
 }
 
+func peerRawStatsFilename(node string) string {
+	return "stats.raw." + node + ".jsonl"
+}
+
 func updateLatestRunSymlink(runsDir string, runID string) (string, error) {
 	latestPath := filepath.Join(runsDir, latestRunSymlinkName)
 	info, err := os.Lstat(latestPath)
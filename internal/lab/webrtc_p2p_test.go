@@ -3,6 +3,7 @@ package lab
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -41,6 +42,74 @@ func TestWebRTCPeerNetNSArgs(t *testing.T) {
 	}
 }
 
+func TestWebRTCPeerNetNSArgsIncludesRawStatsFlag(t *testing.T) {
+	args := webRTCPeerNetNSArgs("node1", "/tmp/rtc-emulator", WebRTCPeerOptions{
+		Role:          "offerer",
+		RunID:         "run-1",
+		RunDir:        "runs/run-1",
+		Node:          "node1",
+		Peer:          "node2",
+		Duration:      time.Second,
+		StatsInterval: time.Second,
+		RawStats:      true,
+	})
+	if args[len(args)-1] != "--raw-stats" {
+		t.Fatalf("expected trailing --raw-stats flag, got %#v", args)
+	}
+}
+
+func TestWebRTCPeerNetNSArgsIncludesICEOptions(t *testing.T) {
+	args := webRTCPeerNetNSArgs("node1", "/tmp/rtc-emulator", WebRTCPeerOptions{
+		Role:          "offerer",
+		RunID:         "run-1",
+		RunDir:        "runs/run-1",
+		Node:          "node1",
+		Peer:          "node2",
+		Duration:      time.Second,
+		StatsInterval: time.Second,
+		ICE: WebRTCICEOptions{
+			Servers:         []string{"turn:10.200.0.4:3478?transport=udp", "turn:10.200.0.4:3478?transport=tcp"},
+			Username:        "rtcemu",
+			Credential:      "secret",
+			TransportPolicy: WebRTCICETransportPolicyRelay,
+		},
+	})
+
+	want := []string{
+		"--ice-servers", "turn:10.200.0.4:3478?transport=udp,turn:10.200.0.4:3478?transport=tcp",
+		"--ice-username", "rtcemu",
+		"--ice-credential-file", filepath.Join("runs/run-1", "ice-credential"),
+		"--ice-transport-policy", "relay",
+	}
+	if got := args[len(args)-len(want):]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ice args = %#v, want %#v", got, want)
+	}
+	if strings.Contains(strings.Join(args, " "), "secret") {
+		t.Fatalf("expected credential to stay off the command line, got %#v", args)
+	}
+}
+
+func TestICECredentialFileRoundTrip(t *testing.T) {
+	runDir := t.TempDir()
+	ice := WebRTCICEOptions{Servers: []string{"turn:10.200.0.4:3478"}, Username: "rtcemu", Credential: "secret"}
+	if err := writeICECredentialFile(runDir, ice); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(runDir, iceCredentialFilename)
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected 0600 credential file, info=%v err=%v", info, err)
+	}
+
+	config, err := WebRTCICEOptions{Servers: ice.Servers, Username: ice.Username, CredentialFile: path}.configuration()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := config.ICEServers[0].Credential; got != "secret" {
+		t.Fatalf("credential = %v, want secret", got)
+	}
+}
+
 func TestRunWebRTCP2PWithDepsWritesConnectedEventAndMergedStats(t *testing.T) {
 	runsDir := t.TempDir()
 	runID := "run-1"
@@ -59,9 +128,9 @@ func TestRunWebRTCP2PWithDepsWritesConnectedEventAndMergedStats(t *testing.T) {
 					return "", nil
 				},
 			},
-			goos:     "linux",
-			isRoot:   func() bool { return true },
-			findPath: func(string) (string, error) { return "/sbin/ip", nil },
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
 			loadState: func(context.Context) (*LabState, error) {
 				return &LabState{Nodes: []string{"node1", "node2"}}, nil
 			},
@@ -166,6 +235,69 @@ func TestRunWebRTCP2PWithDepsWritesConnectedEventAndMergedStats(t *testing.T) {
 	}
 }
 
+func TestRunWebRTCP2PWithDepsStartsBridgeSignalServerForWebSocketMode(t *testing.T) {
+	runsDir := t.TempDir()
+	runID := "run-ws"
+	runDir := filepath.Join(runsDir, runID)
+	var mu sync.Mutex
+	signalURLs := map[string]string{}
+	var listen string
+	var server *SignalServer
+
+	deps := webRTCP2PDeps{
+		createDeps: createDeps{
+			exec: &fakeExecutor{
+				outputFn: func(name string, args ...string) (string, error) {
+					if callKey(name, args...) == "ip netns list" {
+						return "node1\nnode2\n", nil
+					}
+					return "", nil
+				},
+			},
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
+			loadState: func(context.Context) (*LabState, error) {
+				return &LabState{Nodes: []string{"node1", "node2"}}, nil
+			},
+		},
+		newRunID:   func(time.Time) (string, error) { return runID, nil },
+		executable: func() (string, error) { return "/tmp/rtc-emulator", nil },
+		startSignalServer: func(addr string) (*SignalServer, error) {
+			listen = addr
+			var err error
+			server, err = StartSignalServer("127.0.0.1:0")
+			return server, err
+		},
+		runCommand: func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+			node := argValue(args, "--node")
+			mu.Lock()
+			signalURLs[node] = argValue(args, "--signal-url")
+			mu.Unlock()
+			return writePeerConnectedMarker(runDir, WebRTCPeerOptions{RunID: runID, Node: node}, &webRTCPeerRuntimeState{})
+		},
+	}
+
+	_, err := runWebRTCP2PWithDeps(t.Context(), WebRTCP2POptions{
+		RunsDir:   runsDir,
+		Duration:  time.Second,
+		Signaling: WebRTCSignalingWS,
+	}, deps)
+	if err == nil || !strings.Contains(err.Error(), "failed to merge peer stats logs") {
+		t.Fatalf("expected only missing stats error from fake peers, got %v", err)
+	}
+	if listen != "10.200.0.1:0" {
+		t.Fatalf("signal server listen = %q, want bridge address", listen)
+	}
+	want := map[string]string{"node1": server.URL(), "node2": server.URL()}
+	if !reflect.DeepEqual(signalURLs, want) {
+		t.Fatalf("peer signal urls = %#v, want %#v", signalURLs, want)
+	}
+	if _, err := os.Stat(offerPath(runDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected no offer file in ws mode, stat err=%v", err)
+	}
+}
+
 func TestValidateWebRTCP2POptionsRejectsSameNodeBeforeHostChecks(t *testing.T) {
 	err := validateWebRTCP2POptions(t.Context(), WebRTCP2POptions{
 		NodeA:         "node1",
@@ -259,3 +391,26 @@ func TestValidateWebRTCPeerOptions(t *testing.T) {
 		t.Fatalf("expected missing run dir error, got %v", err)
 	}
 }
+
+func TestValidateWebRTCICEOptions(t *testing.T) {
+	turnServer := []string{"turn:10.200.0.4:3478?transport=tcp"}
+	for _, tc := range []struct {
+		opts WebRTCICEOptions
+		want string
+	}{
+		{opts: WebRTCICEOptions{Servers: []string{"stun:10.200.0.4:3478"}}},
+		{opts: WebRTCICEOptions{Servers: turnServer, Username: "u", Credential: "p", TransportPolicy: "relay"}},
+		{opts: WebRTCICEOptions{TransportPolicy: "host"}, want: "unsupported ice transport policy"},
+		{opts: WebRTCICEOptions{Servers: []string{"http://10.200.0.4"}}, want: "invalid ice server"},
+		{opts: WebRTCICEOptions{Servers: turnServer}, want: "require ice username and credential"},
+		{opts: WebRTCICEOptions{Servers: []string{"stun:10.200.0.4:3478"}, TransportPolicy: "relay"}, want: "requires a turn ice server"},
+	} {
+		err := validateWebRTCICEOptions(normalizeWebRTCICEOptions(tc.opts))
+		if tc.want == "" && err != nil {
+			t.Fatalf("validateWebRTCICEOptions(%+v) unexpected error: %v", tc.opts, err)
+		}
+		if tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Fatalf("validateWebRTCICEOptions(%+v) error = %v, want %q", tc.opts, err, tc.want)
+		}
+	}
+}
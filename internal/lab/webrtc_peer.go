@@ -8,10 +8,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/transport/v4"
+	"github.com/pion/transport/v4/stdnet"
 	"github.com/pion/webrtc/v4"
 )
 
@@ -28,81 +33,434 @@ type WebRTCPeerOptions struct {
 	Peer          string
 	Duration      time.Duration
 	StatsInterval time.Duration
+	RawStats      bool
+	SignalURL     string
+	SignalRoom    string
+	ICE           WebRTCICEOptions
 }
 
 func RunWebRTCPeer(ctx context.Context, opts WebRTCPeerOptions) error {
+	return runWebRTCPeerOnNet(ctx, opts, nil)
+}
+
+// runWebRTCPeerOnNet runs a peer on netw; nil uses the host network stack of
+// the current namespace.
+func runWebRTCPeerOnNet(ctx context.Context, opts WebRTCPeerOptions, netw transport.Net) error {
 	opts = normalizeWebRTCPeerOptions(opts)
 	if err := validateWebRTCPeerOptions(opts); err != nil {
 		return err
 	}
 
-	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	config, err := opts.ICE.configuration()
+	if err != nil {
+		return err
+	}
+	room := opts.SignalRoom
+	if room == "" {
+		room = opts.RunID
+	}
+	signaler, err := newWebRTCSignaler(ctx, opts.SignalURL, room, opts.Node, signalDir(opts.RunDir))
+	if err != nil {
+		return err
+	}
+	session, err := openWebRTCPeerSession(ctx, opts.Role, signaler, config, netw, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %w", err)
+		return err
 	}
-	defer pc.Close()
+	defer session.close()
 
-	done := make(chan struct{})
-	defer close(done)
+	if err := writePeerConnectedMarker(opts.RunDir, opts, session.state); err != nil {
+		return err
+	}
+	controlCtx, stopControl := context.WithCancel(ctx)
+	defer stopControl()
+	go watchPeerControl(controlCtx, session, opts)
 
-	state := &webRTCPeerRuntimeState{
-		peerConnection: webrtc.PeerConnectionStateNew.String(),
-		iceConnection:  webrtc.ICEConnectionStateNew.String(),
+	rawPath := ""
+	if opts.RawStats {
+		rawPath = filepath.Join(opts.RunDir, peerRawStatsFilename(opts.Node))
 	}
+	return runPeerStatsLogs(ctx, session, filepath.Join(opts.RunDir, peerStatsFilename(opts.Node)), rawPath, opts)
+}
+
+type webRTCPeerSession struct {
+	pc       *webrtc.PeerConnection
+	net      transport.Net
+	signaler webRTCSignaler
+	role     string
+	state    *webRTCPeerRuntimeState
+	remote   *remoteCandidateQueue
+	done     chan struct{}
+
+	negotiation sync.Mutex
+	iceMu       sync.Mutex
+	iceWaiters  []chan struct{}
+}
+
+// newWebRTCPeerAPI mirrors webrtc.NewPeerConnection defaults but keeps the
+// network handle so interfaces can be re-read on ICE restart.
+func newWebRTCPeerAPI(netw transport.Net) (*webrtc.API, transport.Net, error) {
+	settings := webrtc.SettingEngine{}
+	if netw != nil {
+		// Virtual networks have no multicast, so mDNS candidates only fail.
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	} else {
+		stdNet, err := stdnet.NewNet()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read network interfaces: %w", err)
+		}
+		netw = stdNet
+	}
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, fmt.Errorf("failed to register codecs: %w", err)
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, registry); err != nil {
+		return nil, nil, fmt.Errorf("failed to register interceptors: %w", err)
+	}
+	settings.SetNet(netw)
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(media),
+		webrtc.WithInterceptorRegistry(registry),
+		webrtc.WithSettingEngine(settings),
+	), netw, nil
+}
+
+func openWebRTCPeerSession(
+	ctx context.Context,
+	role string,
+	signaler webRTCSignaler,
+	config webrtc.Configuration,
+	netw transport.Net,
+	configure func(pc *webrtc.PeerConnection, done <-chan struct{}) error,
+) (*webRTCPeerSession, error) {
+	api, netw, err := newWebRTCPeerAPI(netw)
+	if err != nil {
+		return nil, errors.Join(err, signaler.close())
+	}
+	pc, err := api.NewPeerConnection(config)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to create peer connection: %w", err), signaler.close())
+	}
+	session := &webRTCPeerSession{
+		pc:       pc,
+		net:      netw,
+		signaler: signaler,
+		role:     role,
+		state: &webRTCPeerRuntimeState{
+			peerConnection: webrtc.PeerConnectionStateNew.String(),
+			iceConnection:  webrtc.ICEConnectionStateNew.String(),
+		},
+		remote: &remoteCandidateQueue{pc: pc},
+		done:   make(chan struct{}),
+	}
+
 	connected := make(chan struct{})
 	var connectedOnce sync.Once
 	dataOpen := make(chan struct{})
 	var dataOpenOnce sync.Once
 
 	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
-		state.setPeerConnection(s.String())
+		session.state.setPeerConnection(s.String())
 		if s == webrtc.PeerConnectionStateConnected {
 			connectedOnce.Do(func() { close(connected) })
 		}
 	})
 	pc.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
-		state.setICEConnection(s.String())
+		session.state.setICEConnection(s.String())
+		if s == webrtc.ICEConnectionStateConnected || s == webrtc.ICEConnectionStateCompleted {
+			session.notifyICEConnected()
+		}
 	})
+	if signaler.trickle() {
+		pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+			if c == nil {
+				return
+			}
+			_ = signaler.sendCandidate(ctx, c.ToJSON())
+		})
+		go session.receiveCandidates()
+	}
 
-	if opts.Role == webRTCPeerRoleOfferer {
-		if err := configureOffererDataChannel(pc, dataOpen, &dataOpenOnce, done); err != nil {
-			return err
+	if configure != nil {
+		if err := configure(pc, session.done); err != nil {
+			session.close()
+			return nil, err
 		}
-	} else {
-		configureAnswererDataChannel(pc, dataOpen, &dataOpenOnce)
 	}
-
-	if opts.Role == webRTCPeerRoleOfferer {
-		if err := runOffererSignaling(ctx, pc, opts.RunDir); err != nil {
-			return err
+	if role == webRTCPeerRoleOfferer {
+		if err := configureOffererDataChannel(pc, dataOpen, &dataOpenOnce, session.done); err != nil {
+			session.close()
+			return nil, err
+		}
+		if err := session.negotiate(ctx, nil); err != nil {
+			session.close()
+			return nil, err
 		}
 	} else {
-		if err := runAnswererSignaling(ctx, pc, opts.RunDir); err != nil {
-			return err
+		configureAnswererDataChannel(pc, dataOpen, &dataOpenOnce)
+		if err := session.answer(ctx); err != nil {
+			session.close()
+			return nil, err
 		}
+		go session.answerRenegotiations()
 	}
 
 	if err := waitForSignal(ctx, connected, webRTCSignalTimeout, "peer connection connected"); err != nil {
-		return err
+		session.close()
+		return nil, err
 	}
 	if err := waitForSignal(ctx, dataOpen, webRTCSignalTimeout, "data channel open"); err != nil {
+		session.close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *webRTCPeerSession) close() {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	_ = s.pc.Close()
+	_ = s.signaler.close()
+}
+
+func (s *webRTCPeerSession) negotiate(ctx context.Context, options *webrtc.OfferOptions) error {
+	s.negotiation.Lock()
+	defer s.negotiation.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, webRTCSignalTimeout)
+	defer cancel()
+
+	offer, err := s.pc.CreateOffer(options)
+	if err != nil {
+		return fmt.Errorf("failed to create offer: %w", err)
+	}
+	if err := s.setLocalDescription(ctx, offer); err != nil {
+		return err
+	}
+	answer, err := s.signaler.waitDescription(ctx, webrtc.SDPTypeAnswer)
+	if err != nil {
 		return err
 	}
-	if err := writePeerConnectedMarker(opts.RunDir, opts, state); err != nil {
+	if err := s.pc.SetRemoteDescription(*answer); err != nil {
+		return fmt.Errorf("failed to set remote answer: %w", err)
+	}
+	return s.remote.markReady()
+}
+
+func (s *webRTCPeerSession) answer(ctx context.Context) error {
+	waitCtx, cancel := context.WithTimeout(ctx, webRTCSignalTimeout)
+	defer cancel()
+	offer, err := s.signaler.waitDescription(waitCtx, webrtc.SDPTypeOffer)
+	if err != nil {
 		return err
 	}
+	return s.answerOffer(waitCtx, offer)
+}
 
-	statsPath := filepath.Join(opts.RunDir, peerStatsFilename(opts.Node))
+func (s *webRTCPeerSession) answerOffer(ctx context.Context, offer *webrtc.SessionDescription) error {
+	s.negotiation.Lock()
+	defer s.negotiation.Unlock()
+	if err := s.pc.SetRemoteDescription(*offer); err != nil {
+		return fmt.Errorf("failed to set remote offer: %w", err)
+	}
+	if err := s.remote.markReady(); err != nil {
+		return err
+	}
+	answer, err := s.pc.CreateAnswer(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create answer: %w", err)
+	}
+	return s.setLocalDescription(ctx, answer)
+}
+
+func (s *webRTCPeerSession) setLocalDescription(ctx context.Context, desc webrtc.SessionDescription) error {
+	gatherComplete := webrtc.GatheringCompletePromise(s.pc)
+	if err := s.pc.SetLocalDescription(desc); err != nil {
+		return fmt.Errorf("failed to set local %s: %w", desc.Type, err)
+	}
+	if !s.signaler.trickle() {
+		if err := waitForGathering(ctx, gatherComplete, webRTCSignalTimeout, "local "+desc.Type.String()+" candidates"); err != nil {
+			return err
+		}
+	}
+	return s.signaler.sendDescription(ctx, s.pc.LocalDescription())
+}
+
+func (s *webRTCPeerSession) answerRenegotiations() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		offer, err := s.signaler.waitDescription(ctx, webrtc.SDPTypeOffer)
+		if err != nil {
+			return
+		}
+		answerCtx, answerCancel := context.WithTimeout(ctx, webRTCSignalTimeout)
+		err = s.answerOffer(answerCtx, offer)
+		answerCancel()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *webRTCPeerSession) restartICE(ctx context.Context) (time.Duration, error) {
+	if s.role != webRTCPeerRoleOfferer {
+		return 0, errors.New("ice restart must be triggered on the offerer")
+	}
+	// The address may have changed since the last gather (handover), so
+	// refresh the interface list before gathering new candidates.
+	if updater, ok := s.net.(interface{ UpdateInterfaces() error }); ok {
+		if err := updater.UpdateInterfaces(); err != nil {
+			return 0, fmt.Errorf("failed to refresh network interfaces: %w", err)
+		}
+	}
+	reconnected := s.waitICEConnected()
+	startedAt := time.Now()
+	if err := s.negotiate(ctx, &webrtc.OfferOptions{ICERestart: true}); err != nil {
+		return 0, fmt.Errorf("ice restart negotiation failed: %w", err)
+	}
+	if err := waitForSignal(ctx, reconnected, webRTCSignalTimeout, "ice reconnect"); err != nil {
+		return 0, err
+	}
+	return time.Since(startedAt), nil
+}
+
+func (s *webRTCPeerSession) waitICEConnected() <-chan struct{} {
+	s.iceMu.Lock()
+	defer s.iceMu.Unlock()
+	ch := make(chan struct{})
+	s.iceWaiters = append(s.iceWaiters, ch)
+	return ch
+}
+
+func (s *webRTCPeerSession) notifyICEConnected() {
+	s.iceMu.Lock()
+	defer s.iceMu.Unlock()
+	for _, ch := range s.iceWaiters {
+		close(ch)
+	}
+	s.iceWaiters = nil
+}
+
+func (s *webRTCPeerSession) receiveCandidates() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case candidate, ok := <-s.signaler.candidates():
+			if !ok {
+				return
+			}
+			_ = s.remote.add(candidate)
+		}
+	}
+}
+
+type remoteCandidateQueue struct {
+	mu      sync.Mutex
+	pc      *webrtc.PeerConnection
+	ufrag   string
+	ready   bool
+	pending []webrtc.ICECandidateInit
+}
+
+func (q *remoteCandidateQueue) add(candidate webrtc.ICECandidateInit) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.ready || !q.matches(candidate) {
+		q.pending = append(q.pending, candidate)
+		return nil
+	}
+	return q.pc.AddICECandidate(candidate)
+}
+
+func (q *remoteCandidateQueue) markReady() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ready = true
+	q.ufrag = ""
+	if remote := q.pc.RemoteDescription(); remote != nil {
+		q.ufrag = iceUfrag(remote.SDP)
+	}
+
+	var err error
+	pending := q.pending[:0]
+	for _, candidate := range q.pending {
+		if !q.matches(candidate) {
+			pending = append(pending, candidate)
+			continue
+		}
+		if addErr := q.pc.AddICECandidate(candidate); addErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to add remote candidate: %w", addErr))
+		}
+	}
+	q.pending = pending
+	return err
+}
+
+func (q *remoteCandidateQueue) matches(candidate webrtc.ICECandidateInit) bool {
+	ufrag := candidateUfrag(candidate)
+	return ufrag == "" || q.ufrag == "" || ufrag == q.ufrag
+}
+
+func candidateUfrag(candidate webrtc.ICECandidateInit) string {
+	if candidate.UsernameFragment != nil {
+		return *candidate.UsernameFragment
+	}
+	fields := strings.Fields(candidate.Candidate)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "ufrag" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+func iceUfrag(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if ufrag, ok := strings.CutPrefix(strings.TrimSpace(line), "a=ice-ufrag:"); ok {
+			return ufrag
+		}
+	}
+	return ""
+}
+
+func runPeerStatsLogs(ctx context.Context, session *webRTCPeerSession, statsPath string, rawPath string, opts WebRTCPeerOptions) error {
 	logger, err := newStatsLogger(statsPath, func(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
 		return os.OpenFile(path, flag, perm)
 	})
 	if err != nil {
 		return err
 	}
-	err = writePeerStats(ctx, pc, state, logger, opts)
+	var rawLogger *rawStatsLogger
+	if rawPath != "" {
+		rawLogger, err = newRawStatsLogger(rawPath, func(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+			return os.OpenFile(path, flag, perm)
+		})
+		if err != nil {
+			return errors.Join(err, logger.close())
+		}
+	}
+	err = writePeerStats(ctx, session.pc, session.state, logger, rawLogger, opts)
 	if closeErr := logger.close(); closeErr != nil && err == nil {
 		err = closeErr
 	}
+	if rawLogger != nil {
+		if closeErr := rawLogger.close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
 	return err
 }
 
@@ -136,6 +494,9 @@ func normalizeWebRTCPeerOptions(opts WebRTCPeerOptions) WebRTCPeerOptions {
 	opts.RunDir = strings.TrimSpace(opts.RunDir)
 	opts.Node = strings.TrimSpace(opts.Node)
 	opts.Peer = strings.TrimSpace(opts.Peer)
+	opts.SignalURL = strings.TrimSpace(opts.SignalURL)
+	opts.SignalRoom = strings.TrimSpace(opts.SignalRoom)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
 	if opts.Duration <= 0 {
 		opts.Duration = defaultWebRTCDuration
 	}
@@ -161,7 +522,12 @@ func validateWebRTCPeerOptions(opts WebRTCPeerOptions) error {
 	if opts.Peer == "" {
 		return errors.New("peer is required")
 	}
-	return nil
+	if opts.SignalURL != "" {
+		if err := validateSignalURL(opts.SignalURL); err != nil {
+			return err
+		}
+	}
+	return validateWebRTCICEOptions(opts.ICE)
 }
 
 func configureOffererDataChannel(pc *webrtc.PeerConnection, dataOpen chan struct{}, openOnce *sync.Once, done <-chan struct{}) error {
@@ -205,59 +571,6 @@ func sendSyntheticMessages(channel *webrtc.DataChannel, done <-chan struct{}) {
 	}
 }
 
-func runOffererSignaling(ctx context.Context, pc *webrtc.PeerConnection, runDir string) error {
-	offer, err := pc.CreateOffer(nil)
-	if err != nil {
-		return fmt.Errorf("failed to create offer: %w", err)
-	}
-	gatherComplete := webrtc.GatheringCompletePromise(pc)
-	if err := pc.SetLocalDescription(offer); err != nil {
-		return fmt.Errorf("failed to set local offer: %w", err)
-	}
-	if err := waitForGathering(ctx, gatherComplete, webRTCSignalTimeout, "local offer candidates"); err != nil {
-		return err
-	}
-
-	if err := writeSessionDescription(offerPath(runDir), pc.LocalDescription()); err != nil {
-		return err
-	}
-	answer, err := waitForSessionDescription(ctx, answerPath(runDir), webRTCSignalTimeout)
-	if err != nil {
-		return err
-	}
-	if err := pc.SetRemoteDescription(*answer); err != nil {
-		return fmt.Errorf("failed to set remote answer: %w", err)
-	}
-	return nil
-}
-
-func runAnswererSignaling(ctx context.Context, pc *webrtc.PeerConnection, runDir string) error {
-	offer, err := waitForSessionDescription(ctx, offerPath(runDir), webRTCSignalTimeout)
-	if err != nil {
-		return err
-	}
-	if err := pc.SetRemoteDescription(*offer); err != nil {
-		return fmt.Errorf("failed to set remote offer: %w", err)
-	}
-
-	answer, err := pc.CreateAnswer(nil)
-	if err != nil {
-		return fmt.Errorf("failed to create answer: %w", err)
-	}
-	gatherComplete := webrtc.GatheringCompletePromise(pc)
-	if err := pc.SetLocalDescription(answer); err != nil {
-		return fmt.Errorf("failed to set local answer: %w", err)
-	}
-	if err := waitForGathering(ctx, gatherComplete, webRTCSignalTimeout, "local answer candidates"); err != nil {
-		return err
-	}
-
-	if err := writeSessionDescription(answerPath(runDir), pc.LocalDescription()); err != nil {
-		return err
-	}
-	return nil
-}
-
 type peerConnectedMarker struct {
 	RunID               string `json:"run_id"`
 	Time                string `json:"time"`
@@ -281,14 +594,8 @@ func writePeerConnectedMarker(runDir string, opts WebRTCPeerOptions, state *webR
 	if err != nil {
 		return fmt.Errorf("failed to encode WebRTC connected marker for %s: %w", opts.Node, err)
 	}
-	path := peerConnectedMarkerPath(runDir, opts.Node)
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, 0o600); err != nil {
-		return fmt.Errorf("failed to write WebRTC connected marker %s: %w", tmp, err)
-	}
-	if err := os.Rename(tmp, path); err != nil {
-		_ = os.Remove(tmp)
-		return fmt.Errorf("failed to commit WebRTC connected marker %s: %w", path, err)
+	if err := writeFileAtomic(peerConnectedMarkerPath(runDir, opts.Node), b); err != nil {
+		return fmt.Errorf("failed to write WebRTC connected marker: %w", err)
 	}
 	return nil
 }
@@ -301,20 +608,13 @@ func writeSessionDescription(path string, desc *webrtc.SessionDescription) error
 	if err != nil {
 		return fmt.Errorf("failed to encode session description %s: %w", path, err)
 	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, b, 0o600); err != nil {
-		return fmt.Errorf("failed to write session description %s: %w", tmp, err)
-	}
-	if err := os.Rename(tmp, path); err != nil {
-		_ = os.Remove(tmp)
-		return fmt.Errorf("failed to commit session description %s: %w", path, err)
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("failed to write session description: %w", err)
 	}
 	return nil
 }
 
-func waitForSessionDescription(ctx context.Context, path string, timeout time.Duration) (*webrtc.SessionDescription, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+func waitForSessionDescription(ctx context.Context, path string) (*webrtc.SessionDescription, error) {
 	for {
 		b, err := os.ReadFile(path)
 		if err == nil {
@@ -357,8 +657,31 @@ func waitForGathering(ctx context.Context, ch <-chan struct{}, timeout time.Dura
 	}
 }
 
-func writePeerStats(ctx context.Context, pc *webrtc.PeerConnection, state *webRTCPeerRuntimeState, logger *statsLogger, opts WebRTCPeerOptions) error {
-	if err := logger.write(collectWebRTCStats(pc, state, opts)); err != nil {
+func writePeerStats(
+	ctx context.Context,
+	pc *webrtc.PeerConnection,
+	state *webRTCPeerRuntimeState,
+	logger *statsLogger,
+	rawLogger *rawStatsLogger,
+	opts WebRTCPeerOptions,
+) error {
+	sample := func() error {
+		report := pc.GetStats()
+		record := collectWebRTCStats(report, state, opts)
+		if err := logger.writeSample(record); err != nil {
+			return err
+		}
+		if rawLogger == nil {
+			return nil
+		}
+		raw, err := newRawStatsRecord(record, report)
+		if err != nil {
+			return err
+		}
+		return rawLogger.write(raw)
+	}
+
+	if err := sample(); err != nil {
 		return err
 	}
 	ticker := time.NewTicker(opts.StatsInterval)
@@ -371,16 +694,16 @@ func writePeerStats(ctx context.Context, pc *webrtc.PeerConnection, state *webRT
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-deadline.C:
-			return logger.write(collectWebRTCStats(pc, state, opts))
+			return sample()
 		case <-ticker.C:
-			if err := logger.write(collectWebRTCStats(pc, state, opts)); err != nil {
+			if err := sample(); err != nil {
 				return err
 			}
 		}
 	}
 }
 
-func collectWebRTCStats(pc *webrtc.PeerConnection, state *webRTCPeerRuntimeState, opts WebRTCPeerOptions) WebRTCStatsRecord {
+func collectWebRTCStats(report webrtc.StatsReport, state *webRTCPeerRuntimeState, opts WebRTCPeerOptions) WebRTCStatsRecord {
 	pcState, iceState := state.snapshot()
 	record := WebRTCStatsRecord{
 		RunID:               opts.RunID,
@@ -390,104 +713,127 @@ func collectWebRTCStats(pc *webrtc.PeerConnection, state *webRTCPeerRuntimeState
 		PeerConnectionState: pcState,
 		ICEConnectionState:  iceState,
 	}
+	summarizeWebRTCStats(&record, report)
+	return record
+}
 
-	var bytesSent uint64
-	var haveBytesSent bool
-	var bytesReceived uint64
-	var haveBytesReceived bool
-	var packetsSent uint64
-	var havePacketsSent bool
-	var packetsReceived uint64
-	var havePacketsReceived bool
-	var packetsLost int64
-	var havePacketsLost bool
-	var framesSent uint64
-	var haveFramesSent bool
-	var framesReceived uint64
-	var haveFramesReceived bool
-	var dataMessagesSent uint64
-	var haveDataMessagesSent bool
-	var dataMessagesReceived uint64
-	var haveDataMessagesReceived bool
-
-	for _, raw := range pc.GetStats() {
+func summarizeWebRTCStats(record *WebRTCStatsRecord, report webrtc.StatsReport) {
+	streams := make(map[string]*WebRTCStreamStats)
+	stream := func(direction string, ssrc webrtc.SSRC, kind string) *WebRTCStreamStats {
+		key := fmt.Sprintf("%s/%d", direction, ssrc)
+		s, ok := streams[key]
+		if !ok {
+			s = &WebRTCStreamStats{Direction: direction, SSRC: uint32(ssrc), Kind: kind}
+			streams[key] = s
+		}
+		if s.Kind == "" {
+			s.Kind = kind
+		}
+		return s
+	}
+
+	for _, raw := range report {
 		switch stat := raw.(type) {
 		case webrtc.ICECandidatePairStats:
 			if stat.Nominated {
-				bytesSent += stat.BytesSent
-				haveBytesSent = true
-				bytesReceived += stat.BytesReceived
-				haveBytesReceived = true
-				packetsSent += uint64(stat.PacketsSent)
-				havePacketsSent = true
-				packetsReceived += uint64(stat.PacketsReceived)
-				havePacketsReceived = true
+				addUint64(&record.BytesSent, stat.BytesSent)
+				addUint64(&record.BytesReceived, stat.BytesReceived)
+				addUint64(&record.PacketsSent, uint64(stat.PacketsSent))
+				addUint64(&record.PacketsReceived, uint64(stat.PacketsReceived))
 				if stat.CurrentRoundTripTime > 0 {
 					record.RoundTripTime = float64Ptr(stat.CurrentRoundTripTime)
 				} else if stat.ResponsesReceived > 0 && stat.TotalRoundTripTime > 0 {
 					record.RoundTripTime = float64Ptr(stat.TotalRoundTripTime / float64(stat.ResponsesReceived))
 				}
+				if stat.AvailableOutgoingBitrate > 0 {
+					record.AvailableOutgoingBitrate = float64Ptr(stat.AvailableOutgoingBitrate)
+				}
 			}
 		case webrtc.DataChannelStats:
-			bytesSent += stat.BytesSent
-			haveBytesSent = true
-			bytesReceived += stat.BytesReceived
-			haveBytesReceived = true
-			dataMessagesSent += uint64(stat.MessagesSent)
-			haveDataMessagesSent = true
-			dataMessagesReceived += uint64(stat.MessagesReceived)
-			haveDataMessagesReceived = true
+			addUint64(&record.BytesSent, stat.BytesSent)
+			addUint64(&record.BytesReceived, stat.BytesReceived)
+			addUint64(&record.DataMessagesSent, uint64(stat.MessagesSent))
+			addUint64(&record.DataMessagesReceived, uint64(stat.MessagesReceived))
 		case webrtc.PeerConnectionStats:
 			record.DataChannelsOpened = uint64Ptr(uint64(stat.DataChannelsOpened))
 			record.DataChannelsClosed = uint64Ptr(uint64(stat.DataChannelsClosed))
 		case webrtc.OutboundRTPStreamStats:
-			bytesSent += stat.BytesSent
-			haveBytesSent = true
-			packetsSent += uint64(stat.PacketsSent)
-			havePacketsSent = true
-			framesSent += uint64(stat.FramesSent)
-			haveFramesSent = true
+			addUint64(&record.BytesSent, stat.BytesSent)
+			addUint64(&record.PacketsSent, uint64(stat.PacketsSent))
+			addUint64(&record.FramesSent, uint64(stat.FramesSent))
+			addUint64(&record.NACKReceived, uint64(stat.NACKCount))
+			addUint64(&record.PLIReceived, uint64(stat.PLICount))
+			addUint64(&record.FIRReceived, uint64(stat.FIRCount))
+			addUint64(&record.RetransmittedPackets, stat.RetransmittedPacketsSent)
+			if stat.TargetBitrate > 0 {
+				addFloat64(&record.TargetBitrate, stat.TargetBitrate)
+			}
+
+			s := stream(webRTCStreamDirectionOutbound, stat.SSRC, stat.Kind)
+			s.Bytes = uint64Ptr(stat.BytesSent)
+			s.Packets = uint64Ptr(uint64(stat.PacketsSent))
+			s.Frames = uint64Ptr(uint64(stat.FramesSent))
+			s.NACKCount = uint64Ptr(uint64(stat.NACKCount))
+			s.PLICount = uint64Ptr(uint64(stat.PLICount))
+			s.FIRCount = uint64Ptr(uint64(stat.FIRCount))
+			s.RetransmittedPackets = uint64Ptr(stat.RetransmittedPacketsSent)
+			if stat.TargetBitrate > 0 {
+				s.TargetBitrate = float64Ptr(stat.TargetBitrate)
+			}
 		case webrtc.InboundRTPStreamStats:
-			bytesReceived += stat.BytesReceived
-			haveBytesReceived = true
-			packetsReceived += uint64(stat.PacketsReceived)
-			havePacketsReceived = true
-			packetsLost += int64(stat.PacketsLost)
-			havePacketsLost = true
+			addUint64(&record.BytesReceived, stat.BytesReceived)
+			addUint64(&record.PacketsReceived, uint64(stat.PacketsReceived))
+			addInt64(&record.PacketsLost, int64(stat.PacketsLost))
 			if stat.Jitter > 0 {
 				record.Jitter = float64Ptr(stat.Jitter)
 			}
-			framesReceived += uint64(stat.FramesReceived)
-			haveFramesReceived = true
+			addUint64(&record.FramesReceived, uint64(stat.FramesReceived))
+			addUint64(&record.FramesDecoded, uint64(stat.FramesDecoded))
+			addUint64(&record.FramesDropped, uint64(stat.FramesDropped))
+			addUint64(&record.FreezeCount, uint64(stat.FreezeCount))
+			addFloat64(&record.TotalFreezesDuration, stat.TotalFreezesDuration)
+			addUint64(&record.NACKSent, uint64(stat.NACKCount))
+			addUint64(&record.PLISent, uint64(stat.PLICount))
+			addUint64(&record.FIRSent, uint64(stat.FIRCount))
+
+			s := stream(webRTCStreamDirectionInbound, stat.SSRC, stat.Kind)
+			s.Bytes = uint64Ptr(stat.BytesReceived)
+			s.Packets = uint64Ptr(uint64(stat.PacketsReceived))
+			s.PacketsLost = int64Ptr(int64(stat.PacketsLost))
+			s.Jitter = float64Ptr(stat.Jitter)
+			s.Frames = uint64Ptr(uint64(stat.FramesReceived))
+			s.FramesDecoded = uint64Ptr(uint64(stat.FramesDecoded))
+			s.FramesDropped = uint64Ptr(uint64(stat.FramesDropped))
+			s.FreezeCount = uint64Ptr(uint64(stat.FreezeCount))
+			s.NACKCount = uint64Ptr(uint64(stat.NACKCount))
+			s.PLICount = uint64Ptr(uint64(stat.PLICount))
+			s.FIRCount = uint64Ptr(uint64(stat.FIRCount))
+		case webrtc.RemoteInboundRTPStreamStats:
+			if stat.RoundTripTime > 0 {
+				maxFloat64(&record.RemoteRoundTripTime, stat.RoundTripTime)
+			}
+			maxFloat64(&record.RemoteFractionLost, stat.FractionLost)
+
+			s := stream(webRTCStreamDirectionOutbound, stat.SSRC, stat.Kind)
+			if stat.RoundTripTime > 0 {
+				s.RemoteRoundTripTime = float64Ptr(stat.RoundTripTime)
+			}
+			s.RemoteFractionLost = float64Ptr(stat.FractionLost)
+			s.RemotePacketsLost = int64Ptr(int64(stat.PacketsLost))
 		}
 	}
 
-	if haveBytesSent {
-		record.BytesSent = uint64Ptr(bytesSent)
-	}
-	if haveBytesReceived {
-		record.BytesReceived = uint64Ptr(bytesReceived)
-	}
-	if havePacketsSent {
-		record.PacketsSent = uint64Ptr(packetsSent)
-	}
-	if havePacketsReceived {
-		record.PacketsReceived = uint64Ptr(packetsReceived)
-	}
-	if havePacketsLost {
-		record.PacketsLost = int64Ptr(packetsLost)
+	if len(streams) == 0 {
+		return
 	}
-	if haveFramesSent {
-		record.FramesSent = uint64Ptr(framesSent)
+	record.Streams = make([]WebRTCStreamStats, 0, len(streams))
+	for _, s := range streams {
+		record.Streams = append(record.Streams, *s)
 	}
-	if haveFramesReceived {
-		record.FramesReceived = uint64Ptr(framesReceived)
-	}
-	if haveDataMessagesSent {
-		record.DataMessagesSent = uint64Ptr(dataMessagesSent)
-	}
-	if haveDataMessagesReceived {
-		record.DataMessagesReceived = uint64Ptr(dataMessagesReceived)
-	}
-	return record
+	sort.Slice(record.Streams, func(i, j int) bool {
+		if record.Streams[i].Direction != record.Streams[j].Direction {
+			return record.Streams[i].Direction < record.Streams[j].Direction
+		}
+		return record.Streams[i].SSRC < record.Streams[j].SSRC
+	})
 }
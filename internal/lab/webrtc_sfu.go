@@ -0,0 +1,433 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	webRTCSFUEventName = "webrtc_sfu"
+
+	webRTCSFURoleSFU        = "sfu"
+	webRTCSFURolePublisher  = "publisher"
+	webRTCSFURoleSubscriber = "subscriber"
+
+	defaultWebRTCSFUNode = "node3"
+
+	syntheticVideoFrameInterval = time.Second / 30
+	syntheticVideoFrameBytes    = 1000
+	syntheticVideoTimestampStep = 90000 / 30
+)
+
+var (
+	defaultWebRTCSFUPublishers  = []string{"node1"}
+	defaultWebRTCSFUSubscribers = []string{"node2"}
+)
+
+type WebRTCSFUOptions struct {
+	RunsDir       string
+	SFUNode       string
+	Publishers    []string
+	Subscribers   []string
+	Duration      time.Duration
+	StatsInterval time.Duration
+	RawStats      bool
+	Signaling     string
+	SignalURL     string
+	ICE           WebRTCICEOptions
+}
+
+type WebRTCSFUResult struct {
+	RunID         string
+	RunDir        string
+	LatestDir     string
+	EventsPath    string
+	StatsPath     string
+	RawStatsPaths []string
+}
+
+type WebRTCSFUPeerOptions struct {
+	Role          string
+	RunID         string
+	RunDir        string
+	Node          string
+	SFUNode       string
+	Publishers    []string
+	Subscribers   []string
+	Duration      time.Duration
+	StatsInterval time.Duration
+	RawStats      bool
+	SignalURL     string
+	ICE           WebRTCICEOptions
+}
+
+func RunWebRTCSFU(ctx context.Context, opts WebRTCSFUOptions) (*WebRTCSFUResult, error) {
+	return runWebRTCSFUWithDeps(ctx, opts, defaultWebRTCP2PDeps(ctx))
+}
+
+func runWebRTCSFUWithDeps(ctx context.Context, opts WebRTCSFUOptions, deps webRTCP2PDeps) (*WebRTCSFUResult, error) {
+	opts = normalizeWebRTCSFUOptions(opts)
+	deps = fillWebRTCP2PDeps(ctx, deps)
+	if err := validateWebRTCSFUOptions(opts); err != nil {
+		return nil, err
+	}
+	nodes := append([]string{opts.SFUNode}, opts.Publishers...)
+	nodes = append(nodes, opts.Subscribers...)
+	if err := validateWebRTCNodes(ctx, deps.createDeps, "lab webrtc sfu", nodes); err != nil {
+		return nil, err
+	}
+
+	flow := webRTCPeerFlow{
+		event:     webRTCSFUEventName,
+		scenario:  "webrtc-sfu",
+		runsDir:   opts.RunsDir,
+		nodes:     nodes,
+		signaling: opts.Signaling,
+		signalURL: opts.SignalURL,
+		ice:       opts.ICE,
+		procs: func(run webRTCPeerRun) []webRTCPeerProcess {
+			procs := make([]webRTCPeerProcess, 0, len(nodes))
+			for _, node := range nodes {
+				role := webRTCSFURoleForNode(opts, node)
+				procs = append(procs, webRTCPeerProcess{
+					node:  node,
+					label: role,
+					args: webRTCSFUPeerNetNSArgs(node, run.executable, WebRTCSFUPeerOptions{
+						Role:          role,
+						RunID:         run.runID,
+						RunDir:        run.runDir,
+						Node:          node,
+						SFUNode:       opts.SFUNode,
+						Publishers:    opts.Publishers,
+						Subscribers:   opts.Subscribers,
+						Duration:      opts.Duration,
+						StatsInterval: opts.StatsInterval,
+						RawStats:      opts.RawStats,
+						SignalURL:     run.signalURL,
+						ICE:           opts.ICE,
+					}),
+				})
+			}
+			return procs
+		},
+	}
+	addPair := func(offerer string, answerer string) {
+		flow.signalDirs = append(flow.signalDirs, pairSignalSubdir(offerer, answerer))
+		for _, pair := range [][2]string{{offerer, answerer}, {answerer, offerer}} {
+			flow.statsFiles = append(flow.statsFiles, peerPairStatsFilename(pair[0], pair[1]))
+			if opts.RawStats {
+				flow.rawStatsFiles = append(flow.rawStatsFiles, peerPairRawStatsFilename(pair[0], pair[1]))
+			}
+		}
+	}
+	for _, publisher := range opts.Publishers {
+		addPair(publisher, opts.SFUNode)
+	}
+	for _, subscriber := range opts.Subscribers {
+		addPair(opts.SFUNode, subscriber)
+	}
+
+	result, err := runWebRTCPeerFlow(ctx, flow, deps)
+	if result == nil {
+		return nil, err
+	}
+	return &WebRTCSFUResult{
+		RunID:         result.RunID,
+		RunDir:        result.RunDir,
+		LatestDir:     result.LatestDir,
+		EventsPath:    result.EventsPath,
+		StatsPath:     result.StatsPath,
+		RawStatsPaths: result.RawStatsPaths,
+	}, err
+}
+
+func normalizeWebRTCSFUOptions(opts WebRTCSFUOptions) WebRTCSFUOptions {
+	opts.RunsDir = strings.TrimSpace(opts.RunsDir)
+	opts.SFUNode = strings.TrimSpace(opts.SFUNode)
+	opts.Publishers = normalizeNodeList(opts.Publishers)
+	opts.Subscribers = normalizeNodeList(opts.Subscribers)
+	if opts.RunsDir == "" {
+		opts.RunsDir = defaultRunsDir
+	}
+	if opts.SFUNode == "" {
+		opts.SFUNode = defaultWebRTCSFUNode
+	}
+	if len(opts.Publishers) == 0 {
+		opts.Publishers = append([]string(nil), defaultWebRTCSFUPublishers...)
+	}
+	if len(opts.Subscribers) == 0 {
+		opts.Subscribers = append([]string(nil), defaultWebRTCSFUSubscribers...)
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = defaultWebRTCDuration
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = defaultWebRTCStatsInterval
+	}
+	opts.Signaling, opts.SignalURL = normalizeWebRTCSignaling(opts.Signaling, opts.SignalURL)
+	opts.ICE = normalizeWebRTCICEOptions(opts.ICE)
+	return opts
+}
+
+func validateWebRTCSFUOptions(opts WebRTCSFUOptions) error {
+	if opts.SFUNode == "" {
+		return errors.New("sfu node is required")
+	}
+	if len(opts.Publishers) == 0 {
+		return errors.New("at least one publisher is required")
+	}
+	if len(opts.Subscribers) == 0 {
+		return errors.New("at least one subscriber is required")
+	}
+	seen := map[string]string{opts.SFUNode: webRTCSFURoleSFU}
+	for _, group := range []struct {
+		role  string
+		nodes []string
+	}{
+		{role: webRTCSFURolePublisher, nodes: opts.Publishers},
+		{role: webRTCSFURoleSubscriber, nodes: opts.Subscribers},
+	} {
+		for _, node := range group.nodes {
+			if prev, ok := seen[node]; ok {
+				return fmt.Errorf("node %q cannot be both %s and %s", node, prev, group.role)
+			}
+			seen[node] = group.role
+		}
+	}
+	if opts.Duration <= 0 {
+		return errors.New("duration must be positive")
+	}
+	if opts.StatsInterval <= 0 {
+		return errors.New("stats interval must be positive")
+	}
+	if err := validateWebRTCSignaling(opts.Signaling, opts.SignalURL); err != nil {
+		return err
+	}
+	return validateWebRTCICEOptions(opts.ICE)
+}
+
+func webRTCSFURoleForNode(opts WebRTCSFUOptions, node string) string {
+	switch {
+	case node == opts.SFUNode:
+		return webRTCSFURoleSFU
+	case containsString(opts.Publishers, node):
+		return webRTCSFURolePublisher
+	default:
+		return webRTCSFURoleSubscriber
+	}
+}
+
+func webRTCSFUPeerNetNSArgs(node string, executable string, opts WebRTCSFUPeerOptions) []string {
+	args := []string{
+		"netns", "exec", node,
+		executable,
+		"lab", "webrtc", "sfu-peer",
+		"--role", opts.Role,
+		"--run-id", opts.RunID,
+		"--run-dir", opts.RunDir,
+		"--node", opts.Node,
+		"--sfu-node", opts.SFUNode,
+		"--publishers", strings.Join(opts.Publishers, ","),
+		"--subscribers", strings.Join(opts.Subscribers, ","),
+		"--duration", opts.Duration.String(),
+		"--stats-interval", opts.StatsInterval.String(),
+	}
+	if opts.RawStats {
+		args = append(args, "--raw-stats")
+	}
+	if opts.SignalURL != "" {
+		args = append(args, "--signal-url", opts.SignalURL)
+	}
+	return append(args, opts.ICE.args(opts.RunDir)...)
+}
+
+func RunWebRTCSFUPeer(ctx context.Context, opts WebRTCSFUPeerOptions) error {
+	opts.Role = strings.TrimSpace(opts.Role)
+	opts.RunID = strings.TrimSpace(opts.RunID)
+	opts.RunDir = strings.TrimSpace(opts.RunDir)
+	opts.Node = strings.TrimSpace(opts.Node)
+	sfuOpts := normalizeWebRTCSFUOptions(WebRTCSFUOptions{
+		SFUNode:       opts.SFUNode,
+		Publishers:    opts.Publishers,
+		Subscribers:   opts.Subscribers,
+		Duration:      opts.Duration,
+		StatsInterval: opts.StatsInterval,
+		SignalURL:     opts.SignalURL,
+		ICE:           opts.ICE,
+	})
+	if err := validateWebRTCSFUOptions(sfuOpts); err != nil {
+		return err
+	}
+	if opts.RunID == "" {
+		return errors.New("run id is required")
+	}
+	if opts.RunDir == "" {
+		return errors.New("run dir is required")
+	}
+	if opts.Node == "" {
+		return errors.New("node is required")
+	}
+	if want := webRTCSFURoleForNode(sfuOpts, opts.Node); opts.Role != want {
+		return fmt.Errorf("node %q has sfu role %q, got %q", opts.Node, want, opts.Role)
+	}
+
+	var links []webRTCPeerLink
+	switch opts.Role {
+	case webRTCSFURolePublisher:
+		links = []webRTCPeerLink{{
+			peer:      sfuOpts.SFUNode,
+			role:      webRTCPeerRoleOfferer,
+			dir:       pairSignalDir(opts.RunDir, opts.Node, sfuOpts.SFUNode),
+			room:      pairSignalRoom(opts.RunID, opts.Node, sfuOpts.SFUNode),
+			configure: configureSyntheticVideoPublisher(opts.Node),
+		}}
+	case webRTCSFURoleSubscriber:
+		links = []webRTCPeerLink{{
+			peer:      sfuOpts.SFUNode,
+			role:      webRTCPeerRoleAnswerer,
+			dir:       pairSignalDir(opts.RunDir, sfuOpts.SFUNode, opts.Node),
+			room:      pairSignalRoom(opts.RunID, sfuOpts.SFUNode, opts.Node),
+			configure: configureVideoSubscriber,
+		}}
+	case webRTCSFURoleSFU:
+		forwarded := make([]*webrtc.TrackLocalStaticRTP, 0, len(sfuOpts.Publishers))
+		for _, publisher := range sfuOpts.Publishers {
+			track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", publisher)
+			if err != nil {
+				return fmt.Errorf("failed to create forwarding track for %s: %w", publisher, err)
+			}
+			forwarded = append(forwarded, track)
+			links = append(links, webRTCPeerLink{
+				peer:      publisher,
+				role:      webRTCPeerRoleAnswerer,
+				dir:       pairSignalDir(opts.RunDir, publisher, sfuOpts.SFUNode),
+				room:      pairSignalRoom(opts.RunID, publisher, sfuOpts.SFUNode),
+				configure: configureSFUIngest(track),
+			})
+		}
+		for _, subscriber := range sfuOpts.Subscribers {
+			links = append(links, webRTCPeerLink{
+				peer:      subscriber,
+				role:      webRTCPeerRoleOfferer,
+				dir:       pairSignalDir(opts.RunDir, sfuOpts.SFUNode, subscriber),
+				room:      pairSignalRoom(opts.RunID, sfuOpts.SFUNode, subscriber),
+				configure: configureSFUEgress(forwarded),
+			})
+		}
+	}
+
+	return runWebRTCPeerLinks(ctx, WebRTCPeerOptions{
+		RunID:         opts.RunID,
+		RunDir:        opts.RunDir,
+		Node:          opts.Node,
+		Duration:      sfuOpts.Duration,
+		StatsInterval: sfuOpts.StatsInterval,
+		RawStats:      opts.RawStats,
+		SignalURL:     sfuOpts.SignalURL,
+		ICE:           sfuOpts.ICE,
+	}, links)
+}
+
+func configureSyntheticVideoPublisher(streamID string) func(*webrtc.PeerConnection, <-chan struct{}) error {
+	return func(pc *webrtc.PeerConnection, done <-chan struct{}) error {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", streamID)
+		if err != nil {
+			return fmt.Errorf("failed to create synthetic video track: %w", err)
+		}
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			return fmt.Errorf("failed to add synthetic video track: %w", err)
+		}
+		go drainRTCP(sender)
+		go sendSyntheticVideo(track, done)
+		return nil
+	}
+}
+
+func configureVideoSubscriber(pc *webrtc.PeerConnection, _ <-chan struct{}) error {
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		go func() {
+			for {
+				if _, _, err := track.ReadRTP(); err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return nil
+}
+
+func configureSFUIngest(forward *webrtc.TrackLocalStaticRTP) func(*webrtc.PeerConnection, <-chan struct{}) error {
+	return func(pc *webrtc.PeerConnection, _ <-chan struct{}) error {
+		pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			go func() {
+				for {
+					packet, _, err := track.ReadRTP()
+					if err != nil {
+						return
+					}
+					if err := forward.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+						return
+					}
+				}
+			}()
+		})
+		return nil
+	}
+}
+
+func configureSFUEgress(tracks []*webrtc.TrackLocalStaticRTP) func(*webrtc.PeerConnection, <-chan struct{}) error {
+	return func(pc *webrtc.PeerConnection, _ <-chan struct{}) error {
+		for _, track := range tracks {
+			sender, err := pc.AddTrack(track)
+			if err != nil {
+				return fmt.Errorf("failed to add forwarding track %s: %w", track.StreamID(), err)
+			}
+			go drainRTCP(sender)
+		}
+		return nil
+	}
+}
+
+func drainRTCP(sender *webrtc.RTPSender) {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+func sendSyntheticVideo(track *webrtc.TrackLocalStaticRTP, done <-chan struct{}) {
+	ticker := time.NewTicker(syntheticVideoFrameInterval)
+	defer ticker.Stop()
+
+	payload := make([]byte, syntheticVideoFrameBytes)
+	payload[0] = 0x10
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version: 2,
+			Marker:  true,
+		},
+		Payload: payload,
+	}
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			packet.SequenceNumber++
+			packet.Timestamp += syntheticVideoTimestampStep
+			if err := track.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				return
+			}
+		}
+	}
+}
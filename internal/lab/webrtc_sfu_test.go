@@ -0,0 +1,163 @@
+package lab
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidateWebRTCSFUOptionsRejectsOverlappingRoles(t *testing.T) {
+	opts := normalizeWebRTCSFUOptions(WebRTCSFUOptions{
+		SFUNode:     "node3",
+		Publishers:  []string{"node1"},
+		Subscribers: []string{"node1"},
+	})
+	err := validateWebRTCSFUOptions(opts)
+	if err == nil || !strings.Contains(err.Error(), `node "node1" cannot be both publisher and subscriber`) {
+		t.Fatalf("expected overlapping role error, got %v", err)
+	}
+
+	opts.Subscribers = []string{"node3"}
+	err = validateWebRTCSFUOptions(opts)
+	if err == nil || !strings.Contains(err.Error(), `node "node3" cannot be both sfu and subscriber`) {
+		t.Fatalf("expected sfu overlap error, got %v", err)
+	}
+}
+
+func TestWebRTCSFUPeerNetNSArgs(t *testing.T) {
+	args := webRTCSFUPeerNetNSArgs("node1", "/tmp/rtc-emulator", WebRTCSFUPeerOptions{
+		Role:          webRTCSFURolePublisher,
+		RunID:         "run-1",
+		RunDir:        "runs/run-1",
+		Node:          "node1",
+		SFUNode:       "node3",
+		Publishers:    []string{"node1"},
+		Subscribers:   []string{"node2", "node4"},
+		Duration:      time.Second,
+		StatsInterval: time.Second,
+	})
+
+	want := []string{
+		"netns", "exec", "node1",
+		"/tmp/rtc-emulator",
+		"lab", "webrtc", "sfu-peer",
+		"--role", "publisher",
+		"--run-id", "run-1",
+		"--run-dir", "runs/run-1",
+		"--node", "node1",
+		"--sfu-node", "node3",
+		"--publishers", "node1",
+		"--subscribers", "node2,node4",
+		"--duration", "1s",
+		"--stats-interval", "1s",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %#v, want %#v", args, want)
+	}
+}
+
+func TestRunWebRTCSFUWithDepsAssignsRolesAndMergesLegStats(t *testing.T) {
+	runsDir := t.TempDir()
+	runID := "run-sfu"
+	runDir := filepath.Join(runsDir, runID)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	roles := map[string]string{}
+
+	deps := webRTCP2PDeps{
+		createDeps: createDeps{
+			exec: &fakeExecutor{
+				outputFn: func(name string, args ...string) (string, error) {
+					if callKey(name, args...) == "ip netns list" {
+						return "node1\nnode2\nnode3\n", nil
+					}
+					return "", nil
+				},
+			},
+			goos:        "linux",
+			hasNetAdmin: func() bool { return true },
+			findPath:    func(string) (string, error) { return "/sbin/ip", nil },
+			loadState: func(context.Context) (*LabState, error) {
+				return &LabState{Nodes: []string{"node1", "node2", "node3"}}, nil
+			},
+		},
+		now:      func() time.Time { now = now.Add(time.Second); return now },
+		newRunID: func(time.Time) (string, error) { return runID, nil },
+		mkdirAll: os.MkdirAll,
+		openFile: func(path string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+			return os.OpenFile(path, flag, perm)
+		},
+		executable: func() (string, error) { return "/tmp/rtc-emulator", nil },
+		runCommand: func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+			node := argValue(args, "--node")
+			role := argValue(args, "--role")
+			if err := writePeerConnectedMarker(runDir, WebRTCPeerOptions{RunID: runID, Node: node}, &webRTCPeerRuntimeState{
+				peerConnection: "connected",
+				iceConnection:  "connected",
+			}); err != nil {
+				return err
+			}
+			peers := []string{"node3"}
+			if role == webRTCSFURoleSFU {
+				peers = []string{"node1", "node2"}
+			}
+			for _, peer := range peers {
+				if err := writeOneStatsRecord(filepath.Join(runDir, peerPairStatsFilename(node, peer)), WebRTCStatsRecord{
+					RunID: runID,
+					Time:  "2026-01-01T00:00:01Z",
+					Node:  node,
+					Peer:  peer,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	fakeRun := deps.runCommand
+	var mu sync.Mutex
+	deps.runCommand = func(ctx context.Context, name string, args []string, stdout io.Writer, stderr io.Writer) error {
+		mu.Lock()
+		roles[argValue(args, "--node")] = argValue(args, "--role")
+		mu.Unlock()
+		return fakeRun(ctx, name, args, stdout, stderr)
+	}
+
+	result, err := runWebRTCSFUWithDeps(t.Context(), WebRTCSFUOptions{
+		RunsDir:     runsDir,
+		SFUNode:     "node3",
+		Publishers:  []string{"node1"},
+		Subscribers: []string{"node2"},
+		Duration:    time.Second,
+	}, deps)
+	if err != nil {
+		t.Fatalf("unexpected run error: %v", err)
+	}
+
+	wantRoles := map[string]string{"node1": "publisher", "node2": "subscriber", "node3": "sfu"}
+	if !reflect.DeepEqual(roles, wantRoles) {
+		t.Fatalf("roles = %#v, want %#v", roles, wantRoles)
+	}
+	for _, dir := range []string{pairSignalDir(runDir, "node1", "node3"), pairSignalDir(runDir, "node3", "node2")} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Fatalf("expected signal directory %s, err=%v", dir, err)
+		}
+	}
+
+	stats := readStatsRecords(t, result.StatsPath)
+	var pairs []string
+	for _, record := range stats {
+		pairs = append(pairs, record.Node+"->"+record.Peer)
+	}
+	sort.Strings(pairs)
+	wantPairs := []string{"node1->node3", "node2->node3", "node3->node1", "node3->node2"}
+	if !reflect.DeepEqual(pairs, wantPairs) {
+		t.Fatalf("merged stats pairs = %#v, want %#v", pairs, wantPairs)
+	}
+}
@@ -0,0 +1,291 @@
+package lab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	WebRTCSignalingFile = "file"
+	WebRTCSignalingWS   = "ws"
+
+	signalMessageBuffer = 64
+)
+
+type webRTCSignaler interface {
+	sendDescription(ctx context.Context, desc *webrtc.SessionDescription) error
+	waitDescription(ctx context.Context, sdpType webrtc.SDPType) (*webrtc.SessionDescription, error)
+	trickle() bool
+	sendCandidate(ctx context.Context, candidate webrtc.ICECandidateInit) error
+	candidates() <-chan webrtc.ICECandidateInit
+	close() error
+}
+
+const signalMessageCandidate = "candidate"
+
+type signalMessage struct {
+	Type      string                     `json:"type"`
+	From      string                     `json:"from,omitempty"`
+	SDP       *webrtc.SessionDescription `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit   `json:"candidate,omitempty"`
+}
+
+func newWebRTCSignaler(ctx context.Context, signalURL string, room string, node string, dir string) (webRTCSignaler, error) {
+	if signalURL == "" {
+		return &fileSignaler{dir: dir, sent: map[webrtc.SDPType]int{}, received: map[webrtc.SDPType]int{}}, nil
+	}
+	return dialWebSocketSignaler(ctx, signalURL, room, node)
+}
+
+type fileSignaler struct {
+	dir      string
+	mu       sync.Mutex
+	sent     map[webrtc.SDPType]int
+	received map[webrtc.SDPType]int
+}
+
+func (s *fileSignaler) next(counts map[webrtc.SDPType]int, sdpType webrtc.SDPType) (string, error) {
+	if sdpType != webrtc.SDPTypeOffer && sdpType != webrtc.SDPTypeAnswer {
+		return "", fmt.Errorf("unsupported session description type %q", sdpType)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	generation := counts[sdpType]
+	counts[sdpType]++
+	return sessionDescriptionPath(s.dir, sdpType, generation), nil
+}
+
+func sessionDescriptionPath(dir string, sdpType webrtc.SDPType, generation int) string {
+	if generation == 0 {
+		return filepath.Join(dir, sdpType.String()+".json")
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.%d.json", sdpType, generation))
+}
+
+func (s *fileSignaler) sendDescription(_ context.Context, desc *webrtc.SessionDescription) error {
+	if desc == nil {
+		return errors.New("session description is nil")
+	}
+	path, err := s.next(s.sent, desc.Type)
+	if err != nil {
+		return err
+	}
+	return writeSessionDescription(path, desc)
+}
+
+func (s *fileSignaler) waitDescription(ctx context.Context, sdpType webrtc.SDPType) (*webrtc.SessionDescription, error) {
+	path, err := s.next(s.received, sdpType)
+	if err != nil {
+		return nil, err
+	}
+	return waitForSessionDescription(ctx, path)
+}
+
+func (s *fileSignaler) trickle() bool {
+	return false
+}
+
+func (s *fileSignaler) sendCandidate(context.Context, webrtc.ICECandidateInit) error {
+	return nil
+}
+
+func (s *fileSignaler) candidates() <-chan webrtc.ICECandidateInit {
+	return nil
+}
+
+func (s *fileSignaler) close() error {
+	return nil
+}
+
+type webSocketSignaler struct {
+	conn         *websocket.Conn
+	writeMu      sync.Mutex
+	descriptions chan signalMessage
+	remote       chan webrtc.ICECandidateInit
+	done         chan struct{}
+	readErr      error
+	once         sync.Once
+}
+
+func dialWebSocketSignaler(ctx context.Context, signalURL string, room string, node string) (*webSocketSignaler, error) {
+	roomURL, err := signalRoomURL(signalURL, room, node)
+	if err != nil {
+		return nil, err
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, webRTCSignalTimeout)
+	defer cancel()
+	conn, _, err := websocket.DefaultDialer.DialContext(dialCtx, roomURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signaling server %s: %w", roomURL, err)
+	}
+	s := &webSocketSignaler{
+		conn:         conn,
+		descriptions: make(chan signalMessage, signalMessageBuffer),
+		remote:       make(chan webrtc.ICECandidateInit, signalMessageBuffer),
+		done:         make(chan struct{}),
+	}
+	go s.readLoop()
+	return s, nil
+}
+
+func (s *webSocketSignaler) readLoop() {
+	defer close(s.remote)
+	defer close(s.descriptions)
+	for {
+		var msg signalMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			s.readErr = err
+			return
+		}
+		switch {
+		case msg.Type == signalMessageCandidate && msg.Candidate != nil:
+			select {
+			case s.remote <- *msg.Candidate:
+			case <-s.done:
+				return
+			}
+		case msg.SDP != nil:
+			select {
+			case s.descriptions <- msg:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+func (s *webSocketSignaler) send(msg signalMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.conn.WriteJSON(msg); err != nil {
+		return fmt.Errorf("failed to send %s signaling message: %w", msg.Type, err)
+	}
+	return nil
+}
+
+func (s *webSocketSignaler) sendDescription(_ context.Context, desc *webrtc.SessionDescription) error {
+	if desc == nil {
+		return errors.New("session description is nil")
+	}
+	return s.send(signalMessage{Type: desc.Type.String(), SDP: desc})
+}
+
+func (s *webSocketSignaler) waitDescription(ctx context.Context, sdpType webrtc.SDPType) (*webrtc.SessionDescription, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for %s from signaling server: %w", sdpType, ctx.Err())
+		case msg, ok := <-s.descriptions:
+			if !ok {
+				return nil, fmt.Errorf("signaling connection closed while waiting for %s: %w", sdpType, s.readErr)
+			}
+			if msg.Type == sdpType.String() && msg.SDP != nil {
+				return msg.SDP, nil
+			}
+		}
+	}
+}
+
+func (s *webSocketSignaler) trickle() bool {
+	return true
+}
+
+func (s *webSocketSignaler) sendCandidate(_ context.Context, candidate webrtc.ICECandidateInit) error {
+	return s.send(signalMessage{Type: signalMessageCandidate, Candidate: &candidate})
+}
+
+func (s *webSocketSignaler) candidates() <-chan webrtc.ICECandidateInit {
+	return s.remote
+}
+
+func (s *webSocketSignaler) close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		s.writeMu.Lock()
+		_ = s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		s.writeMu.Unlock()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+func signalRoomURL(signalURL string, room string, node string) (string, error) {
+	u, err := url.Parse(signalURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid signal url %q: %w", signalURL, err)
+	}
+	u.Path = path.Join("/", u.Path, "rooms", room)
+	q := u.Query()
+	q.Set("peer", node)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func pairSignalRoom(runID string, offerer string, answerer string) string {
+	return runID + "/" + offerer + "-" + answerer
+}
+
+func normalizeWebRTCSignaling(mode string, signalURL string) (string, string) {
+	mode = strings.TrimSpace(mode)
+	signalURL = strings.TrimSpace(signalURL)
+	if mode == "" {
+		mode = WebRTCSignalingFile
+		if signalURL != "" {
+			mode = WebRTCSignalingWS
+		}
+	}
+	return mode, signalURL
+}
+
+func validateWebRTCSignaling(mode string, signalURL string) error {
+	switch mode {
+	case "", WebRTCSignalingFile:
+		if signalURL != "" {
+			return errors.New("signal url requires ws signaling")
+		}
+		return nil
+	case WebRTCSignalingWS:
+		if signalURL == "" {
+			return nil
+		}
+		return validateSignalURL(signalURL)
+	default:
+		return fmt.Errorf("unsupported signaling mode %q: use %s or %s", mode, WebRTCSignalingFile, WebRTCSignalingWS)
+	}
+}
+
+func validateSignalURL(signalURL string) error {
+	u, err := url.Parse(signalURL)
+	if err != nil {
+		return fmt.Errorf("invalid signal url %q: %w", signalURL, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("invalid signal url %q: scheme must be ws or wss", signalURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid signal url %q: host is required", signalURL)
+	}
+	return nil
+}
+
+func startRunSignaling(mode string, signalURL string, deps webRTCP2PDeps) (string, func() error, error) {
+	if mode != WebRTCSignalingWS || signalURL != "" {
+		return signalURL, func() error { return nil }, nil
+	}
+	server, err := deps.startSignalServer(net.JoinHostPort(bridgeIP, "0"))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to start signaling server: %w", err)
+	}
+	return server.URL(), server.Close, nil
+}
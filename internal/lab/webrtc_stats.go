@@ -8,6 +8,8 @@ import (
 	"os"
 	"sort"
 	"time"
+
+	"github.com/pion/webrtc/v4"
 )
 
 type WebRTCStatsRecord struct {
@@ -30,11 +32,68 @@ type WebRTCStatsRecord struct {
 	DataMessagesReceived *uint64  `json:"data_messages_received,omitempty"`
 	DataChannelsOpened   *uint64  `json:"data_channels_opened,omitempty"`
 	DataChannelsClosed   *uint64  `json:"data_channels_closed,omitempty"`
+
+	AvailableOutgoingBitrate *float64 `json:"available_outgoing_bitrate,omitempty"`
+	TargetBitrate            *float64 `json:"target_bitrate,omitempty"`
+	// *Received counts feedback the remote sent about our outbound streams;
+	// *Sent counts feedback we sent about inbound streams.
+	NACKReceived         *uint64             `json:"nack_received,omitempty"`
+	PLIReceived          *uint64             `json:"pli_received,omitempty"`
+	FIRReceived          *uint64             `json:"fir_received,omitempty"`
+	NACKSent             *uint64             `json:"nack_sent,omitempty"`
+	PLISent              *uint64             `json:"pli_sent,omitempty"`
+	FIRSent              *uint64             `json:"fir_sent,omitempty"`
+	RetransmittedPackets *uint64             `json:"retransmitted_packets,omitempty"`
+	FramesDecoded        *uint64             `json:"frames_decoded,omitempty"`
+	FramesDropped        *uint64             `json:"frames_dropped,omitempty"`
+	FreezeCount          *uint64             `json:"freeze_count,omitempty"`
+	TotalFreezesDuration *float64            `json:"total_freezes_duration,omitempty"`
+	RemoteRoundTripTime  *float64            `json:"remote_round_trip_time,omitempty"`
+	RemoteFractionLost   *float64            `json:"remote_fraction_lost,omitempty"`
+	Streams              []WebRTCStreamStats `json:"streams,omitempty"`
+
+	IntervalSeconds   *float64 `json:"interval_seconds,omitempty"`
+	SendBitrate       *float64 `json:"send_bitrate_bps,omitempty"`
+	ReceiveBitrate    *float64 `json:"receive_bitrate_bps,omitempty"`
+	SendPacketRate    *float64 `json:"send_packet_rate,omitempty"`
+	ReceivePacketRate *float64 `json:"receive_packet_rate,omitempty"`
+	LossRate          *float64 `json:"loss_rate,omitempty"`
+	SendFrameRate     *float64 `json:"send_frame_rate,omitempty"`
+	ReceiveFrameRate  *float64 `json:"receive_frame_rate,omitempty"`
+	CounterReset      bool     `json:"counter_reset,omitempty"`
+}
+
+const (
+	webRTCStreamDirectionInbound  = "inbound"
+	webRTCStreamDirectionOutbound = "outbound"
+)
+
+type WebRTCStreamStats struct {
+	Direction            string   `json:"direction"`
+	SSRC                 uint32   `json:"ssrc"`
+	Kind                 string   `json:"kind,omitempty"`
+	Bytes                *uint64  `json:"bytes,omitempty"`
+	Packets              *uint64  `json:"packets,omitempty"`
+	PacketsLost          *int64   `json:"packets_lost,omitempty"`
+	Jitter               *float64 `json:"jitter,omitempty"`
+	Frames               *uint64  `json:"frames,omitempty"`
+	FramesDecoded        *uint64  `json:"frames_decoded,omitempty"`
+	FramesDropped        *uint64  `json:"frames_dropped,omitempty"`
+	FreezeCount          *uint64  `json:"freeze_count,omitempty"`
+	NACKCount            *uint64  `json:"nack_count,omitempty"`
+	PLICount             *uint64  `json:"pli_count,omitempty"`
+	FIRCount             *uint64  `json:"fir_count,omitempty"`
+	RetransmittedPackets *uint64  `json:"retransmitted_packets,omitempty"`
+	TargetBitrate        *float64 `json:"target_bitrate,omitempty"`
+	RemoteRoundTripTime  *float64 `json:"remote_round_trip_time,omitempty"`
+	RemoteFractionLost   *float64 `json:"remote_fraction_lost,omitempty"`
+	RemotePacketsLost    *int64   `json:"remote_packets_lost,omitempty"`
 }
 
 type statsLogger struct {
 	path   string
 	writer io.WriteCloser
+	prev   *WebRTCStatsRecord
 }
 
 func newStatsLogger(path string, openFile func(string, int, os.FileMode) (io.WriteCloser, error)) (*statsLogger, error) {
@@ -56,6 +115,63 @@ func (l *statsLogger) write(record WebRTCStatsRecord) error {
 	return nil
 }
 
+func (l *statsLogger) writeSample(record WebRTCStatsRecord) error {
+	if l.prev != nil {
+		deriveStatsRates(&record, *l.prev)
+	}
+	if err := l.write(record); err != nil {
+		return err
+	}
+	l.prev = &record
+	return nil
+}
+
+func deriveStatsRates(record *WebRTCStatsRecord, prev WebRTCStatsRecord) {
+	cur, err := time.Parse(time.RFC3339Nano, record.Time)
+	if err != nil {
+		return
+	}
+	before, err := time.Parse(time.RFC3339Nano, prev.Time)
+	if err != nil {
+		return
+	}
+	interval := cur.Sub(before).Seconds()
+	if interval <= 0 {
+		return
+	}
+	record.IntervalSeconds = float64Ptr(interval)
+
+	rate := func(cur *uint64, prev *uint64, scale float64) *float64 {
+		if cur == nil || prev == nil {
+			return nil
+		}
+		if *cur < *prev {
+			record.CounterReset = true
+			return nil
+		}
+		return float64Ptr(float64(*cur-*prev) * scale / interval)
+	}
+	record.SendBitrate = rate(record.BytesSent, prev.BytesSent, 8)
+	record.ReceiveBitrate = rate(record.BytesReceived, prev.BytesReceived, 8)
+	record.SendPacketRate = rate(record.PacketsSent, prev.PacketsSent, 1)
+	record.ReceivePacketRate = rate(record.PacketsReceived, prev.PacketsReceived, 1)
+	record.SendFrameRate = rate(record.FramesSent, prev.FramesSent, 1)
+	record.ReceiveFrameRate = rate(record.FramesReceived, prev.FramesReceived, 1)
+
+	if record.PacketsLost != nil && prev.PacketsLost != nil &&
+		record.PacketsReceived != nil && prev.PacketsReceived != nil &&
+		*record.PacketsReceived >= *prev.PacketsReceived {
+		lost := *record.PacketsLost - *prev.PacketsLost
+		if lost < 0 {
+			lost = 0
+		}
+		expected := lost + int64(*record.PacketsReceived-*prev.PacketsReceived)
+		if expected > 0 {
+			record.LossRate = float64Ptr(float64(lost) / float64(expected))
+		}
+	}
+}
+
 func (l *statsLogger) close() error {
 	if l.writer == nil {
 		return nil
@@ -67,6 +183,82 @@ func (l *statsLogger) close() error {
 	return nil
 }
 
+type RawStatsRecord struct {
+	RunID string          `json:"run_id"`
+	Time  string          `json:"time"`
+	Node  string          `json:"node"`
+	Peer  string          `json:"peer"`
+	Stats []RawStatsEntry `json:"stats"`
+}
+
+type RawStatsEntry struct {
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Stats json.RawMessage `json:"stats"`
+}
+
+type rawStatsLogger struct {
+	path   string
+	writer io.WriteCloser
+}
+
+func newRawStatsLogger(path string, openFile func(string, int, os.FileMode) (io.WriteCloser, error)) (*rawStatsLogger, error) {
+	writer, err := openFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open raw stats log %s: %w", path, err)
+	}
+	return &rawStatsLogger{path: path, writer: writer}, nil
+}
+
+func (l *rawStatsLogger) write(record RawStatsRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode raw stats record: %w", err)
+	}
+	if _, err := l.writer.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write raw stats log %s: %w", l.path, err)
+	}
+	return nil
+}
+
+func (l *rawStatsLogger) close() error {
+	if l.writer == nil {
+		return nil
+	}
+	if err := l.writer.Close(); err != nil {
+		return fmt.Errorf("failed to close raw stats log %s: %w", l.path, err)
+	}
+	l.writer = nil
+	return nil
+}
+
+func newRawStatsRecord(record WebRTCStatsRecord, report webrtc.StatsReport) (RawStatsRecord, error) {
+	raw := RawStatsRecord{
+		RunID: record.RunID,
+		Time:  record.Time,
+		Node:  record.Node,
+		Peer:  record.Peer,
+		Stats: make([]RawStatsEntry, 0, len(report)),
+	}
+	for id, stat := range report {
+		b, err := json.Marshal(stat)
+		if err != nil {
+			return RawStatsRecord{}, fmt.Errorf("failed to encode raw stat %s: %w", id, err)
+		}
+		var header struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(b, &header); err != nil {
+			return RawStatsRecord{}, fmt.Errorf("failed to decode raw stat type %s: %w", id, err)
+		}
+		raw.Stats = append(raw.Stats, RawStatsEntry{ID: id, Type: header.Type, Stats: b})
+	}
+	sort.Slice(raw.Stats, func(i, j int) bool {
+		return raw.Stats[i].ID < raw.Stats[j].ID
+	})
+	return raw, nil
+}
+
 func mergeStatsLogs(outputPath string, inputPaths []string) error {
 	records := make([]WebRTCStatsRecord, 0)
 	for _, inputPath := range inputPaths {
@@ -133,3 +325,30 @@ func int64Ptr(v int64) *int64 {
 func float64Ptr(v float64) *float64 {
 	return &v
 }
+
+func addUint64(dst **uint64, v uint64) {
+	if *dst == nil {
+		*dst = uint64Ptr(0)
+	}
+	**dst += v
+}
+
+func addInt64(dst **int64, v int64) {
+	if *dst == nil {
+		*dst = int64Ptr(0)
+	}
+	**dst += v
+}
+
+func addFloat64(dst **float64, v float64) {
+	if *dst == nil {
+		*dst = float64Ptr(0)
+	}
+	**dst += v
+}
+
+func maxFloat64(dst **float64, v float64) {
+	if *dst == nil || v > **dst {
+		*dst = float64Ptr(v)
+	}
+}
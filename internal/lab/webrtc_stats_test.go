@@ -6,8 +6,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/pion/webrtc/v4"
 )
 
 type nopWriteCloser struct {
@@ -55,6 +58,99 @@ func TestStatsLoggerWritesJSONL(t *testing.T) {
 	}
 }
 
+func TestStatsLoggerWriteSampleDerivesIntervalRates(t *testing.T) {
+	writer := &nopWriteCloser{}
+	logger, err := newStatsLogger("stats.jsonl", func(string, int, os.FileMode) (io.WriteCloser, error) {
+		return writer, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	samples := []WebRTCStatsRecord{
+		{Time: "2026-01-01T00:00:00Z", BytesSent: uint64Ptr(1000), PacketsReceived: uint64Ptr(100), PacketsLost: int64Ptr(0)},
+		{Time: "2026-01-01T00:00:02Z", BytesSent: uint64Ptr(3000), PacketsReceived: uint64Ptr(190), PacketsLost: int64Ptr(10)},
+		{Time: "2026-01-01T00:00:03Z", BytesSent: uint64Ptr(500), PacketsReceived: uint64Ptr(200)},
+	}
+	for _, sample := range samples {
+		if err := logger.writeSample(sample); err != nil {
+			t.Fatalf("unexpected write error: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(writer.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(lines))
+	}
+	var got []WebRTCStatsRecord
+	for _, line := range lines {
+		var record WebRTCStatsRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to parse stats line %q: %v", line, err)
+		}
+		got = append(got, record)
+	}
+
+	if got[0].IntervalSeconds != nil || got[0].SendBitrate != nil {
+		t.Fatalf("first sample must not have derived rates: %+v", got[0])
+	}
+	if got[1].IntervalSeconds == nil || *got[1].IntervalSeconds != 2 {
+		t.Fatalf("interval = %v, want 2", got[1].IntervalSeconds)
+	}
+	if got[1].SendBitrate == nil || *got[1].SendBitrate != 8000 {
+		t.Fatalf("send bitrate = %v, want 8000", got[1].SendBitrate)
+	}
+	if got[1].ReceivePacketRate == nil || *got[1].ReceivePacketRate != 45 {
+		t.Fatalf("receive packet rate = %v, want 45", got[1].ReceivePacketRate)
+	}
+	if got[1].LossRate == nil || *got[1].LossRate != 0.1 {
+		t.Fatalf("loss rate = %v, want 0.1", got[1].LossRate)
+	}
+	if !got[2].CounterReset || got[2].SendBitrate != nil {
+		t.Fatalf("expected counter reset without send bitrate, got %+v", got[2])
+	}
+	if got[2].ReceivePacketRate == nil || *got[2].ReceivePacketRate != 10 {
+		t.Fatalf("receive packet rate after reset = %v, want 10", got[2].ReceivePacketRate)
+	}
+	if got[2].LossRate != nil {
+		t.Fatalf("expected missing loss counter to skip loss rate, got %v", *got[2].LossRate)
+	}
+}
+
+func TestNewRawStatsRecordPreservesTypeAndID(t *testing.T) {
+	raw, err := newRawStatsRecord(WebRTCStatsRecord{
+		RunID: "run-1",
+		Time:  "2026-01-01T00:00:00Z",
+		Node:  "node1",
+		Peer:  "node2",
+	}, webrtc.StatsReport{
+		"pc": webrtc.PeerConnectionStats{ID: "pc", Type: webrtc.StatsTypePeerConnection, DataChannelsOpened: 1},
+		"dc": webrtc.DataChannelStats{ID: "dc", Type: webrtc.StatsTypeDataChannel, MessagesSent: 7},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if raw.RunID != "run-1" || raw.Node != "node1" || raw.Peer != "node2" {
+		t.Fatalf("unexpected raw record header: %+v", raw)
+	}
+	if len(raw.Stats) != 2 {
+		t.Fatalf("expected 2 raw stats entries, got %+v", raw.Stats)
+	}
+	if raw.Stats[0].ID != "dc" || raw.Stats[0].Type != "data-channel" {
+		t.Fatalf("unexpected first raw entry: %+v", raw.Stats[0])
+	}
+	if raw.Stats[1].ID != "pc" || raw.Stats[1].Type != "peer-connection" {
+		t.Fatalf("unexpected second raw entry: %+v", raw.Stats[1])
+	}
+	var dc map[string]any
+	if err := json.Unmarshal(raw.Stats[0].Stats, &dc); err != nil {
+		t.Fatalf("failed to parse raw stat payload: %v", err)
+	}
+	if dc["messagesSent"] != float64(7) {
+		t.Fatalf("expected raw payload to keep pion field names, got %v", dc)
+	}
+}
+
 func TestMergeStatsLogsSortsByTimestamp(t *testing.T) {
 	dir := t.TempDir()
 	node1 := filepath.Join(dir, "stats.node1.jsonl")
@@ -101,6 +197,96 @@ func TestMergeStatsLogsSortsByTimestamp(t *testing.T) {
 	}
 }
 
+func TestSummarizeWebRTCStatsIncludesRTCPAndPerSSRCCounters(t *testing.T) {
+	var record WebRTCStatsRecord
+	summarizeWebRTCStats(&record, webrtc.StatsReport{
+		"pair": webrtc.ICECandidatePairStats{
+			Nominated:                true,
+			CurrentRoundTripTime:     0.05,
+			AvailableOutgoingBitrate: 1500000,
+		},
+		"out-2": webrtc.OutboundRTPStreamStats{
+			SSRC:                     2,
+			Kind:                     "video",
+			BytesSent:                1000,
+			PacketsSent:              10,
+			NACKCount:                3,
+			PLICount:                 1,
+			RetransmittedPacketsSent: 2,
+			TargetBitrate:            800000,
+		},
+		"out-1": webrtc.OutboundRTPStreamStats{
+			SSRC:        1,
+			Kind:        "audio",
+			BytesSent:   500,
+			PacketsSent: 5,
+		},
+		"in-3": webrtc.InboundRTPStreamStats{
+			SSRC:            3,
+			Kind:            "video",
+			BytesReceived:   2000,
+			PacketsReceived: 20,
+			PacketsLost:     4,
+			FramesDecoded:   30,
+			FramesDropped:   2,
+			FreezeCount:     1,
+			FIRCount:        1,
+		},
+		"remote-in-2": webrtc.RemoteInboundRTPStreamStats{
+			SSRC:          2,
+			Kind:          "video",
+			RoundTripTime: 0.08,
+			FractionLost:  0.1,
+		},
+		"remote-in-1": webrtc.RemoteInboundRTPStreamStats{
+			SSRC:          1,
+			Kind:          "audio",
+			RoundTripTime: 0.06,
+			FractionLost:  0.02,
+		},
+	})
+
+	if record.AvailableOutgoingBitrate == nil || *record.AvailableOutgoingBitrate != 1500000 {
+		t.Fatalf("available outgoing bitrate = %v, want 1500000", record.AvailableOutgoingBitrate)
+	}
+	if record.TargetBitrate == nil || *record.TargetBitrate != 800000 {
+		t.Fatalf("target bitrate = %v, want 800000", record.TargetBitrate)
+	}
+	if record.NACKReceived == nil || *record.NACKReceived != 3 || record.PLIReceived == nil || *record.PLIReceived != 1 || record.FIRReceived == nil || *record.FIRReceived != 0 {
+		t.Fatalf("unexpected received rtcp feedback counters: %+v", record)
+	}
+	if record.FIRSent == nil || *record.FIRSent != 1 || record.NACKSent == nil || *record.NACKSent != 0 || record.PLISent == nil || *record.PLISent != 0 {
+		t.Fatalf("unexpected sent rtcp feedback counters: %+v", record)
+	}
+	if record.RetransmittedPackets == nil || *record.RetransmittedPackets != 2 {
+		t.Fatalf("retransmitted packets = %v, want 2", record.RetransmittedPackets)
+	}
+	if record.FramesDecoded == nil || *record.FramesDecoded != 30 || record.FramesDropped == nil || *record.FramesDropped != 2 {
+		t.Fatalf("unexpected frame counters: %+v", record)
+	}
+	if record.RemoteRoundTripTime == nil || *record.RemoteRoundTripTime != 0.08 {
+		t.Fatalf("remote round trip time = %v, want worst stream 0.08", record.RemoteRoundTripTime)
+	}
+	if record.RemoteFractionLost == nil || *record.RemoteFractionLost != 0.1 {
+		t.Fatalf("remote fraction lost = %v, want worst stream 0.1", record.RemoteFractionLost)
+	}
+
+	if len(record.Streams) != 3 {
+		t.Fatalf("expected 3 per-ssrc streams, got %+v", record.Streams)
+	}
+	wantOrder := []string{"inbound/3", "outbound/1", "outbound/2"}
+	for i, want := range wantOrder {
+		got := record.Streams[i].Direction + "/" + strconv.FormatUint(uint64(record.Streams[i].SSRC), 10)
+		if got != want {
+			t.Fatalf("stream %d = %s, want %s", i, got, want)
+		}
+	}
+	video := record.Streams[2]
+	if video.RemoteRoundTripTime == nil || *video.RemoteRoundTripTime != 0.08 || video.NACKCount == nil || *video.NACKCount != 3 {
+		t.Fatalf("expected outbound video stream to merge remote-inbound stats, got %+v", video)
+	}
+}
+
 func writeStatsTestFile(t *testing.T, path string, records []WebRTCStatsRecord) {
 	t.Helper()
 	var buf bytes.Buffer